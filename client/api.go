@@ -0,0 +1,313 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+// ===== ACTIVITIES ===========================================================
+
+// Activities returns the activities of the current user.
+func (c *Client) Activities(ctx context.Context) ([]domain.Activity, error) {
+	var as []domain.Activity
+	_, err := c.Do(ctx, "GET", "/activities", nil, &as)
+	return as, err
+}
+
+// CreateActivity creates an activity of the current user.
+func (c *Client) CreateActivity(ctx context.Context, a domain.Activity) (domain.Activity, error) {
+	res, err := c.Do(ctx, "POST", "/activities", a, nil)
+	if err != nil {
+		return a, err
+	}
+	a.ID, err = createdID(res)
+	return a, err
+}
+
+// ===== CUSTOMERS ============================================================
+
+// CreateCustomer creates a customer of the current user.
+func (c *Client) CreateCustomer(ctx context.Context, cu domain.Customer) (domain.Customer, error) {
+	res, err := c.Do(ctx, "POST", "/customers", cu, nil)
+	if err != nil {
+		return cu, err
+	}
+	cu.ID, err = createdID(res)
+	return cu, err
+}
+
+// UpdateCustomer replaces the name and buyer master data of the customer
+// cu.ID.
+func (c *Client) UpdateCustomer(ctx context.Context, cu domain.Customer) (domain.Customer, error) {
+	var updated domain.Customer
+	_, err := c.Do(ctx, "PUT", fmt.Sprintf("/customers/%d", cu.ID), cu, &updated)
+	return updated, err
+}
+
+// ===== PROFILE ==============================================================
+
+// Profile returns the profile of the current user, the master data of the
+// seller of electronic invoices.
+func (c *Client) Profile(ctx context.Context) (domain.Profile, error) {
+	var p domain.Profile
+	_, err := c.Do(ctx, "GET", "/profile", nil, &p)
+	return p, err
+}
+
+// SaveProfile saves the profile of the current user.
+func (c *Client) SaveProfile(ctx context.Context, p domain.Profile) error {
+	_, err := c.Do(ctx, "PUT", "/profile", p, nil)
+	return err
+}
+
+// ===== PROJECTS AND RATES ===================================================
+
+// CreateProject creates a project of the customer p.CustomerID.
+func (c *Client) CreateProject(ctx context.Context, p domain.Project) (domain.Project, error) {
+	res, err := c.Do(ctx, "POST", fmt.Sprintf("/customers/%d/projects", p.CustomerID), p, nil)
+	if err != nil {
+		return p, err
+	}
+	p.ID, err = createdID(res)
+	return p, err
+}
+
+// CreateRate sets the hourly rate of an activity of the customer's project
+// r.ProjectID.
+func (c *Client) CreateRate(ctx context.Context, customerID int, r domain.Rate) (domain.Rate, error) {
+	_, err := c.Do(ctx, "POST", fmt.Sprintf("/customers/%d/projects/%d/rates", customerID, r.ProjectID), r, nil)
+	return r, err
+}
+
+// ===== INVOICES =============================================================
+
+// Invoice is an invoice with the HAL links of its operations.
+type Invoice struct {
+	domain.Invoice
+	Links    map[string]Link `json:"_links,omitempty"`
+	Embedded *struct {
+		Bookings []domain.Booking `json:"bookings,omitempty"`
+	} `json:"_embedded,omitempty"`
+}
+
+// Link implements the Resource interface.
+func (i Invoice) Link(rel string) (Link, bool) {
+	l, ok := i.Links[rel]
+	return l, ok
+}
+
+// CreateInvoice creates an invoice of the customer i.CustomerID.
+func (c *Client) CreateInvoice(ctx context.Context, i domain.Invoice) (domain.Invoice, error) {
+	res, err := c.Do(ctx, "POST", fmt.Sprintf("/customers/%d/invoices", i.CustomerID), i, nil)
+	if err != nil {
+		return i, err
+	}
+	i.ID, err = createdID(res)
+	return i, err
+}
+
+// Invoice returns the invoice of the customer. Expand lists subresources
+// to include, e.g. "bookings".
+func (c *Client) Invoice(ctx context.Context, customerID, invoiceID int, expand ...string) (Invoice, error) {
+	var i Invoice
+	path := fmt.Sprintf("/customers/%d/invoices/%d", customerID, invoiceID)
+	if len(expand) > 0 {
+		path += "?" + url.Values{"expand": expand}.Encode()
+	}
+	_, err := c.do(ctx, "GET", path, "application/hal+json", nil, &i)
+	return i, err
+}
+
+// Invoices returns the invoices of the current user, with the status
+// unless empty.
+func (c *Client) Invoices(ctx context.Context, status string) ([]Invoice, error) {
+	var is []Invoice
+	path := "/invoices"
+	if len(status) > 0 {
+		path += "?" + url.Values{"status": {status}}.Encode()
+	}
+	_, err := c.do(ctx, "GET", path, "application/hal+json", nil, &is)
+	return is, err
+}
+
+// InvoicePDF writes the PDF of the invoice to w.
+func (c *Client) InvoicePDF(ctx context.Context, customerID, invoiceID int, w io.Writer) error {
+	_, err := c.do(ctx, "GET", fmt.Sprintf("/customers/%d/invoices/%d", customerID, invoiceID), "application/pdf", nil, w)
+	return err
+}
+
+// These are the media types of exports.
+const (
+	CSV  = "text/csv"
+	XLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// These are the media types of electronic invoices. Appending XRechnung
+// asks for invoices conforming to XRechnung, e.g. UBL + XRechnung.
+const (
+	UBL       = "application/ubl+xml"
+	CII       = "application/cii+xml"
+	XRechnung = "; profile=xrechnung"
+)
+
+// InvoiceExport writes the bookings and positions of the invoice to w, as
+// CSV or XLSX, or the invoice as electronic invoice in UBL or CII. Invoices
+// violating business rules of electronic invoices fail with an *Error
+// listing the Violations.
+func (c *Client) InvoiceExport(ctx context.Context, customerID, invoiceID int, mediaType string, w io.Writer) error {
+	_, err := c.do(ctx, "GET", fmt.Sprintf("/customers/%d/invoices/%d", customerID, invoiceID), mediaType, nil, w)
+	return err
+}
+
+// ExportBookings writes the bookings and positions of the invoices of the
+// months from through to, e.g. "2020-11", to w, as CSV or XLSX. Empty
+// months default to the current one.
+func (c *Client) ExportBookings(ctx context.Context, from, to, mediaType string, w io.Writer) error {
+	q := make(url.Values)
+	if len(from) > 0 {
+		q.Set("from", from)
+	}
+	if len(to) > 0 {
+		q.Set("to", to)
+	}
+	path := "/exports/bookings"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	_, err := c.do(ctx, "GET", path, mediaType, nil, w)
+	return err
+}
+
+// Charge aggregates the bookings of the invoice into positions and marks it
+// as "payment expected".
+func (c *Client) Charge(ctx context.Context, i domain.Invoice) error {
+	i.Status = "ready for aggregation"
+	return c.UpdateInvoice(ctx, i)
+}
+
+// UpdateInvoice updates the invoice i.ID of the customer i.CustomerID.
+func (c *Client) UpdateInvoice(ctx context.Context, i domain.Invoice) error {
+	_, err := c.Do(ctx, "PUT", fmt.Sprintf("/customers/%d/invoices/%d", i.CustomerID, i.ID), i, nil)
+	return err
+}
+
+// ===== BOOKINGS =============================================================
+
+// Book adds a booking to the invoice.
+func (c *Client) Book(ctx context.Context, invoiceID int, b domain.Booking) (domain.Booking, error) {
+	res, err := c.Do(ctx, "POST", fmt.Sprintf("/book/%d", invoiceID), b, nil)
+	if err != nil {
+		return b, err
+	}
+	b.InvoiceID = invoiceID
+	b.ID, err = createdID(res)
+	return b, err
+}
+
+// ImportOptions configure the import of a timesheet. Zero values take the
+// defaults of the API.
+type ImportOptions struct {
+	// Mapping maps the fields date, project, activity, hours and
+	// description to the columns of the timesheet.
+	Mapping map[string]string
+	// DateFormat is the Go layout of the dates, e.g. "02.01.2006".
+	DateFormat string
+	// Delimiter separates the fields of CSV.
+	Delimiter rune
+}
+
+// ImportBookings books the records of a timesheet, CSV of the content type
+// "text/csv" or JSON Lines of "application/jsonl". Nothing is booked when a
+// record is invalid, the *Error lists the invalid records.
+func (c *Client) ImportBookings(ctx context.Context, invoiceID int, contentType string, timesheet []byte, opts ImportOptions) ([]domain.Booking, error) {
+	q := make(url.Values)
+	if len(opts.Mapping) > 0 {
+		ms := make([]string, 0, len(opts.Mapping))
+		for f, col := range opts.Mapping {
+			ms = append(ms, f+":"+col)
+		}
+		sort.Strings(ms)
+		q.Set("mapping", strings.Join(ms, ","))
+	}
+	if len(opts.DateFormat) > 0 {
+		q.Set("dateFormat", opts.DateFormat)
+	}
+	if opts.Delimiter != 0 {
+		q.Set("delimiter", string(opts.Delimiter))
+	}
+	path := fmt.Sprintf("/invoices/%d/bookings", invoiceID)
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var bs []domain.Booking
+	_, err := c.send(ctx, "POST", path, "application/json", contentType, timesheet, &bs)
+	return bs, err
+}
+
+// TimesheetOptions configure the import of an export of a time tracking
+// tool.
+type TimesheetOptions struct {
+	// Create creates missing customers, projects, activities and invoices.
+	Create bool
+	// DryRun only reports what the import would create.
+	DryRun bool
+	// Activity is booked for entries without task.
+	Activity string
+}
+
+// TimesheetPlan lists the records an import created, or would create in a
+// dry run. The IDs of planned records are 0.
+type TimesheetPlan struct {
+	DryRun     bool              `json:"dryRun"`
+	Customers  []domain.Customer `json:"customers"`
+	Projects   []domain.Project  `json:"projects"`
+	Activities []domain.Activity `json:"activities"`
+	Invoices   []domain.Invoice  `json:"invoices"`
+	Bookings   []PlannedBooking  `json:"bookings"`
+}
+
+// PlannedBooking is a booking of an entry along with the names of the
+// records it belongs to.
+type PlannedBooking struct {
+	domain.Booking
+	Line     int    `json:"line"`
+	Customer string `json:"customer"`
+	Project  string `json:"project"`
+	Activity string `json:"activity"`
+	Period   string `json:"period"`
+}
+
+// ImportTimesheet books the entries of a CSV export of a time tracking
+// tool, the format is one of "toggl", "harvest" and "clockify". Nothing is
+// booked when an entry is invalid, the *Error lists the invalid entries.
+func (c *Client) ImportTimesheet(ctx context.Context, format string, export []byte, opts TimesheetOptions) (TimesheetPlan, error) {
+	q := make(url.Values)
+	if opts.Create {
+		q.Set("create", "true")
+	}
+	if opts.DryRun {
+		q.Set("dryRun", "true")
+	}
+	if len(opts.Activity) > 0 {
+		q.Set("activity", opts.Activity)
+	}
+	path := "/timesheets/" + url.PathEscape(format)
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var plan TimesheetPlan
+	_, err := c.send(ctx, "POST", path, "application/json", "text/csv", export, &plan)
+	return plan, err
+}
+
+// DeleteBooking removes a booking of the invoice.
+func (c *Client) DeleteBooking(ctx context.Context, invoiceID, bookingID int) error {
+	_, err := c.Do(ctx, "DELETE", fmt.Sprintf("/invoices/%d/bookings/%d", invoiceID, bookingID), nil, nil)
+	return err
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/digest"
+)
+
+// Auth authorizes the requests of a client by wrapping its transport.
+type Auth func(base http.RoundTripper) http.RoundTripper
+
+// Digest authenticates with the "Digest" scheme.
+func Digest(username, password string) Auth {
+	return func(base http.RoundTripper) http.RoundTripper {
+		return &digest.Transport{Username: username, Password: password, Base: base}
+	}
+}
+
+// APIKey authenticates with an API key sent in the X-API-Key header.
+func APIKey(key string) Auth {
+	return func(base http.RoundTripper) http.RoundTripper {
+		return roundTripper(func(r *http.Request) (*http.Response, error) {
+			r = r.Clone(r.Context())
+			r.Header.Set("X-API-Key", key)
+			return base.RoundTrip(r)
+		})
+	}
+}
+
+// Bearer authenticates with the access tokens of the source. A request
+// rejected with 401 is repeated once with a refreshed token.
+func Bearer(ts TokenSource) Auth {
+	return func(base http.RoundTripper) http.RoundTripper {
+		return &bearer{ts: ts, base: base}
+	}
+}
+
+// TokenSource supplies access tokens.
+type TokenSource interface {
+	// Token returns the current access token.
+	Token(ctx context.Context) (string, error)
+	// Refresh replaces the access token rejected by the API.
+	Refresh(ctx context.Context) (string, error)
+}
+
+// StaticToken is a token source of a single access token, which can not be
+// refreshed.
+type StaticToken string
+
+// Token implements the TokenSource interface.
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// Refresh implements the TokenSource interface.
+func (t StaticToken) Refresh(ctx context.Context) (string, error) {
+	return "", errors.New("static token can not be refreshed")
+}
+
+// RefreshTokens is a token source refreshing the access token with the
+// refresh token grant at the token endpoint of the identity provider.
+// Rotated refresh tokens replace the current one.
+type RefreshTokens struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// HTTPClient makes the token requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Refreshed is called with the new tokens after a refresh, e.g. to
+	// store them.
+	Refreshed func(accessToken, refreshToken string)
+
+	mu      sync.Mutex
+	access  string
+	refresh string
+}
+
+// NewRefreshTokens instantiates a token source starting with the tokens,
+// e.g. of a login.
+func NewRefreshTokens(tokenURL, clientID, clientSecret, accessToken, refreshToken string) *RefreshTokens {
+	return &RefreshTokens{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		access:       accessToken,
+		refresh:      refreshToken,
+	}
+}
+
+// Token implements the TokenSource interface.
+func (t *RefreshTokens) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.access) < 1 {
+		return t.exchange(ctx)
+	}
+	return t.access, nil
+}
+
+// Refresh implements the TokenSource interface.
+func (t *RefreshTokens) Refresh(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.exchange(ctx)
+}
+
+func (t *RefreshTokens) exchange(ctx context.Context) (string, error) {
+	if len(t.refresh) < 1 {
+		return "", errors.New("no refresh token")
+	}
+	form := make(url.Values)
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", t.refresh)
+	form.Set("client_id", t.ClientID)
+	form.Set("client_secret", t.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, "POST", t.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "creating token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	hc := t.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "refreshing access token")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("refreshing access token: unexpected status %d", res.StatusCode)
+	}
+	var tr struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return "", errors.Wrap(err, "decoding token response")
+	}
+	if len(tr.AccessToken) < 1 {
+		return "", errors.New("token response without access token")
+	}
+	t.access = tr.AccessToken
+	if len(tr.RefreshToken) > 0 {
+		t.refresh = tr.RefreshToken
+	}
+	if t.Refreshed != nil {
+		t.Refreshed(t.access, t.refresh)
+	}
+	return t.access, nil
+}
+
+// bearer adds the access token of the source to requests.
+type bearer struct {
+	ts   TokenSource
+	base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (b *bearer) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := b.ts.Token(req.Context())
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	res, err := b.send(req, req.Body, token)
+	// Bodies can only be replayed with GetBody.
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if err != nil || res.StatusCode != http.StatusUnauthorized || !replayable {
+		return res, err
+	}
+	if token, err = b.ts.Refresh(req.Context()); err != nil {
+		// Keep the 401 response, the caller maps it to ErrUnauthorized.
+		return res, nil
+	}
+	body := req.Body
+	if req.GetBody != nil {
+		if body, err = req.GetBody(); err != nil {
+			res.Body.Close()
+			return nil, errors.Wrap(err, "replaying request body")
+		}
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4<<10))
+	res.Body.Close()
+	return b.send(req, body, token)
+}
+
+func (b *bearer) send(req *http.Request, body io.ReadCloser, token string) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Body = body
+	r.Header.Set("Authorization", "Bearer "+token)
+	return b.base.RoundTrip(r)
+}
+
+func closeBody(r *http.Request) {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+}
+
+// roundTripper adapts a function to the http.RoundTripper interface.
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,217 @@
+// Package client is a Go client of the invoice API.
+//
+//	c, err := client.New("https://127.0.0.1:8443", client.Options{
+//		Auth: client.Digest("go", "time"),
+//	})
+//	customer, err := c.CreateCustomer(ctx, domain.Customer{Name: "3skills"})
+//
+// Unsuccessful responses are returned as *Error and match the sentinel
+// errors, e.g. errors.Is(err, client.ErrNotFound).
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Defaults of the options.
+const (
+	DefaultRetries = 2
+	DefaultBackoff = 100 * time.Millisecond
+)
+
+// Options configure a client.
+type Options struct {
+	// HTTPClient makes the requests, a client of http.DefaultTransport when
+	// nil. Its transport is wrapped by Auth.
+	HTTPClient *http.Client
+	// Auth authorizes the requests, e.g. Bearer, Digest or APIKey.
+	Auth Auth
+	// Retries is the number of retries of idempotent requests failing with
+	// network errors or the status 429, 502, 503 or 504. Negative values
+	// disable retries.
+	Retries int
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry, unless the server sent Retry-After.
+	Backoff time.Duration
+}
+
+// Client calls the invoice API.
+type Client struct {
+	base *url.URL
+	hc   *http.Client
+	opts Options
+}
+
+// New instantiates a client of the API at the base URL.
+func New(baseURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing base URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("base URL %q is not an http(s) URL", baseURL)
+	}
+	if opts.Retries == 0 {
+		opts.Retries = DefaultRetries
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	hc := &http.Client{}
+	if opts.HTTPClient != nil {
+		c := *opts.HTTPClient
+		hc = &c
+	}
+	if opts.Auth != nil {
+		base := hc.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		hc.Transport = opts.Auth(base)
+	}
+	return &Client{base: u, hc: hc, opts: opts}, nil
+}
+
+// Do sends a request to the path, relative to the base URL, with in
+// encoded as JSON body unless nil. The JSON response body is decoded into
+// out unless nil, or copied to out if it is an io.Writer. The returned
+// response has its body closed.
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) (*http.Response, error) {
+	return c.do(ctx, method, path, "application/json", in, out)
+}
+
+func (c *Client) do(ctx context.Context, method, path, accept string, in, out interface{}) (*http.Response, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, errors.Wrap(err, "encoding request body")
+		}
+	}
+	return c.send(ctx, method, path, accept, "application/json", body, out)
+}
+
+// send sends the body of the content type unless nil.
+func (c *Client) send(ctx context.Context, method, path, accept, contentType string, body []byte, out interface{}) (*http.Response, error) {
+	u, err := url.Parse(path)
+	if err == nil && !u.IsAbs() {
+		u, err = c.base.Parse(c.base.Path + path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing path %q", path)
+	}
+
+	attempts := 1
+	if idempotent(method) && c.opts.Retries > 0 {
+		attempts += c.opts.Retries
+	}
+	for i := 0; ; i++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating request")
+		}
+		req.Header.Set("Accept", accept)
+		if body != nil {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := c.hc.Do(req)
+		if i+1 < attempts && ctx.Err() == nil && retryable(res, err) {
+			d := c.backoff(i, res)
+			if res != nil {
+				_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4<<10))
+				res.Body.Close()
+			}
+			select {
+			case <-time.After(d):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return res, decode(res, out)
+	}
+}
+
+// backoff returns the delay before the retry.
+func (c *Client) backoff(retry int, res *http.Response) time.Duration {
+	if res != nil {
+		if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+	d := c.opts.Backoff << uint(retry)
+	// Jitter spreads the retries of concurrent clients.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// decode reads the response body into out, or returns the error of an
+// unsuccessful response. Bodies are copied to out when it is an io.Writer.
+func decode(res *http.Response, out interface{}) error {
+	defer res.Body.Close()
+	if w, ok := out.(io.Writer); ok && res.StatusCode < 400 {
+		_, err := io.Copy(w, res.Body)
+		return errors.Wrap(err, "reading response body")
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "reading response body")
+	}
+	if res.StatusCode >= 400 {
+		return newError(res, b)
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent || len(b) < 1 {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(b, out), "decoding response body")
+}
+
+// idempotent reports whether requests of the method can be repeated
+// safely (RFC 7231, section 4.2.2).
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// createdID returns the ID of a created resource, the last segment of the
+// Location header.
+func createdID(res *http.Response) (int, error) {
+	loc := res.Header.Get("Location")
+	id, err := strconv.Atoi(loc[strings.LastIndex(loc, "/")+1:])
+	if err != nil {
+		return 0, errors.Errorf("unexpected location %q", loc)
+	}
+	return id, nil
+}
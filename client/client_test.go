@@ -0,0 +1,393 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/client"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/stub/stubtest"
+	"github.com/tullo/invoice-mvp/rest"
+	"github.com/tullo/invoice-mvp/roles"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+// api serves the routes of the service with JWT tokens of the stub
+// identity provider, Digest auth of the user go and personal API keys.
+type api struct {
+	*httptest.Server
+	idp *stubtest.Server
+}
+
+func newAPI(t *testing.T) *api {
+	t.Helper()
+	idp := stubtest.Start(t)
+	idp.User.Roles = []string{rest.RoleAdmin, rest.RoleUser}
+	idp.AddUser(idp.User)
+	cfg := config.Default()
+	cfg.IDP = idp.Config()
+	cfg.Auth.Issuer = idp.Issuer()
+	cfg.Auth.Audience = idp.Client.ID
+	cfg.Auth.Schemes = []string{"jwt", "digest", "apikey"}
+	cfg.Auth.User = config.User{Username: "go", Password: "time"}
+
+	r := database.NewFakeRepository()
+	owners := idp.Provider().Users(cfg.IDP.APIKey, cfg.IDP.ClientID)
+	keys := rest.NewPersonalAPIKeys(usecase.NewAuthenticateAPIKey(r), owners)
+	kf := rest.KeyFunc(cfg.Auth.Algorithms, jwks.New(idp.KeySet, jwks.Options{}), nil)
+	chain, err := rest.NewAuthChain(cfg.Auth, kf, nil, keys)
+	require.NoError(t, err)
+	auth := chain.Authenticate
+	p := roles.DefaultPolicy()
+
+	a := rest.NewAdapter()
+	a.Handle("/apikeys", auth(p.Require(a.CreateAPIKeyHandler(usecase.NewCreateAPIKey(r)), roles.APIKeyWrite))).Methods("POST")
+	a.Handle("/activities", auth(p.Require(a.ActivitiesHandler(usecase.NewActivities(r)), roles.ActivityRead))).Methods("GET")
+	a.Handle("/activities", auth(p.Require(a.CreateActivityHandler(usecase.NewCreateActivity(r)), roles.ActivityWrite))).Methods("POST")
+	a.Handle("/book/{invoiceId:[0-9]+}", auth(p.Require(a.CreateBookingHandler(usecase.NewCreateBooking(r)), roles.BookingWrite, roles.InvoiceOwner(r)))).Methods("POST")
+	a.Handle("/invoices/{invoiceId:[0-9]+}/bookings", auth(p.Require(a.ImportBookingsHandler(usecase.NewImportBookings(r)), roles.BookingWrite, roles.InvoiceOwner(r)))).Methods("POST")
+	a.Handle("/timesheets/{format}", auth(p.Require(a.ImportTimesheetHandler(usecase.NewImportTimesheet(r)), roles.BookingWrite, roles.Self))).Methods("POST")
+	a.Handle("/invoices/{invoiceId:[0-9]+}/bookings/{bookingId:[0-9]+}", auth(p.Require(a.DeleteBookingHandler(usecase.NewDeleteBooking(r)), roles.BookingWrite, roles.InvoiceOwner(r)))).Methods("DELETE")
+	a.Handle("/customers", auth(p.Require(a.CreateCustomerHandler(usecase.NewCreateCustomer(r)), roles.CustomerWrite))).Methods("POST")
+	a.Handle("/customers/{customerId:[0-9]+}", auth(p.Require(a.UpdateCustomerHandler(usecase.NewUpdateCustomer(r)), roles.CustomerWrite, roles.CustomerOwner(r)))).Methods("PUT")
+	a.Handle("/profile", auth(p.Require(a.ProfileHandler(usecase.NewGetProfile(r)), roles.ProfileRead, roles.Self))).Methods("GET")
+	a.Handle("/profile", auth(p.Require(a.SaveProfileHandler(usecase.NewSaveProfile(r)), roles.ProfileWrite, roles.Self))).Methods("PUT")
+	a.Handle("/customers/{customerId:[0-9]+}/invoices", auth(p.Require(a.CreateInvoiceHandler(usecase.NewCreateInvoice(r)), roles.InvoiceWrite, roles.CustomerOwner(r)))).Methods("POST")
+	a.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", auth(p.Require(a.UpdateInvoiceHandler(usecase.NewUpdateInvoice(r)), roles.InvoiceWrite, roles.InvoiceOwner(r)))).Methods("PUT")
+	a.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", auth(p.Require(a.GetInvoiceHandler(usecase.NewGetInvoice(r)), roles.InvoiceRead, roles.InvoiceOwner(r)))).Methods("GET")
+	a.Handle("/charge/{invoiceId:[0-9]+}", auth(p.Require(a.ChargeInvoiceHandler(usecase.NewChargeInvoice(r)), roles.InvoiceCharge, roles.InvoiceOwner(r)))).Methods("POST")
+	a.Handle("/payment/{invoiceId:[0-9]+}", auth(p.Require(a.PayInvoiceHandler(usecase.NewPayInvoice(r)), roles.InvoicePayment, roles.InvoiceOwner(r)))).Methods("POST")
+	a.Handle("/customers/{customerId:[0-9]+}/projects", auth(p.Require(a.CreateProjectHandler(usecase.NewCreateProject(r)), roles.ProjectWrite, roles.CustomerOwner(r)))).Methods("POST")
+	a.Handle("/customers/{customerId:[0-9]+}/projects/{projectId:[0-9]+}/rates", auth(p.Require(a.CreateRateHandler(usecase.NewCreateRate(r)), roles.RateWrite, roles.CustomerOwner(r)))).Methods("POST")
+	a.Handle("/invoices", auth(p.Require(a.InvoicesHandler(usecase.NewInvoices(r)), roles.InvoiceRead, roles.Self))).Methods("GET")
+	a.Handle("/exports/bookings", auth(p.Require(a.ExportBookingsHandler(usecase.NewExportBookings(r)), roles.InvoiceRead, roles.Self))).Methods("GET")
+
+	srv := httptest.NewServer(a.R)
+	t.Cleanup(srv.Close)
+	return &api{Server: srv, idp: idp}
+}
+
+// login returns a client authenticated with the tokens of the stub user.
+func (s *api) login(t *testing.T, accessToken string) *client.Client {
+	t.Helper()
+	auth, err := s.idp.Login()
+	require.NoError(t, err)
+	if len(accessToken) < 1 {
+		accessToken = auth.AccessToken
+	}
+	ts := client.NewRefreshTokens(s.idp.Provider().Endpoints().Token, s.idp.Client.ID, s.idp.Client.Secret, accessToken, auth.RefreshToken)
+	c, err := client.New(s.URL, client.Options{Auth: client.Bearer(ts)})
+	require.NoError(t, err)
+	return c
+}
+
+func TestClient(t *testing.T) {
+	s := newAPI(t)
+	c := s.login(t, "")
+	ctx := context.Background()
+
+	cu, err := c.CreateCustomer(ctx, domain.Customer{Name: "3skills"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, cu.ID)
+	p, err := c.CreateProject(ctx, domain.Project{CustomerID: cu.ID, Name: "Instanfoo.com"})
+	require.NoError(t, err)
+	a, err := c.CreateActivity(ctx, domain.Activity{Name: "Programming"})
+	require.NoError(t, err)
+	_, err = c.CreateRate(ctx, cu.ID, domain.Rate{ProjectID: p.ID, ActivityID: a.ID, Price: 60})
+	require.NoError(t, err)
+	as, err := c.Activities(ctx)
+	require.NoError(t, err)
+	if assert.Len(t, as, 1) {
+		assert.Equal(t, "Programming", as[0].Name)
+	}
+
+	inv, err := c.CreateInvoice(ctx, domain.Invoice{CustomerID: cu.ID, Month: 11, Year: 2020})
+	require.NoError(t, err)
+	b, err := c.Book(ctx, inv.ID, domain.Booking{Day: 31, Hours: 2.5, Description: "Front: bugfix #6789", ProjectID: p.ID, ActivityID: a.ID})
+	require.NoError(t, err)
+	assert.Equal(t, 1, b.ID)
+
+	got, err := c.Invoice(ctx, cu.ID, inv.ID, "bookings")
+	require.NoError(t, err)
+	assert.Equal(t, "open", got.Status)
+	assert.Len(t, got.Bookings, 1)
+	assert.Contains(t, got.Links, "charge")
+
+	var csv bytes.Buffer
+	require.NoError(t, c.InvoiceExport(ctx, cu.ID, inv.ID, client.CSV, &csv))
+	assert.Contains(t, csv.String(), ",3skills,1,Instanfoo.com,Programming,2.5,,Front: bugfix #6789\n")
+	var xlsx bytes.Buffer
+	require.NoError(t, c.ExportBookings(ctx, "2020-11", "", client.XLSX, &xlsx))
+	assert.Equal(t, "PK", xlsx.String()[:2], "zip archive")
+
+	require.NoError(t, c.DeleteBooking(ctx, inv.ID, b.ID))
+	require.NoError(t, c.Charge(ctx, got.Invoice))
+	is, err := c.Invoices(ctx, "payment expected")
+	require.NoError(t, err)
+	if assert.Len(t, is, 1) {
+		assert.Equal(t, inv.ID, is[0].ID)
+		assert.False(t, is[0].Updated.IsZero())
+	}
+	is, err = c.Invoices(ctx, "open")
+	require.NoError(t, err)
+	assert.Empty(t, is)
+
+	// Users may only read their own invoices.
+	other, err := client.New(s.URL, client.Options{Auth: client.Digest("go", "time")})
+	require.NoError(t, err)
+	_, err = other.Invoice(ctx, cu.ID, inv.ID)
+	assert.True(t, errors.Is(err, client.ErrForbidden), "%v", err)
+}
+
+func TestClientImportBookings(t *testing.T) {
+	s := newAPI(t)
+	c := s.login(t, "")
+	ctx := context.Background()
+
+	cu, err := c.CreateCustomer(ctx, domain.Customer{Name: "3skills"})
+	require.NoError(t, err)
+	_, err = c.CreateProject(ctx, domain.Project{CustomerID: cu.ID, Name: "Instanfoo.com"})
+	require.NoError(t, err)
+	_, err = c.CreateActivity(ctx, domain.Activity{Name: "Programming"})
+	require.NoError(t, err)
+	inv, err := c.CreateInvoice(ctx, domain.Invoice{CustomerID: cu.ID, Month: 11, Year: 2020})
+	require.NoError(t, err)
+
+	opts := client.ImportOptions{
+		Mapping:    map[string]string{"date": "Datum", "hours": "Stunden"},
+		DateFormat: "02.01.2006",
+		Delimiter:  ';',
+	}
+	csv := "Datum;project;activity;Stunden;description\n30.11.2020;Instanfoo.com;Programming;2,5;Review\n"
+	bs, err := c.ImportBookings(ctx, inv.ID, "text/csv", []byte(csv), opts)
+	require.NoError(t, err)
+	if assert.Len(t, bs, 1) {
+		assert.Equal(t, float32(2.5), bs[0].Hours)
+	}
+
+	_, err = c.ImportBookings(ctx, inv.ID, "text/csv", []byte(csv+"31.11.2020;Instanfoo.com;Testing;1;\n"), opts)
+	var e *client.Error
+	require.True(t, errors.As(err, &e), "%v", err)
+	assert.Equal(t, http.StatusUnprocessableEntity, e.StatusCode)
+	assert.Equal(t, []client.RecordError{
+		{Line: 3, Field: "date", Message: `invalid date "31.11.2020", expected layout 02.01.2006`},
+		{Line: 3, Field: "activity", Message: `unknown activity "Testing"`},
+	}, e.Errors)
+}
+
+func TestClientFollow(t *testing.T) {
+	s := newAPI(t)
+	c := s.login(t, "")
+	ctx := context.Background()
+
+	cu, err := c.CreateCustomer(ctx, domain.Customer{Name: "3skills"})
+	require.NoError(t, err)
+	inv, err := c.CreateInvoice(ctx, domain.Invoice{CustomerID: cu.ID})
+	require.NoError(t, err)
+	got, err := c.Invoice(ctx, cu.ID, inv.ID)
+	require.NoError(t, err)
+
+	res, err := c.Follow(ctx, got, "book", domain.Booking{Day: 1, Hours: 1, Description: "Review"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "/book/1/bookings/1", res.Header.Get("Location"))
+
+	_, err = c.Follow(ctx, got, "payment", nil, nil)
+	assert.True(t, errors.Is(err, client.ErrNoLink), "%v", err)
+	res, err = c.Follow(ctx, got, "charge", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	_, err = c.Follow(ctx, got, "charge", nil, nil)
+	assert.True(t, errors.Is(err, client.ErrConflict), "charged twice: %v", err)
+
+	got, err = c.Invoice(ctx, cu.ID, inv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "payment expected", got.Status)
+	res, err = c.Follow(ctx, got, "payment", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	got, err = c.Invoice(ctx, cu.ID, inv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "paid", got.Status)
+}
+
+func TestClientAuth(t *testing.T) {
+	s := newAPI(t)
+	ctx := context.Background()
+
+	// An invalid access token is refreshed.
+	c := s.login(t, "header.payload.signature")
+	_, err := c.CreateActivity(ctx, domain.Activity{Name: "Programming"})
+	assert.NoError(t, err)
+
+	// A static token can not be refreshed.
+	c, err = client.New(s.URL, client.Options{Auth: client.Bearer(client.StaticToken("header.payload.signature"))})
+	require.NoError(t, err)
+	_, err = c.Activities(ctx)
+	assert.True(t, errors.Is(err, client.ErrUnauthorized), "%v", err)
+
+	// The digest user may create customers but not projects.
+	c, err = client.New(s.URL, client.Options{Auth: client.Digest("go", "time")})
+	require.NoError(t, err)
+	cu, err := c.CreateCustomer(ctx, domain.Customer{Name: "3skills"})
+	require.NoError(t, err)
+	_, err = c.CreateProject(ctx, domain.Project{CustomerID: cu.ID, Name: "Instanfoo.com"})
+	assert.True(t, errors.Is(err, client.ErrForbidden), "%v", err)
+
+	// API keys of the user.
+	var key struct {
+		Key string `json:"key"`
+	}
+	_, err = s.login(t, "").Do(ctx, "POST", "/apikeys", map[string]interface{}{"name": "ci", "scopes": []string{"activity:read"}}, &key)
+	require.NoError(t, err)
+	c, err = client.New(s.URL, client.Options{Auth: client.APIKey(key.Key)})
+	require.NoError(t, err)
+	_, err = c.Activities(ctx)
+	assert.NoError(t, err)
+	_, err = c.CreateActivity(ctx, domain.Activity{Name: "Programming"})
+	assert.True(t, errors.Is(err, client.ErrForbidden), "%v", err)
+}
+
+func TestClientRetries(t *testing.T) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&n, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"id": 1, "name": "Programming"}]`))
+	}))
+	defer srv.Close()
+	c, err := client.New(srv.URL, client.Options{Backoff: time.Millisecond})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	as, err := c.Activities(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Activity{{ID: 1, Name: "Programming"}}, as)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&n))
+
+	// Requests that are not idempotent are sent once.
+	atomic.StoreInt32(&n, 0)
+	_, err = c.CreateActivity(ctx, domain.Activity{Name: "Programming"})
+	assert.True(t, errors.Is(err, client.ErrServer), "%v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+
+	// Retries give up.
+	atomic.StoreInt32(&n, -10)
+	_, err = c.Activities(ctx)
+	assert.True(t, errors.Is(err, client.ErrServer), "%v", err)
+	assert.Equal(t, int32(-7), atomic.LoadInt32(&n))
+}
+
+func TestError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/activities" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"type": "https://invoice.mvp/problems/duplicate", "title": "Duplicate activity", "detail": "Programming exists"}`))
+			return
+		}
+		http.Error(w, "no such invoice", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c, err := client.New(srv.URL, client.Options{})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = c.CreateActivity(ctx, domain.Activity{Name: "Programming"})
+	var e *client.Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, http.StatusConflict, e.StatusCode)
+		assert.Equal(t, "https://invoice.mvp/problems/duplicate", e.Type)
+		assert.Equal(t, "409 Conflict: Duplicate activity: Programming exists", e.Error())
+	}
+	assert.True(t, errors.Is(err, client.ErrConflict))
+	assert.True(t, errors.Is(err, &client.Error{StatusCode: http.StatusConflict, Type: "https://invoice.mvp/problems/duplicate"}))
+	assert.False(t, errors.Is(err, client.ErrNotFound))
+
+	_, err = c.Invoice(ctx, 1, 1)
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+	assert.EqualError(t, err, "404 Not Found: no such invoice")
+}
+
+func TestClientImportTimesheet(t *testing.T) {
+	s := newAPI(t)
+	c := s.login(t, "")
+	ctx := context.Background()
+
+	clockify := "Project,Client,Description,Task,Start Date,Duration (decimal)\n" +
+		"Instanfoo.com,3skills,Review,Programming,11/30/2020,2.50\n"
+	_, err := c.ImportTimesheet(ctx, "clockify", []byte(clockify), client.TimesheetOptions{})
+	var e *client.Error
+	require.True(t, errors.As(err, &e), "%v", err)
+	assert.Equal(t, http.StatusUnprocessableEntity, e.StatusCode)
+	assert.Len(t, e.Errors, 2)
+
+	plan, err := c.ImportTimesheet(ctx, "clockify", []byte(clockify), client.TimesheetOptions{Create: true})
+	require.NoError(t, err)
+	assert.False(t, plan.DryRun)
+	require.Len(t, plan.Bookings, 1)
+	assert.Equal(t, float32(2.5), plan.Bookings[0].Hours)
+	assert.Equal(t, "2020-11", plan.Bookings[0].Period)
+	is, err := c.Invoices(ctx, "open")
+	require.NoError(t, err)
+	assert.Len(t, is, 1)
+}
+
+func TestClientEInvoice(t *testing.T) {
+	s := newAPI(t)
+	c := s.login(t, "")
+	ctx := context.Background()
+
+	cu, err := c.CreateCustomer(ctx, domain.Customer{Name: "3skills"})
+	require.NoError(t, err)
+	p, err := c.CreateProject(ctx, domain.Project{CustomerID: cu.ID, Name: "Instanfoo.com"})
+	require.NoError(t, err)
+	a, err := c.CreateActivity(ctx, domain.Activity{Name: "Programming"})
+	require.NoError(t, err)
+	_, err = c.CreateRate(ctx, cu.ID, domain.Rate{ProjectID: p.ID, ActivityID: a.ID, Price: 60})
+	require.NoError(t, err)
+	inv, err := c.CreateInvoice(ctx, domain.Invoice{CustomerID: cu.ID, Month: 11, Year: 2020})
+	require.NoError(t, err)
+	_, err = c.Book(ctx, inv.ID, domain.Booking{Day: 30, Hours: 8, ProjectID: p.ID, ActivityID: a.ID})
+	require.NoError(t, err)
+	require.NoError(t, c.Charge(ctx, inv))
+
+	var doc bytes.Buffer
+	err = c.InvoiceExport(ctx, cu.ID, inv.ID, client.UBL, &doc)
+	var e *client.Error
+	require.True(t, errors.As(err, &e), "%v", err)
+	assert.Equal(t, http.StatusUnprocessableEntity, e.StatusCode)
+	assert.Contains(t, e.Violations, client.Violation{Rule: "BR-06", Message: "seller name missing"})
+
+	require.NoError(t, c.SaveProfile(ctx, domain.Profile{
+		Name:    "Tullo & Partner",
+		Address: domain.Address{City: "Hamburg", PostCode: "20095", CountryCode: "DE"},
+		VATID:   "DE123456789", ContactName: "Andreas Tullo", Phone: "+49 40 123456", Email: "at@tullo.example",
+		IBAN: "DE02120300000000202051", VATRate: 19, PaymentTermDays: 14,
+	}))
+	profile, err := c.Profile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "Tullo & Partner", profile.Name)
+	cu.Address = &domain.Address{City: "Kiel", PostCode: "24103", CountryCode: "DE"}
+	cu.Email, cu.BuyerReference = "billing@3skills.example", "04011000-12345-34"
+	updated, err := c.UpdateCustomer(ctx, cu)
+	require.NoError(t, err)
+	assert.Equal(t, "04011000-12345-34", updated.BuyerReference)
+
+	require.NoError(t, c.InvoiceExport(ctx, cu.ID, inv.ID, client.CII+client.XRechnung, &doc))
+	assert.Contains(t, doc.String(), "<ram:DuePayableAmount>571.20</ram:DuePayableAmount>")
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors matching the *Error of a response status with errors.Is.
+// ErrServer matches all 5xx responses.
+var (
+	ErrBadRequest      = &Error{StatusCode: http.StatusBadRequest}
+	ErrUnauthorized    = &Error{StatusCode: http.StatusUnauthorized}
+	ErrForbidden       = &Error{StatusCode: http.StatusForbidden}
+	ErrNotFound        = &Error{StatusCode: http.StatusNotFound}
+	ErrNotAcceptable   = &Error{StatusCode: http.StatusNotAcceptable}
+	ErrConflict        = &Error{StatusCode: http.StatusConflict}
+	ErrTooManyRequests = &Error{StatusCode: http.StatusTooManyRequests}
+	ErrServer          = &Error{StatusCode: http.StatusInternalServerError}
+)
+
+// Error is an unsuccessful response of the API. The problem details of
+// RFC 7807 are decoded when the response is of type
+// application/problem+json, other bodies are kept as Detail.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Type       string `json:"type,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+	Instance   string `json:"instance,omitempty"`
+	// Errors lists the invalid records of a rejected import.
+	Errors []RecordError `json:"errors,omitempty"`
+	// Violations lists the business rules a rejected electronic invoice
+	// violates.
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// RecordError is an invalid field of an imported record.
+type RecordError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Violation is a violated business rule of an electronic invoice, e.g.
+// "BR-DE-15" of XRechnung.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func newError(res *http.Response, body []byte) *Error {
+	e := &Error{StatusCode: res.StatusCode}
+	mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mt == "application/problem+json" && json.Unmarshal(body, e) == nil {
+		e.StatusCode = res.StatusCode
+		return e
+	}
+	if len(body) > 512 {
+		body = body[:512]
+	}
+	e.Detail = strings.TrimSpace(string(body))
+	return e
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	s := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if len(e.Title) > 0 {
+		s += ": " + e.Title
+	}
+	if len(e.Detail) > 0 {
+		s += ": " + e.Detail
+	}
+	return s
+}
+
+// Is reports whether the target is the sentinel error of the status.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	if t == ErrServer {
+		return e.StatusCode >= 500
+	}
+	return t.StatusCode == e.StatusCode && (len(t.Type) < 1 || t.Type == e.Type)
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoLink is returned by Follow when the resource has no link of the
+// relation, e.g. an invoice that can no longer be charged.
+var ErrNoLink = errors.New("no link of the relation")
+
+// Link is a HAL link.
+type Link struct {
+	Href string `json:"href"`
+}
+
+// Resource is a HAL resource.
+type Resource interface {
+	Link(rel string) (Link, bool)
+}
+
+// methods of the invoice operations. HAL links carry no methods; relations
+// not listed are read with GET.
+var methods = map[string]string{
+	"book":    http.MethodPost,
+	"charge":  http.MethodPost,
+	"payment": http.MethodPost,
+	"cancel":  http.MethodDelete,
+	"archive": http.MethodPost,
+	"revoke":  http.MethodPost,
+}
+
+// Follow sends the request of the link relation of the resource, e.g.
+// Follow(ctx, invoice, "charge", nil, nil). In is encoded as JSON body and
+// the response body is decoded into out, unless nil.
+func (c *Client) Follow(ctx context.Context, r Resource, rel string, in, out interface{}) (*http.Response, error) {
+	l, ok := r.Link(rel)
+	if !ok || len(l.Href) < 1 {
+		return nil, errors.Wrap(ErrNoLink, rel)
+	}
+	method, ok := methods[rel]
+	if !ok {
+		method = http.MethodGet
+	}
+	return c.Do(ctx, method, l.Href, in, out)
+}
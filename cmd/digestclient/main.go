@@ -0,0 +1,72 @@
+// Command digestclient sends a request authenticated with the "Digest"
+// scheme. The credentials are the single user of the configuration of the
+// service, MVP_USERNAME and MVP_PASSWORD, e.g. of the .env file.
+//
+//	go run ./cmd/digestclient -data '{"name": "3skills"}' https://127.0.0.1:8443/customers
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/digest"
+)
+
+func main() {
+	log.SetFlags(0)
+	method := flag.String("X", "", "request method, POST with -data and GET otherwise")
+	data := flag.String("data", "", "request body")
+	contentType := flag.String("type", "application/json", "content type of the request body")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: digestclient [flags] url")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		log.Fatal("Error loading .env file: ", err)
+	}
+	cfg, err := config.Parse(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(*method) < 1 {
+		*method = "GET"
+		if len(*data) > 0 {
+			*method = "POST"
+		}
+	}
+	var body io.Reader
+	if len(*data) > 0 {
+		body = strings.NewReader(*data)
+	}
+	req, err := http.NewRequest(*method, flag.Arg(0), body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", *contentType)
+	}
+
+	t := digest.Transport{Username: cfg.Auth.User.Username, Password: cfg.Auth.User.Password}
+	res, err := t.Client().Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer res.Body.Close()
+	log.Println(res.Status)
+	if _, err := io.Copy(os.Stdout, res.Body); err != nil {
+		log.Fatal(err)
+	}
+}
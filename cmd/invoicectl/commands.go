@@ -0,0 +1,919 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/client"
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+// ===== CONFIG ===============================================================
+
+// configure implements the "config" command.
+func (a *app) configure(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: invoicectl config show | set <key> <value> | use <profile>")
+	}
+	switch args[0] {
+	case "show":
+		p := *a.profile
+		p.AccessToken, p.RefreshToken = "", ""
+		if len(p.ClientSecret) > 0 {
+			p.ClientSecret = "***"
+		}
+		loggedIn := len(a.profile.AccessToken) > 0
+		out := struct {
+			Name     string `json:"profile"`
+			LoggedIn bool   `json:"loggedIn"`
+			*Profile
+		}{a.name, loggedIn, &p}
+		return a.print(out, func(w io.Writer) {
+			fmt.Fprintf(w, "profile\t%s\n", a.name)
+			fmt.Fprintf(w, "logged in\t%t\n", loggedIn)
+			fmt.Fprintf(w, "url\t%s\n", p.URL)
+			fmt.Fprintf(w, "discovery-url\t%s\n", p.DiscoveryURL)
+			fmt.Fprintf(w, "idp-url\t%s\n", p.IDPURL)
+			fmt.Fprintf(w, "client-id\t%s\n", p.ClientID)
+			fmt.Fprintf(w, "client-secret\t%s\n", p.ClientSecret)
+			fmt.Fprintf(w, "redirect-uri\t%s\n", p.RedirectURI)
+			fmt.Fprintf(w, "ca-file\t%s\n", p.CAFile)
+			fmt.Fprintf(w, "output\t%s\n", p.Output)
+		})
+	case "set":
+		if len(args) != 3 {
+			return errors.New("usage: invoicectl config set <key> <value>")
+		}
+		field, ok := settings[args[1]]
+		if !ok {
+			return errors.Errorf("unknown key %q, one of %s", args[1], settingKeys())
+		}
+		*field(a.profile) = args[2]
+	case "use":
+		if len(args) != 2 {
+			return errors.New("usage: invoicectl config use <profile>")
+		}
+		a.config.Current = args[1]
+		a.config.profile(args[1])
+	default:
+		return errors.Errorf("unknown config command %q", args[0])
+	}
+	return a.save()
+}
+
+// ===== CUSTOMERS AND PROJECTS ===============================================
+
+// customers implements the "customers" command.
+func (a *app) customers(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "update" {
+		return a.updateCustomer(ctx, args[1:])
+	}
+	if len(args) != 2 || args[0] != "create" {
+		return errors.New("usage: invoicectl customers create <name> | update -name <name> [flags] <id>")
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	cu, err := c.CreateCustomer(ctx, domain.Customer{Name: args[1]})
+	if err != nil {
+		return err
+	}
+	return a.printCustomer(cu)
+}
+
+// updateCustomer implements "customers update". It replaces the master
+// data of the customer, the fields without flag are cleared.
+func (a *app) updateCustomer(ctx context.Context, args []string) error {
+	const usage = "usage: invoicectl customers update -name <name> [-street <street>] [-city <city>] [-post-code <code>] [-country <code>] [-vat-id <id>] [-email <address>] [-buyer-reference <ref>] <id>"
+	fs := a.flags("customers update")
+	name := fs.String("name", "", "name of the customer")
+	var ad domain.Address
+	fs.StringVar(&ad.Street, "street", "", "street and number")
+	fs.StringVar(&ad.City, "city", "", "city")
+	fs.StringVar(&ad.PostCode, "post-code", "", "post code")
+	fs.StringVar(&ad.CountryCode, "country", "", "ISO 3166-1 country code, e.g. DE")
+	vatID := fs.String("vat-id", "", "VAT identifier, e.g. DE123456789")
+	email := fs.String("email", "", "email address for invoices")
+	ref := fs.String("buyer-reference", "", "reference of the buyer, e.g. the Leitweg-ID of public authorities")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || len(*name) < 1 {
+		return errors.New(usage)
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return errors.Errorf("invalid customer ID %q", fs.Arg(0))
+	}
+	cu := domain.Customer{ID: id, Name: *name, VATID: *vatID, Email: *email, BuyerReference: *ref}
+	if ad != (domain.Address{}) {
+		cu.Address = &ad
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	if cu, err = c.UpdateCustomer(ctx, cu); err != nil {
+		return err
+	}
+	return a.printCustomer(cu)
+}
+
+// printCustomer prints the customer with its master data.
+func (a *app) printCustomer(cu domain.Customer) error {
+	return a.print(cu, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tADDRESS\tVAT ID\tBUYER REFERENCE")
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", cu.ID, cu.Name, address(cu.Address), cu.VATID, cu.BuyerReference)
+	})
+}
+
+// address formats the address on one line.
+func address(ad *domain.Address) string {
+	if ad == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s, %s %s %s", ad.Street, ad.CountryCode, ad.PostCode, ad.City))
+}
+
+// projects implements the "projects" command.
+func (a *app) projects(ctx context.Context, args []string) error {
+	const usage = "usage: invoicectl projects create -customer <id> <name>"
+	if len(args) < 1 || args[0] != "create" {
+		return errors.New(usage)
+	}
+	fs := a.flags("projects create")
+	customer := fs.Int("customer", 0, "customer ID")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *customer < 1 {
+		return errors.New(usage)
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	p, err := c.CreateProject(ctx, domain.Project{CustomerID: *customer, Name: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	return a.print(p, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCUSTOMER\tNAME")
+		fmt.Fprintf(w, "%d\t%d\t%s\n", p.ID, p.CustomerID, p.Name)
+	})
+}
+
+// rates implements the "rates" command.
+func (a *app) rates(ctx context.Context, args []string) error {
+	const usage = "usage: invoicectl rates set -customer <id> -project <id> -activity <name> <price>"
+	if len(args) < 1 || args[0] != "set" {
+		return errors.New(usage)
+	}
+	fs := a.flags("rates set")
+	customer := fs.Int("customer", 0, "customer ID")
+	project := fs.Int("project", 0, "project ID")
+	activity := fs.String("activity", "", "activity name or ID")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *customer < 1 || *project < 1 {
+		return errors.New(usage)
+	}
+	price, err := strconv.ParseFloat(fs.Arg(0), 32)
+	if err != nil {
+		return errors.Errorf("invalid price %q", fs.Arg(0))
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	ac, err := findActivity(ctx, c, *activity)
+	if err != nil {
+		return err
+	}
+	r, err := c.CreateRate(ctx, *customer, domain.Rate{ProjectID: *project, ActivityID: ac.ID, Price: float32(price)})
+	if err != nil {
+		return err
+	}
+	return a.print(r, func(w io.Writer) {
+		fmt.Fprintln(w, "PROJECT\tACTIVITY\tPRICE")
+		fmt.Fprintf(w, "%d\t%s\t%.2f\n", r.ProjectID, ac.Name, r.Price)
+	})
+}
+
+// ===== SELLER ===============================================================
+
+// sellerSettings sets the fields of the seller profile by the key of
+// "seller set".
+var sellerSettings = map[string]func(p *domain.Profile, v string) error{
+	"name":          func(p *domain.Profile, v string) error { p.Name = v; return nil },
+	"street":        func(p *domain.Profile, v string) error { p.Address.Street = v; return nil },
+	"city":          func(p *domain.Profile, v string) error { p.Address.City = v; return nil },
+	"post-code":     func(p *domain.Profile, v string) error { p.Address.PostCode = v; return nil },
+	"country":       func(p *domain.Profile, v string) error { p.Address.CountryCode = v; return nil },
+	"vat-id":        func(p *domain.Profile, v string) error { p.VATID = v; return nil },
+	"tax-number":    func(p *domain.Profile, v string) error { p.TaxNumber = v; return nil },
+	"contact":       func(p *domain.Profile, v string) error { p.ContactName = v; return nil },
+	"phone":         func(p *domain.Profile, v string) error { p.Phone = v; return nil },
+	"email":         func(p *domain.Profile, v string) error { p.Email = v; return nil },
+	"iban":          func(p *domain.Profile, v string) error { p.IBAN = v; return nil },
+	"bic":           func(p *domain.Profile, v string) error { p.BIC = v; return nil },
+	"currency":      func(p *domain.Profile, v string) error { p.Currency = v; return nil },
+	"vat-exemption": func(p *domain.Profile, v string) error { p.VATExemption = v; return nil },
+	"vat-rate": func(p *domain.Profile, v string) error {
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return errors.Errorf("invalid vat rate %q", v)
+		}
+		p.VATRate = float32(f)
+		return nil
+	},
+	"payment-term": func(p *domain.Profile, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.Errorf("invalid payment term %q, expected days", v)
+		}
+		p.PaymentTermDays = n
+		return nil
+	},
+}
+
+func sellerKeys() string {
+	keys := make([]string, 0, len(sellerSettings))
+	for k := range sellerSettings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// seller implements the "seller" command, showing and setting the master
+// data of the current user issuing the electronic invoices.
+func (a *app) seller(ctx context.Context, args []string) error {
+	const usage = "usage: invoicectl seller show | set <key> <value> [<key> <value>...]"
+	if len(args) < 1 {
+		return errors.New(usage)
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	p, err := c.Profile(ctx)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "show":
+	case "set":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return errors.New(usage)
+		}
+		for i := 1; i < len(args); i += 2 {
+			set, ok := sellerSettings[args[i]]
+			if !ok {
+				return errors.Errorf("unknown key %q, one of %s", args[i], sellerKeys())
+			}
+			if err := set(&p, args[i+1]); err != nil {
+				return err
+			}
+		}
+		if err := c.SaveProfile(ctx, p); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("unknown seller command %q", args[0])
+	}
+	return a.print(p, func(w io.Writer) {
+		ad := p.Address
+		fmt.Fprintf(w, "name\t%s\n", p.Name)
+		fmt.Fprintf(w, "street\t%s\n", ad.Street)
+		fmt.Fprintf(w, "city\t%s\n", ad.City)
+		fmt.Fprintf(w, "post-code\t%s\n", ad.PostCode)
+		fmt.Fprintf(w, "country\t%s\n", ad.CountryCode)
+		fmt.Fprintf(w, "vat-id\t%s\n", p.VATID)
+		fmt.Fprintf(w, "tax-number\t%s\n", p.TaxNumber)
+		fmt.Fprintf(w, "contact\t%s\n", p.ContactName)
+		fmt.Fprintf(w, "phone\t%s\n", p.Phone)
+		fmt.Fprintf(w, "email\t%s\n", p.Email)
+		fmt.Fprintf(w, "iban\t%s\n", p.IBAN)
+		fmt.Fprintf(w, "bic\t%s\n", p.BIC)
+		fmt.Fprintf(w, "currency\t%s\n", p.Currency)
+		fmt.Fprintf(w, "vat-rate\t%g\n", p.VATRate)
+		fmt.Fprintf(w, "vat-exemption\t%s\n", p.VATExemption)
+		fmt.Fprintf(w, "payment-term\t%d\n", p.PaymentTermDays)
+	})
+}
+
+// ===== ACTIVITIES ===========================================================
+
+// activities implements the "activities" command.
+func (a *app) activities(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		args = []string{"list"}
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	var as []domain.Activity
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		if as, err = c.Activities(ctx); err != nil {
+			return err
+		}
+	case args[0] == "create" && len(args) == 2:
+		ac, err := c.CreateActivity(ctx, domain.Activity{Name: args[1]})
+		if err != nil {
+			return err
+		}
+		as = append(as, ac)
+	default:
+		return errors.New("usage: invoicectl activities list | create <name>")
+	}
+	return a.print(as, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME")
+		for _, ac := range as {
+			fmt.Fprintf(w, "%d\t%s\n", ac.ID, ac.Name)
+		}
+	})
+}
+
+// findActivity returns the activity of the current user with the name,
+// ignoring case, or the ID.
+func findActivity(ctx context.Context, c *client.Client, name string) (domain.Activity, error) {
+	if len(name) < 1 {
+		return domain.Activity{}, errors.New("activity is required")
+	}
+	as, err := c.Activities(ctx)
+	if err != nil {
+		return domain.Activity{}, err
+	}
+	id, _ := strconv.Atoi(name)
+	names := make([]string, 0, len(as))
+	for _, ac := range as {
+		if strings.EqualFold(ac.Name, name) || ac.ID == id {
+			return ac, nil
+		}
+		names = append(names, ac.Name)
+	}
+	return domain.Activity{}, errors.Errorf("unknown activity %q, one of: %s", name, strings.Join(names, ", "))
+}
+
+// ===== INVOICES =============================================================
+
+// invoices implements the "invoices" command.
+func (a *app) invoices(ctx context.Context, args []string) error {
+	if len(args) < 1 {
+		args = []string{"list"}
+	}
+	fs := a.flags("invoices " + args[0])
+	status := fs.String("status", "", "status of the listed invoices")
+	term := fs.Int("term", 14, "payment term in days")
+	customer := fs.Int("customer", 0, "customer ID")
+	now := time.Now()
+	month := fs.Int("month", int(now.Month()), "month of the new invoice")
+	year := fs.Int("year", now.Year(), "year of the new invoice")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+
+	is := make([]client.Invoice, 0)
+	switch args[0] {
+	case "list":
+		if is, err = c.Invoices(ctx, *status); err != nil {
+			return err
+		}
+	case "overdue":
+		all, err := c.Invoices(ctx, "payment expected")
+		if err != nil {
+			return err
+		}
+		for _, i := range all {
+			if due(i.Invoice, *term).Before(now) {
+				is = append(is, i)
+			}
+		}
+	case "show":
+		if fs.NArg() != 1 {
+			return errors.New("usage: invoicectl invoices show <id>")
+		}
+		i, err := a.invoice(ctx, c, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if i, err = c.Invoice(ctx, i.CustomerID, i.ID, "bookings"); err != nil {
+			return err
+		}
+		return a.printInvoice(i, *term)
+	case "create":
+		if *customer < 1 {
+			return errors.New("usage: invoicectl invoices create -customer <id> [-month <month>] [-year <year>]")
+		}
+		i, err := c.CreateInvoice(ctx, domain.Invoice{CustomerID: *customer, Month: *month, Year: *year, Status: "open"})
+		if err != nil {
+			return err
+		}
+		is = append(is, client.Invoice{Invoice: i})
+	default:
+		return errors.Errorf("unknown invoices command %q", args[0])
+	}
+	return a.print(is, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tCUSTOMER\tPERIOD\tSTATUS\tDUE")
+		for _, i := range is {
+			var d string
+			if i.Status == "payment expected" {
+				d = due(i.Invoice, *term).Format("2006-01-02")
+			}
+			fmt.Fprintf(w, "%d\t%d\t%d-%02d\t%s\t%s\n", i.ID, i.CustomerID, i.Year, i.Month, i.Status, d)
+		}
+	})
+}
+
+// printInvoice prints the invoice with its positions and bookings.
+func (a *app) printInvoice(i client.Invoice, term int) error {
+	return a.print(i, func(w io.Writer) {
+		fmt.Fprintf(w, "ID\t%d\n", i.ID)
+		fmt.Fprintf(w, "CUSTOMER\t%d\n", i.CustomerID)
+		fmt.Fprintf(w, "PERIOD\t%d-%02d\n", i.Year, i.Month)
+		fmt.Fprintf(w, "STATUS\t%s\n", i.Status)
+		if i.Status == "payment expected" {
+			fmt.Fprintf(w, "DUE\t%s\n", due(i.Invoice, term).Format("2006-01-02"))
+		}
+		if len(i.Positions) > 0 {
+			fmt.Fprintln(w, "\nPROJECT\tACTIVITY\tHOURS\tPRICE")
+			pids := make([]int, 0, len(i.Positions))
+			for pid := range i.Positions {
+				pids = append(pids, pid)
+			}
+			sort.Ints(pids)
+			for _, pid := range pids {
+				names := make([]string, 0, len(i.Positions[pid]))
+				for name := range i.Positions[pid] {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					p := i.Positions[pid][name]
+					fmt.Fprintf(w, "%d\t%s\t%.2f\t%.2f\n", pid, name, p.Hours, p.Price)
+				}
+			}
+		}
+		if len(i.Bookings) > 0 {
+			fmt.Fprintln(w, "\nBOOKING\tDAY\tHOURS\tPROJECT\tACTIVITY\tDESCRIPTION")
+			for _, b := range i.Bookings {
+				fmt.Fprintf(w, "%d\t%d\t%.2f\t%d\t%d\t%s\n", b.ID, b.Day, b.Hours, b.ProjectID, b.ActivityID, b.Description)
+			}
+		}
+	})
+}
+
+// due returns the due date of a charged invoice, the payment term after
+// the charge.
+func due(i domain.Invoice, term int) time.Time {
+	return i.Updated.AddDate(0, 0, term)
+}
+
+// invoice returns the invoice of the current user with the ID.
+func (a *app) invoice(ctx context.Context, c *client.Client, id string) (client.Invoice, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return client.Invoice{}, errors.Errorf("invalid invoice ID %q", id)
+	}
+	is, err := c.Invoices(ctx, "")
+	if err != nil {
+		return client.Invoice{}, err
+	}
+	for _, i := range is {
+		if i.ID == n {
+			return i, nil
+		}
+	}
+	return client.Invoice{}, errors.Wrapf(client.ErrNotFound, "invoice %d", n)
+}
+
+// charge implements the "charge" command.
+func (a *app) charge(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: invoicectl charge <invoice id>")
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	i, err := a.invoice(ctx, c, args[0])
+	if err != nil {
+		return err
+	}
+	if i.Status != "open" {
+		return errors.Errorf("invoice %d is %s", i.ID, i.Status)
+	}
+	if err := c.Charge(ctx, i.Invoice); err != nil {
+		return err
+	}
+	if i, err = c.Invoice(ctx, i.CustomerID, i.ID); err != nil {
+		return err
+	}
+	return a.printInvoice(i, 14)
+}
+
+// pdf implements the "pdf" command.
+func (a *app) pdf(ctx context.Context, args []string) error {
+	fs := a.flags("pdf")
+	out := fs.String("out", "", `output file, "invoice-<id>.pdf" when empty and standard output for "-"`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: invoicectl pdf [-out <file>] <invoice id>")
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	i, err := a.invoice(ctx, c, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(*out) < 1 {
+		*out = fmt.Sprintf("invoice-%d.pdf", i.ID)
+	}
+	return a.writeFile(*out, "PDF", func(w io.Writer) error {
+		return c.InvoicePDF(ctx, i.CustomerID, i.ID, w)
+	})
+}
+
+// export implements the "export" command.
+func (a *app) export(ctx context.Context, args []string) error {
+	fs := a.flags("export")
+	invoice := fs.String("invoice", "", "invoice ID, the invoices of the period when empty")
+	from := fs.String("from", "", "first month of the period, e.g. 2020-01, the current one when empty")
+	to := fs.String("to", "", "last month of the period, the first one when empty")
+	format := fs.String("format", "xlsx", "csv or xlsx")
+	out := fs.String("out", "", `output file, named after the invoice or period when empty and standard output for "-"`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New("usage: invoicectl export [-invoice <id> | -from <month> [-to <month>]] [-format csv|xlsx] [-out <file>]")
+	}
+	var mediaType string
+	switch *format {
+	case "csv":
+		mediaType = client.CSV
+	case "xlsx":
+		mediaType = client.XLSX
+	default:
+		return errors.Errorf("unknown format %q, expected csv or xlsx", *format)
+	}
+	if len(*to) < 1 {
+		*to = *from
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	if len(*invoice) > 0 {
+		i, err := a.invoice(ctx, c, *invoice)
+		if err != nil {
+			return err
+		}
+		if len(*out) < 1 {
+			*out = fmt.Sprintf("invoice-%d.%s", i.ID, *format)
+		}
+		return a.writeFile(*out, "export", func(w io.Writer) error {
+			return c.InvoiceExport(ctx, i.CustomerID, i.ID, mediaType, w)
+		})
+	}
+	if len(*out) < 1 {
+		period := *from
+		if len(period) < 1 {
+			period = time.Now().Format("2006-01")
+		}
+		if len(*to) > 0 && *to != period {
+			period += "-" + *to
+		}
+		*out = fmt.Sprintf("bookings-%s.%s", period, *format)
+	}
+	return a.writeFile(*out, "export", func(w io.Writer) error {
+		return c.ExportBookings(ctx, *from, *to, mediaType, w)
+	})
+}
+
+// einvoice implements the "einvoice" command.
+func (a *app) einvoice(ctx context.Context, args []string) error {
+	fs := a.flags("einvoice")
+	syntax := fs.String("syntax", "ubl", "ubl or cii")
+	xrechnung := fs.Bool("xrechnung", false, "conform to XRechnung, required by German public authorities")
+	out := fs.String("out", "", `output file, "invoice-<id>.xml" when empty and standard output for "-"`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: invoicectl einvoice [-syntax ubl|cii] [-xrechnung] [-out <file>] <invoice id>")
+	}
+	var mediaType string
+	switch *syntax {
+	case "ubl":
+		mediaType = client.UBL
+	case "cii":
+		mediaType = client.CII
+	default:
+		return errors.Errorf("unknown syntax %q, expected ubl or cii", *syntax)
+	}
+	if *xrechnung {
+		mediaType += client.XRechnung
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	i, err := a.invoice(ctx, c, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(*out) < 1 {
+		*out = fmt.Sprintf("invoice-%d.xml", i.ID)
+	}
+	err = a.writeFile(*out, "e-invoice", func(w io.Writer) error {
+		return c.InvoiceExport(ctx, i.CustomerID, i.ID, mediaType, w)
+	})
+	a.printViolations(err)
+	return err
+}
+
+// printViolations prints the violated business rules of a rejected
+// electronic invoice.
+func (a *app) printViolations(err error) {
+	var e *client.Error
+	if !errors.As(err, &e) || len(e.Violations) < 1 {
+		return
+	}
+	w := tabwriter.NewWriter(a.stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tVIOLATION")
+	for _, v := range e.Violations {
+		fmt.Fprintf(w, "%s\t%s\n", v.Rule, v.Message)
+	}
+	_ = w.Flush()
+}
+
+// writeFile writes the file, or standard output for "-". The file is
+// removed again when write fails.
+func (a *app) writeFile(name, what string, write func(w io.Writer) error) error {
+	if name == "-" {
+		return write(a.stdout)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return errors.Wrapf(err, "creating %s file", what)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "writing %s file", what)
+	}
+	fmt.Fprintln(a.stderr, "Wrote", name)
+	return nil
+}
+
+// ===== BOOKINGS =============================================================
+
+// book implements the "book" command.
+func (a *app) book(ctx context.Context, args []string) error {
+	const usage = "usage: invoicectl book [-invoice <id>] [-customer <id>] -project <id> -activity <name> [-date <yyyy-mm-dd>] <duration> <description>"
+	fs := a.flags("book")
+	invoice := fs.Int("invoice", 0, "invoice ID, the open invoice of the month of the date when 0")
+	customer := fs.Int("customer", 0, "customer ID of the invoice, any customer when 0")
+	project := fs.Int("project", 0, "project ID")
+	activity := fs.String("activity", "", "activity name or ID")
+	date := fs.String("date", time.Now().Format("2006-01-02"), "day of the booking")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 || *project < 1 {
+		return errors.New(usage)
+	}
+	day, err := time.Parse("2006-01-02", *date)
+	if err != nil {
+		return errors.Errorf("invalid date %q, expected yyyy-mm-dd", *date)
+	}
+	hours, err := parseHours(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	ac, err := findActivity(ctx, c, *activity)
+	if err != nil {
+		return err
+	}
+	if *invoice < 1 {
+		if *invoice, err = openInvoice(ctx, c, *customer, day); err != nil {
+			return err
+		}
+	}
+	b, err := c.Book(ctx, *invoice, domain.Booking{
+		Day:         day.Day(),
+		Hours:       hours,
+		Description: fs.Arg(1),
+		ProjectID:   *project,
+		ActivityID:  ac.ID,
+	})
+	if err != nil {
+		return err
+	}
+	return a.print(b, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tINVOICE\tDAY\tHOURS\tACTIVITY\tDESCRIPTION")
+		fmt.Fprintf(w, "%d\t%d\t%s\t%.2f\t%s\t%s\n", b.ID, b.InvoiceID, day.Format("2006-01-02"), b.Hours, ac.Name, b.Description)
+	})
+}
+
+// importBookings implements the "import" command.
+func (a *app) importBookings(ctx context.Context, args []string) error {
+	const usage = "usage: invoicectl import -invoice <id> [-map <field>:<column>,...] [-date-format <layout>] [-delimiter <char>] <file>"
+	fs := a.flags("import")
+	invoice := fs.Int("invoice", 0, "invoice ID")
+	mapping := fs.String("map", "", `columns of the fields, e.g. "date:Datum,hours:Stunden"`)
+	layout := fs.String("date-format", "", `Go layout of the dates, e.g. "02.01.2006"`)
+	delimiter := fs.String("delimiter", "", "field delimiter of CSV")
+	contentType := fs.String("type", "", "content type, taken from the file extension when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *invoice < 1 {
+		return errors.New(usage)
+	}
+	if len(*contentType) < 1 {
+		switch strings.ToLower(filepath.Ext(fs.Arg(0))) {
+		case ".csv":
+			*contentType = "text/csv"
+		case ".jsonl", ".ndjson":
+			*contentType = "application/jsonl"
+		default:
+			return errors.Errorf("unknown type of %s, set -type", fs.Arg(0))
+		}
+	}
+	opts := client.ImportOptions{DateFormat: *layout, Mapping: make(map[string]string)}
+	if len(*delimiter) > 0 {
+		opts.Delimiter, _ = utf8.DecodeRuneInString(*delimiter)
+	}
+	for _, m := range strings.Split(*mapping, ",") {
+		if f, col, ok := strings.Cut(m, ":"); ok {
+			opts.Mapping[f] = col
+		} else if len(m) > 0 {
+			return errors.Errorf("invalid mapping %q, expected <field>:<column>", m)
+		}
+	}
+	b, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return errors.Wrap(err, "reading timesheet")
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	bs, err := c.ImportBookings(ctx, *invoice, *contentType, b, opts)
+	if err != nil {
+		a.printRecordErrors(err)
+		return err
+	}
+	return a.print(bs, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tINVOICE\tDAY\tHOURS\tPROJECT\tACTIVITY\tDESCRIPTION")
+		for _, b := range bs {
+			fmt.Fprintf(w, "%d\t%d\t%d\t%.2f\t%d\t%d\t%s\n", b.ID, b.InvoiceID, b.Day, b.Hours, b.ProjectID, b.ActivityID, b.Description)
+		}
+	})
+}
+
+// timesheet implements the "timesheet" command.
+func (a *app) timesheet(ctx context.Context, args []string) error {
+	const usage = "usage: invoicectl timesheet -format toggl|harvest|clockify [-create] [-dry-run] [-activity <name>] <file.csv>"
+	fs := a.flags("timesheet")
+	format := fs.String("format", "", "tool of the export: toggl, harvest or clockify")
+	var opts client.TimesheetOptions
+	fs.BoolVar(&opts.Create, "create", false, "create missing customers, projects, activities and invoices")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "only show what the import would create")
+	fs.StringVar(&opts.Activity, "activity", "", "activity of entries without task")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || len(*format) < 1 {
+		return errors.New(usage)
+	}
+	b, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return errors.Wrap(err, "reading export")
+	}
+	c, err := a.api()
+	if err != nil {
+		return err
+	}
+	plan, err := c.ImportTimesheet(ctx, *format, b, opts)
+	if err != nil {
+		a.printRecordErrors(err)
+		return err
+	}
+	return a.print(plan, func(w io.Writer) {
+		for _, cu := range plan.Customers {
+			fmt.Fprintf(w, "customer\t%s\t%s\n", created(cu.ID, plan.DryRun), cu.Name)
+		}
+		for _, p := range plan.Projects {
+			fmt.Fprintf(w, "project\t%s\t%s\n", created(p.ID, plan.DryRun), p.Name)
+		}
+		for _, ac := range plan.Activities {
+			fmt.Fprintf(w, "activity\t%s\t%s\n", created(ac.ID, plan.DryRun), ac.Name)
+		}
+		for _, i := range plan.Invoices {
+			fmt.Fprintf(w, "invoice\t%s\t%d-%02d\n", created(i.ID, plan.DryRun), i.Year, i.Month)
+		}
+		if len(plan.Customers)+len(plan.Projects)+len(plan.Activities)+len(plan.Invoices) > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, "LINE\tINVOICE\tPERIOD\tDAY\tHOURS\tCUSTOMER\tPROJECT\tACTIVITY\tDESCRIPTION")
+		for _, b := range plan.Bookings {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%.2f\t%s\t%s\t%s\t%s\n", b.Line, created(b.InvoiceID, plan.DryRun), b.Period, b.Day, b.Hours, b.Customer, b.Project, b.Activity, b.Description)
+		}
+	})
+}
+
+// created returns the ID of a created record, or "new" for one planned in
+// a dry run.
+func created(id int, dryRun bool) string {
+	if id < 1 && dryRun {
+		return "new"
+	}
+	return strconv.Itoa(id)
+}
+
+// printRecordErrors prints the invalid records of a rejected import.
+func (a *app) printRecordErrors(err error) {
+	var e *client.Error
+	if !errors.As(err, &e) || len(e.Errors) < 1 {
+		return
+	}
+	w := tabwriter.NewWriter(a.stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tFIELD\tERROR")
+	for _, re := range e.Errors {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", re.Line, re.Field, re.Message)
+	}
+	_ = w.Flush()
+}
+
+// openInvoice returns the ID of the only open invoice of the month of the
+// day, of the customer unless 0.
+func openInvoice(ctx context.Context, c *client.Client, customer int, day time.Time) (int, error) {
+	is, err := c.Invoices(ctx, "open")
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for _, i := range is {
+		if i.Year == day.Year() && i.Month == int(day.Month()) && (customer < 1 || i.CustomerID == customer) {
+			ids = append(ids, i.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return 0, errors.Errorf("no open invoice of %s, create one with invoicectl invoices create", day.Format("2006-01"))
+	case 1:
+		return ids[0], nil
+	}
+	return 0, errors.Errorf("%d open invoices of %s, select one with -invoice or -customer", len(ids), day.Format("2006-01"))
+}
+
+// parseHours parses a duration in hours, e.g. "3.5", or a Go duration,
+// e.g. "3h30m".
+func parseHours(s string) (float32, error) {
+	h, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		d, derr := time.ParseDuration(s)
+		if derr != nil {
+			return 0, errors.Errorf("invalid duration %q, e.g. 3.5, 3.5h or 90m", s)
+		}
+		h = d.Hours()
+	}
+	if h <= 0 || h > 24 {
+		return 0, errors.Errorf("duration %q is not within a day", s)
+	}
+	return float32(h), nil
+}
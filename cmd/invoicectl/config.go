@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Defaults of new profiles.
+const (
+	defaultProfile     = "default"
+	defaultURL         = "https://127.0.0.1:8443"
+	defaultIDPURL      = "http://localhost:9011"
+	defaultClientID    = "invoice-mvp"
+	defaultRedirectURI = "http://127.0.0.1:8765/callback"
+)
+
+// Config is the file of the profiles, stored in the user's config dir
+// unless INVOICECTL_CONFIG names another file.
+type Config struct {
+	Current  string              `json:"current,omitempty"`
+	Profiles map[string]*Profile `json:"profiles"`
+}
+
+// Profile holds the API, the identity provider and the tokens of a login.
+type Profile struct {
+	URL string `json:"url"` // base URL of the API
+	// DiscoveryURL of an OpenID Connect provider. FusionAuth at IDPURL is
+	// used when empty.
+	DiscoveryURL string `json:"discoveryURL,omitempty"`
+	IDPURL       string `json:"idpURL,omitempty"`
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// RedirectURI of the browser login, a loopback URI registered with the
+	// client, e.g. "http://127.0.0.1:8765/callback".
+	RedirectURI string `json:"redirectURI"`
+	// CAFile is a PEM bundle of certificates trusted in addition to the
+	// system roots, e.g. the mkcert certificate of the local service.
+	CAFile string `json:"caFile,omitempty"`
+	Output string `json:"output,omitempty"` // table, json or yaml
+
+	TokenURL     string `json:"tokenURL,omitempty"`
+	AccessToken  string `json:"accessToken,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// settings of a profile by the key of "config set".
+var settings = map[string]func(p *Profile) *string{
+	"url":           func(p *Profile) *string { return &p.URL },
+	"discovery-url": func(p *Profile) *string { return &p.DiscoveryURL },
+	"idp-url":       func(p *Profile) *string { return &p.IDPURL },
+	"client-id":     func(p *Profile) *string { return &p.ClientID },
+	"client-secret": func(p *Profile) *string { return &p.ClientSecret },
+	"redirect-uri":  func(p *Profile) *string { return &p.RedirectURI },
+	"ca-file":       func(p *Profile) *string { return &p.CAFile },
+	"output":        func(p *Profile) *string { return &p.Output },
+}
+
+func settingKeys() string {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// configPath returns the path of the config file.
+func configPath() (string, error) {
+	if p := os.Getenv("INVOICECTL_CONFIG"); len(p) > 0 {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, "locating config dir")
+	}
+	return filepath.Join(dir, "invoicectl", "config.json"), nil
+}
+
+// loadConfig reads the config file, an empty config when it does not exist.
+func loadConfig(path string) (*Config, error) {
+	c := Config{Profiles: make(map[string]*Profile)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading config")
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.Wrapf(err, "parsing config %s", path)
+	}
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]*Profile)
+	}
+	return &c, nil
+}
+
+// save writes the config file, readable by the user only as it holds
+// tokens.
+func (c *Config) save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding config")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "creating config dir")
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return errors.Wrap(err, "writing config")
+	}
+	return errors.Wrap(os.Rename(tmp, path), "writing config")
+}
+
+// profile returns the named profile, a new one with the defaults if it
+// does not exist.
+func (c *Config) profile(name string) *Profile {
+	p, ok := c.Profiles[name]
+	if !ok {
+		p = &Profile{
+			URL:         defaultURL,
+			IDPURL:      defaultIDPURL,
+			ClientID:    defaultClientID,
+			RedirectURI: defaultRedirectURI,
+		}
+		c.Profiles[name] = p
+	}
+	return p
+}
+
+// httpClient returns a client trusting the certificates of the CA file.
+func (p *Profile) httpClient() (*http.Client, error) {
+	if len(p.CAFile) < 1 {
+		return &http.Client{}, nil
+	}
+	pem, err := ioutil.ReadFile(p.CAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading CA file")
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificates in CA file %s", p.CAFile)
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: t}, nil
+}
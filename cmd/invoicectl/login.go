@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+	"github.com/tullo/invoice-mvp/identityprovider/oidc"
+)
+
+const (
+	// loginTimeout limits the time the user has to log in.
+	loginTimeout = 5 * time.Minute
+	// deviceCodeGrantType is the grant type of the device authorization
+	// grant (RFC 8628).
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// openBrowser opens the URL in the browser of the user.
+var openBrowser = func(u string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u).Start()
+	}
+	return exec.Command("xdg-open", u).Start()
+}
+
+// tokenResponse is the response of the token endpoint.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Error        string `json:"error"`
+}
+
+// login implements the "login" command.
+func (a *app) login(ctx context.Context, args []string) error {
+	fs := a.flags("login")
+	device := fs.Bool("device", false, "log in on another device, e.g. over SSH")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	hc, err := a.profile.httpClient()
+	if err != nil {
+		return err
+	}
+	e, err := a.endpoints(ctx, hc)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+
+	var tr tokenResponse
+	if *device {
+		tr, err = a.deviceLogin(ctx, hc, e)
+	} else {
+		tr, err = a.browserLogin(ctx, hc, e)
+	}
+	if err != nil {
+		return err
+	}
+	a.profile.TokenURL = e.Token
+	a.profile.AccessToken = tr.AccessToken
+	a.profile.RefreshToken = tr.RefreshToken
+	if err := a.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stderr, "Logged in to profile %s.\n", a.name)
+	return nil
+}
+
+// logout implements the "logout" command.
+func (a *app) logout(ctx context.Context, args []string) error {
+	a.profile.AccessToken = ""
+	a.profile.RefreshToken = ""
+	return a.save()
+}
+
+// endpoints returns the endpoints of the identity provider of the profile.
+func (a *app) endpoints(ctx context.Context, hc *http.Client) (oidc.Endpoints, error) {
+	if len(a.profile.DiscoveryURL) > 0 {
+		p, err := oidc.Discover(ctx, a.profile.DiscoveryURL, hc)
+		if err != nil {
+			return oidc.Endpoints{}, err
+		}
+		return p.Endpoints(), nil
+	}
+	if len(a.profile.IDPURL) < 1 {
+		return oidc.Endpoints{}, errors.New("no identity provider, set idp-url or discovery-url")
+	}
+	return fusionauth.NewProvider(a.profile.IDPURL, "").Endpoints(), nil
+}
+
+// browserLogin logs in with the authorization code grant and PKCE. The
+// redirect to the loopback URI is received by a local listener.
+func (a *app) browserLogin(ctx context.Context, hc *http.Client, e oidc.Endpoints) (tokenResponse, error) {
+	redirect, err := url.Parse(a.profile.RedirectURI)
+	if err != nil || redirect.Scheme != "http" {
+		return tokenResponse{}, errors.Errorf("redirect URI %q is not a loopback http URI", a.profile.RedirectURI)
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	state, err := randomString()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	l, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return tokenResponse{}, errors.Wrap(err, "listening for the redirect")
+	}
+	codes := make(chan url.Values, 1)
+	srv := http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+			if r.URL.Path != redirect.Path || q.Get("state") != state {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintln(w, "Login finished, you may close this window.")
+			select {
+			case codes <- q:
+			default:
+			}
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(verifier))
+	q := make(url.Values)
+	q.Set("response_type", "code")
+	q.Set("client_id", a.profile.ClientID)
+	q.Set("redirect_uri", a.profile.RedirectURI)
+	q.Set("scope", "openid offline_access")
+	q.Set("state", state)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	q.Set("code_challenge_method", "S256")
+	u := e.Authorization + "?" + q.Encode()
+	fmt.Fprintf(a.stderr, "Opening the login page, visit it if no browser opens:\n\n  %s\n\n", u)
+	if err := openBrowser(u); err != nil {
+		fmt.Fprintln(a.stderr, "Opening browser:", err)
+	}
+
+	var res url.Values
+	select {
+	case res = <-codes:
+	case <-ctx.Done():
+		return tokenResponse{}, errors.Wrap(ctx.Err(), "waiting for the login")
+	}
+	if msg := res.Get("error"); len(msg) > 0 {
+		return tokenResponse{}, errors.Errorf("login failed: %s", msg)
+	}
+	form := make(url.Values)
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", res.Get("code"))
+	form.Set("redirect_uri", a.profile.RedirectURI)
+	form.Set("code_verifier", verifier)
+	tr, err := a.token(ctx, hc, e.Token, form)
+	if err != nil {
+		return tr, err
+	}
+	if len(tr.Error) > 0 {
+		return tr, errors.Errorf("login failed: %s", tr.Error)
+	}
+	return tr, nil
+}
+
+// deviceLogin logs in with the device authorization grant (RFC 8628): the
+// user enters a code on the verification page, e.g. on a phone, while the
+// token endpoint is polled.
+func (a *app) deviceLogin(ctx context.Context, hc *http.Client, e oidc.Endpoints) (tokenResponse, error) {
+	if len(e.DeviceAuthorization) < 1 {
+		return tokenResponse{}, errors.New("identity provider does not support device login")
+	}
+	form := make(url.Values)
+	form.Set("client_id", a.profile.ClientID)
+	form.Set("scope", "openid offline_access")
+	req, err := http.NewRequestWithContext(ctx, "POST", e.DeviceAuthorization, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenResponse{}, errors.Wrap(err, "creating device authorization request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := hc.Do(req)
+	if err != nil {
+		return tokenResponse{}, errors.Wrap(err, "requesting device authorization")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return tokenResponse{}, errors.Errorf("requesting device authorization: unexpected status %d", res.StatusCode)
+	}
+	var da struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		Interval                int    `json:"interval"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&da); err != nil {
+		return tokenResponse{}, errors.Wrap(err, "decoding device authorization")
+	}
+	fmt.Fprintf(a.stderr, "Visit %s and enter the code %s\n", da.VerificationURI, da.UserCode)
+	if len(da.VerificationURIComplete) > 0 {
+		fmt.Fprintf(a.stderr, "or visit %s\n", da.VerificationURIComplete)
+	}
+
+	interval := time.Duration(da.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	form = make(url.Values)
+	form.Set("grant_type", deviceCodeGrantType)
+	form.Set("device_code", da.DeviceCode)
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return tokenResponse{}, errors.Wrap(ctx.Err(), "waiting for the login")
+		}
+		tr, err := a.token(ctx, hc, e.Token, form)
+		if err != nil {
+			return tr, err
+		}
+		switch tr.Error {
+		case "":
+			return tr, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return tr, errors.Errorf("login failed: %s", tr.Error)
+		}
+	}
+}
+
+// token posts the grant of the form to the token endpoint. OAuth errors
+// are returned in the response.
+func (a *app) token(ctx context.Context, hc *http.Client, uri string, form url.Values) (tokenResponse, error) {
+	var tr tokenResponse
+	form.Set("client_id", a.profile.ClientID)
+	if len(a.profile.ClientSecret) > 0 {
+		form.Set("client_secret", a.profile.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tr, errors.Wrap(err, "creating token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := hc.Do(req)
+	if err != nil {
+		return tr, errors.Wrap(err, "requesting tokens")
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return tr, errors.Wrapf(err, "decoding token response of status %d", res.StatusCode)
+	}
+	if len(tr.Error) < 1 && len(tr.AccessToken) < 1 {
+		return tr, errors.New("token response without access token")
+	}
+	return tr, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "reading random bytes")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
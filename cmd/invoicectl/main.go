@@ -0,0 +1,191 @@
+// Command invoicectl drives the invoice workflow from a terminal: log in
+// with the identity provider, manage customers, projects, rates and
+// activities, book time, charge invoices, download their PDFs or
+// electronic invoices, export bookings as CSV or XLSX and list the overdue
+// ones.
+//
+//	invoicectl login
+//	invoicectl book -project 1 -activity Programming 3.5h "Front: bugfix #6789"
+//	invoicectl -o yaml invoices overdue
+//
+// Profiles are stored in the user's config dir, e.g.
+// ~/.config/invoicectl/config.json.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/client"
+)
+
+const usage = `usage: invoicectl [-profile name] [-o table|json|yaml] <command> [flags] [args]
+
+commands:
+  config      show | set <key> <value> | use <profile>
+  login       [-device]
+  logout
+  customers   create <name>
+              update -name <name> [-street <street>] [-city <city>]
+              [-post-code <code>] [-country <code>] [-vat-id <id>]
+              [-email <address>] [-buyer-reference <ref>] <id>
+  seller      show | set <key> <value> [<key> <value>...]
+  projects    create -customer <id> <name>
+  rates       set -customer <id> -project <id> -activity <name> <price>
+  activities  list | create <name>
+  invoices    list [-status <status>] | show <id> | overdue [-term <days>]
+              create -customer <id> [-month <month>] [-year <year>]
+  book        [-invoice <id>] [-customer <id>] -project <id> -activity <name>
+              [-date <yyyy-mm-dd>] <duration> <description>
+  import      -invoice <id> [-map <field>:<column>,...] [-date-format <layout>]
+              [-delimiter <char>] <file.csv|file.jsonl>
+  timesheet   -format toggl|harvest|clockify [-create] [-dry-run]
+              [-activity <name>] <export.csv>
+  charge      <invoice id>
+  pdf         [-out <file>] <invoice id>
+  einvoice    [-syntax ubl|cii] [-xrechnung] [-out <file>] <invoice id>
+  export      [-invoice <id> | -from <month> [-to <month>]] [-format csv|xlsx]
+              [-out <file>]
+
+customers update replaces the master data, flags left out are cleared.
+
+Durations are hours, e.g. 3.5, or Go durations, e.g. 3h30m or 90m.
+`
+
+// app holds the state of an invocation.
+type app struct {
+	path    string // of the config file
+	config  *Config
+	name    string // of the profile
+	profile *Profile
+	output  string
+
+	stdout io.Writer
+	stderr io.Writer
+	client *client.Client
+}
+
+// command implements a command with its arguments.
+type command func(a *app, ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"config":     (*app).configure,
+	"login":      (*app).login,
+	"logout":     (*app).logout,
+	"customers":  (*app).customers,
+	"seller":     (*app).seller,
+	"projects":   (*app).projects,
+	"rates":      (*app).rates,
+	"activities": (*app).activities,
+	"invoices":   (*app).invoices,
+	"book":       (*app).book,
+	"import":     (*app).importBookings,
+	"timesheet":  (*app).timesheet,
+	"charge":     (*app).charge,
+	"pdf":        (*app).pdf,
+	"einvoice":   (*app).einvoice,
+	"export":     (*app).export,
+}
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invoicectl:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command of the arguments.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("invoicectl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	profile := fs.String("profile", "", "profile, the current one when empty")
+	output := fs.String("o", "", "output format: table, json or yaml")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		return errors.Errorf("unknown command %q", fs.Arg(0))
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	a := app{path: path, config: c, name: *profile, stdout: stdout, stderr: stderr}
+	if len(a.name) < 1 {
+		a.name = c.Current
+	}
+	if len(a.name) < 1 {
+		a.name = defaultProfile
+	}
+	a.profile = c.profile(a.name)
+	a.output = *output
+	if len(a.output) < 1 {
+		a.output = a.profile.Output
+	}
+	switch a.output {
+	case "":
+		a.output = "table"
+	case "table", "json", "yaml":
+	default:
+		return errors.Errorf("unknown output format %q", a.output)
+	}
+	return cmd(&a, ctx, fs.Args()[1:])
+}
+
+// save stores the config file.
+func (a *app) save() error {
+	return a.config.save(a.path)
+}
+
+// api returns the client of the API, authenticated with the tokens of the
+// profile. Refreshed tokens are stored.
+func (a *app) api() (*client.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+	if len(a.profile.AccessToken) < 1 {
+		return nil, errors.Errorf("profile %s is not logged in, run invoicectl login", a.name)
+	}
+	hc, err := a.profile.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	ts := client.NewRefreshTokens(a.profile.TokenURL, a.profile.ClientID, a.profile.ClientSecret, a.profile.AccessToken, a.profile.RefreshToken)
+	ts.HTTPClient = hc
+	ts.Refreshed = func(access, refresh string) {
+		a.profile.AccessToken = access
+		a.profile.RefreshToken = refresh
+		if err := a.save(); err != nil {
+			fmt.Fprintln(a.stderr, "invoicectl: storing refreshed tokens:", err)
+		}
+	}
+	a.client, err = client.New(a.profile.URL, client.Options{HTTPClient: hc, Auth: client.Bearer(ts)})
+	return a.client, err
+}
+
+// flags returns a flag set of the subcommand writing to stderr.
+func (a *app) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	return fs
+}
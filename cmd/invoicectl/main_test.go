@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/client"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/stub/stubtest"
+	"github.com/tullo/invoice-mvp/rest"
+	"github.com/tullo/invoice-mvp/roles"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+// setup starts the stub identity provider and the API, and configures the
+// default profile of a temporary config file.
+func setup(t *testing.T) *stubtest.Server {
+	t.Helper()
+	idp := stubtest.Start(t)
+	idp.DeviceInterval = 1
+	idp.User.Roles = []string{rest.RoleAdmin, rest.RoleUser}
+	idp.AddUser(idp.User)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	redirect := "http://" + l.Addr().String() + "/callback"
+	l.Close()
+	idp.Client.RedirectURI = redirect
+	idp.AddClient(idp.Client)
+
+	cfg := config.Default()
+	cfg.Auth.Issuer = idp.Issuer()
+	cfg.Auth.Audience = idp.Client.ID
+	cfg.Auth.Schemes = []string{"jwt"}
+	kf := rest.KeyFunc(cfg.Auth.Algorithms, jwks.New(idp.KeySet, jwks.Options{}), nil)
+	chain, err := rest.NewAuthChain(cfg.Auth, kf, nil, nil)
+	require.NoError(t, err)
+	auth := chain.Authenticate
+	p := roles.DefaultPolicy()
+	r := database.NewFakeRepository()
+
+	a := rest.NewAdapter()
+	a.Handle("/activities", auth(p.Require(a.ActivitiesHandler(usecase.NewActivities(r)), roles.ActivityRead))).Methods("GET")
+	a.Handle("/activities", auth(p.Require(a.CreateActivityHandler(usecase.NewCreateActivity(r)), roles.ActivityWrite))).Methods("POST")
+	a.Handle("/book/{invoiceId:[0-9]+}", auth(p.Require(a.CreateBookingHandler(usecase.NewCreateBooking(r)), roles.BookingWrite, roles.InvoiceOwner(r)))).Methods("POST")
+	a.Handle("/invoices/{invoiceId:[0-9]+}/bookings", auth(p.Require(a.ImportBookingsHandler(usecase.NewImportBookings(r)), roles.BookingWrite, roles.InvoiceOwner(r)))).Methods("POST")
+	a.Handle("/timesheets/{format}", auth(p.Require(a.ImportTimesheetHandler(usecase.NewImportTimesheet(r)), roles.BookingWrite, roles.Self))).Methods("POST")
+	a.Handle("/customers", auth(p.Require(a.CreateCustomerHandler(usecase.NewCreateCustomer(r)), roles.CustomerWrite))).Methods("POST")
+	a.Handle("/customers/{customerId:[0-9]+}", auth(p.Require(a.UpdateCustomerHandler(usecase.NewUpdateCustomer(r)), roles.CustomerWrite, roles.CustomerOwner(r)))).Methods("PUT")
+	a.Handle("/profile", auth(p.Require(a.ProfileHandler(usecase.NewGetProfile(r)), roles.ProfileRead, roles.Self))).Methods("GET")
+	a.Handle("/profile", auth(p.Require(a.SaveProfileHandler(usecase.NewSaveProfile(r)), roles.ProfileWrite, roles.Self))).Methods("PUT")
+	a.Handle("/customers/{customerId:[0-9]+}/invoices", auth(p.Require(a.CreateInvoiceHandler(usecase.NewCreateInvoice(r)), roles.InvoiceWrite, roles.CustomerOwner(r)))).Methods("POST")
+	a.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", auth(p.Require(a.UpdateInvoiceHandler(usecase.NewUpdateInvoice(r)), roles.InvoiceWrite, roles.InvoiceOwner(r)))).Methods("PUT")
+	a.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", auth(p.Require(a.GetInvoiceHandler(usecase.NewGetInvoice(r)), roles.InvoiceRead, roles.InvoiceOwner(r)))).Methods("GET")
+	a.Handle("/customers/{customerId:[0-9]+}/projects", auth(p.Require(a.CreateProjectHandler(usecase.NewCreateProject(r)), roles.ProjectWrite, roles.CustomerOwner(r)))).Methods("POST")
+	a.Handle("/customers/{customerId:[0-9]+}/projects/{projectId:[0-9]+}/rates", auth(p.Require(a.CreateRateHandler(usecase.NewCreateRate(r)), roles.RateWrite, roles.CustomerOwner(r)))).Methods("POST")
+	a.Handle("/invoices", auth(p.Require(a.InvoicesHandler(usecase.NewInvoices(r)), roles.InvoiceRead, roles.Self))).Methods("GET")
+	a.Handle("/exports/bookings", auth(p.Require(a.ExportBookingsHandler(usecase.NewExportBookings(r)), roles.InvoiceRead, roles.Self))).Methods("GET")
+	srv := httptest.NewServer(a.R)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("INVOICECTL_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	for k, v := range map[string]string{
+		"url":           srv.URL,
+		"idp-url":       idp.URL,
+		"client-id":     idp.Client.ID,
+		"client-secret": idp.Client.Secret,
+		"redirect-uri":  redirect,
+	} {
+		invoicectl(t, "config", "set", k, v)
+	}
+	return idp
+}
+
+// invoicectl runs the command and returns its standard output.
+func invoicectl(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := runErr(args...)
+	require.NoError(t, err, "invoicectl %s", strings.Join(args, " "))
+	return out
+}
+
+func runErr(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, &stdout, &stderr)
+	return stdout.String(), err
+}
+
+// browser logs the stub user in on the login page.
+func browser(idp *stubtest.Server) func(string) error {
+	return func(u string) error {
+		loc, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		form := loc.Query()
+		form.Set("loginId", idp.User.LoginID)
+		form.Set("password", idp.User.Password)
+		// Follows the redirect to the listener of the command.
+		res, err := http.PostForm(idp.URL+loc.Path, form)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+}
+
+func TestWorkflow(t *testing.T) {
+	idp := setup(t)
+	openBrowser = browser(idp)
+
+	_, err := runErr("activities", "list")
+	assert.EqualError(t, err, "profile default is not logged in, run invoicectl login")
+	invoicectl(t, "login")
+
+	assert.Equal(t, "ID  NAME\n1   Programming\n", invoicectl(t, "activities", "create", "Programming"))
+	invoicectl(t, "customers", "create", "3skills")
+	invoicectl(t, "projects", "create", "-customer", "1", "Instanfoo.com")
+	invoicectl(t, "rates", "set", "-customer", "1", "-project", "1", "-activity", "programming", "60")
+	invoicectl(t, "invoices", "create", "-customer", "1", "-month", "11", "-year", "2020")
+
+	_, err = runErr("book", "-project", "1", "-activity", "Programming", "-date", "2020-12-01", "3.5h", "desc")
+	assert.EqualError(t, err, "no open invoice of 2020-12, create one with invoicectl invoices create")
+	_, err = runErr("book", "-project", "1", "-activity", "Testing", "-date", "2020-11-30", "1", "desc")
+	assert.EqualError(t, err, `unknown activity "Testing", one of: Programming`)
+	invoicectl(t, "book", "-project", "1", "-activity", "Programming", "-date", "2020-11-30", "3.5h", "Front: bugfix #6789")
+	invoicectl(t, "book", "-project", "1", "-activity", "1", "-date", "2020-11-30", "90m", "Review")
+
+	timesheet := filepath.Join(t.TempDir(), "timesheet.csv")
+	require.NoError(t, os.WriteFile(timesheet, []byte("Datum;project;activity;hours\n29.11.2020;Instanfoo.com;Programming;1\n"), 0600))
+	_, err = runErr("import", "-invoice", "1", timesheet)
+	assert.Error(t, err)
+	invoicectl(t, "import", "-invoice", "1", "-delimiter", ";", "-map", "date:Datum", "-date-format", "02.01.2006", timesheet)
+
+	var i client.Invoice
+	require.NoError(t, json.Unmarshal([]byte(invoicectl(t, "-o", "json", "invoices", "show", "1")), &i))
+	assert.Equal(t, "open", i.Status)
+	assert.Len(t, i.Bookings, 3)
+	assert.Equal(t, "[]\n", invoicectl(t, "-o", "json", "invoices", "overdue", "-term", "0"))
+
+	invoicectl(t, "charge", "1")
+	_, err = runErr("charge", "1")
+	assert.EqualError(t, err, "invoice 1 is payment expected")
+	out := invoicectl(t, "-o", "yaml", "invoices", "overdue", "-term", "0")
+	assert.Contains(t, out, "- id: 1\n  month: 11\n  year: 2020\n  status: payment expected\n")
+	assert.Contains(t, out, "positions:\n    \"1\":\n      Programming:\n        Hours: 6\n        Price: 360\n")
+	assert.Equal(t, "ID  CUSTOMER  PERIOD  STATUS  DUE\n", invoicectl(t, "invoices", "overdue"))
+
+	pdf := filepath.Join(t.TempDir(), "invoice.pdf")
+	invoicectl(t, "pdf", "-out", pdf, "1")
+	assert.FileExists(t, pdf)
+	_, err = runErr("pdf", "2")
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+
+	out = invoicectl(t, "export", "-invoice", "1", "-format", "csv", "-out", "-")
+	assert.Contains(t, out, "position,2020-11,,3skills,1,Instanfoo.com,Programming,6,360,\n")
+	xlsx := filepath.Join(t.TempDir(), "bookings.xlsx")
+	invoicectl(t, "export", "-from", "2020-11", "-out", xlsx)
+	assert.FileExists(t, xlsx)
+	_, err = runErr("export", "-format", "ods")
+	assert.EqualError(t, err, `unknown format "ods", expected csv or xlsx`)
+
+	invoicectl(t, "logout")
+	_, err = runErr("invoices")
+	assert.Error(t, err)
+}
+
+func TestTimesheet(t *testing.T) {
+	idp := setup(t)
+	openBrowser = browser(idp)
+	invoicectl(t, "login")
+
+	export := filepath.Join(t.TempDir(), "toggl.csv")
+	require.NoError(t, os.WriteFile(export, []byte("Client,Project,Task,Description,Start date,Duration\n"+
+		"3skills,Instanfoo.com,,Front: bugfix #6789,2020-11-30,01:30:00\n"), 0600))
+	_, err := runErr("timesheet", "-format", "toggl", export)
+	assert.Error(t, err)
+
+	out := invoicectl(t, "timesheet", "-format", "toggl", "-create", "-dry-run", "-activity", "Programming", export)
+	assert.Contains(t, out, "customer  new  3skills\n")
+	assert.Contains(t, out, "invoice   new  2020-11\n")
+	assert.Contains(t, out, "2     new      2020-11  30   1.50   3skills   Instanfoo.com  Programming  Front: bugfix #6789\n")
+	assert.Equal(t, "ID  NAME\n", invoicectl(t, "activities", "list"), "dry run creates nothing")
+
+	invoicectl(t, "timesheet", "-format", "toggl", "-create", "-activity", "Programming", export)
+	var is []client.Invoice
+	require.NoError(t, json.Unmarshal([]byte(invoicectl(t, "-o", "json", "invoices", "list")), &is))
+	if assert.Len(t, is, 1) {
+		assert.Equal(t, 2020, is[0].Year)
+	}
+}
+
+func TestEInvoice(t *testing.T) {
+	idp := setup(t)
+	openBrowser = browser(idp)
+	invoicectl(t, "login")
+
+	invoicectl(t, "activities", "create", "Programming")
+	invoicectl(t, "customers", "create", "3skills")
+	invoicectl(t, "projects", "create", "-customer", "1", "Instanfoo.com")
+	invoicectl(t, "rates", "set", "-customer", "1", "-project", "1", "-activity", "Programming", "60")
+	invoicectl(t, "invoices", "create", "-customer", "1", "-month", "11", "-year", "2020")
+	invoicectl(t, "book", "-project", "1", "-activity", "Programming", "-date", "2020-11-30", "8h", "Review")
+	invoicectl(t, "charge", "1")
+
+	_, err := runErr("einvoice", "-out", "-", "1")
+	var e *client.Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, http.StatusUnprocessableEntity, e.StatusCode)
+		assert.NotEmpty(t, e.Violations)
+	}
+	_, err = runErr("seller", "set", "vat-rate", "high")
+	assert.EqualError(t, err, `invalid vat rate "high"`)
+	_, err = runErr("seller", "set", "iban")
+	assert.Error(t, err)
+
+	out := invoicectl(t, "seller", "set", "name", "Go Invoicer", "street", "Hauptstr. 1", "city", "Berlin",
+		"post-code", "10115", "country", "DE", "vat-id", "DE123456789", "contact", "Andreas",
+		"phone", "+49 30 123456", "email", "billing@example.com", "iban", "DE02120300000000202051",
+		"vat-rate", "19", "payment-term", "14")
+	assert.Contains(t, out, "vat-rate       19\n")
+	assert.Contains(t, invoicectl(t, "seller", "show"), "iban           DE02120300000000202051\n")
+	invoicectl(t, "customers", "update", "-name", "3skills GmbH", "-street", "Marktplatz 2", "-city", "Hamburg",
+		"-post-code", "20095", "-country", "DE", "-email", "ap@3skills.example", "-buyer-reference", "04011000-1234512345-06", "1")
+
+	out = invoicectl(t, "einvoice", "-out", "-", "1")
+	assert.Contains(t, out, "<cbc:PayableAmount currencyID=\"EUR\">571.20</cbc:PayableAmount>")
+	out = invoicectl(t, "einvoice", "-syntax", "cii", "-xrechnung", "-out", "-", "1")
+	assert.Contains(t, out, "urn:xeinkauf.de:kosit:xrechnung_3.0")
+	assert.Contains(t, out, "3skills GmbH")
+	_, err = runErr("einvoice", "-syntax", "edifact", "1")
+	assert.EqualError(t, err, `unknown syntax "edifact", expected ubl or cii`)
+}
+
+// approver approves the device login announced on stderr.
+type approver struct {
+	idp *stubtest.Server
+	wg  sync.WaitGroup
+}
+
+var completeURI = regexp.MustCompile(`or visit (\S+)`)
+
+func (a *approver) Write(b []byte) (int, error) {
+	if m := completeURI.FindSubmatch(b); m != nil {
+		u, err := url.Parse(string(m[1]))
+		if err != nil {
+			return 0, err
+		}
+		form := u.Query()
+		form.Set("loginId", a.idp.User.LoginID)
+		form.Set("password", a.idp.User.Password)
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			if res, err := http.PostForm(a.idp.URL+u.Path, form); err == nil {
+				res.Body.Close()
+			}
+		}()
+	}
+	return len(b), nil
+}
+
+func TestDeviceLogin(t *testing.T) {
+	idp := setup(t)
+	a := approver{idp: idp}
+	require.NoError(t, run(context.Background(), []string{"login", "-device"}, os.Stdout, &a))
+	a.wg.Wait()
+
+	assert.Equal(t, "ID  NAME\n", invoicectl(t, "activities"))
+	// Refreshed tokens are stored.
+	invoicectl(t, "config", "set", "output", "json")
+	c, err := loadConfig(os.Getenv("INVOICECTL_CONFIG"))
+	require.NoError(t, err)
+	p := c.Profiles[defaultProfile]
+	p.AccessToken = "expired"
+	require.NoError(t, c.save(os.Getenv("INVOICECTL_CONFIG")))
+	assert.Equal(t, "[]\n", invoicectl(t, "activities"))
+	c, err = loadConfig(os.Getenv("INVOICECTL_CONFIG"))
+	require.NoError(t, err)
+	assert.NotEqual(t, "expired", c.Profiles[defaultProfile].AccessToken)
+	assert.NotEqual(t, p.RefreshToken, c.Profiles[defaultProfile].RefreshToken)
+}
+
+func TestParseHours(t *testing.T) {
+	for s, want := range map[string]float32{"3.5": 3.5, "3.5h": 3.5, "90m": 1.5, "1h15m": 1.25} {
+		h, err := parseHours(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, h, s)
+	}
+	for _, s := range []string{"", "x", "0", "-1h", "25h"} {
+		_, err := parseHours(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"go.yaml.in/yaml/v3"
+)
+
+// print writes v in the output format. Tables are written by table.
+func (a *app) print(v interface{}, table func(w io.Writer)) error {
+	switch a.output {
+	case "json":
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "encoding JSON")
+		}
+		_, err = fmt.Fprintf(a.stdout, "%s\n", b)
+		return err
+	case "yaml":
+		return writeYAML(a.stdout, v)
+	}
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// writeYAML writes v as YAML with the keys of its JSON encoding, in the
+// same order.
+func writeYAML(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "encoding JSON")
+	}
+	// JSON is YAML, decoding it into a node keeps the order of the keys.
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return errors.Wrap(err, "decoding JSON")
+	}
+	blockStyle(&n)
+	e := yaml.NewEncoder(w)
+	e.SetIndent(2)
+	if err := e.Encode(&n); err != nil {
+		return errors.Wrap(err, "encoding YAML")
+	}
+	return e.Close()
+}
+
+// blockStyle drops the flow style and quotes of the JSON source.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
@@ -0,0 +1,71 @@
+// Command stubidp serves the stub identity provider on the address of the
+// local FusionAuth instance, so the service runs without docker. The client
+// and the user are taken from the flags, which default to the configuration
+// of the service, e.g. the .env file. Secrets have no flag, they are read
+// from CLIENT_SECRET, MVP_PASSWORD and FUSIONAUTH_API_KEY.
+//
+//	go run ./cmd/stubidp -client-id invoice-mvp -login-id go@invoice.mvp
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/identityprovider/stub"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		log.Fatal("Error loading .env file: ", err)
+	}
+	cfg, err := config.Parse(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addr := flag.String("addr", "localhost:9011", "listen address")
+	issuer := flag.String("issuer", cfg.IDP.Issuer, "token issuer (IDP_ISSUER)")
+	var c stub.Client
+	flag.StringVar(&c.ID, "client-id", cfg.IDP.ClientID, "OAuth2 client ID (CLIENT_ID)")
+	flag.StringVar(&c.RedirectURI, "redirect-uri", cfg.IDP.RedirectURI, "OAuth2 redirect URI (REDIRECT_URI)")
+	var u stub.User
+	flag.StringVar(&u.ID, "user-id", cfg.Auth.User.ID, "user ID (USER_ID)")
+	flag.StringVar(&u.LoginID, "login-id", cfg.Auth.User.Username, "login ID of the user (MVP_USERNAME)")
+	roles := flag.String("roles", rest.RoleAdmin+","+rest.RoleUser, "comma separated roles of the user")
+	flag.Parse()
+	c.Secret = cfg.IDP.ClientSecret
+	u.Password = cfg.Auth.User.Password
+	u.Roles = strings.Split(*roles, ",")
+
+	for _, v := range []struct{ name, value string }{
+		{"-issuer", *issuer},
+		{"-client-id", c.ID},
+		{"-redirect-uri", c.RedirectURI},
+		{"-user-id", u.ID},
+		{"-login-id", u.LoginID},
+		{"CLIENT_SECRET", c.Secret},
+		{"MVP_PASSWORD", u.Password},
+	} {
+		if len(v.value) < 1 {
+			log.Fatalf("%s is required", v.name)
+		}
+	}
+
+	idp, err := stub.New(*issuer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	idp.AddClient(c)
+	idp.AddUser(u)
+	idp.APIKey = cfg.IDP.APIKey
+
+	log.Printf("Stub identity provider of issuer %q listening on %s", *issuer, *addr)
+	log.Printf("Client %s, redirect URI %s", c.ID, c.RedirectURI)
+	log.Printf("User %s (%s)", u.LoginID, u.ID)
+	log.Fatal(http.ListenAndServe(*addr, idp))
+}
@@ -0,0 +1,358 @@
+// Package config holds the typed service configuration. It is loaded from
+// defaults, an optional JSON file, environment variables and command line
+// flags, each overriding the former, and validated once at startup.
+package config
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/secret"
+)
+
+// Config is the service configuration.
+type Config struct {
+	Server     Server     `json:"server"`
+	IDP        IDP        `json:"idp"`
+	Auth       Auth       `json:"auth"`
+	Sessions   Sessions   `json:"sessions"`
+	JWKS       JWKS       `json:"jwks"`
+	Repository Repository `json:"repository"`
+	Secrets    Secrets    `json:"secrets"`
+}
+
+// Server configures the HTTPS server.
+type Server struct {
+	Addr         string   `json:"addr"`
+	CertFile     string   `json:"certFile"`
+	KeyFile      string   `json:"keyFile"`
+	MinVersion   string   `json:"minVersion"`             // "1.2" or "1.3"
+	CipherSuites []string `json:"cipherSuites,omitempty"` // TLS 1.2 only, empty takes the Go defaults
+	// Dev serves a generated self-signed certificate when the certificate
+	// files do not exist.
+	Dev bool `json:"dev"`
+	// ReloadInterval is the period of checking the certificate and
+	// credential files for changes.
+	ReloadInterval Duration `json:"reloadInterval"`
+
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+	ReadTimeout       Duration `json:"readTimeout"`
+	WriteTimeout      Duration `json:"writeTimeout"`
+	IdleTimeout       Duration `json:"idleTimeout"`
+	ShutdownTimeout   Duration `json:"shutdownTimeout"`
+
+	// Optional mutual TLS: CA bundle of client certificates and the mapping
+	// of certificates to users.
+	ClientCAFile      string `json:"clientCAFile,omitempty"`
+	ClientCertMapping string `json:"clientCertMapping,omitempty"`
+}
+
+// IDP configures the identity provider and the OAuth2 client registered
+// with it.
+type IDP struct {
+	// DiscoveryURL of an OpenID Connect provider. FusionAuth at BaseURL is
+	// used when empty.
+	DiscoveryURL string `json:"discoveryURL,omitempty"`
+	BaseURL      string `json:"baseURL"`
+	Issuer       string `json:"issuer"`
+
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret"`
+	GrantType    string `json:"grantType"`
+	RedirectURI  string `json:"redirectURI"` // must match the IDP client config
+	// APIKey of the FusionAuth user API, to resolve the roles of the owners
+	// of personal API keys.
+	APIKey string `json:"apiKey,omitempty"`
+
+	// Endpoints default to the ones of the provider when empty.
+	AuthorizeURI     string `json:"authorizeURI,omitempty"`
+	TokenURI         string `json:"tokenURI,omitempty"`
+	RevokeURI        string `json:"revokeURI,omitempty"`
+	IntrospectionURI string `json:"introspectionURI,omitempty"`
+	// Introspection checks bearer tokens with the IDP on high-risk routes.
+	Introspection bool `json:"introspection"`
+}
+
+// Auth configures authentication and authorization.
+type Auth struct {
+	Realm      string   `json:"realm"`
+	Schemes    []string `json:"schemes"`    // e.g. jwt, apikey, basic, digest
+	Algorithms []string `json:"algorithms"` // accepted JWT signing algorithms
+	// Issuer and Audience of access tokens, taken from the IDP issuer and
+	// client ID when empty.
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+
+	Policy          string `json:"policy,omitempty"` // JSON policy file, built-in policy when empty
+	APIKeysFile     string `json:"apiKeysFile,omitempty"`
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	RevocationsFile string `json:"revocationsFile,omitempty"`
+
+	User User `json:"user"`
+}
+
+// User is the single user of the Basic and Digest schemes when no
+// credentials file is configured.
+type User struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	ID       string `json:"id,omitempty"` // claims subject, the username when empty
+}
+
+// Sessions configures browser sessions.
+type Sessions struct {
+	// Store is "memory" or the path of a session file. Sessions are
+	// disabled when empty.
+	Store string `json:"store,omitempty"`
+	// Key is the base64 encoded AES key of the session cookies. Without a
+	// key, a random one is used and sessions do not survive restarts.
+	Key string   `json:"key,omitempty"`
+	TTL Duration `json:"ttl"`
+}
+
+// JWKS configures the cache of the public signing keys of the IDP.
+type JWKS struct {
+	// File is a local JSON Web Key Set used instead of the keys of the IDP,
+	// e.g. of development keys.
+	File               string   `json:"file,omitempty"`
+	TTL                Duration `json:"ttl"`
+	RefreshInterval    Duration `json:"refreshInterval"`
+	MinRefreshInterval Duration `json:"minRefreshInterval"`
+	FetchTimeout       Duration `json:"fetchTimeout"`
+}
+
+// Secrets configures where secrets are read from. Secret settings left empty
+// by the other sources, e.g. the client secret, are looked up by the name of
+// their environment variable, e.g. "CLIENT_SECRET".
+type Secrets struct {
+	Provider string `json:"provider"`       // "env", "file" or "sops"
+	Path     string `json:"path,omitempty"` // directory of secret files or sops encrypted dotenv file
+	// Keyring names the secret of the HMAC keys of HS256 tokens, see
+	// secret.ParseKeyring.
+	Keyring string `json:"keyring"`
+}
+
+// Repository configures the storage backend.
+type Repository struct {
+	Backend string `json:"backend"` // "memory"
+}
+
+// Default returns the configuration used unless configured otherwise.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:              ":8443",
+			CertFile:          "localhost+2.pem",
+			KeyFile:           "localhost+2-key.pem",
+			MinVersion:        "1.2",
+			ReloadInterval:    Duration(10 * time.Second),
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(15 * time.Second),
+			WriteTimeout:      Duration(30 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(20 * time.Second),
+		},
+		IDP: IDP{
+			BaseURL:   "http://localhost:9011",
+			GrantType: "authorization_code",
+		},
+		Auth: Auth{
+			Realm:      "invoice.mvp",
+			Schemes:    []string{"jwt", "apikey"},
+			Algorithms: []string{"RS256"},
+		},
+		Sessions: Sessions{
+			TTL: Duration(8 * time.Hour),
+		},
+		JWKS: JWKS{
+			TTL:                Duration(jwks.DefaultTTL),
+			RefreshInterval:    Duration(jwks.DefaultRefreshInterval),
+			MinRefreshInterval: Duration(jwks.DefaultMinRefreshInterval),
+			FetchTimeout:       Duration(jwks.DefaultFetchTimeout),
+		},
+		Repository: Repository{Backend: "memory"},
+		Secrets: Secrets{
+			Provider: "env",
+			Keyring:  secret.DefaultKeyringName,
+		},
+	}
+}
+
+// Known values of the enumerated settings.
+var (
+	schemes    = []string{"basic", "digest", "jwt", "bearer", "apikey"}
+	algorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA", "HS256", "HS384", "HS512"}
+	backends   = []string{"memory"}
+)
+
+// Validate checks the configuration and reports all problems at once.
+func (c Config) Validate() error {
+	var problems []string
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	s := c.Server
+	if len(s.Addr) < 1 {
+		add("server.addr", "is required")
+	}
+	if !s.Dev && (len(s.CertFile) < 1 || len(s.KeyFile) < 1) {
+		add("server.certFile", "certificate and key files are required unless in dev mode")
+	}
+	if s.MinVersion != "1.2" && s.MinVersion != "1.3" {
+		add("server.minVersion", "%q is not supported, expected 1.2 or 1.3", s.MinVersion)
+	}
+	secure := make(map[string]bool)
+	for _, cs := range tls.CipherSuites() {
+		secure[cs.Name] = true
+	}
+	for _, name := range s.CipherSuites {
+		if !secure[name] {
+			add("server.cipherSuites", "unknown or insecure cipher suite %q", name)
+		}
+	}
+	positive := []struct {
+		field string
+		d     Duration
+	}{
+		{"server.reloadInterval", s.ReloadInterval},
+		{"server.readHeaderTimeout", s.ReadHeaderTimeout},
+		{"server.readTimeout", s.ReadTimeout},
+		{"server.writeTimeout", s.WriteTimeout},
+		{"server.idleTimeout", s.IdleTimeout},
+		{"server.shutdownTimeout", s.ShutdownTimeout},
+		{"sessions.ttl", c.Sessions.TTL},
+		{"jwks.ttl", c.JWKS.TTL},
+		{"jwks.refreshInterval", c.JWKS.RefreshInterval},
+		{"jwks.minRefreshInterval", c.JWKS.MinRefreshInterval},
+		{"jwks.fetchTimeout", c.JWKS.FetchTimeout},
+	}
+	for _, p := range positive {
+		if p.d <= 0 {
+			add(p.field, "must be positive, got %v", p.d)
+		}
+	}
+	if len(s.ClientCAFile) > 0 && len(s.ClientCertMapping) < 1 {
+		add("server.clientCertMapping", "is required with a client CA file")
+	}
+
+	i := c.IDP
+	if len(i.DiscoveryURL) < 1 && len(i.BaseURL) < 1 {
+		add("idp.baseURL", "is required without a discovery URL")
+	}
+	urls := []struct{ field, v string }{
+		{"idp.discoveryURL", i.DiscoveryURL},
+		{"idp.baseURL", i.BaseURL},
+		{"idp.redirectURI", i.RedirectURI},
+		{"idp.authorizeURI", i.AuthorizeURI},
+		{"idp.tokenURI", i.TokenURI},
+		{"idp.revokeURI", i.RevokeURI},
+		{"idp.introspectionURI", i.IntrospectionURI},
+	}
+	for _, u := range urls {
+		if len(u.v) < 1 {
+			continue
+		}
+		if pu, err := url.Parse(u.v); err != nil || !pu.IsAbs() || len(pu.Host) < 1 {
+			add(u.field, "%q is not an absolute URL", u.v)
+		}
+	}
+	if i.Introspection && (len(i.ClientID) < 1 || len(i.ClientSecret) < 1) {
+		add("idp.introspection", "requires the client ID and secret")
+	}
+
+	a := c.Auth
+	if len(a.Realm) < 1 {
+		add("auth.realm", "is required")
+	}
+	if len(a.Schemes) < 1 {
+		add("auth.schemes", "at least one scheme is required")
+	}
+	for _, sc := range a.Schemes {
+		sc = strings.ToLower(sc)
+		if !contains(schemes, sc) {
+			add("auth.schemes", "unknown scheme %q, expected one of %s", sc, strings.Join(schemes, ", "))
+		}
+		if (sc == "jwt" || sc == "bearer") && len(a.Issuer) < 1 {
+			add("auth.issuer", "is required for the %s scheme, set the IDP issuer", sc)
+		}
+	}
+	if len(a.Algorithms) < 1 {
+		add("auth.algorithms", "at least one algorithm is required")
+	}
+	for _, alg := range a.Algorithms {
+		if !contains(algorithms, alg) {
+			add("auth.algorithms", "unsupported algorithm %q, expected one of %s", alg, strings.Join(algorithms, ", "))
+		}
+	}
+
+	if len(c.Sessions.Key) > 0 {
+		k, err := base64.StdEncoding.DecodeString(c.Sessions.Key)
+		if err != nil {
+			add("sessions.key", "is not base64 encoded")
+		} else if len(k) != 16 && len(k) != 24 && len(k) != 32 {
+			add("sessions.key", "must be an AES key of 16, 24 or 32 bytes, got %d", len(k))
+		}
+	}
+
+	if _, err := secret.New(c.Secrets.Provider, c.Secrets.Path); err != nil {
+		add("secrets.provider", "%v", err)
+	}
+
+	if !contains(backends, c.Repository.Backend) {
+		add("repository.backend", "unknown backend %q, expected one of %s", c.Repository.Backend, strings.Join(backends, ", "))
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as string, e.g. "15m", in JSON files.
+type Duration time.Duration
+
+// String implements the flag.Value interface.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set implements the flag.Value interface.
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Wrap(err, "duration must be a string like \"15m\"")
+	}
+	return d.Set(s)
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
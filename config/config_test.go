@@ -0,0 +1,134 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/config"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParsePrecedence(t *testing.T) {
+	path := writeFile(t, `{
+		"server": {"addr": ":9443", "shutdownTimeout": "5s"},
+		"idp": {"issuer": "file.issuer", "clientID": "file-client"},
+		"auth": {"realm": "file.realm", "schemes": ["basic"]}
+	}`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("AUTH_REALM", "env.realm")
+	t.Setenv("AUTH_SCHEMES", "jwt, apikey")
+	t.Setenv("TLS_ADDR", ":7443")
+
+	c, err := config.Parse([]string{"-addr", ":6443", "-dev"})
+	assert.NoError(t, err)
+	// flags > env > file > defaults
+	assert.Equal(t, ":6443", c.Server.Addr)
+	assert.True(t, c.Server.Dev)
+	assert.Equal(t, "env.realm", c.Auth.Realm)
+	assert.Equal(t, []string{"jwt", "apikey"}, c.Auth.Schemes)
+	assert.Equal(t, 5*time.Second, time.Duration(c.Server.ShutdownTimeout))
+	assert.Equal(t, "file.issuer", c.IDP.Issuer)
+	assert.Equal(t, "localhost+2.pem", c.Server.CertFile)
+	// Derived from the IDP settings.
+	assert.Equal(t, "file.issuer", c.Auth.Issuer)
+	assert.Equal(t, "file-client", c.Auth.Audience)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := config.Parse([]string{"-config", writeFile(t, `{"server": {"adress": ":8443"}}`)})
+	assert.Error(t, err, "unknown fields are rejected")
+
+	_, err = config.Parse([]string{"-config", writeFile(t, `{"server": {"readTimeout": 15}}`)})
+	assert.Error(t, err, "durations are strings")
+
+	t.Setenv("SESSION_TTL", "forever")
+	_, err = config.Parse(nil)
+	assert.ErrorContains(t, err, "SESSION_TTL")
+}
+
+func TestParseSecretFlags(t *testing.T) {
+	_, err := config.Parse([]string{"-client-secret", "s3cr3t"})
+	assert.Error(t, err, "secrets are not accepted on the command line")
+}
+
+func TestValidate(t *testing.T) {
+	c := config.Default()
+	c.IDP.Issuer = "invoice.mvp"
+	c.Auth.Issuer = "invoice.mvp"
+	assert.NoError(t, c.Validate())
+
+	c.Server.MinVersion = "1.0"
+	c.Server.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	c.Server.ReadTimeout = 0
+	c.IDP.TokenURI = "/oauth2/token"
+	c.Auth.Schemes = []string{"jwt", "kerberos"}
+	c.Auth.Algorithms = []string{"none"}
+	c.Sessions.Key = "c2hvcnQ="
+	c.Repository.Backend = "postgres"
+	c.Secrets.Provider = "file"
+	err := c.Validate()
+	assert.Error(t, err)
+	for _, field := range []string{
+		"server.minVersion",
+		"server.cipherSuites",
+		"server.readTimeout",
+		"idp.tokenURI",
+		"auth.schemes",
+		"auth.algorithms",
+		"sessions.key",
+		"repository.backend",
+		"secrets.provider",
+	} {
+		assert.Contains(t, err.Error(), field)
+	}
+
+	// The issuer is required to verify tokens.
+	c = config.Default()
+	err = c.Validate()
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "auth.issuer"))
+}
+
+func TestLoad(t *testing.T) {
+	_, err := config.Load(nil)
+	assert.ErrorContains(t, err, "invalid configuration")
+
+	t.Setenv("IDP_ISSUER", "invoice.mvp")
+	c, err := config.Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "invoice.mvp", c.Auth.Issuer)
+}
+
+func TestLoadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "CLIENT_SECRET"), []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("IDP_ISSUER", "invoice.mvp")
+	t.Setenv("SECRETS_PROVIDER", "file")
+	t.Setenv("SECRETS_PATH", dir)
+	t.Setenv("CLIENT_SECRET", "") // restored after the test
+	os.Unsetenv("CLIENT_SECRET")
+	t.Setenv("MVP_PASSWORD", "from-env")
+
+	c, err := config.Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", c.IDP.ClientSecret)
+	// Settings present are not overridden.
+	assert.Equal(t, "from-env", c.Auth.User.Password)
+	// Missing secrets stay empty.
+	assert.Empty(t, c.Sessions.Key)
+}
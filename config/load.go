@@ -0,0 +1,248 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/identityprovider/secret"
+)
+
+// binding maps a setting to its environment variable and command line flag.
+// Secrets have no flag, command lines are visible to other users.
+type binding struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		{"TLS_ADDR", "addr", "listen address", (*stringValue)(&c.Server.Addr)},
+		{"TLS_CERT_FILE", "cert-file", "server certificate file", (*stringValue)(&c.Server.CertFile)},
+		{"TLS_KEY_FILE", "key-file", "server key file", (*stringValue)(&c.Server.KeyFile)},
+		{"TLS_MIN_VERSION", "tls-min-version", "minimum TLS version, 1.2 or 1.3", (*stringValue)(&c.Server.MinVersion)},
+		{"TLS_CIPHER_SUITES", "tls-cipher-suites", "comma separated TLS 1.2 cipher suites", (*listValue)(&c.Server.CipherSuites)},
+		{"TLS_DEV", "dev", "serve a generated self-signed certificate when the certificate files do not exist", (*boolValue)(&c.Server.Dev)},
+		{"TLS_RELOAD_INTERVAL", "tls-reload-interval", "period of checking the certificate and credential files for changes", &c.Server.ReloadInterval},
+		{"HTTP_READ_HEADER_TIMEOUT", "read-header-timeout", "time to read request headers", &c.Server.ReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", "read-timeout", "time to read requests", &c.Server.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", "write-timeout", "time to write responses", &c.Server.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", "idle-timeout", "time to keep idle connections open", &c.Server.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "time to drain requests on shutdown", &c.Server.ShutdownTimeout},
+		{"CLIENT_CA_FILE", "client-ca-file", "CA bundle of client certificates", (*stringValue)(&c.Server.ClientCAFile)},
+		{"CLIENT_CERT_MAPPING", "client-cert-mapping", "mapping of client certificates to users", (*stringValue)(&c.Server.ClientCertMapping)},
+
+		{"IDP_DISCOVERY_URL", "idp-discovery-url", "OpenID Connect discovery URL", (*stringValue)(&c.IDP.DiscoveryURL)},
+		{"FUSIONAUTH_URL", "fusionauth-url", "FusionAuth base URL", (*stringValue)(&c.IDP.BaseURL)},
+		{"IDP_ISSUER", "idp-issuer", "token issuer of the identity provider", (*stringValue)(&c.IDP.Issuer)},
+		{"CLIENT_ID", "client-id", "OAuth2 client ID", (*stringValue)(&c.IDP.ClientID)},
+		{"CLIENT_SECRET", "", "OAuth2 client secret", (*stringValue)(&c.IDP.ClientSecret)},
+		{"FUSIONAUTH_API_KEY", "", "FusionAuth API key of the user API", (*stringValue)(&c.IDP.APIKey)},
+		{"GRANT_TYPE", "grant-type", "OAuth2 grant type", (*stringValue)(&c.IDP.GrantType)},
+		{"REDIRECT_URI", "redirect-uri", "OAuth2 redirect URI", (*stringValue)(&c.IDP.RedirectURI)},
+		{"AUTHORIZE_URI", "authorize-uri", "OAuth2 authorization endpoint", (*stringValue)(&c.IDP.AuthorizeURI)},
+		{"TOKEN_URI", "token-uri", "OAuth2 token endpoint", (*stringValue)(&c.IDP.TokenURI)},
+		{"REVOKE_URI", "revoke-uri", "OAuth2 revocation endpoint", (*stringValue)(&c.IDP.RevokeURI)},
+		{"INTROSPECTION_URI", "introspection-uri", "OAuth2 introspection endpoint", (*stringValue)(&c.IDP.IntrospectionURI)},
+		{"TOKEN_INTROSPECTION", "token-introspection", "introspect bearer tokens on high-risk routes", (*boolValue)(&c.IDP.Introspection)},
+
+		{"AUTH_REALM", "realm", "authentication realm", (*stringValue)(&c.Auth.Realm)},
+		{"AUTH_SCHEMES", "auth-schemes", "comma separated authentication schemes", (*listValue)(&c.Auth.Schemes)},
+		{"JWT_ALGORITHMS", "jwt-algorithms", "comma separated JWT signing algorithms", (*listValue)(&c.Auth.Algorithms)},
+		{"JWT_ISSUER", "jwt-issuer", "access token issuer, the IDP issuer when empty", (*stringValue)(&c.Auth.Issuer)},
+		{"JWT_AUDIENCE", "jwt-audience", "access token audience, the client ID when empty", (*stringValue)(&c.Auth.Audience)},
+		{"AUTH_POLICY", "auth-policy", "authorization policy file", (*stringValue)(&c.Auth.Policy)},
+		{"API_KEYS_FILE", "api-keys-file", "static API keys file", (*stringValue)(&c.Auth.APIKeysFile)},
+		{"CREDENTIALS_FILE", "credentials-file", "credential store file", (*stringValue)(&c.Auth.CredentialsFile)},
+		{"REVOCATIONS_FILE", "revocations-file", "revocation store file", (*stringValue)(&c.Auth.RevocationsFile)},
+		{"MVP_USERNAME", "", "username of the single user", (*stringValue)(&c.Auth.User.Username)},
+		{"MVP_PASSWORD", "", "password of the single user", (*stringValue)(&c.Auth.User.Password)},
+		{"USER_ID", "", "user ID of the single user", (*stringValue)(&c.Auth.User.ID)},
+
+		{"SESSION_STORE", "session-store", `browser session store, "memory" or a file`, (*stringValue)(&c.Sessions.Store)},
+		{"SESSION_KEY", "", "base64 encoded session cookie key", (*stringValue)(&c.Sessions.Key)},
+		{"SESSION_TTL", "session-ttl", "browser session lifetime", &c.Sessions.TTL},
+
+		{"JWKS_FILE", "jwks-file", "local JSON Web Key Set used instead of the keys of the IDP", (*stringValue)(&c.JWKS.File)},
+		{"JWKS_TTL", "jwks-ttl", "lifetime of cached signing keys", &c.JWKS.TTL},
+		{"JWKS_REFRESH_INTERVAL", "jwks-refresh-interval", "period of refreshing signing keys", &c.JWKS.RefreshInterval},
+		{"JWKS_MIN_REFRESH_INTERVAL", "jwks-min-refresh-interval", "minimum time between signing key fetches", &c.JWKS.MinRefreshInterval},
+		{"JWKS_FETCH_TIMEOUT", "jwks-fetch-timeout", "maximum time of fetching signing keys", &c.JWKS.FetchTimeout},
+
+		{"REPOSITORY_BACKEND", "repository", "storage backend", (*stringValue)(&c.Repository.Backend)},
+
+		{"SECRETS_PROVIDER", "secrets-provider", "secret provider, env, file or sops", (*stringValue)(&c.Secrets.Provider)},
+		{"SECRETS_PATH", "secrets-path", "directory of secret files or sops encrypted dotenv file", (*stringValue)(&c.Secrets.Path)},
+		{"SECRETS_KEYRING", "secrets-keyring", "name of the HMAC keyring secret", (*stringValue)(&c.Secrets.Keyring)},
+	}
+}
+
+// Parse merges the defaults, the JSON file named by the -config flag or the
+// environment variable "CONFIG_FILE", the environment variables and the
+// flags of the arguments. The result is not validated.
+func Parse(args []string) (Config, error) {
+	c := Default()
+	bs := c.bindings()
+
+	fs := flag.NewFlagSet("invoice-mvp", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON configuration `file` (CONFIG_FILE)")
+	flags := make(map[string]*flagValue)
+	for _, b := range bs {
+		if len(b.flag) < 1 {
+			continue
+		}
+		_, isBool := b.value.(*boolValue)
+		fv := flagValue{isBool: isBool}
+		fs.Var(&fv, b.flag, fmt.Sprintf("%s (%s)", b.usage, b.env))
+		flags[b.flag] = &fv
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	if len(*file) > 0 {
+		if err := c.readFile(*file); err != nil {
+			return c, err
+		}
+	}
+	for _, b := range bs {
+		if v, ok := os.LookupEnv(b.env); ok {
+			if err := b.value.Set(v); err != nil {
+				return c, errors.Wrapf(err, "environment variable %s", b.env)
+			}
+		}
+	}
+	for _, b := range bs {
+		if fv, ok := flags[b.flag]; ok && fv.set {
+			if err := b.value.Set(fv.raw); err != nil {
+				return c, errors.Wrapf(err, "flag -%s", b.flag)
+			}
+		}
+	}
+
+	if len(c.Auth.Issuer) < 1 {
+		c.Auth.Issuer = c.IDP.Issuer
+	}
+	if len(c.Auth.Audience) < 1 {
+		c.Auth.Audience = c.IDP.ClientID
+	}
+	return c, nil
+}
+
+// Load parses the configuration, resolves its secrets and validates it.
+func Load(args []string) (Config, error) {
+	c, err := Parse(args)
+	if err != nil {
+		return c, err
+	}
+	p, err := c.SecretProvider()
+	if err != nil {
+		return c, err
+	}
+	if err := c.ResolveSecrets(p); err != nil {
+		return c, err
+	}
+	if err := c.Validate(); err != nil {
+		return c, errors.Wrap(err, "invalid configuration")
+	}
+	return c, nil
+}
+
+// SecretProvider returns the configured secret provider.
+func (c *Config) SecretProvider() (secret.Provider, error) {
+	p, err := secret.New(c.Secrets.Provider, c.Secrets.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "secrets.provider")
+	}
+	return p, nil
+}
+
+// ResolveSecrets reads the secret settings left empty from the provider.
+func (c *Config) ResolveSecrets(p secret.Provider) error {
+	secrets := []struct {
+		name  string
+		value *string
+	}{
+		{"CLIENT_SECRET", &c.IDP.ClientSecret},
+		{"FUSIONAUTH_API_KEY", &c.IDP.APIKey},
+		{"SESSION_KEY", &c.Sessions.Key},
+		{"MVP_PASSWORD", &c.Auth.User.Password},
+	}
+	for _, s := range secrets {
+		if len(*s.value) > 0 {
+			continue
+		}
+		v, err := p.Secret(s.name)
+		if errors.Is(err, secret.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "secret %s", s.name)
+		}
+		*s.value = v
+	}
+	return nil
+}
+
+// readFile overrides the settings present in the JSON file.
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "reading config file")
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return errors.Wrapf(err, "config file %s", path)
+	}
+	return nil
+}
+
+// flagValue records a flag, it is applied after the environment variables.
+type flagValue struct {
+	raw    string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return f.raw }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+func (f *flagValue) Set(s string) error { f.raw, f.set = s, true; return nil }
+
+type stringValue string
+
+func (s *stringValue) String() string     { return string(*s) }
+func (s *stringValue) Set(v string) error { *s = stringValue(v); return nil }
+
+type boolValue bool
+
+func (b *boolValue) String() string { return strconv.FormatBool(bool(*b)) }
+func (b *boolValue) Set(v string) error {
+	p, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.Errorf("%q is not a boolean", v)
+	}
+	*b = boolValue(p)
+	return nil
+}
+
+// listValue is a comma separated list.
+type listValue []string
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+func (l *listValue) Set(v string) error {
+	*l = nil
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
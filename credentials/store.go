@@ -0,0 +1,390 @@
+// Package credentials implements a local user store holding bcrypt password
+// hashes for Basic authentication and precomputed HA1 values for Digest
+// authentication.
+package credentials
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/rest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Lockout defaults.
+const (
+	DefaultMaxFailures = 5
+	DefaultLockout     = 15 * time.Minute
+)
+
+// dummyHash is compared against for unknown users, so the response time
+// does not reveal whether a username exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("invoice-mvp"), bcrypt.DefaultCost)
+
+// User is a stored credential record.
+type User struct {
+	Username     string            `json:"username"`
+	Subject      string            `json:"subject"`
+	Roles        []string          `json:"roles"`
+	PasswordHash string            `json:"passwordHash"`
+	HA1          map[string]string `json:"ha1"` // digest algorithm -> H(username:realm:password)
+	Disabled     bool              `json:"disabled,omitempty"`
+	Rotated      time.Time         `json:"rotated"`
+}
+
+// Claims converts the user record into authorization claims.
+func (u User) Claims() rest.Claims {
+	var c rest.Claims
+	c.Subject = u.Subject
+	c.Roles = u.Roles
+	return c
+}
+
+// client identifies the logins of a user from a client address.
+type client struct {
+	username, addr string
+}
+
+// attempts are the consecutive failed logins of a client.
+type attempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+type file struct {
+	Realm string           `json:"realm"`
+	Users map[string]*User `json:"users"`
+}
+
+// Store is a JSON file backed credential store. It is safe for concurrent
+// use.
+type Store struct {
+	path        string
+	realm       string
+	MaxFailures int
+	Lockout     time.Duration
+
+	mu       sync.Mutex
+	data     file
+	attempts map[client]*attempts
+	modTime  time.Time
+	now      func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open loads the store from path. A missing file yields an empty store for
+// the realm, which is created on the first Save.
+func Open(path, realm string) (*Store, error) {
+	s := Store{
+		path:        path,
+		realm:       realm,
+		MaxFailures: DefaultMaxFailures,
+		Lockout:     DefaultLockout,
+		data:        file{Realm: realm, Users: make(map[string]*User)},
+		attempts:    make(map[client]*attempts),
+		now:         time.Now,
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Reload reads the store from its file, so users added, rotated or
+// disabled by another process take effect. Lockouts are kept. On error the
+// current users are kept.
+func (s *Store) Reload() error {
+	mod := s.lastModified()
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "reading credential store")
+	}
+	var data file
+	if err := json.Unmarshal(b, &data); err != nil {
+		return errors.Wrap(err, "unmarshalling credential store")
+	}
+	if data.Realm != s.realm {
+		return errors.Errorf("credential store realm %q does not match %q", data.Realm, s.realm)
+	}
+	if data.Users == nil {
+		data.Users = make(map[string]*User)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = data
+	s.modTime = mod
+	return nil
+}
+
+// Watch reloads the store when the file changed or SIGHUP is received,
+// until Stop is called.
+func (s *Store) Watch(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer close(s.done)
+		defer signal.Stop(hup)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s.mu.Lock()
+				changed := s.lastModified().After(s.modTime)
+				s.mu.Unlock()
+				if !changed {
+					continue
+				}
+			case <-hup:
+			case <-s.stop:
+				return
+			}
+			if err := s.Reload(); err != nil {
+				log.Println("credentials: keeping current users:", err)
+				continue
+			}
+			log.Println("credentials: reloaded", s.path)
+		}
+	}()
+}
+
+// Stop ends watching the file.
+func (s *Store) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+	s.stop = nil
+}
+
+// lastModified returns the modification time of the file.
+func (s *Store) lastModified() time.Time {
+	if fi, err := os.Stat(s.path); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+// Save writes the store atomically with owner-only permissions.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling credential store")
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "writing credential store")
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return errors.Wrap(err, "replacing credential store")
+	}
+	s.modTime = s.lastModified()
+	return nil
+}
+
+// Add creates a user.
+func (s *Store) Add(username, subject, password string, roles []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Users[username]; ok {
+		return errors.Errorf("user %q exists", username)
+	}
+	if len(username) < 1 || len(subject) < 1 {
+		return errors.New("username and subject are required")
+	}
+	u := User{Username: username, Subject: subject, Roles: roles}
+	if err := s.setPassword(&u, password); err != nil {
+		return err
+	}
+	s.data.Users[username] = &u
+	return nil
+}
+
+// Rotate replaces the password of a user and lifts a lockout.
+func (s *Store) Rotate(username, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.data.Users[username]
+	if !ok {
+		return errors.Errorf("user %q not found", username)
+	}
+	return s.setPassword(u, password)
+}
+
+// SetDisabled disables or re-enables a user.
+func (s *Store) SetDisabled(username string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.data.Users[username]
+	if !ok {
+		return errors.Errorf("user %q not found", username)
+	}
+	u.Disabled = disabled
+	s.unlock(username)
+	return nil
+}
+
+// Users returns copies of all user records ordered by username.
+func (s *Store) Users() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	us := make([]User, 0, len(s.data.Users))
+	for _, u := range s.data.Users {
+		us = append(us, *u)
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].Username < us[j].Username })
+	return us
+}
+
+func (s *Store) setPassword(u *User, password string) error {
+	if len(password) < 8 {
+		return errors.New("password must have at least 8 characters")
+	}
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "hashing password")
+	}
+	u.PasswordHash = string(h)
+	u.HA1 = map[string]string{
+		rest.AlgMD5:    rest.DigestHash(rest.AlgMD5, u.Username+":"+s.data.Realm+":"+password),
+		rest.AlgSHA256: rest.DigestHash(rest.AlgSHA256, u.Username+":"+s.data.Realm+":"+password),
+	}
+	s.unlock(u.Username)
+	u.Rotated = s.now().UTC()
+	return nil
+}
+
+// active returns the user unless it is unknown or disabled.
+func (s *Store) active(username string) (*User, bool) {
+	u, ok := s.data.Users[username]
+	if !ok || u.Disabled {
+		return nil, false
+	}
+	return u, true
+}
+
+// VerifyPassword implements the rest.PasswordVerifier interface.
+func (s *Store) VerifyPassword(username, password string) (rest.Claims, bool) {
+	s.mu.Lock()
+	var user User
+	u, ok := s.active(username)
+	hash := dummyHash
+	if ok {
+		user = *u
+		hash = []byte(u.PasswordHash)
+	}
+	s.mu.Unlock()
+
+	// bcrypt compares in constant time.
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || !ok {
+		return rest.Claims{}, false
+	}
+	return user.Claims(), true
+}
+
+// HA1 implements the rest.DigestCredentials interface.
+func (s *Store) HA1(username, realm, algorithm string) (string, rest.Claims, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.active(username)
+	if !ok || realm != s.data.Realm {
+		return "", rest.Claims{}, false
+	}
+	base := algorithm
+	switch algorithm {
+	case rest.AlgMD5Sess:
+		base = rest.AlgMD5
+	case rest.AlgSHA256Sess:
+		base = rest.AlgSHA256
+	}
+	ha1, ok := u.HA1[base]
+	return ha1, u.Claims(), ok
+}
+
+// RolesOf implements the rest.RoleDirectory interface. Disabled users have
+// no roles.
+func (s *Store) RolesOf(subject string) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.data.Users {
+		if u.Subject == subject && !u.Disabled {
+			return u.Roles, true
+		}
+	}
+	return nil, false
+}
+
+// LockedOut implements the rest.LoginObserver interface.
+func (s *Store) LockedOut(username, addr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.attempts[client{username, addr}]
+	return ok && s.now().Before(a.lockedUntil)
+}
+
+// LoginFailed implements the rest.LoginObserver interface. A client is
+// locked out of a user after MaxFailures consecutive failures. Only the
+// client address is locked out, the user can still log in from others.
+func (s *Store) LoginFailed(username, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Users[username]; !ok {
+		return
+	}
+	now := s.now()
+	for c, a := range s.attempts {
+		if now.Sub(a.last) > s.Lockout && now.After(a.lockedUntil) {
+			delete(s.attempts, c)
+		}
+	}
+	a, ok := s.attempts[client{username, addr}]
+	if !ok {
+		a = &attempts{}
+		s.attempts[client{username, addr}] = a
+	}
+	a.failures++
+	a.last = now
+	if s.MaxFailures > 0 && a.failures >= s.MaxFailures {
+		a.lockedUntil = now.Add(s.Lockout)
+		a.failures = 0
+	}
+}
+
+// LoginSucceeded implements the rest.LoginObserver interface.
+func (s *Store) LoginSucceeded(username, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, client{username, addr})
+}
+
+// unlock forgets the failed logins of the user from all clients.
+func (s *Store) unlock(username string) {
+	for c := range s.attempts {
+		if c.username == username {
+			delete(s.attempts, c)
+		}
+	}
+}
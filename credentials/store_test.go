@@ -0,0 +1,127 @@
+package credentials_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/credentials"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+const (
+	realm   = "invoice.mvp"
+	subject = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+)
+
+func newStore(t *testing.T) (*credentials.Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	s, err := credentials.Open(path, realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("go", subject, "time-to-go", []string{rest.RoleUser}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func TestStorePersistence(t *testing.T) {
+	_, path := newStore(t)
+
+	s, err := credentials.Open(path, realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := s.VerifyPassword("go", "time-to-go")
+	assert.True(t, ok)
+	assert.Equal(t, subject, c.Subject)
+	assert.Equal(t, []string{rest.RoleUser}, c.Roles)
+
+	ha1, _, ok := s.HA1("go", realm, rest.AlgSHA256Sess)
+	assert.True(t, ok)
+	assert.Equal(t, rest.DigestHash(rest.AlgSHA256, "go:"+realm+":time-to-go"), ha1)
+
+	_, err = credentials.Open(path, "other.realm")
+	assert.Error(t, err)
+}
+
+func TestStoreRotateAndDisable(t *testing.T) {
+	s, _ := newStore(t)
+
+	assert.Error(t, s.Rotate("go", "short"))
+	assert.NoError(t, s.Rotate("go", "time-to-rotate"))
+	_, ok := s.VerifyPassword("go", "time-to-go")
+	assert.False(t, ok)
+	_, ok = s.VerifyPassword("go", "time-to-rotate")
+	assert.True(t, ok)
+
+	assert.NoError(t, s.SetDisabled("go", true))
+	_, ok = s.VerifyPassword("go", "time-to-rotate")
+	assert.False(t, ok)
+	_, _, ok = s.HA1("go", realm, rest.AlgMD5)
+	assert.False(t, ok)
+}
+
+func TestStoreLockout(t *testing.T) {
+	s, _ := newStore(t)
+	s.MaxFailures = 3
+	basic := rest.NewBasicAuthenticator(realm, s)
+	login := func(addr, password string) error {
+		r := httptest.NewRequest("GET", "/activities", nil)
+		r.RemoteAddr = addr
+		r.SetBasicAuth("go", password)
+		_, err := basic.Authenticate(r)
+		return err
+	}
+
+	for i := 0; i < s.MaxFailures; i++ {
+		assert.Error(t, login("192.0.2.1:40000", "guess"))
+	}
+	// The client is locked out, even with the correct password.
+	assert.Error(t, login("192.0.2.1:40001", "time-to-go"))
+	assert.True(t, s.LockedOut("go", "192.0.2.1"))
+
+	// The user still logs in from other clients.
+	assert.NoError(t, login("198.51.100.7:40000", "time-to-go"))
+
+	// Re-enabling lifts the lockout.
+	assert.NoError(t, s.SetDisabled("go", false))
+	assert.NoError(t, login("192.0.2.1:40002", "time-to-go"))
+}
+
+func TestStoreReload(t *testing.T) {
+	s, path := newStore(t)
+	s.Watch(10 * time.Millisecond)
+	defer s.Stop()
+
+	// The users command changes the file from another process.
+	cli, err := credentials.Open(path, realm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, cli.Add("gopher", subject, "time-to-dig", []string{rest.RoleUser}))
+	assert.NoError(t, cli.SetDisabled("go", true))
+	assert.NoError(t, cli.Save())
+
+	assert.Eventually(t, func() bool {
+		_, ok := s.VerifyPassword("gopher", "time-to-dig")
+		return ok
+	}, 10*time.Second, 10*time.Millisecond)
+	_, ok := s.VerifyPassword("go", "time-to-go")
+	assert.False(t, ok)
+	s.Stop()
+
+	// A broken file keeps the current users.
+	assert.NoError(t, ioutil.WriteFile(path, []byte("garbage"), 0600))
+	assert.Error(t, s.Reload())
+	_, ok = s.VerifyPassword("gopher", "time-to-dig")
+	assert.True(t, ok)
+}
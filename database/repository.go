@@ -2,18 +2,39 @@ package database
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/tullo/invoice-mvp/config"
 	"github.com/tullo/invoice-mvp/domain"
 )
 
+// Flusher is implemented by persistent repositories buffering writes. Flush
+// is called on shutdown, once no requests are served any more.
+type Flusher interface {
+	Flush() error
+}
+
+// NewRepository opens the repository of the configured backend.
+func NewRepository(c config.Repository) (*FakeRepository, error) {
+	switch c.Backend {
+	case "memory":
+		return NewFakeRepository(), nil
+	}
+	return nil, fmt.Errorf("unknown repository backend %q", c.Backend)
+}
+
 // FakeRepository is an in-memory store.
 type FakeRepository struct {
+	apiKeysMu  sync.Mutex // keys are touched by concurrent requests
+	apiKeys    map[int]domain.APIKey
 	activities map[string]map[int]domain.Activity
 	bookings   map[int]map[int]domain.Booking
 	customers  map[int]domain.Customer
 	invoices   map[int]domain.Invoice
+	profiles   map[string]domain.Profile
 	projects   map[int]domain.Project
 	rates      map[int]map[int]domain.Rate
 }
@@ -21,10 +42,12 @@ type FakeRepository struct {
 // NewFakeRepository creates a new repository.
 func NewFakeRepository() *FakeRepository {
 	r := FakeRepository{
+		apiKeys:    make(map[int]domain.APIKey),
 		activities: make(map[string]map[int]domain.Activity),
 		bookings:   make(map[int]map[int]domain.Booking),
 		customers:  make(map[int]domain.Customer),
 		invoices:   make(map[int]domain.Invoice),
+		profiles:   make(map[string]domain.Profile),
 		projects:   make(map[int]domain.Project),
 		rates:      make(map[int]map[int]domain.Rate),
 	}
@@ -64,6 +87,71 @@ func (r *FakeRepository) CreateActivity(a domain.Activity) (domain.Activity, err
 	return a, nil
 }
 
+//=============================================================================
+// API Keys
+
+// CreateAPIKey adds an API key to the repository.
+func (r *FakeRepository) CreateAPIKey(k domain.APIKey) (domain.APIKey, error) {
+	r.apiKeysMu.Lock()
+	defer r.apiKeysMu.Unlock()
+	k.ID = 1
+	for _, v := range r.apiKeys {
+		if v.ID >= k.ID {
+			k.ID = v.ID + 1
+		}
+	}
+	r.apiKeys[k.ID] = k
+	return k, nil
+}
+
+// APIKeys gets the API keys of a user.
+func (r *FakeRepository) APIKeys(userID string) []domain.APIKey {
+	r.apiKeysMu.Lock()
+	defer r.apiKeysMu.Unlock()
+	var ks []domain.APIKey
+	for _, k := range r.apiKeys {
+		if k.UserID == userID {
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+// APIKeyByHash finds an API key by the digest of its secret.
+func (r *FakeRepository) APIKeyByHash(hash string) (domain.APIKey, bool) {
+	r.apiKeysMu.Lock()
+	defer r.apiKeysMu.Unlock()
+	for _, k := range r.apiKeys {
+		if k.Hash == hash {
+			return k, true
+		}
+	}
+	return domain.APIKey{}, false
+}
+
+// TouchAPIKey records the last use of an API key.
+func (r *FakeRepository) TouchAPIKey(id int, t time.Time) {
+	r.apiKeysMu.Lock()
+	defer r.apiKeysMu.Unlock()
+	if k, ok := r.apiKeys[id]; ok {
+		k.LastUsed = t
+		r.apiKeys[id] = k
+	}
+}
+
+// RevokeAPIKey revokes an API key of a user.
+func (r *FakeRepository) RevokeAPIKey(userID string, id int) error {
+	r.apiKeysMu.Lock()
+	defer r.apiKeysMu.Unlock()
+	k, ok := r.apiKeys[id]
+	if !ok || k.UserID != userID {
+		return domain.ErrAPIKeyNotFound
+	}
+	k.Revoked = true
+	r.apiKeys[id] = k
+	return nil
+}
+
 //=============================================================================
 // Bookings
 
@@ -93,6 +181,19 @@ func (r *FakeRepository) CreateBooking(b domain.Booking) (domain.Booking, error)
 
 }
 
+// CreateBookings creates the bookings at once, e.g. of an import.
+func (r *FakeRepository) CreateBookings(bs []domain.Booking) ([]domain.Booking, error) {
+	created := make([]domain.Booking, 0, len(bs))
+	for _, b := range bs {
+		b, err := r.CreateBooking(b)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, b)
+	}
+	return created, nil
+}
+
 // DeleteBooking deletes a booking.
 func (r *FakeRepository) DeleteBooking(b domain.Booking) error {
 	if bm, ok := r.bookings[b.InvoiceID]; ok {
@@ -129,6 +230,15 @@ func (r *FakeRepository) CustomerByID(id int) domain.Customer {
 	return r.customers[id]
 }
 
+// UpdateCustomer updates the customer in the repository.
+func (r *FakeRepository) UpdateCustomer(c domain.Customer) error {
+	if _, ok := r.customers[c.ID]; !ok {
+		return fmt.Errorf("customer %d not found", c.ID)
+	}
+	r.customers[c.ID] = c
+	return nil
+}
+
 //=============================================================================
 // Invoices
 
@@ -156,10 +266,41 @@ func (r *FakeRepository) CreateInvoice(i domain.Invoice) (domain.Invoice, error)
 
 // UpdateInvoice updates the invoice in the repository.
 func (r *FakeRepository) UpdateInvoice(i domain.Invoice) error {
+	i.Updated = time.Now().UTC()
 	r.invoices[i.ID] = i
 	return nil
 }
 
+// Invoices gets the invoices of the customers of a user, ordered by ID.
+func (r *FakeRepository) Invoices(userID string) []domain.Invoice {
+	var is []domain.Invoice
+	for _, i := range r.invoices {
+		if r.customers[i.CustomerID].UserID == userID {
+			is = append(is, i)
+		}
+	}
+	sort.Slice(is, func(a, b int) bool { return is[a].ID < is[b].ID })
+	return is
+}
+
+//=============================================================================
+// Profiles
+
+// Profile gets the profile of a user, the zero profile when none was saved.
+func (r *FakeRepository) Profile(userID string) domain.Profile {
+	p, ok := r.profiles[userID]
+	if !ok {
+		p.UserID = userID
+	}
+	return p
+}
+
+// SaveProfile creates or replaces the profile of a user.
+func (r *FakeRepository) SaveProfile(p domain.Profile) error {
+	r.profiles[p.UserID] = p
+	return nil
+}
+
 //=============================================================================
 // Projects
 
@@ -0,0 +1,106 @@
+package digest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Scheme is the name of the HTTP authentication scheme.
+const Scheme = "Digest"
+
+// Supported digest algorithms, in order of preference.
+const (
+	SHA256     = "SHA-256"
+	SHA256Sess = "SHA-256-sess"
+	MD5        = "MD5"
+	MD5Sess    = "MD5-sess"
+)
+
+// Hash returns the hex encoded digest of s for the algorithm.
+func Hash(algorithm, s string) string {
+	var h hash.Hash
+	switch strings.TrimSuffix(algorithm, "-sess") {
+	case SHA256:
+		h = sha256.New()
+	default:
+		h = md5.New()
+	}
+	_, _ = io.WriteString(h, s)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ParseParams parses a comma separated list of auth-params as defined in
+// RFC 7235, where values are either tokens or quoted strings that may
+// contain commas and backslash escapes. Names are lower cased.
+func ParseParams(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	i := 0
+	skip := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+	}
+	for {
+		skip()
+		if i >= len(s) {
+			return m, nil
+		}
+		// name
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ',' && s[i] != ' ' {
+			i++
+		}
+		name := strings.ToLower(s[start:i])
+		skip()
+		if len(name) < 1 || i >= len(s) || s[i] != '=' {
+			return nil, errors.Errorf("malformed auth-param at offset %d", start)
+		}
+		i++
+		skip()
+		// value
+		var v strings.Builder
+		if i < len(s) && s[i] == '"' {
+			i++
+			closed := false
+			for i < len(s) {
+				ch := s[i]
+				i++
+				if ch == '\\' && i < len(s) {
+					v.WriteByte(s[i])
+					i++
+					continue
+				}
+				if ch == '"' {
+					closed = true
+					break
+				}
+				v.WriteByte(ch)
+			}
+			if !closed {
+				return nil, errors.Errorf("unterminated quoted string for %q", name)
+			}
+		} else {
+			for i < len(s) && s[i] != ',' && s[i] != ' ' && s[i] != '\t' {
+				v.WriteByte(s[i])
+				i++
+			}
+		}
+		if _, dup := m[name]; dup {
+			return nil, errors.Errorf("duplicate auth-param %q", name)
+		}
+		m[name] = v.String()
+		skip()
+		if i < len(s) {
+			if s[i] != ',' {
+				return nil, errors.Errorf("expected ',' at offset %d", i)
+			}
+			i++
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package digest implements the client side of the "Digest" HTTP
+// authentication scheme (RFC 7616) as an http.RoundTripper.
+//
+//	c := &http.Client{Transport: &digest.Transport{Username: "go", Password: "time"}}
+//	res, err := c.Post("https://127.0.0.1:8443/customers", "application/json", body)
+package digest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Transport authenticates requests with the "Digest" scheme.
+//
+// The first request to a host is sent without credentials. When the server
+// answers with a Digest challenge, the request is repeated once with an
+// Authorization header. The challenge is cached per host, so later requests
+// are authorized up front, with an increasing nonce count and a fresh client
+// nonce each. Expired or rejected nonces are renewed by the next challenge.
+//
+// Request bodies are replayed from GetBody, or buffered in memory when the
+// request has none.
+type Transport struct {
+	Username string
+	Password string
+	// Base makes the requests, http.DefaultTransport when nil.
+	Base http.RoundTripper
+
+	mu         sync.Mutex
+	challenges map[string]*challenge // by host
+}
+
+// challenge is a Digest challenge of a server.
+type challenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	nc        uint32
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	getBody := req.GetBody
+	body := req.Body
+	if body != nil && body != http.NoBody && getBody == nil {
+		b, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "buffering request body")
+		}
+		getBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(b)), nil
+		}
+		body, _ = getBody()
+	}
+
+	host := req.URL.Host
+	res, err := t.send(req, body, getBody, t.cached(host))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	c, ok := parseChallenges(res.Header.Values("WWW-Authenticate"))
+	if !ok {
+		return res, nil
+	}
+	if body != nil && body != http.NoBody {
+		if body, err = getBody(); err != nil {
+			res.Body.Close()
+			return nil, errors.Wrap(err, "replaying request body")
+		}
+	}
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4<<10))
+	res.Body.Close()
+
+	t.mu.Lock()
+	if t.challenges == nil {
+		t.challenges = make(map[string]*challenge)
+	}
+	t.challenges[host] = c
+	t.mu.Unlock()
+	return t.send(req, body, getBody, c)
+}
+
+// Client returns an HTTP client using the transport.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+func (t *Transport) cached(host string) *challenge {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.challenges[host]
+}
+
+// send makes a copy of the request with the body, authorized by the
+// challenge when not nil.
+func (t *Transport) send(req *http.Request, body io.ReadCloser, getBody func() (io.ReadCloser, error), c *challenge) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Body = body
+	r.GetBody = getBody
+	if c != nil {
+		auth, err := t.authorization(c, r.Method, r.URL.RequestURI())
+		if err != nil {
+			if body != nil {
+				body.Close()
+			}
+			return nil, err
+		}
+		r.Header.Set("Authorization", auth)
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
+
+// authorization computes the credentials of the next request using the
+// challenge.
+func (t *Transport) authorization(c *challenge, method, uri string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating client nonce")
+	}
+	cnonce := hex.EncodeToString(b)
+	t.mu.Lock()
+	c.nc++
+	nc := fmt.Sprintf("%08x", c.nc)
+	t.mu.Unlock()
+
+	ha1 := Hash(c.algorithm, t.Username+":"+c.realm+":"+t.Password)
+	if strings.HasSuffix(c.algorithm, "-sess") {
+		ha1 = Hash(c.algorithm, ha1+":"+c.nonce+":"+cnonce)
+	}
+	ha2 := Hash(c.algorithm, method+":"+uri)
+	response := Hash(c.algorithm, strings.Join([]string{ha1, c.nonce, nc, cnonce, "auth", ha2}, ":"))
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s username=%s, realm=%s, nonce=%s, uri=%s, algorithm=%s, qop=auth, nc=%s, cnonce=%s, response=%s",
+		Scheme, quote(t.Username), quote(c.realm), quote(c.nonce), quote(uri), c.algorithm, nc, quote(cnonce), quote(response))
+	if len(c.opaque) > 0 {
+		fmt.Fprintf(&s, ", opaque=%s", quote(c.opaque))
+	}
+	return s.String(), nil
+}
+
+// parseChallenges returns the Digest challenge with the preferred algorithm
+// of the WWW-Authenticate header values. Challenges without the "auth" qop
+// are ignored.
+func parseChallenges(values []string) (*challenge, bool) {
+	var best *challenge
+	rank := func(alg string) int {
+		for i, a := range []string{SHA256, SHA256Sess, MD5, MD5Sess} {
+			if a == alg {
+				return i
+			}
+		}
+		return -1
+	}
+	for _, v := range values {
+		if len(v) <= len(Scheme) || !strings.EqualFold(v[:len(Scheme)+1], Scheme+" ") {
+			continue
+		}
+		m, err := ParseParams(v[len(Scheme)+1:])
+		if err != nil || len(m["nonce"]) < 1 || !hasToken(m["qop"], "auth") {
+			continue
+		}
+		alg := m["algorithm"]
+		if len(alg) < 1 {
+			alg = MD5
+		}
+		if rank(alg) < 0 || (best != nil && rank(alg) >= rank(best.algorithm)) {
+			continue
+		}
+		best = &challenge{realm: m["realm"], nonce: m["nonce"], opaque: m["opaque"], algorithm: alg}
+	}
+	return best, best != nil
+}
+
+// hasToken reports whether the comma separated list contains the token.
+func hasToken(list, token string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if strings.TrimSpace(v) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// quote returns s as a quoted string.
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,197 @@
+package digest_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/digest"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+const (
+	realm = "invoice.mvp"
+	user  = "go"
+	pass  = "time"
+)
+
+// recorder serves the authenticated handler and records the Authorization
+// headers of the requests.
+type recorder struct {
+	mu      sync.Mutex
+	auth    []string
+	bodies  []string
+	offered string // algorithm of the only challenge sent, all by default
+}
+
+func (rec *recorder) serve(h rest.Handler) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec.mu.Lock()
+		rec.auth = append(rec.auth, r.Header.Get("Authorization"))
+		rec.mu.Unlock()
+		if len(rec.offered) > 0 {
+			w = &offerWriter{ResponseWriter: w, alg: rec.offered}
+		}
+		h(r.Context(), w, r)
+	}))
+}
+
+func (rec *recorder) handler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	rec.mu.Lock()
+	rec.bodies = append(rec.bodies, string(b))
+	rec.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+// offerWriter replaces the challenges with the SHA-256 one rewritten to the
+// algorithm, e.g. to offer a -sess variant.
+type offerWriter struct {
+	http.ResponseWriter
+	alg string
+}
+
+func (w *offerWriter) WriteHeader(status int) {
+	if cs := w.Header().Values("WWW-Authenticate"); len(cs) > 0 {
+		w.Header().Set("WWW-Authenticate", strings.Replace(cs[0], "algorithm=SHA-256", "algorithm="+w.alg, 1))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func auth() config.Auth {
+	return config.Auth{Realm: realm, User: config.User{Username: user, Password: pass}}
+}
+
+func post(t *testing.T, c *http.Client, url string, body io.Reader) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestTransport(t *testing.T) {
+	for _, alg := range []string{digest.SHA256, digest.SHA256Sess, digest.MD5, digest.MD5Sess} {
+		t.Run(alg, func(t *testing.T) {
+			rec := recorder{offered: alg}
+			srv := rec.serve(rest.DigestAuth(auth(), rec.handler))
+			defer srv.Close()
+			c := (&digest.Transport{Username: user, Password: pass}).Client()
+
+			for i := 0; i < 3; i++ {
+				res := post(t, c, srv.URL+"/customers?page=1", strings.NewReader(`{"name": "3skills"}`))
+				assert.Equal(t, http.StatusCreated, res.StatusCode)
+			}
+			// One challenge, then the cached nonce with increasing counts.
+			if assert.Len(t, rec.auth, 4) {
+				assert.Empty(t, rec.auth[0])
+				for i, nc := range []string{"nc=00000001", "nc=00000002", "nc=00000003"} {
+					a := rec.auth[i+1]
+					assert.Contains(t, a, nc)
+					assert.Contains(t, a, "algorithm="+alg+",")
+					assert.Contains(t, a, `uri="/customers?page=1"`)
+				}
+			}
+			assert.Equal(t, []string{`{"name": "3skills"}`, `{"name": "3skills"}`, `{"name": "3skills"}`}, rec.bodies)
+		})
+	}
+}
+
+func TestTransportPrefersSHA256(t *testing.T) {
+	var rec recorder
+	srv := rec.serve(rest.DigestAuth(auth(), rec.handler))
+	defer srv.Close()
+	c := (&digest.Transport{Username: user, Password: pass}).Client()
+
+	res := post(t, c, srv.URL+"/customers", nil)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Contains(t, rec.auth[1], "algorithm=SHA-256,")
+}
+
+func TestTransportReplaysBody(t *testing.T) {
+	var rec recorder
+	srv := rec.serve(rest.DigestAuth(auth(), rec.handler))
+	defer srv.Close()
+	c := (&digest.Transport{Username: user, Password: pass}).Client()
+
+	// A reader unknown to http.NewRequest leaves GetBody unset.
+	body := io.MultiReader(strings.NewReader(`{"name": `), strings.NewReader(`"3skills"}`))
+	res := post(t, c, srv.URL+"/customers", body)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, []string{`{"name": "3skills"}`}, rec.bodies)
+}
+
+func TestTransportStaleNonce(t *testing.T) {
+	var rec recorder
+	a := rest.NewDigestAuthenticator(realm, rest.SingleUser{Username: user, Password: pass})
+	a.SetNonceTTL(200 * time.Millisecond)
+	srv := rec.serve(rest.AuthChain{a}.Authenticate(rec.handler))
+	defer srv.Close()
+	c := (&digest.Transport{Username: user, Password: pass}).Client()
+
+	res := post(t, c, srv.URL+"/customers", strings.NewReader("{}"))
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	time.Sleep(300 * time.Millisecond)
+
+	// The stale nonce is renewed without failing the request.
+	res = post(t, c, srv.URL+"/customers", strings.NewReader("{}"))
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	if assert.Len(t, rec.auth, 4) {
+		assert.Contains(t, rec.auth[2], "nc=00000002")
+		assert.Contains(t, rec.auth[3], "nc=00000001")
+	}
+	assert.Equal(t, []string{"{}", "{}"}, rec.bodies)
+}
+
+func TestTransportWrongPassword(t *testing.T) {
+	var rec recorder
+	srv := rec.serve(rest.DigestAuth(auth(), rec.handler))
+	defer srv.Close()
+	c := (&digest.Transport{Username: user, Password: "tim"}).Client()
+
+	res := post(t, c, srv.URL+"/customers", strings.NewReader("{}"))
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Len(t, rec.auth, 2)
+	assert.Empty(t, rec.bodies)
+}
+
+func TestTransportOtherScheme(t *testing.T) {
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		w.Header().Set("WWW-Authenticate", `Bearer realm="invoice.mvp"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	c := (&digest.Transport{Username: user, Password: pass}).Client()
+
+	res := post(t, c, srv.URL+"/customers", nil)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, 1, n)
+}
+
+func TestParseParams(t *testing.T) {
+	m, err := digest.ParseParams(`realm="invoice, \"mvp\"", qop="auth,auth-int", algorithm=SHA-256`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"realm": `invoice, "mvp"`, "qop": "auth,auth-int", "algorithm": "SHA-256"}, m)
+	}
+	for _, s := range []string{`realm`, `realm="mvp`, `realm=a, realm=b`, `realm=a b=c`} {
+		_, err := digest.ParseParams(s)
+		assert.Error(t, err, s)
+	}
+}
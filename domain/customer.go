@@ -1,10 +1,15 @@
 package domain
 
 // Customer represents an entity that is related to one or more projects.
-// A customer is owned by a user.
+// A customer is owned by a user. Address, VAT ID, email and buyer reference
+// are the buyer's master data of electronic invoices.
 type Customer struct {
-	ID       int       `json:"id,omitempty"`
-	Name     string    `json:"name,omitempty"`
-	UserID   string    `json:"userId,omitempty"`   // belongs to user
-	Projects []Project `json:"projects,omitempty"` // has many projects
+	ID             int       `json:"id,omitempty"`
+	Name           string    `json:"name,omitempty"`
+	UserID         string    `json:"userId,omitempty"`   // belongs to user
+	Projects       []Project `json:"projects,omitempty"` // has many projects
+	Address        *Address  `json:"address,omitempty"`
+	VATID          string    `json:"vatId,omitempty"`
+	Email          string    `json:"email,omitempty"`          // receives electronic invoices
+	BuyerReference string    `json:"buyerReference,omitempty"` // e.g. the Leitweg-ID of German authorities
 }
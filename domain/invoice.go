@@ -24,6 +24,7 @@ type Invoice struct {
 	Positions  map[int]map[string]Position `json:"positions,omitempty"`
 	Bookings   []Booking                   `json:"bookings,omitempty"`
 	Updated    time.Time                   `json:"updated,omitempty"`
+	Charged    time.Time                   `json:"charged,omitempty"`
 	//Bookings   []Booking                 `json:"-"` excluded in json representation
 }
 
@@ -80,3 +81,13 @@ func (invoice Invoice) Operations() []Operation {
 		return []Operation{}
 	}
 }
+
+// Allows reports whether the operation is allowed in the current state.
+func (invoice Invoice) Allows(o Operation) bool {
+	for _, op := range invoice.Operations() {
+		if op == o {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+)
+
+// Address is a postal address.
+type Address struct {
+	Street      string `json:"street,omitempty"`
+	City        string `json:"city,omitempty"`
+	PostCode    string `json:"postCode,omitempty"`
+	CountryCode string `json:"countryCode,omitempty"` // ISO 3166-1 alpha-2, e.g. "DE"
+}
+
+// Profile holds the master data of a user invoicing customers, the seller of
+// electronic invoices.
+type Profile struct {
+	UserID          string  `json:"userId,omitempty"`
+	Name            string  `json:"name"` // legal name of the seller
+	Address         Address `json:"address"`
+	VATID           string  `json:"vatId,omitempty"`
+	TaxNumber       string  `json:"taxNumber,omitempty"` // required without VAT ID
+	ContactName     string  `json:"contactName,omitempty"`
+	Phone           string  `json:"phone,omitempty"`
+	Email           string  `json:"email,omitempty"`
+	IBAN            string  `json:"iban,omitempty"`
+	BIC             string  `json:"bic,omitempty"`
+	Currency        string  `json:"currency,omitempty"`     // ISO 4217, EUR when empty
+	VATRate         float32 `json:"vatRate"`                // percent, e.g. 19
+	VATExemption    string  `json:"vatExemption,omitempty"` // reason, e.g. small business, invoices are VAT exempt when set
+	PaymentTermDays int     `json:"paymentTermDays"`
+}
+
+// Validate checks the address, its country code if given.
+func (a Address) Validate() error {
+	if len(a.CountryCode) > 0 && !isUpper(a.CountryCode, 2) {
+		return errors.New("country code must be two upper case letters, e.g. DE")
+	}
+	return nil
+}
+
+// Validate checks the user provided fields of a profile.
+func (p Profile) Validate() error {
+	if len(strings.TrimSpace(p.Name)) < 1 {
+		return errors.New("name is required")
+	}
+	if err := p.Address.Validate(); err != nil {
+		return err
+	}
+	if len(p.Currency) > 0 && !isUpper(p.Currency, 3) {
+		return errors.New("currency must be three upper case letters, e.g. EUR")
+	}
+	if p.VATRate < 0 || p.VATRate > 100 {
+		return errors.New("vat rate must be a percentage")
+	}
+	if p.VATRate > 0 && len(p.VATExemption) > 0 {
+		return errors.New("vat exempt profiles have no vat rate")
+	}
+	if p.PaymentTermDays < 0 {
+		return errors.New("payment term days must not be negative")
+	}
+	return nil
+}
+
+// isUpper reports whether s consists of n upper case letters.
+func isUpper(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
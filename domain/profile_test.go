@@ -0,0 +1,31 @@
+package domain_test
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+func TestValidateProfile(t *testing.T) {
+	valid := domain.Profile{Name: "Tullo & Partner", Address: domain.Address{CountryCode: "DE"}, Currency: "EUR", VATRate: 19, PaymentTermDays: 14}
+	assert.Equal(t, nil, valid.Validate())
+
+	tests := map[string]func(p *domain.Profile){
+		"name is required": func(p *domain.Profile) { p.Name = " " },
+		"country code must be two upper case letters, e.g. DE": func(p *domain.Profile) { p.Address.CountryCode = "de" },
+		"currency must be three upper case letters, e.g. EUR":  func(p *domain.Profile) { p.Currency = "€" },
+		"vat rate must be a percentage":                        func(p *domain.Profile) { p.VATRate = -7 },
+		"vat exempt profiles have no vat rate":                 func(p *domain.Profile) { p.VATExemption = "Kleinunternehmer" },
+		"payment term days must not be negative":               func(p *domain.Profile) { p.PaymentTermDays = -1 },
+	}
+	for want, change := range tests {
+		p := valid
+		change(&p)
+		err := p.Validate()
+		assert.NotEqual(t, nil, err)
+		if err != nil {
+			assert.Equal(t, want, err.Error())
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package einvoice
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// CII namespaces.
+const (
+	ciiRsmNS = "urn:un:unece:uncefact:data:standard:CrossIndustryInvoice:100"
+	ciiRamNS = "urn:un:unece:uncefact:data:standard:ReusableAggregateBusinessInformationEntity:100"
+	ciiUdtNS = "urn:un:unece:uncefact:data:standard:UnqualifiedDataType:100"
+)
+
+// The CII types below declare the elements in the order of the schema.
+
+type ciiInvoice struct {
+	XMLName     xml.Name       `xml:"rsm:CrossIndustryInvoice"`
+	RsmNS       string         `xml:"xmlns:rsm,attr"`
+	RamNS       string         `xml:"xmlns:ram,attr"`
+	UdtNS       string         `xml:"xmlns:udt,attr"`
+	GuidelineID string         `xml:"rsm:ExchangedDocumentContext>ram:GuidelineSpecifiedDocumentContextParameter>ram:ID"`
+	Document    ciiDocument    `xml:"rsm:ExchangedDocument"`
+	Transaction ciiTransaction `xml:"rsm:SupplyChainTradeTransaction"`
+}
+
+type ciiDocument struct {
+	ID            string  `xml:"ram:ID"`
+	TypeCode      string  `xml:"ram:TypeCode"`
+	IssueDateTime ciiDate `xml:"ram:IssueDateTime>udt:DateTimeString"`
+}
+
+type ciiDate struct {
+	Format string `xml:"format,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type ciiTransaction struct {
+	Lines      []ciiLine     `xml:"ram:IncludedSupplyChainTradeLineItem"`
+	Agreement  ciiAgreement  `xml:"ram:ApplicableHeaderTradeAgreement"`
+	Delivery   struct{}      `xml:"ram:ApplicableHeaderTradeDelivery"`
+	Settlement ciiSettlement `xml:"ram:ApplicableHeaderTradeSettlement"`
+}
+
+type ciiLine struct {
+	LineID      string         `xml:"ram:AssociatedDocumentLineDocument>ram:LineID"`
+	ProductName string         `xml:"ram:SpecifiedTradeProduct>ram:Name"`
+	NetPrice    string         `xml:"ram:SpecifiedLineTradeAgreement>ram:NetPriceProductTradePrice>ram:ChargeAmount"`
+	Quantity    ciiQuantity    `xml:"ram:SpecifiedLineTradeDelivery>ram:BilledQuantity"`
+	Settlement  ciiLineSettled `xml:"ram:SpecifiedLineTradeSettlement"`
+}
+
+type ciiQuantity struct {
+	UnitCode string `xml:"unitCode,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type ciiLineSettled struct {
+	Tax       ciiTax `xml:"ram:ApplicableTradeTax"`
+	LineTotal string `xml:"ram:SpecifiedTradeSettlementLineMonetarySummation>ram:LineTotalAmount"`
+}
+
+type ciiTax struct {
+	CalculatedAmount      string `xml:"ram:CalculatedAmount,omitempty"`
+	TypeCode              string `xml:"ram:TypeCode"`
+	ExemptionReason       string `xml:"ram:ExemptionReason,omitempty"`
+	BasisAmount           string `xml:"ram:BasisAmount,omitempty"`
+	CategoryCode          string `xml:"ram:CategoryCode"`
+	RateApplicablePercent string `xml:"ram:RateApplicablePercent"`
+}
+
+type ciiAgreement struct {
+	BuyerReference string   `xml:"ram:BuyerReference,omitempty"`
+	Seller         ciiParty `xml:"ram:SellerTradeParty"`
+	Buyer          ciiParty `xml:"ram:BuyerTradeParty"`
+}
+
+type ciiParty struct {
+	Name             string            `xml:"ram:Name"`
+	Contact          *ciiContact       `xml:"ram:DefinedTradeContact"`
+	Address          ciiAddress        `xml:"ram:PostalTradeAddress"`
+	URI              *ciiIdentifier    `xml:"ram:URIUniversalCommunication>ram:URIID"`
+	TaxRegistrations []ciiRegistration `xml:"ram:SpecifiedTaxRegistration"`
+}
+
+type ciiIdentifier struct {
+	SchemeID string `xml:"schemeID,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type ciiRegistration struct {
+	ID ciiIdentifier `xml:"ram:ID"`
+}
+
+type ciiContact struct {
+	PersonName string    `xml:"ram:PersonName,omitempty"`
+	Telephone  *ciiPhone `xml:"ram:TelephoneUniversalCommunication"`
+	Email      *ciiEmail `xml:"ram:EmailURIUniversalCommunication"`
+}
+
+type ciiPhone struct {
+	CompleteNumber string `xml:"ram:CompleteNumber"`
+}
+
+type ciiEmail struct {
+	URIID string `xml:"ram:URIID"`
+}
+
+type ciiAddress struct {
+	PostcodeCode string `xml:"ram:PostcodeCode,omitempty"`
+	LineOne      string `xml:"ram:LineOne,omitempty"`
+	CityName     string `xml:"ram:CityName,omitempty"`
+	CountryID    string `xml:"ram:CountryID"`
+}
+
+type ciiSettlement struct {
+	InvoiceCurrencyCode string           `xml:"ram:InvoiceCurrencyCode"`
+	PaymentMeans        *ciiPaymentMeans `xml:"ram:SpecifiedTradeSettlementPaymentMeans"`
+	Tax                 ciiTax           `xml:"ram:ApplicableTradeTax"`
+	Period              *ciiPeriod       `xml:"ram:BillingSpecifiedPeriod"`
+	PaymentTerms        *ciiPaymentTerms `xml:"ram:SpecifiedTradePaymentTerms"`
+	Summation           ciiSummation     `xml:"ram:SpecifiedTradeSettlementHeaderMonetarySummation"`
+}
+
+type ciiPaymentMeans struct {
+	TypeCode string          `xml:"ram:TypeCode"`
+	IBAN     string          `xml:"ram:PayeePartyCreditorFinancialAccount>ram:IBANID"`
+	BIC      *ciiInstitution `xml:"ram:PayeeSpecifiedCreditorFinancialInstitution"`
+}
+
+type ciiInstitution struct {
+	BICID string `xml:"ram:BICID"`
+}
+
+type ciiPeriod struct {
+	Start *ciiDate `xml:"ram:StartDateTime>udt:DateTimeString"`
+	End   *ciiDate `xml:"ram:EndDateTime>udt:DateTimeString"`
+}
+
+type ciiPaymentTerms struct {
+	Description string   `xml:"ram:Description,omitempty"`
+	DueDate     *ciiDate `xml:"ram:DueDateDateTime>udt:DateTimeString"`
+}
+
+type ciiSummation struct {
+	LineTotalAmount     string    `xml:"ram:LineTotalAmount"`
+	TaxBasisTotalAmount string    `xml:"ram:TaxBasisTotalAmount"`
+	TaxTotalAmount      ciiAmount `xml:"ram:TaxTotalAmount"`
+	GrandTotalAmount    string    `xml:"ram:GrandTotalAmount"`
+	DuePayableAmount    string    `xml:"ram:DuePayableAmount"`
+}
+
+type ciiAmount struct {
+	CurrencyID string `xml:"currencyID,attr"`
+	Value      string `xml:",chardata"`
+}
+
+// cii maps an invoice to CII.
+func cii(i Invoice, p Profile) ciiInvoice {
+	t := i.Totals()
+	c := ciiInvoice{
+		RsmNS:       ciiRsmNS,
+		RamNS:       ciiRamNS,
+		UdtNS:       ciiUdtNS,
+		GuidelineID: string(p),
+		Document: ciiDocument{
+			ID:            i.Number,
+			TypeCode:      typeCode,
+			IssueDateTime: *ciiDateOf(i.IssueDate),
+		},
+	}
+	rate := formatDecimal(i.VAT.Rate)
+	for _, l := range i.Lines {
+		c.Transaction.Lines = append(c.Transaction.Lines, ciiLine{
+			LineID:      l.ID,
+			ProductName: l.Name,
+			NetPrice:    l.Price.String(),
+			Quantity:    ciiQuantity{UnitCode: hoursUnit, Value: formatDecimal(l.Hours)},
+			Settlement: ciiLineSettled{
+				Tax:       ciiTax{TypeCode: "VAT", CategoryCode: i.VAT.Category, RateApplicablePercent: rate},
+				LineTotal: l.NetAmount.String(),
+			},
+		})
+	}
+	c.Transaction.Agreement = ciiAgreement{
+		BuyerReference: i.BuyerReference,
+		Seller:         ciiPartyOf(i.Seller),
+		Buyer:          ciiPartyOf(i.Buyer),
+	}
+	s := ciiSettlement{
+		InvoiceCurrencyCode: i.Currency,
+		Tax: ciiTax{
+			CalculatedAmount:      t.Tax.String(),
+			TypeCode:              "VAT",
+			ExemptionReason:       i.VAT.ExemptionReason,
+			BasisAmount:           t.TaxBasis.String(),
+			CategoryCode:          i.VAT.Category,
+			RateApplicablePercent: rate,
+		},
+		Summation: ciiSummation{
+			LineTotalAmount:     t.LineNet.String(),
+			TaxBasisTotalAmount: t.TaxBasis.String(),
+			TaxTotalAmount:      ciiAmount{CurrencyID: i.Currency, Value: t.Tax.String()},
+			GrandTotalAmount:    t.Gross.String(),
+			DuePayableAmount:    t.Payable.String(),
+		},
+	}
+	if len(i.Payment.IBAN) > 0 {
+		s.PaymentMeans = &ciiPaymentMeans{TypeCode: sepapayment, IBAN: i.Payment.IBAN}
+		if len(i.Payment.BIC) > 0 {
+			s.PaymentMeans.BIC = &ciiInstitution{BICID: i.Payment.BIC}
+		}
+	}
+	if !i.PeriodStart.IsZero() || !i.PeriodEnd.IsZero() {
+		s.Period = &ciiPeriod{Start: ciiDateOf(i.PeriodStart), End: ciiDateOf(i.PeriodEnd)}
+	}
+	if len(i.PaymentTerms) > 0 || !i.DueDate.IsZero() {
+		s.PaymentTerms = &ciiPaymentTerms{Description: i.PaymentTerms, DueDate: ciiDateOf(i.DueDate)}
+	}
+	c.Transaction.Settlement = s
+	return c
+}
+
+func ciiPartyOf(p Party) ciiParty {
+	c := ciiParty{
+		Name: p.Name,
+		Address: ciiAddress{
+			PostcodeCode: p.Address.PostCode,
+			LineOne:      p.Address.Street,
+			CityName:     p.Address.City,
+			CountryID:    p.Address.CountryCode,
+		},
+	}
+	if ct := p.Contact; ct != (Contact{}) {
+		c.Contact = &ciiContact{PersonName: ct.Name}
+		if len(ct.Phone) > 0 {
+			c.Contact.Telephone = &ciiPhone{CompleteNumber: ct.Phone}
+		}
+		if len(ct.Email) > 0 {
+			c.Contact.Email = &ciiEmail{URIID: ct.Email}
+		}
+	}
+	if len(p.Email) > 0 {
+		c.URI = &ciiIdentifier{SchemeID: "EM", Value: p.Email}
+	}
+	if len(p.VATID) > 0 {
+		c.TaxRegistrations = append(c.TaxRegistrations, ciiRegistration{ciiIdentifier{SchemeID: "VA", Value: p.VATID}})
+	}
+	if len(p.TaxNumber) > 0 {
+		c.TaxRegistrations = append(c.TaxRegistrations, ciiRegistration{ciiIdentifier{SchemeID: "FC", Value: p.TaxNumber}})
+	}
+	return c
+}
+
+// ciiDateOf formats a date in format 102, CCYYMMDD.
+func ciiDateOf(t time.Time) *ciiDate {
+	if t.IsZero() {
+		return nil
+	}
+	return &ciiDate{Format: "102", Value: t.Format("20060102")}
+}
@@ -0,0 +1,170 @@
+// Package einvoice writes electronic invoices conforming to the European
+// standard EN 16931, and its German CIUS XRechnung, in the two syntaxes the
+// standard binds to: OASIS UBL 2.1 and UN/CEFACT Cross Industry Invoice
+// (CII) D16B. Invoices are checked against the business rules the package
+// implements before they are written; comments name the business terms
+// (BT) and groups (BG) of the semantic model.
+package einvoice
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Syntax is an XML syntax of electronic invoices.
+type Syntax string
+
+// Supported syntaxes.
+const (
+	UBL Syntax = "ubl" // OASIS UBL 2.1
+	CII Syntax = "cii" // UN/CEFACT CII D16B
+)
+
+// Profile is the specification identifier (BT-24) an invoice conforms to.
+type Profile string
+
+// Supported profiles.
+const (
+	EN16931   Profile = "urn:cen.eu:en16931:2017"
+	XRechnung Profile = "urn:cen.eu:en16931:2017#compliant#urn:xeinkauf.de:kosit:xrechnung_3.0"
+)
+
+// VAT category codes (BT-151).
+const (
+	StandardRate = "S"
+	Exempt       = "E"
+)
+
+const (
+	typeCode    = "380" // commercial invoice
+	hoursUnit   = "HUR" // UN/ECE recommendation 20
+	sepapayment = "58"  // SEPA credit transfer, UNTDID 4461
+)
+
+// Invoice is a commercial invoice of services billed by the hour. All
+// lines have the same VAT category.
+type Invoice struct {
+	Number         string    // BT-1
+	IssueDate      time.Time // BT-2
+	DueDate        time.Time // BT-9
+	Currency       string    // BT-5, ISO 4217
+	BuyerReference string    // BT-10
+	PeriodStart    time.Time // BT-73
+	PeriodEnd      time.Time // BT-74
+	PaymentTerms   string    // BT-20
+	Seller         Party     // BG-4
+	Buyer          Party     // BG-7
+	Payment        Payment   // BG-16
+	VAT            VAT       // BG-23
+	Lines          []Line    // BG-25
+}
+
+// Party is the seller or buyer of an invoice.
+type Party struct {
+	Name      string  // BT-27, BT-44
+	Address   Address // BG-5, BG-8
+	VATID     string  // BT-31, BT-48
+	TaxNumber string  // BT-32, seller only
+	Email     string  // electronic address BT-34, BT-49
+	Contact   Contact // BG-6, seller only
+}
+
+// Address is the postal address of a party.
+type Address struct {
+	Street      string // BT-35, BT-50
+	City        string // BT-37, BT-52
+	PostCode    string // BT-38, BT-53
+	CountryCode string // BT-40, BT-55, ISO 3166-1 alpha-2
+}
+
+// Contact is the contact point of the seller.
+type Contact struct {
+	Name  string // BT-41
+	Phone string // BT-42
+	Email string // BT-43
+}
+
+// Payment instructs the buyer to pay by SEPA credit transfer.
+type Payment struct {
+	IBAN string // BT-84
+	BIC  string // BT-86
+}
+
+// VAT is the VAT category of all lines.
+type VAT struct {
+	Category        string  // BT-118, BT-151
+	Rate            float64 // BT-119, BT-152, percent
+	ExemptionReason string  // BT-120
+}
+
+// Line is an invoice line of hours worked.
+type Line struct {
+	ID        string  // BT-126
+	Name      string  // BT-153
+	Hours     float64 // BT-129
+	Price     Amount  // BT-146, per hour
+	NetAmount Amount  // BT-131
+}
+
+// Amount is an amount of money in cents.
+type Amount int64
+
+// NewAmount rounds an amount to cents.
+func NewAmount(f float64) Amount {
+	return Amount(math.Round(f * 100))
+}
+
+// String formats the amount with two decimals, e.g. "480.00".
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign, a = "-", -a
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, a/100, a%100)
+}
+
+// Totals are the document totals (BG-22) of an invoice.
+type Totals struct {
+	LineNet  Amount // BT-106
+	TaxBasis Amount // BT-109 and BT-116
+	Tax      Amount // BT-110 and BT-117
+	Gross    Amount // BT-112
+	Payable  Amount // BT-115
+}
+
+// Totals calculates the totals of the invoice. Without allowances, charges
+// and prepaid amounts the tax basis is the sum of the lines, and the
+// payable amount the gross amount.
+func (i Invoice) Totals() Totals {
+	var t Totals
+	for _, l := range i.Lines {
+		t.LineNet += l.NetAmount
+	}
+	t.TaxBasis = t.LineNet
+	t.Tax = NewAmount(float64(t.TaxBasis) * i.VAT.Rate / 10000)
+	t.Gross = t.TaxBasis + t.Tax
+	t.Payable = t.Gross
+	return t
+}
+
+// Marshal validates the invoice against the business rules of the profile
+// and writes it in the syntax. Violations are returned as Violations.
+func Marshal(i Invoice, s Syntax, p Profile) ([]byte, error) {
+	if err := i.Validate(p); err != nil {
+		return nil, err
+	}
+	switch s {
+	case UBL:
+		return marshal(ubl(i, p))
+	case CII:
+		return marshal(cii(i, p))
+	}
+	return nil, fmt.Errorf("unknown syntax %q", s)
+}
+
+// formatDecimal formats quantities and percentages without trailing zeros.
+func formatDecimal(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
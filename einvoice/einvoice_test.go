@@ -0,0 +1,250 @@
+package einvoice_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/einvoice"
+)
+
+// invoice returns an invoice valid for XRechnung.
+func invoice() einvoice.Invoice {
+	return einvoice.Invoice{
+		Number:         "1",
+		IssueDate:      time.Date(2020, 11, 2, 0, 0, 0, 0, time.UTC),
+		DueDate:        time.Date(2020, 11, 16, 0, 0, 0, 0, time.UTC),
+		Currency:       "EUR",
+		BuyerReference: "04011000-12345-34",
+		PeriodStart:    time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:      time.Date(2020, 10, 31, 0, 0, 0, 0, time.UTC),
+		PaymentTerms:   "Payable within 14 days",
+		Seller: einvoice.Party{
+			Name:    "Tullo & Partner",
+			Address: einvoice.Address{Street: "Hauptstr. 1", City: "Hamburg", PostCode: "20095", CountryCode: "DE"},
+			VATID:   "DE123456789",
+			Email:   "invoices@tullo.example",
+			Contact: einvoice.Contact{Name: "Andreas Tullo", Phone: "+49 40 123456", Email: "at@tullo.example"},
+		},
+		Buyer: einvoice.Party{
+			Name:    "3skills",
+			Address: einvoice.Address{Street: "Marktplatz 2", City: "Kiel", PostCode: "24103", CountryCode: "DE"},
+			Email:   "billing@3skills.example",
+		},
+		Payment: einvoice.Payment{IBAN: "DE02120300000000202051", BIC: "BYLADEM1001"},
+		VAT:     einvoice.VAT{Category: einvoice.StandardRate, Rate: 19},
+		Lines: []einvoice.Line{
+			{ID: "1", Name: "Instanfoo.com: Programming", Hours: 8.5, Price: 6000, NetAmount: 51000},
+			{ID: "2", Name: "Instanfoo.com: Quality control", Hours: 1.25, Price: 5533, NetAmount: 6917},
+		},
+	}
+}
+
+func TestAmount(t *testing.T) {
+	assert.Equal(t, "0.00", einvoice.Amount(0).String())
+	assert.Equal(t, "69.17", einvoice.NewAmount(69.166).String())
+	assert.Equal(t, "-0.05", einvoice.Amount(-5).String())
+	assert.Equal(t, "1200.50", einvoice.NewAmount(1200.5).String())
+}
+
+func TestTotals(t *testing.T) {
+	i := invoice()
+	assert.Equal(t, einvoice.Totals{LineNet: 57917, TaxBasis: 57917, Tax: 11004, Gross: 68921, Payable: 68921}, i.Totals())
+
+	i.VAT = einvoice.VAT{Category: einvoice.Exempt, ExemptionReason: "Kleinunternehmer gemäß § 19 UStG"}
+	assert.Equal(t, einvoice.Totals{LineNet: 57917, TaxBasis: 57917, Gross: 57917, Payable: 57917}, i.Totals())
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, invoice().Validate(einvoice.XRechnung))
+
+	tests := []struct {
+		name    string
+		change  func(i *einvoice.Invoice)
+		profile einvoice.Profile
+		rules   []string
+	}{
+		{"number", func(i *einvoice.Invoice) { i.Number = "" }, einvoice.EN16931, []string{"BR-02"}},
+		{"parties", func(i *einvoice.Invoice) { i.Seller.Name, i.Buyer.Address = "", einvoice.Address{} }, einvoice.EN16931, []string{"BR-06", "BR-11"}},
+		{"lines", func(i *einvoice.Invoice) { i.Lines = nil }, einvoice.EN16931, []string{"BR-16"}},
+		{"line", func(i *einvoice.Invoice) { i.Lines[1].Name, i.Lines[1].Price = "", -1 }, einvoice.EN16931, []string{"BR-25", "BR-27"}},
+		{"period", func(i *einvoice.Invoice) { i.PeriodEnd = i.PeriodStart.AddDate(0, 0, -1) }, einvoice.EN16931, []string{"BR-29"}},
+		{"vat id prefix", func(i *einvoice.Invoice) { i.Seller.VATID = "123456789" }, einvoice.EN16931, []string{"BR-CO-09"}},
+		{"payment due", func(i *einvoice.Invoice) { i.DueDate, i.PaymentTerms = time.Time{}, "" }, einvoice.EN16931, []string{"BR-CO-25"}},
+		{"standard rated without vat id", func(i *einvoice.Invoice) { i.Seller.VATID = "" }, einvoice.EN16931, []string{"BR-S-02"}},
+		{"standard rated with tax number", func(i *einvoice.Invoice) { i.Seller.VATID, i.Seller.TaxNumber = "", "22/815/08154" }, einvoice.XRechnung, nil},
+		{"standard rate zero", func(i *einvoice.Invoice) { i.VAT.Rate = 0 }, einvoice.EN16931, []string{"BR-S-05"}},
+		{"exempt", func(i *einvoice.Invoice) { i.VAT = einvoice.VAT{Category: einvoice.Exempt, Rate: 19} }, einvoice.EN16931, []string{"BR-E-05", "BR-E-10"}},
+		{"reverse charge", func(i *einvoice.Invoice) { i.VAT.Category = "AE" }, einvoice.EN16931, []string{"BT-151"}},
+		{"en 16931 is laxer", func(i *einvoice.Invoice) {
+			i.BuyerReference, i.Payment, i.Seller.Contact = "", einvoice.Payment{}, einvoice.Contact{}
+		}, einvoice.EN16931, nil},
+		{"xrechnung", func(i *einvoice.Invoice) {
+			i.BuyerReference, i.Payment, i.Seller.Contact = "", einvoice.Payment{}, einvoice.Contact{}
+		}, einvoice.XRechnung, []string{"BR-DE-1", "BR-DE-5", "BR-DE-6", "BR-DE-7", "BR-DE-15"}},
+		{"xrechnung addresses", func(i *einvoice.Invoice) { i.Buyer.Address.City, i.Buyer.Email = "", "" }, einvoice.XRechnung, []string{"BR-DE-8", "BT-49"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			i := invoice()
+			tc.change(&i)
+			err := i.Validate(tc.profile)
+			if tc.rules == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var vs einvoice.Violations
+			require.ErrorAs(t, err, &vs)
+			var rules []string
+			for _, v := range vs {
+				rules = append(rules, v.Rule)
+			}
+			assert.Equal(t, tc.rules, rules)
+		})
+	}
+}
+
+// The documents as read back, enough to check the totals.
+type ublDocument struct {
+	CustomizationID string   `xml:"CustomizationID"`
+	Lines           []string `xml:"InvoiceLine>LineExtensionAmount"`
+	TaxAmount       string   `xml:"TaxTotal>TaxAmount"`
+	Taxable         string   `xml:"TaxTotal>TaxSubtotal>TaxableAmount"`
+	LineExtension   string   `xml:"LegalMonetaryTotal>LineExtensionAmount"`
+	TaxExclusive    string   `xml:"LegalMonetaryTotal>TaxExclusiveAmount"`
+	TaxInclusive    string   `xml:"LegalMonetaryTotal>TaxInclusiveAmount"`
+	Payable         string   `xml:"LegalMonetaryTotal>PayableAmount"`
+}
+
+type ciiDocument struct {
+	GuidelineID string   `xml:"ExchangedDocumentContext>GuidelineSpecifiedDocumentContextParameter>ID"`
+	Lines       []string `xml:"SupplyChainTradeTransaction>IncludedSupplyChainTradeLineItem>SpecifiedLineTradeSettlement>SpecifiedTradeSettlementLineMonetarySummation>LineTotalAmount"`
+	Settlement  struct {
+		TaxAmount string `xml:"ApplicableTradeTax>CalculatedAmount"`
+		Taxable   string `xml:"ApplicableTradeTax>BasisAmount"`
+		Summation struct {
+			LineTotal  string `xml:"LineTotalAmount"`
+			TaxBasis   string `xml:"TaxBasisTotalAmount"`
+			TaxTotal   string `xml:"TaxTotalAmount"`
+			GrandTotal string `xml:"GrandTotalAmount"`
+			DuePayable string `xml:"DuePayableAmount"`
+		} `xml:"SpecifiedTradeSettlementHeaderMonetarySummation"`
+	} `xml:"SupplyChainTradeTransaction>ApplicableHeaderTradeSettlement"`
+}
+
+func TestMarshal(t *testing.T) {
+	i := invoice()
+	b, err := einvoice.Marshal(i, einvoice.UBL, einvoice.XRechnung)
+	require.NoError(t, err)
+	var u ublDocument
+	require.NoError(t, xml.Unmarshal(b, &u))
+	assert.Equal(t, ublDocument{
+		CustomizationID: string(einvoice.XRechnung),
+		Lines:           []string{"510.00", "69.17"},
+		TaxAmount:       "110.04",
+		Taxable:         "579.17",
+		LineExtension:   "579.17", // BR-CO-10
+		TaxExclusive:    "579.17", // BR-CO-13
+		TaxInclusive:    "689.21", // BR-CO-15
+		Payable:         "689.21", // BR-CO-16
+	}, u)
+	assert.Contains(t, string(b), `<cbc:InvoicedQuantity unitCode="HUR">1.25</cbc:InvoicedQuantity>`)
+	assert.Contains(t, string(b), `<cbc:EndpointID schemeID="EM">billing@3skills.example</cbc:EndpointID>`)
+
+	b, err = einvoice.Marshal(i, einvoice.CII, einvoice.EN16931)
+	require.NoError(t, err)
+	var c ciiDocument
+	require.NoError(t, xml.Unmarshal(b, &c))
+	assert.Equal(t, string(einvoice.EN16931), c.GuidelineID)
+	assert.Equal(t, []string{"510.00", "69.17"}, c.Lines)
+	assert.Equal(t, "110.04", c.Settlement.TaxAmount)
+	assert.Equal(t, "579.17", c.Settlement.Taxable)
+	assert.Equal(t, "579.17", c.Settlement.Summation.LineTotal)
+	assert.Equal(t, "579.17", c.Settlement.Summation.TaxBasis)
+	assert.Equal(t, "110.04", c.Settlement.Summation.TaxTotal)
+	assert.Equal(t, "689.21", c.Settlement.Summation.GrandTotal)
+	assert.Equal(t, "689.21", c.Settlement.Summation.DuePayable)
+	assert.Contains(t, string(b), `<udt:DateTimeString format="102">20201102</udt:DateTimeString>`)
+
+	i.BuyerReference = ""
+	_, err = einvoice.Marshal(i, einvoice.CII, einvoice.XRechnung)
+	assert.EqualError(t, err, "BR-DE-15: buyer reference missing")
+	_, err = einvoice.Marshal(invoice(), "edifact", einvoice.EN16931)
+	assert.EqualError(t, err, `unknown syntax "edifact"`)
+}
+
+// TestSchema validates the documents against the official schemas in
+// testdata/official or else the bundled subsets, see testdata/README.md.
+func TestSchema(t *testing.T) {
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Skip("xmllint not found, install libxml2-utils to validate the documents")
+	}
+	schemas := map[einvoice.Syntax]string{
+		einvoice.UBL: schema(t, "UBL-Invoice-2.1.xsd", "testdata/ubl/UBL-Invoice-2.1-subset.xsd"),
+		einvoice.CII: schema(t, "CrossIndustryInvoice_100pD16B.xsd", "testdata/cii/CrossIndustryInvoice_100pD16B-subset.xsd"),
+	}
+	exempt := invoice()
+	exempt.VAT = einvoice.VAT{Category: einvoice.Exempt, ExemptionReason: "Kleinunternehmer gemäß § 19 UStG"}
+	exempt.Seller.VATID, exempt.Seller.TaxNumber = "", "22/815/08154"
+	minimal := invoice()
+	minimal.DueDate, minimal.BuyerReference, minimal.Payment = time.Time{}, "", einvoice.Payment{}
+	minimal.PeriodStart, minimal.PeriodEnd = time.Time{}, time.Time{}
+	minimal.Seller.Contact, minimal.Seller.Email, minimal.Buyer.Email = einvoice.Contact{}, "", ""
+	invoices := map[string]einvoice.Invoice{"standard": invoice(), "exempt": exempt, "minimal": minimal}
+
+	dir := t.TempDir()
+	for s, xsd := range schemas {
+		for name, i := range invoices {
+			for _, p := range []einvoice.Profile{einvoice.EN16931, einvoice.XRechnung} {
+				if name == "minimal" && p == einvoice.XRechnung {
+					continue
+				}
+				b, err := einvoice.Marshal(i, s, p)
+				require.NoError(t, err, name)
+				f := filepath.Join(dir, string(s)+"-"+name+".xml")
+				require.NoError(t, os.WriteFile(f, b, 0o600))
+				out, err := exec.Command(xmllint, "--noout", "--schema", xsd, f).CombinedOutput()
+				assert.NoError(t, err, "%s %s %s: %s", s, name, p, out)
+			}
+		}
+	}
+
+	// The schemas reject documents out of order.
+	f := filepath.Join(dir, "disordered.xml")
+	require.NoError(t, os.WriteFile(f, []byte(`<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+		xmlns:cbc="urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2">
+		<cbc:IssueDate>2020-11-02</cbc:IssueDate><cbc:ID>1</cbc:ID></Invoice>`), 0o600))
+	assert.Error(t, exec.Command(xmllint, "--noout", "--schema", schemas[einvoice.UBL], f).Run())
+}
+
+// schema finds the official schema name below testdata/official, where the
+// einvoice-schemas target of the makefile unpacks the artefacts, or returns
+// the subset.
+func schema(t *testing.T, name, subset string) string {
+	var official string
+	err := filepath.WalkDir("testdata/official", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || official != "" {
+			return err
+		}
+		if !d.IsDir() && d.Name() == name {
+			official = path
+		}
+		return nil
+	})
+	if !errors.Is(err, fs.ErrNotExist) {
+		require.NoError(t, err)
+	}
+	if official == "" {
+		t.Logf("%s not found in testdata/official, validating against %s", name, subset)
+		return subset
+	}
+	return official
+}
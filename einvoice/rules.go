@@ -0,0 +1,92 @@
+package einvoice
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Violation is a violated business rule. Rule is the identifier of the
+// rule, e.g. "BR-06" of EN 16931 or "BR-DE-15" of XRechnung, or the
+// business term the profile makes mandatory.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Violations lists the business rules an invoice violates.
+type Violations []Violation
+
+func (vs Violations) Error() string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = fmt.Sprintf("%s: %s", v.Rule, v.Message)
+	}
+	return strings.Join(s, "; ")
+}
+
+// vatID matches VAT identifiers prefixed with a country code (BR-CO-09).
+var vatID = regexp.MustCompile(`^[A-Z]{2}[0-9A-Za-z+*.]{2,12}$`)
+
+// Validate checks the invoice against the business rules of EN 16931 and,
+// for XRechnung, the rules of the CIUS that its content is subject to.
+// Rules the model or the writers guarantee, e.g. the calculation of the
+// totals, are not checked. It returns nil or the Violations.
+func (i Invoice) Validate(p Profile) error {
+	var vs Violations
+	check := func(ok bool, rule, msg string) {
+		if !ok {
+			vs = append(vs, Violation{Rule: rule, Message: msg})
+		}
+	}
+	check(len(i.Number) > 0, "BR-02", "invoice number missing")
+	check(!i.IssueDate.IsZero(), "BR-03", "issue date missing")
+	check(len(i.Currency) == 3, "BR-05", "invoice currency code missing")
+	check(len(i.Seller.Name) > 0, "BR-06", "seller name missing")
+	check(len(i.Buyer.Name) > 0, "BR-07", "buyer name missing")
+	check(len(i.Seller.Address.CountryCode) == 2, "BR-09", "seller country code missing")
+	check(len(i.Buyer.Address.CountryCode) == 2, "BR-11", "buyer country code missing")
+	check(len(i.Lines) > 0, "BR-16", "invoice without lines")
+	for _, l := range i.Lines {
+		check(len(l.ID) > 0, "BR-21", "invoice line identifier missing")
+		check(l.Hours != 0, "BR-22", fmt.Sprintf("line %s: invoiced quantity missing", l.ID))
+		check(len(l.Name) > 0, "BR-25", fmt.Sprintf("line %s: item name missing", l.ID))
+		check(l.Price >= 0, "BR-27", fmt.Sprintf("line %s: item net price negative", l.ID))
+	}
+	check(i.PeriodEnd.IsZero() || !i.PeriodEnd.Before(i.PeriodStart), "BR-29", "invoicing period ends before it starts")
+	for _, id := range []string{i.Seller.VATID, i.Buyer.VATID} {
+		check(len(id) == 0 || vatID.MatchString(id), "BR-CO-09", fmt.Sprintf("VAT identifier %q lacks a country prefix", id))
+	}
+	check(i.Totals().Payable <= 0 || !i.DueDate.IsZero() || len(i.PaymentTerms) > 0, "BR-CO-25", "payment due date or terms missing")
+	taxID := len(i.Seller.VATID) > 0 || len(i.Seller.TaxNumber) > 0
+	switch i.VAT.Category {
+	case StandardRate:
+		check(taxID, "BR-S-02", "seller VAT identifier or tax registration missing")
+		check(i.VAT.Rate > 0, "BR-S-05", "standard rate VAT rate must be greater than zero")
+		check(len(i.VAT.ExemptionReason) == 0, "BR-S-10", "standard rated VAT must not have an exemption reason")
+	case Exempt:
+		check(taxID, "BR-E-02", "seller VAT identifier or tax registration missing")
+		check(i.VAT.Rate == 0, "BR-E-05", "exempt VAT rate must be zero")
+		check(len(i.VAT.ExemptionReason) > 0, "BR-E-10", "VAT exemption reason missing")
+	default:
+		check(false, "BT-151", fmt.Sprintf("unsupported VAT category %q", i.VAT.Category))
+	}
+	if p == XRechnung {
+		check(len(i.Payment.IBAN) > 0, "BR-DE-1", "payment instructions missing")
+		check(len(i.Seller.Address.City) > 0, "BR-DE-3", "seller city missing")
+		check(len(i.Seller.Address.PostCode) > 0, "BR-DE-4", "seller post code missing")
+		check(len(i.Seller.Contact.Name) > 0, "BR-DE-5", "seller contact point missing")
+		check(len(i.Seller.Contact.Phone) > 0, "BR-DE-6", "seller contact telephone number missing")
+		check(len(i.Seller.Contact.Email) > 0, "BR-DE-7", "seller contact email address missing")
+		check(len(i.Buyer.Address.City) > 0, "BR-DE-8", "buyer city missing")
+		check(len(i.Buyer.Address.PostCode) > 0, "BR-DE-9", "buyer post code missing")
+		check(len(i.BuyerReference) > 0, "BR-DE-15", "buyer reference missing")
+		check(taxID, "BR-DE-16", "seller VAT identifier or tax number missing")
+		check(len(i.Seller.Email) > 0, "BT-34", "seller electronic address missing")
+		check(len(i.Buyer.Email) > 0, "BT-49", "buyer electronic address missing")
+	}
+	if len(vs) > 0 {
+		return vs
+	}
+	return nil
+}
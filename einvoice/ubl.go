@@ -0,0 +1,245 @@
+package einvoice
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// UBL namespaces.
+const (
+	ublInvoiceNS = "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+	ublCacNS     = "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+	ublCbcNS     = "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2"
+)
+
+// The UBL types below declare the elements in the order of the schema.
+
+type ublInvoice struct {
+	XMLName              xml.Name         `xml:"Invoice"`
+	NS                   string           `xml:"xmlns,attr"`
+	CacNS                string           `xml:"xmlns:cac,attr"`
+	CbcNS                string           `xml:"xmlns:cbc,attr"`
+	CustomizationID      string           `xml:"cbc:CustomizationID"`
+	ID                   string           `xml:"cbc:ID"`
+	IssueDate            string           `xml:"cbc:IssueDate"`
+	DueDate              string           `xml:"cbc:DueDate,omitempty"`
+	InvoiceTypeCode      string           `xml:"cbc:InvoiceTypeCode"`
+	DocumentCurrencyCode string           `xml:"cbc:DocumentCurrencyCode"`
+	BuyerReference       string           `xml:"cbc:BuyerReference,omitempty"`
+	InvoicePeriod        *ublPeriod       `xml:"cac:InvoicePeriod"`
+	Supplier             ublParty         `xml:"cac:AccountingSupplierParty>cac:Party"`
+	Customer             ublParty         `xml:"cac:AccountingCustomerParty>cac:Party"`
+	PaymentMeans         *ublPaymentMeans `xml:"cac:PaymentMeans"`
+	PaymentTerms         *ublPaymentTerms `xml:"cac:PaymentTerms"`
+	TaxTotal             ublTaxTotal      `xml:"cac:TaxTotal"`
+	LegalMonetaryTotal   ublMonetaryTotal `xml:"cac:LegalMonetaryTotal"`
+	Lines                []ublInvoiceLine `xml:"cac:InvoiceLine"`
+}
+
+type ublPeriod struct {
+	StartDate string `xml:"cbc:StartDate,omitempty"`
+	EndDate   string `xml:"cbc:EndDate,omitempty"`
+}
+
+type ublParty struct {
+	EndpointID       *ublIdentifier   `xml:"cbc:EndpointID"`
+	PostalAddress    ublAddress       `xml:"cac:PostalAddress"`
+	PartyTaxSchemes  []ublPartyScheme `xml:"cac:PartyTaxScheme"`
+	RegistrationName string           `xml:"cac:PartyLegalEntity>cbc:RegistrationName"`
+	Contact          *ublContact      `xml:"cac:Contact"`
+}
+
+type ublIdentifier struct {
+	SchemeID string `xml:"schemeID,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type ublAddress struct {
+	StreetName string `xml:"cbc:StreetName,omitempty"`
+	CityName   string `xml:"cbc:CityName,omitempty"`
+	PostalZone string `xml:"cbc:PostalZone,omitempty"`
+	Country    string `xml:"cac:Country>cbc:IdentificationCode"`
+}
+
+type ublPartyScheme struct {
+	CompanyID string `xml:"cbc:CompanyID"`
+	TaxScheme string `xml:"cac:TaxScheme>cbc:ID"`
+}
+
+type ublContact struct {
+	Name           string `xml:"cbc:Name,omitempty"`
+	Telephone      string `xml:"cbc:Telephone,omitempty"`
+	ElectronicMail string `xml:"cbc:ElectronicMail,omitempty"`
+}
+
+type ublPaymentMeans struct {
+	PaymentMeansCode string          `xml:"cbc:PaymentMeansCode"`
+	Account          ublFinancialAcc `xml:"cac:PayeeFinancialAccount"`
+}
+
+type ublFinancialAcc struct {
+	ID     string     `xml:"cbc:ID"`
+	Branch *ublBranch `xml:"cac:FinancialInstitutionBranch"`
+}
+
+type ublBranch struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublPaymentTerms struct {
+	Note string `xml:"cbc:Note"`
+}
+
+type ublAmount struct {
+	CurrencyID string `xml:"currencyID,attr"`
+	Value      string `xml:",chardata"`
+}
+
+type ublTaxTotal struct {
+	TaxAmount   ublAmount      `xml:"cbc:TaxAmount"`
+	TaxSubtotal ublTaxSubtotal `xml:"cac:TaxSubtotal"`
+}
+
+type ublTaxSubtotal struct {
+	TaxableAmount ublAmount      `xml:"cbc:TaxableAmount"`
+	TaxAmount     ublAmount      `xml:"cbc:TaxAmount"`
+	TaxCategory   ublTaxCategory `xml:"cac:TaxCategory"`
+}
+
+type ublTaxCategory struct {
+	ID                 string `xml:"cbc:ID"`
+	Percent            string `xml:"cbc:Percent"`
+	TaxExemptionReason string `xml:"cbc:TaxExemptionReason,omitempty"`
+	TaxScheme          string `xml:"cac:TaxScheme>cbc:ID"`
+}
+
+type ublMonetaryTotal struct {
+	LineExtensionAmount ublAmount `xml:"cbc:LineExtensionAmount"`
+	TaxExclusiveAmount  ublAmount `xml:"cbc:TaxExclusiveAmount"`
+	TaxInclusiveAmount  ublAmount `xml:"cbc:TaxInclusiveAmount"`
+	PayableAmount       ublAmount `xml:"cbc:PayableAmount"`
+}
+
+type ublInvoiceLine struct {
+	ID                  string         `xml:"cbc:ID"`
+	InvoicedQuantity    ublQuantity    `xml:"cbc:InvoicedQuantity"`
+	LineExtensionAmount ublAmount      `xml:"cbc:LineExtensionAmount"`
+	ItemName            string         `xml:"cac:Item>cbc:Name"`
+	ItemTaxCategory     ublTaxCategory `xml:"cac:Item>cac:ClassifiedTaxCategory"`
+	PriceAmount         ublAmount      `xml:"cac:Price>cbc:PriceAmount"`
+}
+
+type ublQuantity struct {
+	UnitCode string `xml:"unitCode,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// ubl maps an invoice to UBL.
+func ubl(i Invoice, p Profile) ublInvoice {
+	t := i.Totals()
+	amount := func(a Amount) ublAmount {
+		return ublAmount{CurrencyID: i.Currency, Value: a.String()}
+	}
+	category := ublTaxCategory{ID: i.VAT.Category, Percent: formatDecimal(i.VAT.Rate), TaxScheme: "VAT"}
+	u := ublInvoice{
+		NS:                   ublInvoiceNS,
+		CacNS:                ublCacNS,
+		CbcNS:                ublCbcNS,
+		CustomizationID:      string(p),
+		ID:                   i.Number,
+		IssueDate:            ublDate(i.IssueDate),
+		DueDate:              ublDate(i.DueDate),
+		InvoiceTypeCode:      typeCode,
+		DocumentCurrencyCode: i.Currency,
+		BuyerReference:       i.BuyerReference,
+		Supplier:             ublPartyOf(i.Seller),
+		Customer:             ublPartyOf(i.Buyer),
+		TaxTotal: ublTaxTotal{
+			TaxAmount: amount(t.Tax),
+			TaxSubtotal: ublTaxSubtotal{
+				TaxableAmount: amount(t.TaxBasis),
+				TaxAmount:     amount(t.Tax),
+				TaxCategory:   category,
+			},
+		},
+		LegalMonetaryTotal: ublMonetaryTotal{
+			LineExtensionAmount: amount(t.LineNet),
+			TaxExclusiveAmount:  amount(t.TaxBasis),
+			TaxInclusiveAmount:  amount(t.Gross),
+			PayableAmount:       amount(t.Payable),
+		},
+	}
+	u.TaxTotal.TaxSubtotal.TaxCategory.TaxExemptionReason = i.VAT.ExemptionReason
+	if !i.PeriodStart.IsZero() || !i.PeriodEnd.IsZero() {
+		u.InvoicePeriod = &ublPeriod{StartDate: ublDate(i.PeriodStart), EndDate: ublDate(i.PeriodEnd)}
+	}
+	if len(i.Payment.IBAN) > 0 {
+		u.PaymentMeans = &ublPaymentMeans{
+			PaymentMeansCode: sepapayment,
+			Account:          ublFinancialAcc{ID: i.Payment.IBAN},
+		}
+		if len(i.Payment.BIC) > 0 {
+			u.PaymentMeans.Account.Branch = &ublBranch{ID: i.Payment.BIC}
+		}
+	}
+	if len(i.PaymentTerms) > 0 {
+		u.PaymentTerms = &ublPaymentTerms{Note: i.PaymentTerms}
+	}
+	if c := i.Seller.Contact; c != (Contact{}) {
+		u.Supplier.Contact = &ublContact{Name: c.Name, Telephone: c.Phone, ElectronicMail: c.Email}
+	}
+	for _, l := range i.Lines {
+		u.Lines = append(u.Lines, ublInvoiceLine{
+			ID:                  l.ID,
+			InvoicedQuantity:    ublQuantity{UnitCode: hoursUnit, Value: formatDecimal(l.Hours)},
+			LineExtensionAmount: amount(l.NetAmount),
+			ItemName:            l.Name,
+			ItemTaxCategory:     category,
+			PriceAmount:         amount(l.Price),
+		})
+	}
+	return u
+}
+
+func ublPartyOf(p Party) ublParty {
+	u := ublParty{
+		PostalAddress: ublAddress{
+			StreetName: p.Address.Street,
+			CityName:   p.Address.City,
+			PostalZone: p.Address.PostCode,
+			Country:    p.Address.CountryCode,
+		},
+		RegistrationName: p.Name,
+	}
+	if len(p.Email) > 0 {
+		u.EndpointID = &ublIdentifier{SchemeID: "EM", Value: p.Email}
+	}
+	if len(p.VATID) > 0 {
+		u.PartyTaxSchemes = append(u.PartyTaxSchemes, ublPartyScheme{CompanyID: p.VATID, TaxScheme: "VAT"})
+	}
+	if len(p.TaxNumber) > 0 {
+		u.PartyTaxSchemes = append(u.PartyTaxSchemes, ublPartyScheme{CompanyID: p.TaxNumber, TaxScheme: "FC"})
+	}
+	return u
+}
+
+func ublDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// marshal writes a document with XML declaration.
+func marshal(doc interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	e := xml.NewEncoder(&b)
+	e.Indent("", "  ")
+	if err := e.Encode(doc); err != nil {
+		return nil, err
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
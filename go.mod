@@ -9,6 +9,6 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.12.1
+	go.yaml.in/yaml/v3 v3.0.5
+	golang.org/x/crypto v0.31.0
 )
-
-require go.yaml.in/yaml/v3 v3.0.5 // indirect
@@ -1,10 +1,12 @@
 package fusionauth
 
 import (
-	"crypto/rsa"
+	"context"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -14,11 +16,19 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/dgrijalva/jwt-go/v4"
 	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/oidc"
 )
 
+// DefaultBaseURL is the address of a local FusionAuth instance.
+const DefaultBaseURL = "http://localhost:9011"
+
+// Endpoints of a local FusionAuth instance.
+//
+// Deprecated: use the endpoints of a Provider.
 const (
 	// AuthorizeEndpoint ...
 	AuthorizeEndpoint = "http://localhost:9011/oauth2/authorize"
@@ -32,13 +42,54 @@ const (
 	TokenEndpoint = "http://localhost:9011/oauth2/token"
 )
 
+// Provider is the FusionAuth identity provider. It implements the
+// oidc.IdentityProvider interface.
+type Provider struct {
+	baseURL string
+	issuer  string
+}
+
+// NewProvider instantiates a FusionAuth provider. The issuer is configured
+// per tenant in FusionAuth, e.g. "invoice.mvp".
+func NewProvider(baseURL, issuer string) Provider {
+	return Provider{baseURL: strings.TrimSuffix(baseURL, "/"), issuer: issuer}
+}
+
+// Issuer implements the oidc.IdentityProvider interface.
+func (p Provider) Issuer() string {
+	return p.issuer
+}
+
+// Endpoints implements the oidc.IdentityProvider interface.
+func (p Provider) Endpoints() oidc.Endpoints {
+	return oidc.Endpoints{
+		Authorization: p.baseURL + "/oauth2/authorize",
+		Token:         p.baseURL + "/oauth2/token",
+		JWKS:          p.baseURL + "/.well-known/jwks.json",
+		UserInfo:      p.baseURL + "/oauth2/userinfo",
+		EndSession:    p.baseURL + "/oauth2/logout",
+		Introspection: p.baseURL + "/oauth2/introspect",
+
+		DeviceAuthorization: p.baseURL + "/oauth2/device_authorize",
+	}
+}
+
+// KeySet implements the oidc.IdentityProvider interface.
+func (p Provider) KeySet(ctx context.Context) (map[string]jwks.Key, error) {
+	client, err := Client(false) // no TLS
+	if err != nil {
+		return nil, errors.Wrap(err, "creating client instance")
+	}
+	return oidc.FetchKeySet(ctx, client, p.Endpoints().JWKS)
+}
+
 // Key represents a JSON Web Key
 type Key struct {
 	Alg          string `json:"alg"`
 	ID           string `json:"kid"`
 	PublicKeyPEM string `json:"publicKey"`
 	Use          string `json:"use"`
-	Instance     *rsa.PublicKey
+	Instance     crypto.PublicKey
 }
 
 // KeySet holds a JSON Web Key Set (JWKS)
@@ -48,10 +99,11 @@ type KeySet struct {
 
 // AuthInfo represents incomming data from the identity provider.
 type AuthInfo struct {
-	AccessToken string  `json:"access_token"`
-	ExpiresIn   float64 `json:"expires_in"`
-	TokenType   string  `json:"token_type"`
-	UserID      string  `json:"userId"`
+	AccessToken  string  `json:"access_token"`
+	ExpiresIn    float64 `json:"expires_in"`
+	RefreshToken string  `json:"refresh_token,omitempty"`
+	TokenType    string  `json:"token_type"`
+	UserID       string  `json:"userId"`
 }
 
 // AuthConfig holds configuration for external IDP integration.
@@ -61,6 +113,8 @@ type AuthConfig struct {
 	GrantType    string
 	Issuer       string
 	TokenURI     string
+	AuthorizeURI string
+	RevokeURI    string
 	RedirectURI  string // Must match FA config for "Authorized redirect URLs"
 }
 
@@ -87,10 +141,13 @@ func tlsTransportConfig() (http.RoundTripper, error) {
 	return t, nil
 }
 
+// DefaultTimeout bounds the requests of the clients returned by Client.
+const DefaultTimeout = 10 * time.Second
+
 // Client returns a http.Client instance with TLS transport configured if tls
 // is set to true.
 func Client(tls bool) (*http.Client, error) {
-	var c http.Client
+	c := http.Client{Timeout: DefaultTimeout}
 	if tls {
 		t, err := tlsTransportConfig()
 		if err != nil {
@@ -106,13 +163,13 @@ func Client(tls bool) (*http.Client, error) {
 	return &c, nil
 }
 
-func accessCodeGrant(data url.Values) (string, error) {
+func (p Provider) accessCodeGrant(cfg AuthConfig, data url.Values) (string, error) {
 	var code string
 
 	form := make(url.Values)
 	form.Set("response_type", "code")
-	form.Set("client_id", os.Getenv("CLIENT_ID"))       // Invoice MVP
-	form.Set("redirect_uri", os.Getenv("REDIRECT_URI")) // Must match FA config.
+	form.Set("client_id", cfg.ClientID)       // Invoice MVP
+	form.Set("redirect_uri", cfg.RedirectURI) // Must match FA config.
 
 	// Overwrite default settings
 	if ss, ok := data["client_id"]; ok {
@@ -128,7 +185,7 @@ func accessCodeGrant(data url.Values) (string, error) {
 
 	// URL-encoded payload
 	payload := form.Encode()
-	req, err := http.NewRequest("POST", AuthorizeEndpoint, strings.NewReader(payload))
+	req, err := http.NewRequest("POST", p.Endpoints().Authorization, strings.NewReader(payload))
 	if err != nil {
 		return code, errors.Wrap(err, "posting URL-encoded payload")
 	}
@@ -138,10 +195,10 @@ func accessCodeGrant(data url.Values) (string, error) {
 	// HTTP roundtrip, redirects are not followed.
 	var noRedirect http.RoundTripper = &http.Transport{}
 	res, err := noRedirect.RoundTrip(req)
-	defer res.Body.Close()
 	if err != nil {
 		return code, errors.Wrap(err, "posting URL-encoded payload")
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusFound {
 		return code, errors.Errorf("Unexpected response status %d", res.StatusCode)
@@ -173,13 +230,29 @@ func accessCodeGrant(data url.Values) (string, error) {
 	return q["code"][0], nil
 }
 
-// Login uses the provided user credentials to login with
-// the IDM and converts the resulting code grant to JWT token.
+// Login uses the provided user credentials to login with the local
+// FusionAuth instance and the client configured by the environment
+// variables "CLIENT_ID", "CLIENT_SECRET", "GRANT_TYPE" and "REDIRECT_URI".
+//
+// Deprecated: use Provider.Login.
 func Login(data url.Values) (AuthInfo, error) {
+	cfg := AuthConfig{
+		ClientID:     os.Getenv("CLIENT_ID"),
+		ClientSecret: os.Getenv("CLIENT_SECRET"),
+		GrantType:    os.Getenv("GRANT_TYPE"),
+		RedirectURI:  os.Getenv("REDIRECT_URI"),
+	}
+	return NewProvider(DefaultBaseURL, "").Login(cfg, data)
+}
+
+// Login uses the provided user credentials to login with the IDM and
+// converts the resulting code grant to JWT token. The client of the config
+// is used unless data sets "client_id" and "client_secret".
+func (p Provider) Login(cfg AuthConfig, data url.Values) (AuthInfo, error) {
 
 	var auth AuthInfo
 
-	grant, err := accessCodeGrant(data)
+	grant, err := p.accessCodeGrant(cfg, data)
 	if err != nil {
 		return auth, errors.Wrap(err, "access code grant retrieval failed")
 	}
@@ -188,10 +261,10 @@ func Login(data url.Values) (AuthInfo, error) {
 	//form.Set("user_code", "")
 	//form.Set("scope", "")
 	form.Set("code", grant)
-	form.Set("grant_type", os.Getenv("GRANT_TYPE"))
-	form.Set("redirect_uri", os.Getenv("REDIRECT_URI")) // Must match FA config.
-	form.Set("client_id", os.Getenv("CLIENT_ID"))       // Invoice MVP
-	form.Set("client_secret", os.Getenv("CLIENT_SECRET"))
+	form.Set("grant_type", cfg.GrantType)
+	form.Set("redirect_uri", cfg.RedirectURI) // Must match FA config.
+	form.Set("client_id", cfg.ClientID)       // Invoice MVP
+	form.Set("client_secret", cfg.ClientSecret)
 
 	// Overwrite default settings
 	if ss, ok := data["client_id"]; ok {
@@ -206,7 +279,7 @@ func Login(data url.Values) (AuthInfo, error) {
 		return auth, errors.Wrap(err, "creating client instance")
 	}
 	payload := form.Encode() // URL-encoded payload
-	res, err := client.Post(TokenEndpoint, "application/x-www-form-urlencoded", strings.NewReader(payload))
+	res, err := client.Post(p.Endpoints().Token, "application/x-www-form-urlencoded", strings.NewReader(payload))
 	if err != nil {
 		return auth, errors.Wrap(err, "posting URL-encoded payload")
 	}
@@ -240,6 +313,82 @@ func Login(data url.Values) (AuthInfo, error) {
 	return auth, nil
 }
 
+// ErrUserNotFound is returned for unknown and deactivated users.
+var ErrUserNotFound = errors.New("user not found")
+
+// Users looks up the users registered for an application with the user API
+// of FusionAuth. The API key needs the permission GET /api/user.
+type Users struct {
+	provider      Provider
+	apiKey        string
+	applicationID string
+}
+
+// Users returns the users registered for the application, in FusionAuth
+// the ID of the OAuth2 client.
+func (p Provider) Users(apiKey, applicationID string) Users {
+	return Users{provider: p, apiKey: apiKey, applicationID: applicationID}
+}
+
+// Roles retrieves the roles the user has in the application now.
+func (u Users) Roles(ctx context.Context, userID string) ([]string, error) {
+	uri := fmt.Sprintf("%s/api/user/%s", u.provider.baseURL, url.PathEscape(userID))
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Authorization", u.apiKey)
+	client, err := Client(false) // no TLS
+	if err != nil {
+		return nil, errors.Wrap(err, "creating client instance")
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving user")
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrUserNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Unexpected response status %d", res.StatusCode)
+	}
+	var body struct {
+		User struct {
+			Active        bool `json:"active"`
+			Registrations []struct {
+				ApplicationID string   `json:"applicationId"`
+				Roles         []string `json:"roles"`
+			} `json:"registrations"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling response body")
+	}
+	if !body.User.Active {
+		return nil, ErrUserNotFound
+	}
+	for _, r := range body.User.Registrations {
+		if r.ApplicationID == u.applicationID {
+			return r.Roles, nil
+		}
+	}
+	return nil, nil
+}
+
+// RolesOf implements the rest.RoleDirectory interface. Users the identity
+// provider can not be asked about count as unknown.
+func (u Users) RolesOf(subject string) ([]string, bool) {
+	roles, err := u.Roles(context.Background(), subject)
+	if err != nil {
+		if err != ErrUserNotFound {
+			log.Println("fusionauth: resolving roles:", err)
+		}
+		return nil, false
+	}
+	return roles, true
+}
+
 // JSONWebKeySet retrieves the publisched set of JSON Web
 // Keys from the identity provider.
 func JSONWebKeySet(jwksURI string) (KeySet, error) {
@@ -266,9 +415,17 @@ func JSONWebKeySet(jwksURI string) (KeySet, error) {
 
 // PublicSigningKey retrieves the public signing key
 // identified by the passed key ID.
+//
+// Deprecated: use Provider.PublicSigningKey.
 func PublicSigningKey(keyID string) (Key, error) {
+	return NewProvider(DefaultBaseURL, "").PublicSigningKey(keyID)
+}
+
+// PublicSigningKey retrieves the public signing key identified by the
+// passed key ID.
+func (p Provider) PublicSigningKey(keyID string) (Key, error) {
 	var key Key
-	keyURI := fmt.Sprintf("%s?kid=%s", PublicKeyEndpoint, keyID)
+	keyURI := fmt.Sprintf("%s/api/jwt/public-key?kid=%s", p.baseURL, url.QueryEscape(keyID))
 	client, err := Client(false) // no TLS
 	if err != nil {
 		return key, errors.Wrap(err, "creating client instance")
@@ -283,6 +440,9 @@ func PublicSigningKey(keyID string) (Key, error) {
 	if err != nil {
 		return key, errors.Wrap(err, "reading response body")
 	}
+	if res.StatusCode != http.StatusOK {
+		return key, errors.Errorf("Unexpected response status %d", res.StatusCode)
+	}
 
 	if err := json.Unmarshal(body, &key); err != nil {
 		return key, errors.Wrap(err, "unmarshalling response body")
@@ -311,14 +471,22 @@ func PublicSigningKeyMap(keys []Key, filter string) map[string]Key {
 // RetrievePublicKeyInstance gets the public signing key
 // from the Identity Provider service and parses the PEM
 // key representation into a key instance.
+//
+// Deprecated: use Provider.RetrievePublicKeyInstance.
 func RetrievePublicKeyInstance(keyID string) (Key, error) {
+	return NewProvider(DefaultBaseURL, "").RetrievePublicKeyInstance(keyID)
+}
+
+// RetrievePublicKeyInstance gets the public signing key from the identity
+// provider and parses the PEM key representation into a key instance.
+func (p Provider) RetrievePublicKeyInstance(keyID string) (Key, error) {
 	var key Key
-	k, err := PublicSigningKey(keyID)
+	k, err := p.PublicSigningKey(keyID)
 	if err != nil {
 		return key, errors.Wrap(err, "Could not retrieve public signing key from IDP")
 	}
 	key.PublicKeyPEM = k.PublicKeyPEM
-	pkInstance, err := jwt.ParseRSAPublicKeyFromPEM([]byte(k.PublicKeyPEM))
+	pkInstance, err := ParsePublicKeyPEM([]byte(k.PublicKeyPEM))
 	if err != nil {
 		return key, errors.Wrap(err, "Could not parse public signing key")
 	}
@@ -326,12 +494,41 @@ func RetrievePublicKeyInstance(keyID string) (Key, error) {
 	return key, nil
 }
 
+// ParsePublicKeyPEM parses a PEM encoded PKIX public key, PKCS1 RSA public
+// key or certificate. RSA, ECDSA and Ed25519 keys are supported.
+func ParsePublicKeyPEM(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+}
+
 // RetrievePublicKeyInstances gets the public signing keys
 // from the IDP and parses the PEM key representation.
+//
+// Deprecated: use Provider.RetrievePublicKeyInstances.
 func RetrievePublicKeyInstances(km map[string]Key) (map[string]Key, error) {
+	return NewProvider(DefaultBaseURL, "").RetrievePublicKeyInstances(km)
+}
+
+// RetrievePublicKeyInstances gets the public signing keys from the IDP and
+// parses the PEM key representation.
+func (p Provider) RetrievePublicKeyInstances(km map[string]Key) (map[string]Key, error) {
 	im := make(map[string]Key, len(km))
 	for _, v := range km {
-		key, err := RetrievePublicKeyInstance(v.ID)
+		key, err := p.RetrievePublicKeyInstance(v.ID)
 		if err != nil {
 			return nil, errors.Wrap(err, "Could not retrieve public signing key instance")
 		}
@@ -1,27 +1,63 @@
 package fusionauth_test
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
-	"log"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/url"
-	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dgrijalva/jwt-go/v4"
-	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
 	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+	"github.com/tullo/invoice-mvp/identityprovider/stub"
+	"github.com/tullo/invoice-mvp/identityprovider/stub/stubtest"
 )
 
-func Test_client(t *testing.T) {
-
-	err := godotenv.Load("../../.env")
+// writeCertificate writes a self-signed certificate to the file
+// localhost+2.pem of a temporary BASE_DIR.
+func writeCertificate(t *testing.T, subject pkix.Name) {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      subject,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &k.PublicKey, k)
 	if err != nil {
-		log.Println("Error loading .env file")
 		t.Fatal(err)
 	}
+	dir := t.TempDir()
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, "localhost+2.pem"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BASE_DIR", dir)
+}
+
+func Test_client(t *testing.T) {
+	writeCertificate(t, pkix.Name{
+		Organization:       []string{"mkcert development certificate"},
+		OrganizationalUnit: []string{"go@invoice.mvp"},
+	})
 
 	tests := []struct {
 		name    string
@@ -52,6 +88,9 @@ func Test_client(t *testing.T) {
 				t.Errorf("client() client: %v", c)
 				return
 			}
+			if c.Timeout != fusionauth.DefaultTimeout {
+				t.Errorf("client() timeout: %v, want %v", c.Timeout, fusionauth.DefaultTimeout)
+			}
 			// check our tls config
 			if tt.wantTLS {
 				// should have configured custom transport
@@ -68,7 +107,7 @@ func Test_client(t *testing.T) {
 				}
 				// subject should match
 				var subject pkix.RDNSequence
-				sub := "OU=anda@pop-os (Andreas),O=mkcert development certificate"
+				sub := "OU=go@invoice.mvp,O=mkcert development certificate"
 				rawSubj := rCAs.Subjects()[0]
 				if _, err := asn1.Unmarshal(rawSubj, &subject); err != nil {
 					t.Errorf("client() Unmarshal err: %v", err)
@@ -83,15 +122,11 @@ func Test_client(t *testing.T) {
 }
 
 func TestLogin(t *testing.T) {
-	// skips this test if short flag was provided.
-	if testing.Short() {
-		t.Skip()
-	}
-	err := godotenv.Load("../../.env")
-	if err != nil {
-		log.Println("Error loading .env file")
-		t.Fatal(err)
-	}
+	idp := stubtest.Start(t)
+	// A second application with its own user.
+	testApp := stub.Client{ID: "test-app", Secret: "t3st", RedirectURI: stubtest.RedirectURI}
+	idp.AddClient(testApp)
+	idp.AddUser(stub.User{ID: "0d3a8b4e-2d5c-4d8e-9c1e-7f3b5a6c9d21", LoginID: "test@invoice.mvp", Password: "T3stp4ss"})
 
 	type args struct {
 		loginId       string
@@ -109,11 +144,11 @@ func TestLogin(t *testing.T) {
 		{
 			name: "Valid Invoice App Login",
 			args: args{
-				os.Getenv("MVP_USERNAME"),
-				os.Getenv("MVP_PASSWORD"),
-				os.Getenv("CLIENT_ID"),
-				os.Getenv("CLIENT_SECRET"),
-				os.Getenv("REDIRECT_URI"),
+				stubtest.LoginID,
+				stubtest.Password,
+				stubtest.ClientID,
+				stubtest.ClientSecret,
+				stubtest.RedirectURI,
 			},
 			wantErr: false,
 			wantAlg: "RS256",
@@ -121,11 +156,11 @@ func TestLogin(t *testing.T) {
 		{
 			name: "Valid Test App Login",
 			args: args{
-				os.Getenv("TEST_LOGIN"),
-				os.Getenv("TEST_PASSWD"),
-				os.Getenv("TEST_CLIENT_ID"),     // Test app
-				os.Getenv("TEST_CLIENT_SECRET"), // Test app secret
-				os.Getenv("REDIRECT_URI"),
+				"test@invoice.mvp",
+				"T3stp4ss",
+				testApp.ID,     // Test app
+				testApp.Secret, // Test app secret
+				stubtest.RedirectURI,
 			},
 			wantErr: false,
 			wantAlg: "RS256",
@@ -133,11 +168,23 @@ func TestLogin(t *testing.T) {
 		{
 			name: "Wrong Password",
 			args: args{
-				os.Getenv("TEST_LOGIN"),
+				"test@invoice.mvp",
 				"T0ps3cr3t",
-				os.Getenv("CLIENT_ID"),
-				os.Getenv("CLIENT_SECRET"),
-				os.Getenv("REDIRECT_URI"),
+				stubtest.ClientID,
+				stubtest.ClientSecret,
+				stubtest.RedirectURI,
+			},
+			wantErr: true,
+			wantAlg: "RS256",
+		},
+		{
+			name: "Wrong Client Secret",
+			args: args{
+				stubtest.LoginID,
+				stubtest.Password,
+				stubtest.ClientID,
+				testApp.Secret,
+				stubtest.RedirectURI,
 			},
 			wantErr: true,
 			wantAlg: "RS256",
@@ -153,7 +200,7 @@ func TestLogin(t *testing.T) {
 			data.Set("client_secret", tt.args.client_secret)
 			data.Set("redirect_uri", tt.args.redirect_uri)
 
-			auth, err := fusionauth.Login(data)
+			auth, err := idp.Provider().Login(idp.AuthConfig(), data)
 			if !tt.wantErr && err != nil {
 				t.Errorf("Login() got error: [%v], but did not expect one: %v", err, tt.wantErr)
 				return
@@ -167,11 +214,8 @@ func TestLogin(t *testing.T) {
 				return
 			}
 
-			var claims jwt.Claims
-			token, _, _ := jwt.NewParser().ParseUnverified(auth.AccessToken, claims)
-			//for k, v := range token.Header {
-			//	t.Logf("claim: %s=%v", k, v)
-			//}
+			var claims jwt.MapClaims
+			token, _, _ := jwt.NewParser().ParseUnverified(auth.AccessToken, &claims)
 			if _, ok := token.Header["alg"].(string); !ok {
 				t.Error("Login() token alg header not found")
 				return
@@ -180,11 +224,15 @@ func TestLogin(t *testing.T) {
 			if alg != tt.wantAlg {
 				t.Errorf("Login() unexpected token alg header: got %v want %v", alg, tt.wantAlg)
 			}
+			if aud := claims["aud"]; aud != tt.args.client_id {
+				t.Errorf("Login() unexpected token audience: got %v want %v", aud, tt.args.client_id)
+			}
 		})
 	}
 }
 
 func TestJSONWebKeySet(t *testing.T) {
+	idp := stubtest.Start(t)
 	type args struct {
 		jwksURI string
 	}
@@ -195,7 +243,7 @@ func TestJSONWebKeySet(t *testing.T) {
 	}{
 		{
 			name:    "Load JSON Web Keys from Identity Provider.",
-			args:    args{fusionauth.JWKSEndpoint},
+			args:    args{idp.Provider().Endpoints().JWKS},
 			wantErr: false,
 		},
 	}
@@ -231,9 +279,7 @@ func TestJSONWebKeySet(t *testing.T) {
 }
 
 func TestPublicSigningKey(t *testing.T) {
-	type args struct {
-		keyURI string
-	}
+	idp := stubtest.Start(t)
 	tests := []struct {
 		name    string
 		alg     string
@@ -249,7 +295,7 @@ func TestPublicSigningKey(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ks, err := fusionauth.JSONWebKeySet(fusionauth.JWKSEndpoint)
+			ks, err := fusionauth.JSONWebKeySet(idp.Provider().Endpoints().JWKS)
 			if err != nil {
 				t.Error(err)
 				return
@@ -261,7 +307,7 @@ func TestPublicSigningKey(t *testing.T) {
 			}
 			m := fusionauth.PublicSigningKeyMap(ks.Keys, tt.use)
 			for _, value := range m {
-				k, err := fusionauth.PublicSigningKey(value.ID)
+				k, err := idp.Provider().PublicSigningKey(value.ID)
 				if !tt.wantErr && err != nil {
 					t.Errorf("PublicSigningKey() got error: [%v], but did not expect one: %v", err, tt.wantErr)
 					return
@@ -281,11 +327,15 @@ func TestPublicSigningKey(t *testing.T) {
 			}
 		})
 	}
+
+	if _, err := idp.Provider().PublicSigningKey("unknown"); err == nil {
+		t.Error("PublicSigningKey() expected error for unknown key ID")
+	}
 }
 
 func TestPublicSigningKeyMap(t *testing.T) {
-
-	ks, err := fusionauth.JSONWebKeySet(fusionauth.JWKSEndpoint)
+	idp := stubtest.Start(t)
+	ks, err := fusionauth.JSONWebKeySet(idp.Provider().Endpoints().JWKS)
 	if err != nil {
 		t.Error(err)
 		return
@@ -311,7 +361,7 @@ func TestPublicSigningKeyMap(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := fusionauth.PublicSigningKeyMap(ks.Keys, tt.filter)
-			if len(m) < tt.items {
+			if len(m) != tt.items {
 				t.Errorf("PublicSigningKeyMap() expected map with [%d] items, m=%v", tt.items, m)
 				return
 			}
@@ -320,6 +370,7 @@ func TestPublicSigningKeyMap(t *testing.T) {
 }
 
 func TestRetrievePublicKeyInstances(t *testing.T) {
+	idp := stubtest.Start(t)
 
 	tests := []struct {
 		name   string
@@ -334,7 +385,7 @@ func TestRetrievePublicKeyInstances(t *testing.T) {
 	}
 
 	// Load published JSON key set.
-	ks, err := fusionauth.JSONWebKeySet(fusionauth.JWKSEndpoint)
+	ks, err := fusionauth.JSONWebKeySet(idp.Provider().Endpoints().JWKS)
 	if err != nil {
 		t.Error(err)
 		return
@@ -348,7 +399,7 @@ func TestRetrievePublicKeyInstances(t *testing.T) {
 				t.Errorf("PublicSigningKeyMap() expected map with [%d] items, m=%v", tt.items, km)
 				return
 			}
-			im, err := fusionauth.RetrievePublicKeyInstances(km)
+			im, err := idp.Provider().RetrievePublicKeyInstances(km)
 			if err != nil {
 				t.Errorf("RetrievePublicKeyInstances() expected no error, got [%v]", err)
 			}
@@ -367,3 +418,34 @@ func TestRetrievePublicKeyInstances(t *testing.T) {
 		})
 	}
 }
+
+func TestUsersRoles(t *testing.T) {
+	idp := stubtest.Start(t)
+	tests := []struct {
+		name    string
+		apiKey  string
+		appID   string
+		userID  string
+		want    []string
+		wantErr error
+	}{
+		{"registered user", idp.APIKey, idp.Client.ID, idp.User.ID, idp.User.Roles, nil},
+		{"other application", idp.APIKey, "other-app", idp.User.ID, nil, nil},
+		{"unknown user", idp.APIKey, idp.Client.ID, "d68df2ec-d79a-4fbd-b290-22ae3a91532b", nil, fusionauth.ErrUserNotFound},
+		{"invalid api key", "guess", idp.Client.ID, idp.User.ID, nil, errors.New("Unexpected response status 401")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles, err := idp.Provider().Users(tt.apiKey, tt.appID).Roles(context.Background(), tt.userID)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("Roles() got error: %v", err)
+			}
+			if tt.wantErr != nil && (err == nil || err.Error() != tt.wantErr.Error()) {
+				t.Fatalf("Roles() got error: %v, want: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(roles, tt.want) {
+				t.Errorf("Roles() = %v, want %v", roles, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Package jwks caches the public signing keys published by an identity
+// provider as JSON Web Key Set.
+package jwks
+
+import (
+	"context"
+	"expvar"
+	"io/ioutil"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Cache defaults.
+const (
+	DefaultTTL                = time.Hour
+	DefaultRefreshInterval    = 15 * time.Minute
+	DefaultMinRefreshInterval = 30 * time.Second
+	DefaultFetchTimeout       = 10 * time.Second
+)
+
+// ErrKeyNotFound is returned for key IDs missing from the published set.
+var ErrKeyNotFound = errors.New("key not found in published key set")
+
+// Fetcher loads the current key set, mapped by key ID.
+type Fetcher func(ctx context.Context) (map[string]Key, error)
+
+// Options configure a cache.
+type Options struct {
+	// TTL is the age after which keys are refetched before use. Expired
+	// keys are still served while the identity provider is unavailable.
+	TTL time.Duration
+	// RefreshInterval is the period of the background refresh.
+	RefreshInterval time.Duration
+	// MinRefreshInterval throttles on-demand refreshes triggered by unknown
+	// key IDs, so random "kid" values can not cause a fetch per request.
+	MinRefreshInterval time.Duration
+	// FetchTimeout bounds a refresh, so a hung identity provider does not
+	// stall the requests waiting for keys.
+	FetchTimeout time.Duration
+}
+
+// Stats are the cache metrics.
+type Stats struct {
+	Keys            int       `json:"keys"`
+	Refreshes       uint64    `json:"refreshes"`
+	RefreshFailures uint64    `json:"refreshFailures"`
+	Throttled       uint64    `json:"throttled"`
+	LastRefresh     time.Time `json:"lastRefresh"`
+	LastError       string    `json:"lastError,omitempty"`
+}
+
+// Cache is a concurrency-safe store of public signing keys.
+type Cache struct {
+	fetch Fetcher
+	opts  Options
+	now   func() time.Time
+
+	mu          sync.RWMutex
+	keys        map[string]Key
+	fetched     time.Time // last successful refresh
+	lastAttempt time.Time
+	lastErr     error
+
+	refreshMu sync.Mutex // serializes refreshes
+
+	refreshes uint64
+	failures  uint64
+	throttled uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// File returns a fetcher reading the key set of the JSON file, e.g. of local
+// development keys.
+func File(path string) Fetcher {
+	return func(ctx context.Context) (map[string]Key, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading json web key set")
+		}
+		return ParseSet(b)
+	}
+}
+
+// New instantiates a cache. Zero options take the package defaults.
+func New(fetch Fetcher, opts Options) *Cache {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = DefaultRefreshInterval
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = DefaultMinRefreshInterval
+	}
+	if opts.FetchTimeout <= 0 {
+		opts.FetchTimeout = DefaultFetchTimeout
+	}
+	return &Cache{
+		fetch: fetch,
+		opts:  opts,
+		now:   time.Now,
+		keys:  make(map[string]Key),
+	}
+}
+
+// Key returns the key with the ID. Unknown IDs and expired keys trigger a
+// throttled refresh.
+func (c *Cache) Key(kid string) (Key, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	expired := c.now().Sub(c.fetched) > c.opts.TTL
+	c.mu.RUnlock()
+	if ok && !expired {
+		return key, nil
+	}
+
+	if err := c.refresh(context.Background(), false); err != nil {
+		if ok {
+			// Stale while error: keep serving the expired key.
+			return key, nil
+		}
+		return key, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if key, ok = c.keys[kid]; !ok {
+		return key, errors.Wrapf(ErrKeyNotFound, "kid %s", kid)
+	}
+	return key, nil
+}
+
+// Refresh fetches the key set now, ignoring the throttle.
+func (c *Cache) Refresh(ctx context.Context) error {
+	return c.refresh(ctx, true)
+}
+
+func (c *Cache) refresh(ctx context.Context, force bool) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mu.RLock()
+	since := c.now().Sub(c.lastAttempt)
+	lastErr := c.lastErr
+	c.mu.RUnlock()
+	if !force && since < c.opts.MinRefreshInterval {
+		// A refresh just happened, possibly while we waited for the lock.
+		atomic.AddUint64(&c.throttled, 1)
+		return lastErr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.FetchTimeout)
+	keys, err := c.fetch(ctx)
+	cancel()
+	atomic.AddUint64(&c.refreshes, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastAttempt = c.now()
+	c.lastErr = err
+	if err != nil {
+		atomic.AddUint64(&c.failures, 1)
+		log.Println("jwks: refresh failed:", err)
+		return errors.Wrap(err, "refreshing key set")
+	}
+	c.keys = keys
+	c.fetched = c.lastAttempt
+	return nil
+}
+
+// Start refreshes the keys in the background until Stop is called.
+func (c *Cache) Start() {
+	c.stop = make(chan struct{})
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		t := time.NewTicker(c.opts.RefreshInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				_ = c.Refresh(context.Background())
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background refresh and waits for it to finish.
+func (c *Cache) Stop() {
+	if c.stop == nil {
+		return
+	}
+	close(c.stop)
+	<-c.done
+	c.stop = nil
+}
+
+// Stats returns a snapshot of the cache metrics.
+func (c *Cache) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := Stats{
+		Keys:            len(c.keys),
+		Refreshes:       atomic.LoadUint64(&c.refreshes),
+		RefreshFailures: atomic.LoadUint64(&c.failures),
+		Throttled:       atomic.LoadUint64(&c.throttled),
+		LastRefresh:     c.fetched,
+	}
+	if c.lastErr != nil {
+		s.LastError = c.lastErr.Error()
+	}
+	return s
+}
+
+// Publish exposes the cache metrics as expvar variable, served by the
+// expvar handler at /debug/vars.
+func (c *Cache) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return c.Stats() }))
+}
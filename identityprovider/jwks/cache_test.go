@@ -0,0 +1,104 @@
+package jwks_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+)
+
+// idp simulates the key set endpoint of an identity provider.
+type idp struct {
+	fetches int32
+	down    int32
+}
+
+func (p *idp) fetch(ctx context.Context) (map[string]jwks.Key, error) {
+	atomic.AddInt32(&p.fetches, 1)
+	if atomic.LoadInt32(&p.down) == 1 {
+		return nil, errors.New("connection refused")
+	}
+	return map[string]jwks.Key{
+		"k1": {ID: "k1", Alg: "RS256", Use: "sig"},
+	}, nil
+}
+
+func TestCacheThrottlesUnknownKeyIDs(t *testing.T) {
+	var p idp
+	c := jwks.New(p.fetch, jwks.Options{MinRefreshInterval: time.Hour})
+
+	k, err := c.Key("k1")
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", k.ID)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Key("random-kid")
+			assert.True(t, errors.Is(err, jwks.ErrKeyNotFound), "%v", err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&p.fetches))
+	assert.Equal(t, uint64(50), c.Stats().Throttled)
+}
+
+func TestCacheServesStaleKeysWhileIDPIsDown(t *testing.T) {
+	var p idp
+	c := jwks.New(p.fetch, jwks.Options{TTL: time.Millisecond, MinRefreshInterval: time.Millisecond})
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&p.down, 1)
+	time.Sleep(5 * time.Millisecond)
+
+	k, err := c.Key("k1")
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", k.ID)
+	s := c.Stats()
+	assert.Equal(t, uint64(1), s.RefreshFailures)
+	assert.Contains(t, s.LastError, "connection refused")
+	assert.Equal(t, 1, s.Keys)
+}
+
+func TestCacheFailsWithoutKeys(t *testing.T) {
+	p := idp{down: 1}
+	c := jwks.New(p.fetch, jwks.Options{})
+	_, err := c.Key("k1")
+	assert.Error(t, err)
+}
+
+func TestCacheBackgroundRefresh(t *testing.T) {
+	var p idp
+	c := jwks.New(p.fetch, jwks.Options{RefreshInterval: time.Millisecond})
+	c.Start()
+	time.Sleep(20 * time.Millisecond)
+	c.Stop()
+
+	n := atomic.LoadInt32(&p.fetches)
+	assert.True(t, n > 1, "expected background refreshes, got %d", n)
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&p.fetches))
+}
+
+func TestCacheBoundsHungRefresh(t *testing.T) {
+	hung := func(ctx context.Context) (map[string]jwks.Key, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	c := jwks.New(hung, jwks.Options{FetchTimeout: 10 * time.Millisecond})
+
+	start := time.Now()
+	_, err := c.Key("k1")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
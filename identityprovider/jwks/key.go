@@ -0,0 +1,210 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Key is a public signing key of an identity provider.
+type Key struct {
+	ID       string
+	Alg      string
+	Use      string
+	Instance crypto.PublicKey
+}
+
+// JWK is the JSON representation of a JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string   `json:"kty"`
+	Alg string   `json:"alg,omitempty"`
+	Use string   `json:"use,omitempty"`
+	Kid string   `json:"kid,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	X5c []string `json:"x5c,omitempty"`
+}
+
+// Set is the JSON representation of a JSON Web Key Set.
+type Set struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ParseSet parses a JSON Web Key Set and returns its signing keys mapped by
+// key ID. Keys of unsupported types are skipped.
+func ParseSet(b []byte) (map[string]Key, error) {
+	var s Set
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling json web key set")
+	}
+	m := make(map[string]Key, len(s.Keys))
+	for _, jwk := range s.Keys {
+		// JWK property `use` determines the JWK is for signature verification
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		k, err := jwk.Key()
+		if err != nil {
+			log.Printf("jwks: skipping key %q: %v", jwk.Kid, err)
+			continue
+		}
+		m[k.ID] = k
+	}
+	return m, nil
+}
+
+// Key converts the JWK into a key holding the parsed public key instance.
+func (j JWK) Key() (Key, error) {
+	k := Key{ID: j.Kid, Alg: j.Alg, Use: j.Use}
+	var err error
+	switch {
+	case len(j.X5c) > 0:
+		k.Instance, err = parseX5c(j.X5c[0])
+	case j.Kty == "RSA":
+		k.Instance, err = j.rsa()
+	case j.Kty == "EC":
+		k.Instance, err = j.ecdsa()
+	case j.Kty == "OKP":
+		k.Instance, err = j.okp()
+	default:
+		err = errors.Errorf("unsupported key type %q", j.Kty)
+	}
+	if len(k.Alg) < 1 {
+		// "alg" is optional, assume the common algorithm of the key type.
+		k.Alg = defaultAlg[j.Kty+j.Crv]
+	}
+	return k, err
+}
+
+// NewJWK converts the key into its JSON representation, the inverse of
+// JWK.Key. RSA, ECDSA and Ed25519 keys are supported.
+func NewJWK(k Key) (JWK, error) {
+	j := JWK{Kid: k.ID, Alg: k.Alg, Use: k.Use}
+	switch pub := k.Instance.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = encodeInt(pub.N, 0)
+		j.E = encodeInt(big.NewInt(int64(pub.E)), 0)
+	case *ecdsa.PublicKey:
+		j.Kty = "EC"
+		j.Crv = pub.Curve.Params().Name
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		j.X = encodeInt(pub.X, size)
+		j.Y = encodeInt(pub.Y, size)
+	case ed25519.PublicKey:
+		j.Kty = "OKP"
+		j.Crv = "Ed25519"
+		j.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return j, errors.Errorf("unsupported key type %T", k.Instance)
+	}
+	return j, nil
+}
+
+var defaultAlg = map[string]string{
+	"RSA":        "RS256",
+	"ECP-256":    "ES256",
+	"ECP-384":    "ES384",
+	"ECP-521":    "ES512",
+	"OKPEd25519": "EdDSA",
+}
+
+func (j JWK) rsa() (*rsa.PublicKey, error) {
+	n, err := decodeInt(j.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding modulus")
+	}
+	e, err := decodeInt(j.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding exponent")
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (j JWK) ecdsa() (*ecdsa.PublicKey, error) {
+	var c elliptic.Curve
+	switch j.Crv {
+	case "P-256":
+		c = elliptic.P256()
+	case "P-384":
+		c = elliptic.P384()
+	case "P-521":
+		c = elliptic.P521()
+	default:
+		return nil, errors.Errorf("unsupported curve %q", j.Crv)
+	}
+	x, err := decodeInt(j.X)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding x coordinate")
+	}
+	y, err := decodeInt(j.Y)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding y coordinate")
+	}
+	if !c.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on curve")
+	}
+	return &ecdsa.PublicKey{Curve: c, X: x, Y: y}, nil
+}
+
+func (j JWK) okp() (ed25519.PublicKey, error) {
+	if j.Crv != "Ed25519" {
+		return nil, errors.Errorf("unsupported curve %q", j.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(j.X)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding public key")
+	}
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key size")
+	}
+	return ed25519.PublicKey(x), nil
+}
+
+func parseX5c(s string) (crypto.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding x5c certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing x5c certificate")
+	}
+	return cert.PublicKey, nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 1 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// encodeInt encodes the integer big-endian, left padded with zeros to size
+// bytes.
+func encodeInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
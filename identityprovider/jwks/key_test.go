@@ -0,0 +1,44 @@
+package jwks_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+)
+
+func TestNewJWK(t *testing.T) {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ek, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := []jwks.Key{
+		{ID: "rs", Alg: "RS256", Use: "sig", Instance: &rk.PublicKey},
+		{ID: "es", Alg: "ES512", Use: "sig", Instance: &ek.PublicKey},
+		{ID: "ed", Alg: "EdDSA", Use: "sig", Instance: pub},
+	}
+	var s jwks.Set
+	for _, k := range keys {
+		j, err := jwks.NewJWK(k)
+		assert.NoError(t, err)
+		s.Keys = append(s.Keys, j)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := jwks.ParseSet(b)
+	assert.NoError(t, err)
+	for _, k := range keys {
+		assert.Equal(t, k, m[k.ID])
+	}
+
+	_, err = jwks.NewJWK(jwks.Key{ID: "hs", Instance: []byte("secret")})
+	assert.Error(t, err)
+}
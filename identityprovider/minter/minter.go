@@ -0,0 +1,189 @@
+// Package minter signs access tokens for local development and tests, with
+// HMAC keys or local RSA and ECDSA key pairs, so the API can be used without
+// an identity provider.
+package minter
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+)
+
+// Algorithms lists the supported signing algorithms.
+var Algorithms = []string{"HS256", "RS256", "ES256"}
+
+// Key is a signing key.
+type Key struct {
+	ID  string
+	Alg string
+	// Private is the HMAC key ([]byte), *rsa.PrivateKey or
+	// *ecdsa.PrivateKey.
+	Private crypto.PrivateKey
+}
+
+// Options are the claims of a token.
+type Options struct {
+	Issuer   string
+	Audience []string
+	Subject  string
+	Roles    []string
+	Scopes   []string
+	// TTL is the lifetime of the token.
+	TTL time.Duration
+	// Claims are added to the token and override the claims above.
+	Claims map[string]interface{}
+}
+
+// Mint signs a token with the claims of the options.
+func Mint(k Key, o Options) (string, error) {
+	m := jwt.GetSigningMethod(k.Alg)
+	if m == nil {
+		return "", errors.Errorf("unsupported algorithm %q", k.Alg)
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", errors.Wrap(err, "generating token ID")
+	}
+	now := time.Now()
+	c := jwt.MapClaims{
+		"iat": now.Unix(),
+		"exp": now.Add(o.TTL).Unix(),
+		"jti": hex.EncodeToString(jti),
+	}
+	set := func(name string, v interface{}, ok bool) {
+		if ok {
+			c[name] = v
+		}
+	}
+	set("iss", o.Issuer, len(o.Issuer) > 0)
+	set("sub", o.Subject, len(o.Subject) > 0)
+	set("roles", o.Roles, len(o.Roles) > 0)
+	set("scopes", o.Scopes, len(o.Scopes) > 0)
+	switch len(o.Audience) {
+	case 0:
+	case 1:
+		c["aud"] = o.Audience[0]
+	default:
+		c["aud"] = o.Audience
+	}
+	for name, v := range o.Claims {
+		c[name] = v
+	}
+
+	t := jwt.NewWithClaims(m, c)
+	if len(k.ID) > 0 {
+		t.Header["kid"] = k.ID
+	}
+	s, err := t.SignedString(k.Private)
+	return s, errors.Wrap(err, "signing token")
+}
+
+// HMACKey returns the HS256 signing key.
+func HMACKey(kid string, key []byte) Key {
+	return Key{ID: kid, Alg: "HS256", Private: key}
+}
+
+// GenerateKey generates an RS256 or ES256 key pair. The key ID defaults to
+// the thumbprint of the public key.
+func GenerateKey(alg, kid string) (Key, error) {
+	var k crypto.PrivateKey
+	var err error
+	switch alg {
+	case "RS256":
+		k, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return Key{}, errors.Errorf("cannot generate %q keys, expected RS256 or ES256", alg)
+	}
+	if err != nil {
+		return Key{}, errors.Wrap(err, "generating key")
+	}
+	return newKey(kid, alg, k)
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8, PKCS #1 or SEC 1 private
+// key. The algorithm is derived from the key type, the key ID defaults to
+// the thumbprint of the public key.
+func ParsePrivateKey(kid string, b []byte) (Key, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return Key{}, errors.New("key must be PEM encoded")
+	}
+	var k crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		k, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return Key{}, errors.Wrap(err, "parsing private key")
+	}
+	var alg string
+	switch pk := k.(type) {
+	case *rsa.PrivateKey:
+		alg = "RS256"
+	case *ecdsa.PrivateKey:
+		if pk.Curve != elliptic.P256() {
+			return Key{}, errors.Errorf("unsupported curve %s, expected P-256", pk.Curve.Params().Name)
+		}
+		alg = "ES256"
+	default:
+		return Key{}, errors.Errorf("unsupported key type %T", k)
+	}
+	return newKey(kid, alg, k)
+}
+
+func newKey(kid, alg string, k crypto.PrivateKey) (Key, error) {
+	if len(kid) < 1 {
+		der, err := x509.MarshalPKIXPublicKey(k.(crypto.Signer).Public())
+		if err != nil {
+			return Key{}, errors.Wrap(err, "marshalling public key")
+		}
+		sum := sha256.Sum256(der)
+		kid = base64.RawURLEncoding.EncodeToString(sum[:12])
+	}
+	return Key{ID: kid, Alg: alg, Private: k}, nil
+}
+
+// MarshalPrivateKey encodes the private key in PEM encoded PKCS #8 form.
+func MarshalPrivateKey(k Key) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling private key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// KeySet returns the JSON Web Key Set publishing the public key.
+func KeySet(keys ...Key) (jwks.Set, error) {
+	var s jwks.Set
+	for _, k := range keys {
+		signer, ok := k.Private.(crypto.Signer)
+		if !ok {
+			return s, errors.Errorf("key %s has no public key", k.ID)
+		}
+		j, err := jwks.NewJWK(jwks.Key{ID: k.ID, Alg: k.Alg, Use: "sig", Instance: signer.Public()})
+		if err != nil {
+			return s, err
+		}
+		s.Keys = append(s.Keys, j)
+	}
+	return s, nil
+}
@@ -0,0 +1,151 @@
+package minter_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/minter"
+	"github.com/tullo/invoice-mvp/identityprovider/secret"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+var opts = minter.Options{
+	Issuer:   "invoice.mvp",
+	Audience: []string{"invoice-mvp"},
+	Subject:  "f8c39a31-9ced-4761-8a33-b9c628a67510",
+	Roles:    []string{rest.RoleUser, rest.RoleAdmin},
+	TTL:      time.Minute,
+}
+
+// authenticate verifies the token like the service configured with the key
+// set file and the algorithm.
+func authenticate(t *testing.T, set, alg, token string) (rest.Claims, error) {
+	t.Helper()
+	kf := rest.KeyFunc([]string{alg}, jwks.New(jwks.File(set), jwks.Options{}), nil)
+	a := rest.NewJWTAuthenticator("invoice.mvp", "invoice.mvp", "invoice-mvp", kf)
+	req := httptest.NewRequest("GET", "/activities", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.Authenticate(req)
+}
+
+func writeKeySet(t *testing.T, k minter.Key) string {
+	t.Helper()
+	s, err := minter.KeySet(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(s)
+	path := filepath.Join(t.TempDir(), "dev-jwks.json")
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMintKeyPair(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			k, err := minter.GenerateKey(alg, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.NotEmpty(t, k.ID)
+			set := writeKeySet(t, k)
+
+			// The key survives the PEM round trip with the same key ID.
+			b, err := minter.MarshalPrivateKey(k)
+			assert.NoError(t, err)
+			parsed, err := minter.ParsePrivateKey("", b)
+			assert.NoError(t, err)
+			assert.Equal(t, k.ID, parsed.ID)
+			assert.Equal(t, alg, parsed.Alg)
+
+			token, err := minter.Mint(parsed, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := authenticate(t, set, alg, token)
+			assert.NoError(t, err)
+			assert.Equal(t, opts.Subject, c.Subject)
+			assert.Equal(t, opts.Roles, c.Roles)
+
+			_, err = authenticate(t, set, "RS384", token)
+			assert.Error(t, err, "algorithm not allowed")
+		})
+	}
+}
+
+func TestMintHMAC(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, secret.MinKeySize)
+	ring, err := secret.ParseKeyring("dev:" + base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := minter.Mint(minter.HMACKey("dev", key), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := rest.NewJWTAuthenticator("invoice.mvp", "invoice.mvp", "invoice-mvp", rest.KeyFunc([]string{"HS256"}, nil, ring))
+	req := httptest.NewRequest("GET", "/activities", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	c, err := a.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, opts.Subject, c.Subject)
+}
+
+func TestMintClaims(t *testing.T) {
+	k, err := minter.GenerateKey("ES256", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := writeKeySet(t, k)
+
+	o := opts
+	o.Audience = []string{"other", "invoice-mvp"}
+	o.Scopes = []string{"invoices:read"}
+	token, err := minter.Mint(k, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := authenticate(t, set, "ES256", token)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"invoices:read"}, c.Scopes)
+
+	// Claims override the options.
+	o.Claims = map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}
+	token, err = minter.Mint(k, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = authenticate(t, set, "ES256", token)
+	assert.Error(t, err, "expired")
+
+	o.Claims = nil
+	o.Issuer = "elsewhere"
+	token, err = minter.Mint(k, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = authenticate(t, set, "ES256", token)
+	assert.Error(t, err, "foreign issuer")
+}
+
+func TestKeyErrors(t *testing.T) {
+	_, err := minter.GenerateKey("HS256", "")
+	assert.Error(t, err)
+	_, err = minter.ParsePrivateKey("", []byte("not PEM"))
+	assert.Error(t, err)
+	_, err = minter.Mint(minter.Key{Alg: "none"}, opts)
+	assert.Error(t, err)
+	_, err = minter.KeySet(minter.HMACKey("dev", []byte("secret")))
+	assert.Error(t, err, "HMAC keys are not published")
+}
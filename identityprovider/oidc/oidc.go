@@ -0,0 +1,167 @@
+// Package oidc bootstraps an identity provider from its OpenID Connect
+// discovery document.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+)
+
+// DiscoveryPath is appended to the issuer URL to locate the discovery
+// document.
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// DefaultTimeout bounds the requests of providers discovered without a
+// client, so a hung identity provider fails instead of blocking.
+const DefaultTimeout = 10 * time.Second
+
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// Endpoints of an identity provider.
+type Endpoints struct {
+	Authorization string
+	Token         string
+	JWKS          string
+	UserInfo      string
+	EndSession    string
+	Introspection string
+	Revocation    string
+	// DeviceAuthorization is the endpoint of the device authorization
+	// grant (RFC 8628).
+	DeviceAuthorization string
+}
+
+// IdentityProvider is implemented by the identity providers the invoice
+// service can verify tokens of.
+type IdentityProvider interface {
+	// Issuer is the expected "iss" claim of issued tokens.
+	Issuer() string
+	// Endpoints returns the OAuth2 and OIDC endpoints.
+	Endpoints() Endpoints
+	// KeySet fetches the public signing keys mapped by key ID.
+	KeySet(ctx context.Context) (map[string]jwks.Key, error)
+}
+
+// Configuration is the OpenID Provider Metadata of the discovery document.
+type Configuration struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	UserInfoEndpoint                 string   `json:"userinfo_endpoint,omitempty"`
+	EndSessionEndpoint               string   `json:"end_session_endpoint,omitempty"`
+	IntrospectionEndpoint            string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint               string   `json:"revocation_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint      string   `json:"device_authorization_endpoint,omitempty"`
+	ResponseTypesSupported           []string `json:"response_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	CodeChallengeMethodsSupported    []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// Provider is a generic OpenID Connect identity provider.
+type Provider struct {
+	config Configuration
+	client *http.Client
+}
+
+// Discover loads the discovery document of the issuer. The issuer in the
+// document must match the requested issuer (OIDC Discovery 1.0, 4.3).
+func Discover(ctx context.Context, issuer string, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = defaultClient
+	}
+	issuer = strings.TrimSuffix(issuer, "/")
+	var c Configuration
+	if err := getJSON(ctx, client, issuer+DiscoveryPath, &c); err != nil {
+		return nil, errors.Wrap(err, "retrieving discovery document")
+	}
+	if strings.TrimSuffix(c.Issuer, "/") != issuer {
+		return nil, errors.Errorf("discovery document issuer %q does not match %q", c.Issuer, issuer)
+	}
+	if len(c.JWKSURI) < 1 || len(c.TokenEndpoint) < 1 {
+		return nil, errors.New("discovery document lacks jwks_uri or token_endpoint")
+	}
+	return &Provider{config: c, client: client}, nil
+}
+
+// Configuration returns the discovered provider metadata.
+func (p *Provider) Configuration() Configuration {
+	return p.config
+}
+
+// Issuer implements the IdentityProvider interface.
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// Endpoints implements the IdentityProvider interface.
+func (p *Provider) Endpoints() Endpoints {
+	return Endpoints{
+		Authorization: p.config.AuthorizationEndpoint,
+		Token:         p.config.TokenEndpoint,
+		JWKS:          p.config.JWKSURI,
+		UserInfo:      p.config.UserInfoEndpoint,
+		EndSession:    p.config.EndSessionEndpoint,
+		Introspection: p.config.IntrospectionEndpoint,
+		Revocation:    p.config.RevocationEndpoint,
+
+		DeviceAuthorization: p.config.DeviceAuthorizationEndpoint,
+	}
+}
+
+// KeySet implements the IdentityProvider interface.
+func (p *Provider) KeySet(ctx context.Context) (map[string]jwks.Key, error) {
+	return FetchKeySet(ctx, p.client, p.config.JWKSURI)
+}
+
+// FetchKeySet retrieves and parses the JSON Web Key Set at uri.
+func FetchKeySet(ctx context.Context, client *http.Client, uri string) (map[string]jwks.Key, error) {
+	if client == nil {
+		client = defaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving json web key set")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Unexpected response status %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response body")
+	}
+	return jwks.ParseSet(body)
+}
+
+func getJSON(ctx context.Context, client *http.Client, uri string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("Unexpected response status %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "reading response body")
+	}
+	return errors.Wrap(json.Unmarshal(body, v), "unmarshalling response body")
+}
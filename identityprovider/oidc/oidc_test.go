@@ -0,0 +1,114 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/oidc"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+var edPub, _, _ = ed25519.GenerateKey(rand.Reader)
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// stubProvider serves a discovery document and a key set holding an RSA and
+// an EC key in standard JWK notation.
+func stubProvider(t *testing.T, rk *rsa.PrivateKey, ek *ecdsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidc.DiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidc.Configuration{
+			Issuer:                srv.URL,
+			AuthorizationEndpoint: srv.URL + "/authorize",
+			TokenEndpoint:         srv.URL + "/token",
+			JWKSURI:               srv.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwks.Set{Keys: []jwks.JWK{
+			{Kty: "RSA", Kid: "rsa-1", Use: "sig", N: b64(rk.N), E: b64(big.NewInt(int64(rk.E)))},
+			{Kty: "EC", Kid: "ec-1", Use: "sig", Crv: "P-256", X: b64(ek.X), Y: b64(ek.Y)},
+			{Kty: "OKP", Kid: "ed-1", Use: "sig", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(edPub)},
+			{Kty: "RSA", Kid: "enc-1", Use: "enc", N: b64(rk.N), E: b64(big.NewInt(int64(rk.E)))},
+		}})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDiscover(t *testing.T) {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv := stubProvider(t, rk, ek)
+
+	p, err := oidc.Discover(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, srv.URL, p.Issuer())
+	assert.Equal(t, srv.URL+"/token", p.Endpoints().Token)
+
+	ks, err := p.KeySet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, ks, 3) // encryption key skipped
+	assert.Equal(t, "RS256", ks["rsa-1"].Alg)
+	assert.Equal(t, &rk.PublicKey, ks["rsa-1"].Instance)
+	assert.Equal(t, "ES256", ks["ec-1"].Alg)
+	assert.True(t, ek.PublicKey.Equal(ks["ec-1"].Instance))
+	assert.Equal(t, "EdDSA", ks["ed-1"].Alg)
+	assert.Equal(t, edPub, ks["ed-1"].Instance)
+
+	// The issuer in the document must match the requested one.
+	_, err = oidc.Discover(context.Background(), srv.URL+"/tenant", nil)
+	assert.Error(t, err)
+}
+
+func TestVerifyTokenOfDiscoveredProvider(t *testing.T) {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	srv := stubProvider(t, rk, ek)
+	p, err := oidc.Discover(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c rest.Claims
+	c.Issuer = p.Issuer()
+	c.Audience = jwt.ClaimStrings{"invoice-mvp"}
+	c.Subject = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+	c.ExpiresAt = jwt.At(time.Now().Add(time.Minute))
+	c.Roles = []string{rest.RoleUser}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	tok.Header["kid"] = "rsa-1"
+	s, err := tok.SignedString(rk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := rest.NewJWTAuthenticator("invoice.mvp", p.Issuer(), "invoice-mvp", rest.RS256KeyFunc(jwks.New(p.KeySet, jwks.Options{})))
+	req := httptest.NewRequest("GET", "/activities", nil)
+	req.Header.Set("Authorization", "Bearer "+s)
+	got, err := a.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, c.Subject, got.Subject)
+}
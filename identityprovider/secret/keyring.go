@@ -0,0 +1,78 @@
+package secret
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultKeyringName is the secret holding the HMAC keyring.
+const DefaultKeyringName = "JWT_HMAC_KEYS"
+
+// MinKeySize is the minimum length of HMAC keys in bytes.
+const MinKeySize = 32
+
+// Keyring holds the HMAC keys of HS256 tokens by key ID ("kid"). The primary
+// key signs new tokens, all keys verify. Keys are rotated by adding a new
+// primary key in front and dropping the old one once its tokens expired.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// ParseKeyring parses a comma separated list of "<kid>:<base64 key>" pairs.
+// The first key is the primary key.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := Keyring{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) < 1 {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || len(parts[0]) < 1 {
+			return nil, errors.New("malformed keyring entry, expected <kid>:<base64 key>")
+		}
+		kid := parts[0]
+		if _, ok := k.keys[kid]; ok {
+			return nil, errors.Errorf("duplicate key id %s", kid)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding key %s", kid)
+		}
+		if len(key) < MinKeySize {
+			return nil, errors.Errorf("key %s has %d bytes, at least %d are required", kid, len(key), MinKeySize)
+		}
+		if len(k.primary) < 1 {
+			k.primary = kid
+		}
+		k.keys[kid] = key
+	}
+	if len(k.keys) < 1 {
+		return nil, errors.New("keyring is empty")
+	}
+	return &k, nil
+}
+
+// LoadKeyring reads the keyring of the secret.
+func LoadKeyring(p Provider, name string) (*Keyring, error) {
+	s, err := p.Secret(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading keyring %s", name)
+	}
+	k, err := ParseKeyring(s)
+	return k, errors.Wrapf(err, "keyring %s", name)
+}
+
+// Key returns the key of the ID.
+func (k *Keyring) Key(kid string) ([]byte, bool) {
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// Primary returns the ID and key signing new tokens.
+func (k *Keyring) Primary() (string, []byte) {
+	return k.primary, k.keys[k.primary]
+}
@@ -1,4 +1,87 @@
+// Package secret reads secrets like client secrets and signing keys from the
+// environment, a directory of files or a sops encrypted file, so they are
+// neither compiled in nor kept in plaintext configuration files.
 package secret
 
-// Shared HMAC secret
-const Shared = "my_secret_hmac_key"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned for unknown secrets.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider resolves secrets by name, e.g. "CLIENT_SECRET".
+type Provider interface {
+	Secret(name string) (string, error)
+}
+
+// New instantiates the provider of the kind "env", "file" or "sops". The
+// path is the directory of secret files or the sops encrypted file.
+func New(kind, path string) (Provider, error) {
+	switch kind {
+	case "env":
+		return Env{}, nil
+	case "file":
+		if len(path) < 1 {
+			return nil, errors.New("secret directory is required")
+		}
+		return Dir(path), nil
+	case "sops":
+		if len(path) < 1 {
+			return nil, errors.New("sops encrypted file is required")
+		}
+		return NewSOPSFile(path), nil
+	}
+	return nil, errors.Errorf("unknown secret provider %q, expected env, file or sops", kind)
+}
+
+// Env reads secrets from environment variables of the same name.
+type Env struct{}
+
+// Secret implements the Provider interface.
+func (Env) Secret(name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// Dir reads secrets from files of the same name in the directory, like the
+// secrets mounted by Docker or Kubernetes. A trailing newline is dropped.
+type Dir string
+
+// Secret implements the Provider interface.
+func (d Dir) Secret(name string) (string, error) {
+	if len(name) < 1 || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", errors.Errorf("invalid secret name %q", name)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(string(d), name))
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "reading secret %s", name)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Chain asks its providers in order and returns the first secret found.
+type Chain []Provider
+
+// Secret implements the Provider interface.
+func (c Chain) Secret(name string) (string, error) {
+	for _, p := range c {
+		v, err := p.Secret(name)
+		if err == ErrNotFound {
+			continue
+		}
+		return v, err
+	}
+	return "", ErrNotFound
+}
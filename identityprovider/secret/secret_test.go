@@ -0,0 +1,132 @@
+package secret_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/identityprovider/secret"
+)
+
+func TestEnv(t *testing.T) {
+	t.Setenv("TEST_SECRET", "s3cr3t")
+	v, err := secret.Env{}.Secret("TEST_SECRET")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", v)
+
+	_, err = secret.Env{}.Secret("TEST_SECRET_MISSING")
+	assert.Equal(t, secret.ErrNotFound, err)
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "CLIENT_SECRET"), []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := secret.Dir(dir)
+	v, err := d.Secret("CLIENT_SECRET")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", v, "trailing newline is dropped")
+
+	_, err = d.Secret("SESSION_KEY")
+	assert.Equal(t, secret.ErrNotFound, err)
+
+	for _, name := range []string{"", "../CLIENT_SECRET", ".hidden", "a/b"} {
+		_, err = d.Secret(name)
+		assert.Error(t, err, name)
+		assert.NotEqual(t, secret.ErrNotFound, err, name)
+	}
+}
+
+func TestChain(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "CLIENT_SECRET"), []byte("from-file"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLIENT_SECRET", "from-env")
+	t.Setenv("SESSION_KEY", "key-from-env")
+
+	c := secret.Chain{secret.Dir(dir), secret.Env{}}
+	v, err := c.Secret("CLIENT_SECRET")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-file", v)
+	v, err = c.Secret("SESSION_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "key-from-env", v)
+	_, err = c.Secret("TEST_SECRET_MISSING")
+	assert.Equal(t, secret.ErrNotFound, err)
+}
+
+func TestSOPSFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc.env")
+	if err := ioutil.WriteFile(path, []byte("CLIENT_SECRET=s3cr3t\nSESSION_KEY='a b'\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f := secret.NewSOPSFile(path)
+	f.Command = []string{"cat"} // the test file is not encrypted
+	v, err := f.Secret("CLIENT_SECRET")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", v)
+	v, err = f.Secret("SESSION_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "a b", v)
+	_, err = f.Secret("MVP_PASSWORD")
+	assert.Equal(t, secret.ErrNotFound, err)
+
+	f = secret.NewSOPSFile(filepath.Join(t.TempDir(), "missing.enc.env"))
+	f.Command = []string{"cat"}
+	_, err = f.Secret("CLIENT_SECRET")
+	assert.Error(t, err)
+	assert.NotEqual(t, secret.ErrNotFound, err)
+}
+
+func TestNew(t *testing.T) {
+	_, err := secret.New("env", "")
+	assert.NoError(t, err)
+	_, err = secret.New("file", "")
+	assert.Error(t, err)
+	_, err = secret.New("sops", "")
+	assert.Error(t, err)
+	_, err = secret.New("vault", "secret/invoice")
+	assert.Error(t, err)
+}
+
+func TestKeyring(t *testing.T) {
+	k1 := bytes.Repeat([]byte{1}, secret.MinKeySize)
+	k2 := bytes.Repeat([]byte{2}, secret.MinKeySize)
+	enc := base64.StdEncoding.EncodeToString
+
+	ring, err := secret.ParseKeyring("2024-02:" + enc(k2) + ", 2024-01:" + enc(k1))
+	assert.NoError(t, err)
+	kid, key := ring.Primary()
+	assert.Equal(t, "2024-02", kid)
+	assert.Equal(t, k2, key)
+	key, ok := ring.Key("2024-01")
+	assert.True(t, ok)
+	assert.Equal(t, k1, key)
+	_, ok = ring.Key("2023-12")
+	assert.False(t, ok)
+
+	for _, s := range []string{
+		"",
+		enc(k1),
+		":" + enc(k1),
+		"a:not base64",
+		"a:" + enc(k1[:16]),
+		"a:" + enc(k1) + ",a:" + enc(k2),
+	} {
+		_, err := secret.ParseKeyring(s)
+		assert.Error(t, err, s)
+	}
+
+	t.Setenv(secret.DefaultKeyringName, "a:"+enc(k1))
+	ring, err = secret.LoadKeyring(secret.Env{}, secret.DefaultKeyringName)
+	assert.NoError(t, err)
+	kid, _ = ring.Primary()
+	assert.Equal(t, "a", kid)
+	_, err = secret.LoadKeyring(secret.Env{}, "TEST_KEYRING_MISSING")
+	assert.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package secret
+
+import (
+	"bytes"
+	"os/exec"
+	"sync"
+
+	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+)
+
+// SOPSFile reads secrets from a dotenv file encrypted with sops, e.g. the
+// .enc.env of "make sops-encrypt". The file is decrypted once, on first use,
+// and the plaintext is kept in memory only.
+type SOPSFile struct {
+	path string
+	// Command decrypts the file given as last argument to dotenv format on
+	// standard output.
+	Command []string
+
+	once   sync.Once
+	values map[string]string
+	err    error
+}
+
+// NewSOPSFile instantiates a provider of the encrypted file, decrypted with
+// the sops binary found in the PATH.
+func NewSOPSFile(path string) *SOPSFile {
+	return &SOPSFile{
+		path:    path,
+		Command: []string{"sops", "--decrypt", "--output-type", "dotenv"},
+	}
+}
+
+// Secret implements the Provider interface.
+func (f *SOPSFile) Secret(name string) (string, error) {
+	f.once.Do(f.decrypt)
+	if f.err != nil {
+		return "", f.err
+	}
+	v, ok := f.values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+func (f *SOPSFile) decrypt() {
+	args := append(append([]string{}, f.Command[1:]...), f.path)
+	cmd := exec.Command(f.Command[0], args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		f.err = errors.Wrapf(err, "decrypting %s: %s", f.path, bytes.TrimSpace(stderr.Bytes()))
+		return
+	}
+	f.values, f.err = godotenv.Unmarshal(string(out))
+	if f.err != nil {
+		f.err = errors.Wrapf(f.err, "parsing decrypted %s", f.path)
+	}
+}
@@ -0,0 +1,152 @@
+package stub
+
+import (
+	"crypto/rand"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DeviceCodeGrantType is the grant type of the device authorization grant
+// (RFC 8628).
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// device is a pending device authorization.
+type device struct {
+	userCode string
+	clientID string
+	user     *User // set once the user approved
+	expires  time.Time
+}
+
+// deviceAuthorize starts a device authorization of a client.
+func (p *IDP) deviceAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	p.mu.Lock()
+	c, ok := p.clients[r.Form.Get("client_id")]
+	p.mu.Unlock()
+	if !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ttl := 10 * time.Minute
+	p.mu.Lock()
+	p.devices[code] = &device{userCode: userCode, clientID: c.ID, expires: time.Now().Add(ttl)}
+	p.mu.Unlock()
+
+	uri := baseURL(r) + "/oauth2/device"
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":               code,
+		"user_code":                 userCode,
+		"verification_uri":          uri,
+		"verification_uri_complete": uri + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		"expires_in":                int(ttl.Seconds()),
+		"interval":                  p.DeviceInterval,
+	})
+}
+
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Device login</title></head>
+<body>
+<form method="post">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<label>Code <input name="user_code" value="{{.UserCode}}"></label>
+<label>Login <input name="loginId"></label>
+<label>Password <input name="password" type="password"></label>
+<button>Login</button>
+</form>
+</body>
+</html>
+`))
+
+// verifyDevice renders the form of the user code on GET and approves the
+// device authorization once the user authenticated.
+func (p *IDP) verifyDevice(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	form := struct {
+		UserCode string
+		Error    string
+	}{UserCode: r.Form.Get("user_code")}
+	render := func(status int) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		_ = devicePage.Execute(w, form)
+	}
+	if r.Method != "POST" {
+		render(http.StatusOK)
+		return
+	}
+	u, ok := p.authenticate(r.Form.Get("loginId"), r.Form.Get("password"))
+	if !ok {
+		form.Error = "Invalid login credentials."
+		render(http.StatusOK)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range p.devices {
+		if d.userCode == strings.ToUpper(form.UserCode) && time.Now().Before(d.expires) {
+			d.user = &u
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<p>Device approved, you may close this window.</p>"))
+			return
+		}
+	}
+	form.Error = "Invalid or expired code."
+	render(http.StatusBadRequest)
+}
+
+// redeemDevice returns the grant of an approved device authorization. The
+// error code tells the client to keep polling or to give up.
+func (p *IDP) redeemDevice(code, clientID string) (grant, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.devices[code]
+	switch {
+	case !ok || d.clientID != clientID:
+		return grant{}, "invalid_grant"
+	case time.Now().After(d.expires):
+		delete(p.devices, code)
+		return grant{}, "expired_token"
+	case d.user == nil:
+		return grant{}, "authorization_pending"
+	}
+	delete(p.devices, code)
+	return grant{user: *d.user, clientID: clientID}, ""
+}
+
+// newUserCode returns a code of the form "BCDF-GHJK" that is easy to type.
+func newUserCode() (string, error) {
+	const chars = "BCDFGHJKLMNPQRSTVWXZ"
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = chars[int(b[i])%len(chars)]
+	}
+	return string(b[:4]) + "-" + string(b[4:]), nil
+}
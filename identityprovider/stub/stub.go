@@ -0,0 +1,609 @@
+// Package stub is an in-process identity provider for tests and local
+// development. It serves the FusionAuth endpoints used by this service, the
+// OpenID Connect discovery document and a JSON Web Key Set, and mints RS256
+// signed tokens for its users. State is kept in memory only.
+package stub
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/oidc"
+)
+
+// KeyID is the "kid" of the signing key.
+const KeyID = "stub-rs256"
+
+// User is an account of the identity provider.
+type User struct {
+	ID       string
+	LoginID  string
+	Password string
+	Roles    []string
+	// Claims are added to the tokens of the user.
+	Claims map[string]interface{}
+}
+
+// Client is a registered OAuth2 client, an application in FusionAuth terms.
+type Client struct {
+	ID          string
+	Secret      string
+	RedirectURI string
+}
+
+// grant is an issued authorization code or refresh token.
+type grant struct {
+	user      User
+	clientID  string
+	redirect  string
+	challenge string
+	method    string
+	expires   time.Time
+}
+
+// IDP is the stub identity provider. It implements http.Handler.
+type IDP struct {
+	issuer string
+	key    *rsa.PrivateKey
+	mux    *http.ServeMux
+
+	// TTL is the lifetime of access tokens.
+	TTL time.Duration
+	// Claims are added to all tokens and override the standard claims,
+	// e.g. an expired "exp" or a foreign "aud".
+	Claims map[string]interface{}
+	// DeviceInterval is the polling interval in seconds of the device
+	// authorization grant.
+	DeviceInterval int
+	// APIKey authorizes requests to the user API, which is closed when
+	// empty.
+	APIKey string
+
+	mu      sync.Mutex
+	users   map[string]User // by login ID
+	clients map[string]Client
+	codes   map[string]grant
+	refresh map[string]grant
+	devices map[string]*device // by device code
+	revoked map[string]bool    // by token ID
+}
+
+// New instantiates an identity provider of the issuer with a new signing key.
+func New(issuer string) (*IDP, error) {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating signing key")
+	}
+	p := IDP{
+		issuer: issuer,
+		key:    k,
+		TTL:    time.Hour,
+
+		DeviceInterval: 5,
+		users:          make(map[string]User),
+		clients:        make(map[string]Client),
+		codes:          make(map[string]grant),
+		refresh:        make(map[string]grant),
+		devices:        make(map[string]*device),
+		revoked:        make(map[string]bool),
+	}
+	p.mux = http.NewServeMux()
+	p.mux.HandleFunc(oidc.DiscoveryPath, p.discovery)
+	p.mux.HandleFunc("/.well-known/jwks.json", p.keySet)
+	p.mux.HandleFunc("/api/jwt/public-key", p.publicKey)
+	p.mux.HandleFunc("/api/login", p.login)
+	p.mux.HandleFunc("/api/user/", p.user)
+	p.mux.HandleFunc("/oauth2/authorize", p.authorize)
+	p.mux.HandleFunc("/oauth2/token", p.token)
+	p.mux.HandleFunc("/oauth2/device_authorize", p.deviceAuthorize)
+	p.mux.HandleFunc("/oauth2/device", p.verifyDevice)
+	p.mux.HandleFunc("/oauth2/revoke", p.revoke)
+	p.mux.HandleFunc("/oauth2/introspect", p.introspect)
+	return &p, nil
+}
+
+// Issuer returns the "iss" claim of the tokens.
+func (p *IDP) Issuer() string {
+	return p.issuer
+}
+
+// AddUser registers or replaces the user.
+func (p *IDP) AddUser(u User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[u.LoginID] = u
+}
+
+// AddClient registers or replaces the client.
+func (p *IDP) AddClient(c Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clients[c.ID] = c
+}
+
+// KeySet returns the public signing key, it is a jwks.Fetcher.
+func (p *IDP) KeySet(ctx context.Context) (map[string]jwks.Key, error) {
+	return map[string]jwks.Key{KeyID: p.publicSigningKey()}, nil
+}
+
+func (p *IDP) publicSigningKey() jwks.Key {
+	return jwks.Key{ID: KeyID, Alg: "RS256", Use: "sig", Instance: &p.key.PublicKey}
+}
+
+// Token mints an access token of the user for the client.
+func (p *IDP) Token(u User, clientID string) (string, error) {
+	jti, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	c := jwt.MapClaims{
+		"iss":                p.issuer,
+		"aud":                clientID,
+		"sub":                u.ID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(p.TTL).Unix(),
+		"jti":                jti,
+		"applicationId":      clientID,
+		"authenticationType": "PASSWORD",
+		"email":              u.LoginID,
+		"roles":              u.Roles,
+	}
+	for k, v := range u.Claims {
+		c[k] = v
+	}
+	for k, v := range p.Claims {
+		c[k] = v
+	}
+	return p.Sign(c)
+}
+
+// Sign signs arbitrary claims with the signing key.
+func (p *IDP) Sign(c jwt.Claims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	t.Header["kid"] = KeyID
+	return t.SignedString(p.key)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (p *IDP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// ===== ENDPOINTS ============================================================
+
+func (p *IDP) discovery(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	writeJSON(w, http.StatusOK, oidc.Configuration{
+		Issuer:                           p.issuer,
+		AuthorizationEndpoint:            base + "/oauth2/authorize",
+		TokenEndpoint:                    base + "/oauth2/token",
+		JWKSURI:                          base + "/.well-known/jwks.json",
+		IntrospectionEndpoint:            base + "/oauth2/introspect",
+		RevocationEndpoint:               base + "/oauth2/revoke",
+		DeviceAuthorizationEndpoint:      base + "/oauth2/device_authorize",
+		ResponseTypesSupported:           []string{"code"},
+		IDTokenSigningAlgValuesSupported: []string{"RS256"},
+		CodeChallengeMethodsSupported:    []string{"S256", "plain"},
+	})
+}
+
+func (p *IDP) keySet(w http.ResponseWriter, r *http.Request) {
+	jwk, err := jwks.NewJWK(p.publicSigningKey())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, jwks.Set{Keys: []jwks.JWK{jwk}})
+}
+
+// publicKey serves the FusionAuth representation of the key, a PEM encoded
+// public key.
+func (p *IDP) publicKey(w http.ResponseWriter, r *http.Request) {
+	der, err := x509.MarshalPKIXPublicKey(&p.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	switch kid := r.URL.Query().Get("kid"); kid {
+	case "":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"publicKeys": map[string]string{KeyID: key}})
+	case KeyID:
+		writeJSON(w, http.StatusOK, map[string]string{"publicKey": key})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// login implements the FusionAuth login API. The API key is not checked.
+func (p *IDP) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		LoginID       string `json:"loginId"`
+		Password      string `json:"password"`
+		ApplicationID string `json:"applicationId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	u, ok := p.authenticate(req.LoginID, req.Password)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	t, err := p.Token(u, req.ApplicationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token": t,
+		"user":  map[string]string{"id": u.ID, "email": u.LoginID},
+	})
+}
+
+// user implements the FusionAuth user API retrieving a user by ID. Users are
+// registered for all clients with their roles.
+func (p *IDP) user(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	key := r.Header.Get("Authorization")
+	if len(p.APIKey) < 1 || subtle.ConstantTimeCompare([]byte(key), []byte(p.APIKey)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/user/")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, u := range p.users {
+		if u.ID != id {
+			continue
+		}
+		var regs []map[string]interface{}
+		for _, c := range p.clients {
+			regs = append(regs, map[string]interface{}{"applicationId": c.ID, "roles": u.Roles})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"user": map[string]interface{}{
+			"id":            u.ID,
+			"email":         u.LoginID,
+			"active":        true,
+			"registrations": regs,
+		}})
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Login</title></head>
+<body>
+<form method="post">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">
+{{end}}<label>Login <input name="loginId"></label>
+<label>Password <input name="password" type="password"></label>
+<button>Login</button>
+</form>
+</body>
+</html>
+`))
+
+// authorize renders a login form on GET and redirects with an authorization
+// code to the redirect URI of the client once the user authenticated.
+func (p *IDP) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	p.mu.Lock()
+	c, ok := p.clients[r.Form.Get("client_id")]
+	p.mu.Unlock()
+	if !ok {
+		http.Error(w, "invalid client_id", http.StatusBadRequest)
+		return
+	}
+	redirect := r.Form.Get("redirect_uri")
+	if redirect != c.RedirectURI {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := make(url.Values)
+	for _, k := range []string{"client_id", "redirect_uri", "response_type", "state", "scope", "code_challenge", "code_challenge_method"} {
+		if v := r.Form.Get(k); len(v) > 0 {
+			params.Set(k, v)
+		}
+	}
+	form := struct {
+		Params url.Values
+		Error  string
+	}{Params: params}
+	if r.Method != "POST" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = loginPage.Execute(w, form)
+		return
+	}
+
+	u, ok := p.authenticate(r.Form.Get("loginId"), r.Form.Get("password"))
+	if !ok {
+		// FusionAuth renders the form again.
+		form.Error = "Invalid login credentials."
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = loginPage.Execute(w, form)
+		return
+	}
+	loc, err := url.Parse(redirect)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := loc.Query()
+	if rt := r.Form.Get("response_type"); rt != "code" {
+		q.Set("error", "unsupported_response_type")
+	} else {
+		code, err := p.issue(p.codes, grant{
+			user:      u,
+			clientID:  c.ID,
+			redirect:  redirect,
+			challenge: r.Form.Get("code_challenge"),
+			method:    r.Form.Get("code_challenge_method"),
+			expires:   time.Now().Add(time.Minute),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		q.Set("code", code)
+		q.Set("userState", "Authenticated")
+	}
+	if s := r.Form.Get("state"); len(s) > 0 {
+		q.Set("state", s)
+	}
+	loc.RawQuery = q.Encode()
+	http.Redirect(w, r, loc.String(), http.StatusFound)
+}
+
+// token exchanges authorization codes and refresh tokens for access tokens.
+func (p *IDP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	c, ok := p.client(r)
+	if !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	var g grant
+	switch r.Form.Get("grant_type") {
+	case "authorization_code":
+		g, ok = p.redeem(p.codes, r.Form.Get("code"))
+		if !ok || g.clientID != c.ID || g.redirect != r.Form.Get("redirect_uri") ||
+			!verifyChallenge(g, r.Form.Get("code_verifier")) {
+			oauthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+	case "refresh_token":
+		// Refresh tokens are rotated.
+		g, ok = p.redeem(p.refresh, r.Form.Get("refresh_token"))
+		if !ok || g.clientID != c.ID {
+			oauthError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+	case DeviceCodeGrantType:
+		var code string
+		if g, code = p.redeemDevice(r.Form.Get("device_code"), c.ID); len(code) > 0 {
+			oauthError(w, http.StatusBadRequest, code)
+			return
+		}
+	default:
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	at, err := p.Token(g.user, c.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	g.expires = time.Now().Add(30 * 24 * time.Hour)
+	rt, err := p.issue(p.refresh, g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  at,
+		"expires_in":    int(p.TTL.Seconds()),
+		"refresh_token": rt,
+		"token_type":    "Bearer",
+		"userId":        g.user.ID,
+	})
+}
+
+// revoke revokes refresh tokens and, by token ID, access tokens.
+func (p *IDP) revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	token := r.Form.Get("token")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.refresh[token]; ok {
+		delete(p.refresh, token)
+		return
+	}
+	if c, err := p.parse(token, jwt.WithoutAudienceValidation()); err == nil {
+		if jti, ok := c["jti"].(string); ok {
+			p.revoked[jti] = true
+		}
+	}
+}
+
+// introspect reports whether an access token of the client is valid and not
+// revoked.
+func (p *IDP) introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	client, ok := p.client(r)
+	if !ok {
+		oauthError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	c, err := p.parse(r.Form.Get("token"), jwt.WithAudience(client.ID))
+	p.mu.Lock()
+	revoked := err == nil && p.revoked[stringClaim(c, "jti")]
+	p.mu.Unlock()
+	if err != nil || revoked {
+		writeJSON(w, http.StatusOK, map[string]bool{"active": false})
+		return
+	}
+	c["active"] = true
+	writeJSON(w, http.StatusOK, c)
+}
+
+// ===== HELPERS ==============================================================
+
+func (p *IDP) authenticate(loginID, password string) (User, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.users[loginID]
+	if !ok || subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) != 1 {
+		return User{}, false
+	}
+	return u, true
+}
+
+// client authenticates the client by basic auth or form parameters.
+func (p *IDP) client(r *http.Request) (Client, bool) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.Form.Get("client_id"), r.Form.Get("client_secret")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.clients[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) != 1 {
+		return Client{}, false
+	}
+	return c, true
+}
+
+func (p *IDP) issue(m map[string]grant, g grant) (string, error) {
+	s, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m[s] = g
+	return s, nil
+}
+
+// redeem removes the grant, codes and refresh tokens are used once.
+func (p *IDP) redeem(m map[string]grant, s string) (grant, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g, ok := m[s]
+	delete(m, s)
+	if !ok || time.Now().After(g.expires) {
+		return grant{}, false
+	}
+	return g, true
+}
+
+func (p *IDP) parse(token string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	c := make(jwt.MapClaims)
+	_, err := jwt.ParseWithClaims(token, c, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodRS256 {
+			return nil, errors.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return &p.key.PublicKey, nil
+	}, opts...)
+	return c, err
+}
+
+// verifyChallenge verifies the PKCE code verifier (RFC 7636).
+func verifyChallenge(g grant, verifier string) bool {
+	if len(g.challenge) < 1 {
+		return true
+	}
+	if g.method == "plain" {
+		return verifier == g.challenge
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == g.challenge
+}
+
+func stringClaim(c jwt.MapClaims, name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating random string")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func baseURL(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+func oauthError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
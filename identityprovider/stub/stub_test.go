@@ -0,0 +1,255 @@
+package stub_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/oidc"
+	"github.com/tullo/invoice-mvp/identityprovider/stub"
+	"github.com/tullo/invoice-mvp/identityprovider/stub/stubtest"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	UserID       string `json:"userId"`
+	Error        string `json:"error"`
+}
+
+func postForm(t *testing.T, uri string, form url.Values) (int, tokenResponse) {
+	t.Helper()
+	res, err := http.PostForm(uri, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var tr tokenResponse
+	_ = json.NewDecoder(res.Body).Decode(&tr)
+	return res.StatusCode, tr
+}
+
+// verify authenticates the access token like the service does.
+func verify(t *testing.T, idp *stubtest.Server, token string) (rest.Claims, error) {
+	t.Helper()
+	kf := rest.KeyFunc([]string{"RS256"}, jwks.New(idp.KeySet, jwks.Options{}), nil)
+	a := rest.NewJWTAuthenticator("invoice.mvp", idp.Issuer(), idp.Client.ID, kf)
+	req := httptest.NewRequest("GET", "/activities", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return a.Authenticate(req)
+}
+
+func TestDiscovery(t *testing.T) {
+	srv := httptest.NewUnstartedServer(nil)
+	issuer := "http://" + srv.Listener.Addr().String()
+	idp, err := stub.New(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = idp
+	srv.Start()
+	defer srv.Close()
+
+	p, err := oidc.Discover(context.Background(), issuer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, issuer+"/oauth2/token", p.Endpoints().Token)
+	keys, err := p.KeySet(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "RS256", keys[stub.KeyID].Alg)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := stubtest.Start(t)
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+
+	// The login form keeps the authorization request.
+	q := make(url.Values)
+	q.Set("response_type", "code")
+	q.Set("client_id", stubtest.ClientID)
+	q.Set("redirect_uri", stubtest.RedirectURI)
+	q.Set("state", "xyz")
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	q.Set("code_challenge_method", "S256")
+	res, err := http.Get(idp.URL + "/oauth2/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	q.Set("redirect_uri", "https://evil.example/callback")
+	status, _ := postForm(t, idp.URL+"/oauth2/authorize", q)
+	assert.Equal(t, http.StatusBadRequest, status, "unregistered redirect URI")
+	q.Set("redirect_uri", stubtest.RedirectURI)
+
+	q.Set("loginId", stubtest.LoginID)
+	q.Set("password", stubtest.Password)
+	noRedirect := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err = noRedirect.PostForm(idp.URL+"/oauth2/authorize", q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	loc, _ := url.Parse(res.Header.Get("Location"))
+	assert.True(t, strings.HasPrefix(loc.String(), stubtest.RedirectURI))
+	assert.Equal(t, "xyz", loc.Query().Get("state"))
+	assert.Equal(t, "Authenticated", loc.Query().Get("userState"))
+
+	form := make(url.Values)
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", loc.Query().Get("code"))
+	form.Set("client_id", stubtest.ClientID)
+	form.Set("client_secret", stubtest.ClientSecret)
+	form.Set("redirect_uri", stubtest.RedirectURI)
+	form.Set("code_verifier", "wrong")
+	status, tr := postForm(t, idp.URL+"/oauth2/token", form)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_grant", tr.Error)
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	idp := stubtest.Start(t)
+	auth, err := idp.Login()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := verify(t, idp, auth.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, stubtest.UserID, c.Subject)
+	assert.Equal(t, []string{"USER"}, c.Roles)
+
+	// Refresh tokens are rotated.
+	form := make(url.Values)
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", auth.RefreshToken)
+	form.Set("client_id", stubtest.ClientID)
+	form.Set("client_secret", stubtest.ClientSecret)
+	status, tr := postForm(t, idp.URL+"/oauth2/token", form)
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotEqual(t, auth.RefreshToken, tr.RefreshToken)
+	status, _ = postForm(t, idp.URL+"/oauth2/token", form)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	form.Set("client_secret", "wrong")
+	form.Set("refresh_token", tr.RefreshToken)
+	status, tr = postForm(t, idp.URL+"/oauth2/token", form)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, "invalid_client", tr.Error)
+
+	// Revoked access tokens are inactive.
+	in := rest.NewIntrospector(idp.URL+"/oauth2/introspect", stubtest.ClientID, stubtest.ClientSecret)
+	var reached bool
+	h := in.Require(func(ctx context.Context, w http.ResponseWriter, r *http.Request) { reached = true })
+	serve := func() {
+		reached = false
+		req := httptest.NewRequest("GET", "/invoices", nil)
+		req.Header.Set("Authorization", "Bearer "+auth.AccessToken)
+		h(context.WithValue(req.Context(), rest.Key, c), httptest.NewRecorder(), req)
+	}
+	serve()
+	assert.True(t, reached)
+	status, _ = postForm(t, idp.URL+"/oauth2/revoke", url.Values{"token": {auth.AccessToken}})
+	assert.Equal(t, http.StatusOK, status)
+	serve()
+	assert.False(t, reached)
+}
+
+func TestClaims(t *testing.T) {
+	idp := stubtest.Start(t)
+	u := idp.User
+	u.Claims = map[string]interface{}{"roles": []string{"ADMIN"}}
+	token, err := idp.Token(u, stubtest.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := verify(t, idp, token)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ADMIN"}, c.Roles)
+
+	idp.Claims = map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}
+	token, err = idp.Token(u, stubtest.ClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verify(t, idp, token)
+	assert.Error(t, err, "expired")
+
+	idp.Claims = nil
+	token, err = idp.Token(u, "other-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = verify(t, idp, token)
+	assert.Error(t, err, "foreign audience")
+}
+
+func TestDeviceFlow(t *testing.T) {
+	idp := stubtest.Start(t)
+	res, err := http.PostForm(idp.URL+"/oauth2/device_authorize", url.Values{"client_id": {stubtest.ClientID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var da struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		Interval        int    `json:"interval"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&da)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, idp.URL+"/oauth2/device", da.VerificationURI)
+	assert.Equal(t, 5, da.Interval)
+
+	form := make(url.Values)
+	form.Set("grant_type", stub.DeviceCodeGrantType)
+	form.Set("device_code", da.DeviceCode)
+	form.Set("client_id", stubtest.ClientID)
+	form.Set("client_secret", stubtest.ClientSecret)
+	status, tr := postForm(t, idp.URL+"/oauth2/token", form)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "authorization_pending", tr.Error)
+
+	approve := url.Values{"user_code": {da.UserCode}, "loginId": {stubtest.LoginID}, "password": {"wrong"}}
+	res, err = http.PostForm(da.VerificationURI, approve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	approve.Set("password", stubtest.Password)
+	approve.Set("user_code", strings.ToLower(da.UserCode))
+	res, err = http.PostForm(da.VerificationURI, approve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	status, tr = postForm(t, idp.URL+"/oauth2/token", form)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, stubtest.UserID, tr.UserID)
+	_, err = verify(t, idp, tr.AccessToken)
+	assert.NoError(t, err)
+
+	status, tr = postForm(t, idp.URL+"/oauth2/token", form)
+	assert.Equal(t, "invalid_grant", tr.Error, "device codes are redeemed once")
+}
@@ -0,0 +1,118 @@
+// Package stubtest runs the stub identity provider with a test client and
+// user on a local test server.
+package stubtest
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+	"github.com/tullo/invoice-mvp/identityprovider/stub"
+)
+
+// Fixtures registered by NewServer.
+const (
+	Issuer       = "invoice.mvp"
+	ClientID     = "invoice-mvp"
+	ClientSecret = "s3cr3t"
+	RedirectURI  = "https://127.0.0.1:8443/auth/token"
+	UserID       = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+	LoginID      = "go@invoice.mvp"
+	Password     = "T0ps3cr3t"
+	APIKey       = "stub-api-key"
+)
+
+// Server is an identity provider listening on a local test server.
+type Server struct {
+	*stub.IDP
+	// URL is the base URL, e.g. "http://127.0.0.1:41085".
+	URL    string
+	Client stub.Client
+	User   stub.User
+
+	srv *httptest.Server
+}
+
+// NewServer starts an identity provider with one client and one user with
+// the user role. Close stops it.
+func NewServer() (*Server, error) {
+	p, err := stub.New(Issuer)
+	if err != nil {
+		return nil, err
+	}
+	s := Server{
+		IDP:    p,
+		Client: stub.Client{ID: ClientID, Secret: ClientSecret, RedirectURI: RedirectURI},
+		User:   stub.User{ID: UserID, LoginID: LoginID, Password: Password, Roles: []string{"USER"}},
+	}
+	s.AddClient(s.Client)
+	s.AddUser(s.User)
+	s.IDP.APIKey = APIKey
+	s.srv = httptest.NewServer(p)
+	s.URL = s.srv.URL
+	return &s, nil
+}
+
+// Start starts a server that is closed when the test finishes.
+func Start(t testing.TB) *Server {
+	t.Helper()
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Provider returns the FusionAuth provider of the server.
+func (s *Server) Provider() fusionauth.Provider {
+	return fusionauth.NewProvider(s.URL, s.Issuer())
+}
+
+// AuthConfig returns the client configuration of FusionAuth logins.
+func (s *Server) AuthConfig() fusionauth.AuthConfig {
+	e := s.Provider().Endpoints()
+	return fusionauth.AuthConfig{
+		ClientID:     s.Client.ID,
+		ClientSecret: s.Client.Secret,
+		GrantType:    "authorization_code",
+		Issuer:       s.Issuer(),
+		TokenURI:     e.Token,
+		AuthorizeURI: e.Authorization,
+		RevokeURI:    s.URL + "/oauth2/revoke",
+		RedirectURI:  s.Client.RedirectURI,
+	}
+}
+
+// Config returns the identity provider configuration of the service.
+func (s *Server) Config() config.IDP {
+	e := s.Provider().Endpoints()
+	return config.IDP{
+		BaseURL:          s.URL,
+		Issuer:           s.Issuer(),
+		ClientID:         s.Client.ID,
+		ClientSecret:     s.Client.Secret,
+		GrantType:        "authorization_code",
+		RedirectURI:      s.Client.RedirectURI,
+		APIKey:           s.IDP.APIKey,
+		AuthorizeURI:     e.Authorization,
+		TokenURI:         e.Token,
+		RevokeURI:        s.URL + "/oauth2/revoke",
+		IntrospectionURI: e.Introspection,
+	}
+}
+
+// Login logs the user in with the authorization code grant.
+func (s *Server) Login() (fusionauth.AuthInfo, error) {
+	data := make(url.Values)
+	data.Set("loginId", s.User.LoginID)
+	data.Set("password", s.User.Password)
+	return s.Provider().Login(s.AuthConfig(), data)
+}
@@ -1,85 +1,452 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"expvar"
+	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/credentials"
 	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/oidc"
+	"github.com/tullo/invoice-mvp/identityprovider/secret"
 	"github.com/tullo/invoice-mvp/rest"
 	"github.com/tullo/invoice-mvp/roles"
 	"github.com/tullo/invoice-mvp/usecase"
 )
 
 func main() {
-	err := godotenv.Load()
+	if len(os.Args) > 1 && os.Args[1] == "users" {
+		if err := runUsers(os.Args[2:]); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Environment variables may be kept in a .env file.
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		log.Println("Error loading .env file:", err)
+		os.Exit(1)
+	}
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	// Identity provider: any OpenID Connect provider found by discovery, or
+	// FusionAuth by default.
+	var idp oidc.IdentityProvider
+	if len(cfg.IDP.DiscoveryURL) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		idp, err = oidc.Discover(ctx, cfg.IDP.DiscoveryURL, nil)
+		cancel()
+		if err != nil {
+			log.Println("Error discovering identity provider:", err)
+			os.Exit(1)
+		}
+		if idp.Issuer() != cfg.IDP.Issuer {
+			log.Printf("IDP_ISSUER %q does not match discovered issuer %q", cfg.IDP.Issuer, idp.Issuer())
+			os.Exit(1)
+		}
+	} else {
+		idp = fusionauth.NewProvider(cfg.IDP.BaseURL, cfg.IDP.Issuer)
+	}
+
+	repository, err := database.NewRepository(cfg.Repository)
+	if err != nil {
+		log.Println("Error opening repository:", err)
+		os.Exit(1)
+	}
+	a := rest.NewAdapter().WithConfig(cfg.IDP).WithIdentityProvider(idp)
+
+	// Public signing keys of the IDP, refreshed in the background, or of a
+	// local key set, e.g. of development tokens.
+	fetch := idp.KeySet
+	if len(cfg.JWKS.File) > 0 {
+		log.Println("Verifying tokens with the keys of", cfg.JWKS.File)
+		fetch = jwks.File(cfg.JWKS.File)
+	}
+	keys := jwks.New(fetch, jwks.Options{
+		TTL:                time.Duration(cfg.JWKS.TTL),
+		RefreshInterval:    time.Duration(cfg.JWKS.RefreshInterval),
+		MinRefreshInterval: time.Duration(cfg.JWKS.MinRefreshInterval),
+		FetchTimeout:       time.Duration(cfg.JWKS.FetchTimeout),
+	})
+	keys.Publish("jwks")
+	keys.Start()
+
+	// Shared HMAC keys of HS256 tokens, e.g. of the dev identity provider.
+	var ring *secret.Keyring
+	if usesHMAC(cfg.Auth.Algorithms) {
+		p, err := cfg.SecretProvider()
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		ring, err = secret.LoadKeyring(p, cfg.Secrets.Keyring)
+		if err != nil {
+			log.Println("Error loading HMAC keyring:", err)
+			os.Exit(1)
+		}
+	}
+	keyFunc := rest.KeyFunc(cfg.Auth.Algorithms, keys, ring)
+
+	// Basic and Digest users. The credential store is reloaded when the
+	// users command changed it.
+	var users rest.Credentials = rest.SingleUser(cfg.Auth.User)
+	var store *credentials.Store
+	if v := cfg.Auth.CredentialsFile; len(v) > 0 {
+		store, err = credentials.Open(v, cfg.Auth.Realm)
+		if err != nil {
+			log.Println("Error loading credential store:", err)
+			os.Exit(1)
+		}
+		store.Watch(time.Duration(cfg.Server.ReloadInterval))
+		users = store
+	}
+
+	// Authentication schemes accepted by the API routes, e.g. "jwt,apikey".
+	// API keys are the personal keys of the users and optionally the static
+	// keys of a file. Personal keys carry the roles their owner has now,
+	// known from the users or else from the user API of FusionAuth.
+	owners := rest.RoleDirectories{users}
+	// Users sending credentials with every request can not be logged out.
+	var perRequest rest.RoleDirectories
+	for _, s := range cfg.Auth.Schemes {
+		if s := strings.ToLower(strings.TrimSpace(s)); s == "basic" || s == "digest" {
+			perRequest = append(perRequest, users)
+			break
+		}
+	}
+	if fa, ok := idp.(fusionauth.Provider); ok && len(cfg.IDP.APIKey) > 0 {
+		owners = append(owners, fa.Users(cfg.IDP.APIKey, cfg.IDP.ClientID))
+	}
+	apiKeys := rest.APIKeyStores{rest.NewPersonalAPIKeys(usecase.NewAuthenticateAPIKey(repository), owners)}
+	if v := cfg.Auth.APIKeysFile; len(v) > 0 {
+		static, err := rest.LoadAPIKeys(v)
+		if err != nil {
+			log.Println("Error loading API keys:", err)
+			os.Exit(1)
+		}
+		apiKeys = append(apiKeys, static)
+		perRequest = append(perRequest, static)
+	}
+	chain, err := rest.NewAuthChain(cfg.Auth, keyFunc, users, apiKeys)
 	if err != nil {
-		log.Println("Error loading .env file")
+		log.Println("Error configuring authentication:", err)
 		os.Exit(1)
 	}
 
-	repository := database.NewFakeRepository()
-	a := rest.NewAdapter()
+	// Optional browser sessions: "memory" or the path of a session file.
+	var sessions *rest.Sessions
+	if len(cfg.Sessions.Store) > 0 {
+		sessions, err = newSessions(cfg.Sessions)
+		if err != nil {
+			log.Println("Error configuring sessions:", err)
+			os.Exit(1)
+		}
+		// Last, so bearer tokens and API keys take precedence over cookies.
+		chain = append(chain, sessions)
+	}
+
+	// Optional mutual TLS for B2B integrations, mapping client certificates
+	// to users.
+	if v := cfg.Server.ClientCAFile; len(v) > 0 {
+		pool, err := rest.LoadCertPool(v)
+		if err != nil {
+			log.Println("Error loading client CA bundle:", err)
+			os.Exit(1)
+		}
+		mapping, err := rest.LoadCertMapping(cfg.Server.ClientCertMapping)
+		if err != nil {
+			log.Println("Error loading client certificate mapping:", err)
+			os.Exit(1)
+		}
+		a = a.WithClientCAs(pool)
+		chain = append(chain, rest.NewCertAuthenticator(mapping))
+	}
+
+	// Revoked tokens and forcibly logged out users.
+	revocations := rest.NewRevocations()
+	if v := cfg.Auth.RevocationsFile; len(v) > 0 {
+		revocations, err = rest.OpenRevocations(v)
+		if err != nil {
+			log.Println("Error loading revocation store:", err)
+			os.Exit(1)
+		}
+	}
+	chain = chain.WithRevocations(revocations)
+	auth := chain.Authenticate
+
+	// High-risk routes optionally check bearer tokens with the IDP on every
+	// request (RFC 7662).
+	introspect := func(next rest.Handler) rest.Handler { return next }
+	if cfg.IDP.Introspection {
+		uri := cfg.IDP.IntrospectionURI
+		if len(uri) < 1 {
+			uri = idp.Endpoints().Introspection
+		}
+		introspect = rest.NewIntrospector(uri, cfg.IDP.ClientID, cfg.IDP.ClientSecret).Require
+	}
+
+	// Authorization policy mapping roles to permissions.
+	policy := roles.DefaultPolicy()
+	if v := cfg.Auth.Policy; len(v) > 0 {
+		policy, err = roles.LoadPolicy(v)
+		if err != nil {
+			log.Println("Error loading authorization policy:", err)
+			os.Exit(1)
+		}
+	}
+	policy.SetRealm(cfg.Auth.Realm)
+
+	// Login with the authorization code flow. The IDP redirects to
+	// /auth/token after user authentication.
+	flow := a.NewOAuth2Flow(rest.NewMemoryRefreshTokens())
+	if sessions != nil {
+		verifier := rest.NewJWTAuthenticator(cfg.Auth.Realm, cfg.Auth.Issuer, cfg.Auth.Audience, keyFunc)
+		flow.WithSessions(sessions, verifier)
+		a.Handle("/auth/session", sessions.InfoHandler()).Methods("GET")
+	}
+	a.Handle("/auth/login", flow.LoginHandler()).Methods("GET")
+	a.Handle("/auth/token", flow.CallbackHandler()).Methods("GET")
+	a.Handle("/auth/refresh", flow.RefreshHandler()).Methods("POST")
+	a.Handle("/auth/logout", flow.LogoutHandler()).Methods("POST")
+
+	// API keys
+	createAPIKey := usecase.NewCreateAPIKey(repository)
+	ck := a.CreateAPIKeyHandler(createAPIKey)
+	ck = auth(introspect(policy.Require(ck, roles.APIKeyWrite)))
+	a.Handle("/apikeys", ck).Methods("POST")
 
-	// IDP redirects to this URI after user authentication
-	a.Handle("/auth/token", rest.OAuth2AccessCodeGrant(a.OAuth2AccessTokenHandler())).Methods("GET")
+	apiKeyList := usecase.NewAPIKeys(repository)
+	gk := a.APIKeysHandler(apiKeyList)
+	gk = auth(policy.Require(gk, roles.APIKeyRead))
+	a.Handle("/apikeys", gk).Methods("GET")
+
+	revokeAPIKey := usecase.NewRevokeAPIKey(repository)
+	rk := a.RevokeAPIKeyHandler(revokeAPIKey)
+	rk = auth(introspect(policy.Require(rk, roles.APIKeyWrite)))
+	a.Handle("/apikeys/{keyId:[0-9]+}", rk).Methods("DELETE")
 
 	// Activities
 	activities := usecase.NewActivities(repository)
 	ga := a.ActivitiesHandler(activities)
-	ga = rest.JWTAuth(ga)
+	ga = auth(policy.Require(ga, roles.ActivityRead))
 	a.Handle("/activities", ga).Methods("GET")
 
 	createActivity := usecase.NewCreateActivity(repository)
 	ca := a.CreateActivityHandler(createActivity)
-	ca = rest.JWTAuth(ca)
+	ca = auth(policy.Require(ca, roles.ActivityWrite))
 	a.Handle("/activities", ca).Methods("POST")
 
 	// Booking
 	createBooking := usecase.NewCreateBooking(repository)
 	cb := a.CreateBookingHandler(createBooking)
-	cb = rest.JWTAuth(roles.AssertOwnsInvoice(cb, repository))
+	cb = auth(policy.Require(cb, roles.BookingWrite, roles.InvoiceOwner(repository)))
 	a.Handle("/book/{invoiceId:[0-9]+}", cb).Methods("POST")
 
 	deleteBooking := usecase.NewDeleteBooking(repository)
 	db := a.DeleteBookingHandler(deleteBooking)
-	db = rest.JWTAuth(db)
+	db = auth(policy.Require(db, roles.BookingWrite, roles.InvoiceOwner(repository)))
 	a.Handle("/invoices/{invoiceId:[0-9]+}/bookings/{bookingId:[0-9]+}", db).Methods("DELETE")
 
+	importBookings := usecase.NewImportBookings(repository)
+	ib := a.ImportBookingsHandler(importBookings)
+	ib = auth(policy.Require(ib, roles.BookingWrite, roles.InvoiceOwner(repository)))
+	a.Handle("/invoices/{invoiceId:[0-9]+}/bookings", ib).Methods("POST")
+
+	// Creating records while importing requires the permissions to create
+	// them one by one.
+	importTimesheet := usecase.NewImportTimesheet(repository)
+	it := a.ImportTimesheetHandler(importTimesheet)
+	creates := func(r *http.Request) bool {
+		b, _ := strconv.ParseBool(r.URL.Query().Get("create"))
+		return b
+	}
+	for _, p := range []roles.Permission{roles.CustomerWrite, roles.ProjectWrite, roles.ActivityWrite, roles.InvoiceWrite} {
+		it = policy.RequireIf(it, creates, p, roles.Self)
+	}
+	it = auth(policy.Require(it, roles.BookingWrite, roles.Self))
+	a.Handle("/timesheets/{format:toggl|harvest|clockify}", it).Methods("POST")
+
 	// Customer
 	createCustomer := usecase.NewCreateCustomer(repository)
 	cc := a.CreateCustomerHandler(createCustomer)
-	cc = rest.JWTAuth(cc)
+	cc = auth(policy.Require(cc, roles.CustomerWrite))
 	a.Handle("/customers", cc).Methods("POST")
 
+	updateCustomer := usecase.NewUpdateCustomer(repository)
+	uc := a.UpdateCustomerHandler(updateCustomer)
+	uc = auth(policy.Require(uc, roles.CustomerWrite, roles.CustomerOwner(repository)))
+	a.Handle("/customers/{customerId:[0-9]+}", uc).Methods("PUT")
+
+	// Profile, the master data of the user as seller of electronic invoices.
+	profile := usecase.NewGetProfile(repository)
+	gp := a.ProfileHandler(profile)
+	gp = auth(policy.Require(gp, roles.ProfileRead, roles.Self))
+	a.Handle("/profile", gp).Methods("GET")
+
+	saveProfile := usecase.NewSaveProfile(repository)
+	sp := a.SaveProfileHandler(saveProfile)
+	sp = auth(policy.Require(sp, roles.ProfileWrite, roles.Self))
+	a.Handle("/profile", sp).Methods("PUT")
+
 	// Invoice
 	createInvoice := usecase.NewCreateInvoice(repository)
 	ci := a.CreateInvoiceHandler(createInvoice)
-	ci = rest.JWTAuth(ci)
+	ci = auth(policy.Require(ci, roles.InvoiceWrite, roles.CustomerOwner(repository)))
 	a.Handle("/customers/{customerId:[0-9]+}/invoices", ci).Methods("POST")
 
 	updateInvoice := usecase.NewUpdateInvoice(repository)
 	ui := a.UpdateInvoiceHandler(updateInvoice)
-	ui = rest.JWTAuth(ui)
+	// Updates moving the invoice on also require the permission of the
+	// operation, like its dedicated route below.
+	ui = policy.RequireIf(ui, roles.Charges, roles.InvoiceCharge, roles.InvoiceOwner(repository))
+	ui = policy.RequireIf(ui, roles.Pays, roles.InvoicePayment, roles.InvoiceOwner(repository))
+	ui = auth(introspect(policy.Require(ui, roles.InvoiceWrite, roles.InvoiceOwner(repository))))
 	a.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", ui).Methods("PUT")
 
+	chargeInvoice := usecase.NewChargeInvoice(repository)
+	ch := a.ChargeInvoiceHandler(chargeInvoice)
+	ch = auth(introspect(policy.Require(ch, roles.InvoiceCharge, roles.InvoiceOwner(repository))))
+	a.Handle("/charge/{invoiceId:[0-9]+}", ch).Methods("POST")
+
+	payInvoice := usecase.NewPayInvoice(repository)
+	pi := a.PayInvoiceHandler(payInvoice)
+	pi = auth(introspect(policy.Require(pi, roles.InvoicePayment, roles.InvoiceOwner(repository))))
+	a.Handle("/payment/{invoiceId:[0-9]+}", pi).Methods("POST")
+
 	invoice := usecase.NewGetInvoice(repository)
 	gi := a.GetInvoiceHandler(invoice)
-	gi = rest.JWTAuth(gi)
+	gi = auth(policy.Require(gi, roles.InvoiceRead, roles.InvoiceOwner(repository)))
 	a.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", gi).Methods("GET")
 
+	invoices := usecase.NewInvoices(repository)
+	li := a.InvoicesHandler(invoices)
+	li = auth(policy.Require(li, roles.InvoiceRead, roles.Self))
+	a.Handle("/invoices", li).Methods("GET")
+
+	exportBookings := usecase.NewExportBookings(repository)
+	eb := a.ExportBookingsHandler(exportBookings)
+	eb = auth(policy.Require(eb, roles.InvoiceRead, roles.Self))
+	a.Handle("/exports/bookings", eb).Methods("GET")
+
 	// Project
 	createProject := usecase.NewCreateProject(repository)
 	cp := a.CreateProjectHandler(createProject)
-	cp = rest.JWTAuth(roles.AssertAdmin(cp))
+	cp = auth(policy.Require(cp, roles.ProjectWrite, roles.CustomerOwner(repository)))
 	a.Handle("/customers/{customerId:[0-9]+}/projects", cp).Methods("POST")
 
 	// Hourly rate
 	createRate := usecase.NewCreateRate(repository)
 	cr := a.CreateRateHandler(createRate)
-	cr = rest.JWTAuth(cr)
+	cr = auth(policy.Require(cr, roles.RateWrite, roles.CustomerOwner(repository)))
 	a.Handle("/customers/{customerId:[0-9]+}/projects/{projectId:[0-9]+}/rates", cr).Methods("POST")
 
+	// Revocation
+	rt := a.RevokeTokenHandler(revocations)
+	rt = auth(introspect(policy.Require(rt, roles.TokenRevoke)))
+	a.Handle("/admin/revocations", rt).Methods("POST")
+
+	rs := a.RevokeSubjectHandler(revocations, perRequest)
+	rs = auth(introspect(policy.Require(rs, roles.TokenRevoke)))
+	a.Handle("/admin/users/{userId}/revoke", rs).Methods("POST")
+
+	// Metrics, e.g. JWKS refresh failures.
+	vars := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		expvar.Handler().ServeHTTP(w, r)
+	}
+	a.Handle("/debug/vars", auth(roles.AssertAdmin(vars, cfg.Auth.Realm))).Methods("GET")
+
 	// Webserver
-	a.ListenAndServeTLS()
+	opts, err := rest.NewTLSOptions(cfg.Server)
+	if err != nil {
+		log.Println("Error configuring TLS:", err)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	err = a.ListenAndServeTLS(ctx, opts)
+	if err != nil {
+		log.Println(err)
+	}
+
+	// The server no longer serves requests: persist buffered writes, then
+	// stop the background workers.
+	if f, ok := interface{}(repository).(database.Flusher); ok {
+		if err := f.Flush(); err != nil {
+			log.Println("Error flushing repository:", err)
+		}
+	}
+	keys.Stop()
+	if store != nil {
+		store.Stop()
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	log.Println("Stopped")
+}
+
+// newSessions creates the session authenticator. The cookie key is the
+// base64 encoded AES key; without a key, a random one is used and sessions
+// do not survive restarts.
+func newSessions(c config.Sessions) (*rest.Sessions, error) {
+	var k []byte
+	if len(c.Key) > 0 {
+		var err error
+		if k, err = base64.StdEncoding.DecodeString(c.Key); err != nil {
+			return nil, errors.Wrap(err, "decoding SESSION_KEY")
+		}
+	} else {
+		log.Println("SESSION_KEY not set, sessions end on restart")
+		k = make([]byte, 32)
+		if _, err := rand.Read(k); err != nil {
+			return nil, err
+		}
+	}
+	var ss rest.SessionStore = rest.NewMemorySessions()
+	if c.Store != "memory" {
+		fs, err := rest.OpenFileSessions(c.Store)
+		if err != nil {
+			return nil, err
+		}
+		ss = fs
+	}
+	s, err := rest.NewSessions(ss, k)
+	if err != nil {
+		return nil, err
+	}
+	s.SetTTL(time.Duration(c.TTL))
+	return s, nil
+}
+
+// usesHMAC reports whether tokens signed with a shared key are accepted.
+func usesHMAC(algorithms []string) bool {
+	for _, alg := range algorithms {
+		if strings.HasPrefix(alg, "HS") {
+			return true
+		}
+	}
+	return false
 }
@@ -2,21 +2,28 @@ package rest
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"mime"
+	"net"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/tullo/invoice-mvp/config"
 	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+	"github.com/tullo/invoice-mvp/identityprovider/oidc"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/tullo/invoice-mvp/domain"
 	"github.com/tullo/invoice-mvp/usecase"
+	"github.com/tullo/invoice-mvp/xlsx"
 )
 
 const dateFormat = "Mon, _2 Jan 2006 15:04:05 GMT"
@@ -29,34 +36,56 @@ func truncateToSeconds(t time.Time) time.Time {
 type Adapter struct {
 	R   *mux.Router
 	idp fusionauth.AuthConfig
+	tls *tls.Config
 }
 
 // NewAdapter instantiates an adapter.
 func NewAdapter() Adapter {
-	var idp fusionauth.AuthConfig
-	if v, ok := os.LookupEnv("CLIENT_ID"); ok {
-		idp.ClientID = v
-	}
-	if v, ok := os.LookupEnv("CLIENT_SECRET"); ok {
-		idp.ClientSecret = v
+	var a Adapter
+	a.R = mux.NewRouter()
+
+	return a
+}
+
+// WithConfig returns a copy of the adapter acting as the OAuth2 client of
+// the configuration.
+func (a Adapter) WithConfig(c config.IDP) Adapter {
+	a.idp = fusionauth.AuthConfig{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		GrantType:    c.GrantType,
+		Issuer:       c.Issuer,
+		RedirectURI:  c.RedirectURI,
+		TokenURI:     c.TokenURI,
+		AuthorizeURI: c.AuthorizeURI,
+		RevokeURI:    c.RevokeURI,
 	}
-	if v, ok := os.LookupEnv("GRANT_TYPE"); ok {
-		idp.GrantType = v
+	return a
+}
+
+// WithIdentityProvider returns a copy of the adapter that redirects logins
+// to and exchanges code grants at the endpoints of the identity provider,
+// unless the URIs were configured explicitly.
+func (a Adapter) WithIdentityProvider(p oidc.IdentityProvider) Adapter {
+	if len(a.idp.TokenURI) < 1 {
+		a.idp.TokenURI = p.Endpoints().Token
 	}
-	if v, ok := os.LookupEnv("IDP_ISSUER"); ok {
-		idp.Issuer = v
+	if len(a.idp.AuthorizeURI) < 1 {
+		a.idp.AuthorizeURI = p.Endpoints().Authorization
 	}
-	if v, ok := os.LookupEnv("REDIRECT_URI"); ok {
-		idp.RedirectURI = v
+	if len(a.idp.RevokeURI) < 1 {
+		a.idp.RevokeURI = p.Endpoints().Revocation
 	}
-	if v, ok := os.LookupEnv("TOKEN_URI"); ok {
-		idp.TokenURI = v
+	if len(a.idp.Issuer) < 1 {
+		a.idp.Issuer = p.Issuer()
 	}
+	return a
+}
 
-	var a Adapter
-	a.R = mux.NewRouter()
-	a.idp = idp
-
+// WithClientCAs returns a copy of the adapter whose TLS server requests
+// client certificates signed by the CAs (mutual TLS).
+func (a Adapter) WithClientCAs(pool *x509.CertPool) Adapter {
+	a.tls = ClientCertTLSConfig(nil, pool)
 	return a
 }
 
@@ -66,10 +95,60 @@ func (a Adapter) ListenAndServe() {
 	_ = http.ListenAndServe(":8080", a.R)
 }
 
-// ListenAndServeTLS launches a web server on port 8080.
-func (a Adapter) ListenAndServeTLS() {
-	log.Printf("Listening on https://0.0.0.0%s\n", ":8443")
-	_ = http.ListenAndServeTLS(":8443", "localhost+2.pem", "localhost+2-key.pem", a.R)
+// ListenAndServeTLS launches a web server configured by the options, see
+// ServeTLS.
+func (a Adapter) ListenAndServeTLS(ctx context.Context, opts TLSOptions) error {
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return errors.Wrap(err, "listening")
+	}
+	return a.ServeTLS(ctx, ln, opts)
+}
+
+// ServeTLS serves HTTPS on the listener until the context is done. In-flight
+// requests are then drained within the shutdown timeout. The certificate is
+// reloaded when its files change or on SIGHUP, until the server stopped.
+func (a Adapter) ServeTLS(ctx context.Context, ln net.Listener, opts TLSOptions) error {
+	cfg, cr, err := a.TLSConfig(opts)
+	if err != nil {
+		ln.Close()
+		return errors.Wrap(err, "configuring TLS")
+	}
+	if cr != nil {
+		cr.Watch(opts.ReloadInterval)
+		defer cr.Stop()
+	}
+	srv := http.Server{
+		Handler:           a.R,
+		TLSConfig:         cfg,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		ReadTimeout:       opts.ReadTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on https://%s\n", ln.Addr())
+		serveErr <- srv.ServeTLS(ln, "", "")
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, draining requests for up to %v\n", opts.ShutdownTimeout)
+	sctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		srv.Close()
+		return errors.Wrap(err, "draining requests")
+	}
+	if err := <-serveErr; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
 // Handler is a type that handles http requests.
@@ -99,25 +178,46 @@ func (a Adapter) Handle(path string, handler Handler) *mux.Route {
 }
 
 // InvoicePresenter returns a presenter matching the 'Accept' request header.
+// The first supported media type wins. Electronic invoices are negotiated
+// as UBL or CII, e.g. "application/ubl+xml;profile=xrechnung".
 func (a Adapter) InvoicePresenter(w http.ResponseWriter, r *http.Request) (InvoicePresenter, bool) {
-	// e.g. "Accept: application/json;q=0.8, application/hal+json"
-	headers := strings.Split(r.Header.Get("Accept"), ",")
-	var ip InvoicePresenter
-	var ok bool
-	for _, accept := range headers {
-		switch accept {
+	for _, mr := range accepted(r) {
+		switch mr.mediaType {
 		case "application/json", "application/hal+json":
-			ip, ok = NewJSONInvoicePresenter(w), true
-			break
+			return NewJSONInvoicePresenter(w), true
 		case "application/pdf":
-			ip, ok = NewPDFInvoicePresenter(w, r), true
-			break
-		default:
-			ip, ok = NewDefaultPresenter(), false
-			break
+			return NewPDFInvoicePresenter(w, r), true
+		case "text/csv":
+			return NewCSVPresenter(w, "invoice-"+mux.Vars(r)["invoiceId"]), true
+		case xlsx.ContentType:
+			return NewXLSXPresenter(w, "invoice-"+mux.Vars(r)["invoiceId"]), true
+		case UBLMediaType, CIIMediaType:
+			return eInvoicePresenter(w, "invoice-"+mux.Vars(r)["invoiceId"], mr), true
 		}
 	}
-	return ip, ok
+	return NewDefaultPresenter(), false
+}
+
+// mediaRange is a media type of the 'Accept' request header along with its
+// parameters, e.g. "application/ubl+xml;profile=xrechnung".
+type mediaRange struct {
+	mediaType string
+	params    map[string]string
+}
+
+// accepted returns the media ranges of the 'Accept' request header, e.g.
+// "application/json;q=0.8, application/hal+json", in the order given.
+func accepted(r *http.Request) []mediaRange {
+	var mrs []mediaRange
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(accept)
+		if err != nil {
+			mt, _, _ = strings.Cut(accept, ";")
+			mt = strings.ToLower(strings.TrimSpace(mt))
+		}
+		mrs = append(mrs, mediaRange{mediaType: mt, params: params})
+	}
+	return mrs
 }
 
 // Extracts the authorized user's ID from the request (JWT).
@@ -130,6 +230,23 @@ func (a Adapter) currentUser(ctx context.Context) string {
 	return claims.Subject
 }
 
+//=============================================================================
+// API Key
+
+func (a Adapter) readAPIKey(r *http.Request, c Claims) (domain.APIKey, error) {
+	var k domain.APIKey
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return k, err
+	}
+	if err := json.Unmarshal(body, &k); err != nil {
+		return k, err
+	}
+	k.UserID = c.Subject
+	k.Roles = c.Roles
+	return k, k.Validate()
+}
+
 //=============================================================================
 // Activity
 
@@ -190,6 +307,9 @@ func (a Adapter) readCustomer(r *http.Request, uid string) (domain.Customer, err
 		return c, err
 	}
 	c.UserID = uid
+	if c.Address != nil {
+		return c, c.Address.Validate()
+	}
 	return c, nil
 }
 
@@ -203,6 +323,22 @@ func (a Adapter) writeCustomer(c domain.Customer, w http.ResponseWriter) error {
 	return nil
 }
 
+//=============================================================================
+// Profile
+
+func (a Adapter) readProfile(r *http.Request, uid string) (domain.Profile, error) {
+	var p domain.Profile
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return p, err
+	}
+	p.UserID = uid
+	return p, p.Validate()
+}
+
 //=============================================================================
 // Invoice
 
@@ -366,6 +502,99 @@ func (a Adapter) CreateActivityHandler(uc usecase.CreateActivity) Handler {
 	}
 }
 
+// CreateAPIKeyHandler returns a handler that knows how to create an API key
+// for the current user. The secret is only part of this response.
+func (a Adapter) CreateAPIKeyHandler(uc usecase.CreateAPIKey) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		claims, ok := ctx.Value(Key).(Claims)
+		if !ok || len(claims.Subject) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if claims.apiKey || len(claims.Scopes) > 0 {
+			// API keys can not create API keys.
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		k, err := a.readAPIKey(r, claims)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Runs the usecase to create an API key.
+		created, secret, err := uc.Run(k)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		bs, err := json.Marshal(struct {
+			domain.APIKey
+			Key string `json:"key"`
+		}{created, secret})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Location", fmt.Sprintf("%s/%d", r.URL.String(), created.ID))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(bs)
+	}
+}
+
+// APIKeysHandler returns a handler that knows how to list the API keys of
+// the current user.
+func (a Adapter) APIKeysHandler(uc usecase.APIKeys) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// Runs the usecase to get the user's API keys.
+		ks := uc.Run(uid)
+		if len(ks) < 1 {
+			ks = []domain.APIKey{}
+		}
+		bs, err := json.Marshal(ks)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(bs)
+	}
+}
+
+// RevokeAPIKeyHandler returns a handler that knows how to revoke an API key
+// of the current user.
+func (a Adapter) RevokeAPIKeyHandler(uc usecase.RevokeAPIKey) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["keyId"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Runs the usecase to revoke an API key.
+		err = uc.Run(uid, id)
+		if err == domain.ErrAPIKeyNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 // CreateBookingHandler returns a handler that knows how to create a booking.
 func (a Adapter) CreateBookingHandler(uc usecase.CreateBooking) Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -437,6 +666,86 @@ func (a Adapter) CreateCustomerHandler(uc usecase.CreateCustomer) Handler {
 	}
 }
 
+// UpdateCustomerHandler returns a handler that knows how to update the name
+// and buyer master data of a customer.
+func (a Adapter) UpdateCustomerHandler(uc usecase.UpdateCustomer) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["customerId"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		customer, err := a.readCustomer(r, uid)
+		if err != nil {
+			writeProblem(w, Problem{Title: "Invalid customer", Status: http.StatusBadRequest, Detail: err.Error()})
+			return
+		}
+		customer.ID = id
+		// Runs the usecase to update the customer.
+		updated, err := uc.Run(uid, customer)
+		switch {
+		case errors.Is(err, usecase.ErrCustomerNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := a.writeCustomer(updated, w); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
+// ProfileHandler returns a handler that knows how to get the profile of the
+// current user.
+func (a Adapter) ProfileHandler(uc usecase.GetProfile) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// Runs the usecase to get the user's profile.
+		bs, err := json.Marshal(uc.Run(uid))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(bs)
+	}
+}
+
+// SaveProfileHandler returns a handler that knows how to save the profile of
+// the current user, the master data of the user as seller of electronic
+// invoices.
+func (a Adapter) SaveProfileHandler(uc usecase.SaveProfile) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p, err := a.readProfile(r, uid)
+		if err != nil {
+			writeProblem(w, Problem{Title: "Invalid profile", Status: http.StatusBadRequest, Detail: err.Error()})
+			return
+		}
+		// Runs the usecase to save the profile.
+		if err := uc.Run(p); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 // CreateInvoiceHandler returns a handler that knows how to create an invoice.
 func (a Adapter) CreateInvoiceHandler(uc usecase.CreateInvoice) Handler {
 	// A Closure that is closing over the createInvoice usecase instance.
@@ -460,7 +769,7 @@ func (a Adapter) CreateInvoiceHandler(uc usecase.CreateInvoice) Handler {
 }
 
 // GetInvoiceHandler returns a handler that knows how to deliver an invoice in
-// either JSON or PDF format.
+// either JSON or PDF format, or its bookings and positions as CSV or XLSX.
 func (a Adapter) GetInvoiceHandler(uc usecase.GetInvoice) Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		// extracts invoiceId from the URI
@@ -476,18 +785,57 @@ func (a Adapter) GetInvoiceHandler(uc usecase.GetInvoice) Handler {
 			// Use first element of the list.
 			expand = v[0]
 		}
-		// JSON or PDF representation of the invoice.
-		if p, ok := a.InvoicePresenter(w, r); ok {
+		// JSON, PDF, CSV, XLSX, UBL or CII representation of the invoice.
+		p, ok := a.InvoicePresenter(w, r)
+		switch p.(type) {
+		case CSVPresenter, XLSXPresenter:
+			// Spreadsheets list the bookings and positions of the invoice.
+			p.Present(uc.Export(id))
+		case EInvoicePresenter:
+			e, err := uc.EInvoice(id)
+			if errors.Is(err, usecase.ErrInvoiceNotCharged) {
+				writeProblem(w, Problem{Title: "Invoice not charged", Status: http.StatusConflict, Detail: "electronic invoices are issued once the invoice is charged"})
+				return
+			}
+			p.Present(e)
+		default:
+			if !ok {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
 			// Runs the usecase to get an invoice that optionaly includes and
 			// lists subresources.
 			i := uc.Run(id, expand)
 			p.Present(NewHALInvoice(i))
-		} else {
-			w.WriteHeader(http.StatusNotAcceptable)
 		}
 	}
 }
 
+// InvoicesHandler returns a handler that knows how to list the invoices of
+// the current user, optionally filtered by the status query parameter.
+func (a Adapter) InvoicesHandler(uc usecase.Invoices) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// Runs the usecase to get the user's invoices.
+		is := uc.Run(uid, r.URL.Query().Get("status"))
+		hs := make([]HALInvoice, 0, len(is))
+		for _, i := range is {
+			hs = append(hs, NewHALInvoice(i))
+		}
+		bs, err := json.Marshal(hs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(bs)
+	}
+}
+
 // UpdateInvoiceHandler returns a handler that knows how to update an ivoice.
 func (a Adapter) UpdateInvoiceHandler(updateInvoice usecase.UpdateInvoice) Handler {
 	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -513,6 +861,47 @@ func (a Adapter) UpdateInvoiceHandler(updateInvoice usecase.UpdateInvoice) Handl
 	return handler
 }
 
+// ChargeInvoiceHandler returns a handler that knows how to charge an
+// invoice, the target of its "charge" link.
+func (a Adapter) ChargeInvoiceHandler(uc usecase.ChargeInvoice) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["invoiceId"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, err = uc.Run(a.currentUser(ctx), id)
+		writeTransition(w, err)
+	}
+}
+
+// PayInvoiceHandler returns a handler that knows how to record the payment
+// of an invoice, the target of its "payment" link.
+func (a Adapter) PayInvoiceHandler(uc usecase.PayInvoice) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["invoiceId"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, err = uc.Run(id)
+		writeTransition(w, err)
+	}
+}
+
+// writeTransition writes the outcome of an operation on an invoice.
+func writeTransition(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, usecase.ErrOperationNotAllowed):
+		writeProblem(w, Problem{Title: "Operation not allowed", Status: http.StatusConflict, Detail: err.Error()})
+	default:
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 // CreateProjectHandler returns a handler that knows how to create a project.
 func (a Adapter) CreateProjectHandler(uc usecase.CreateProject) Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -3,38 +3,32 @@ package rest_test
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/go-playground/assert/v2"
-	"github.com/joho/godotenv"
 	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+	"github.com/tullo/invoice-mvp/identityprovider/stub/stubtest"
 	"github.com/tullo/invoice-mvp/rest"
 )
 
-func TestAuthAccessCodeGrant(t *testing.T) {
-	err := godotenv.Load("../.env")
-	if err != nil {
-		log.Println("Error loading .env file")
-		t.Fatal(err)
-	}
-
+// authorize posts the credentials of the stub user to the authorize endpoint
+// and returns the redirect location with the access code grant.
+func authorize(t *testing.T, idp *stubtest.Server) *url.URL {
+	t.Helper()
 	// POST form data
 	data := url.Values{}
 	data.Set("response_type", "code")
-	data.Set("loginId", os.Getenv("TEST_LOGIN"))
-	data.Set("password", os.Getenv("TEST_PASSWD"))
-	data.Set("client_id", os.Getenv("TEST_CLIENT_ID"))  // Test application
-	data.Set("redirect_uri", os.Getenv("REDIRECT_URI")) // Must match FA config.
+	data.Set("loginId", idp.User.LoginID)
+	data.Set("password", idp.User.Password)
+	data.Set("client_id", idp.Client.ID)
+	data.Set("redirect_uri", idp.Client.RedirectURI) // Must match IDP config.
 
-	// FA authorize:
-	endpoint := "http://localhost:9011/oauth2/authorize"
+	endpoint := idp.Provider().Endpoints().Authorization
 	r, err := http.NewRequest("POST", endpoint, strings.NewReader(data.Encode())) // URL-encoded payload
 	if err != nil {
 		t.Fatal(err)
@@ -71,87 +65,61 @@ func TestAuthAccessCodeGrant(t *testing.T) {
 			t.Error("Unexpected user state", us)
 		}
 	}
-	// t.Log(q["code"][0])
+	if len(q.Get("code")) < 1 {
+		t.Fatal("Access code grant missing")
+	}
+	return u
 }
 
-func TestAuthAccessToken(t *testing.T) {
-	err := godotenv.Load("../.env")
-	if err != nil {
-		log.Println("Error loading .env file")
-		t.Fatal(err)
-	}
+func TestAuthAccessCodeGrant(t *testing.T) {
+	idp := stubtest.Start(t)
+	authorize(t, idp)
 
-	// ====================== AUTHORIZE =======================================
-	// POST form data
+	// Wrong credentials render the login form again.
 	data := url.Values{}
 	data.Set("response_type", "code")
-	data.Set("loginId", os.Getenv("TEST_LOGIN"))
-	data.Set("password", os.Getenv("TEST_PASSWD"))
-	data.Set("client_id", os.Getenv("TEST_CLIENT_ID"))  // Test application
-	data.Set("redirect_uri", os.Getenv("REDIRECT_URI")) // Must match FA config.
-
-	// FA authorize:
-	endpoint := "http://localhost:9011/oauth2/authorize"
-	r, err := http.NewRequest("POST", endpoint, strings.NewReader(data.Encode())) // URL-encoded payload
-	if err != nil {
-		t.Error(err)
-	}
-	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
-
-	var noRedirect http.RoundTripper = &http.Transport{}
-	res, err := noRedirect.RoundTrip(r)
-	res.Body.Close()
+	data.Set("loginId", idp.User.LoginID)
+	data.Set("password", "wrong")
+	data.Set("client_id", idp.Client.ID)
+	data.Set("redirect_uri", idp.Client.RedirectURI)
+	res, err := http.PostForm(idp.Provider().Endpoints().Authorization, data)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if res.StatusCode != http.StatusFound {
-		t.Fatal("Unexpected response status", res.StatusCode)
-	}
-	if _, ok := res.Header["Location"]; !ok {
-		t.Fatal("Location header not available")
-	}
-	if loc, ok := res.Header["Location"]; ok {
-		if len(loc) < 1 {
-			t.Fatal("Location value missing")
-		}
-	}
-	loc := res.Header["Location"][0]
-	u, err := url.Parse(loc)
-	if err != nil {
-		t.Fatal(err)
-	}
-	q := u.Query()
-	if us, ok := q["userState"]; ok {
-		if us[0] != "Authenticated" {
-			t.Error("Unexpected user state", us)
-		}
-	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 0, len(res.Header.Get("Location")))
+}
+
+func TestAuthAccessToken(t *testing.T) {
+	idp := stubtest.Start(t)
+
+	// ====================== AUTHORIZE =======================================
+	q := authorize(t, idp).Query()
 
 	// ====================== ACCESS TOKEN ====================================
 
-	// FusionAuth token endpoint:
-	endpoint = "http://localhost:9011/oauth2/token"
-	data = url.Values{}
+	endpoint := idp.Provider().Endpoints().Token
+	data := url.Values{}
 	//data.Set("user_code", "")
 	//data.Set("scope", "")
 	data.Set("grant_type", "authorization_code")
-	data.Set("redirect_uri", os.Getenv("REDIRECT_URI")) // Must match FA config.
-	data.Set("client_id", os.Getenv("TEST_CLIENT_ID"))  // Test application
-	data.Set("client_secret", os.Getenv("TEST_CLIENT_SECRET"))
+	data.Set("redirect_uri", idp.Client.RedirectURI) // Must match IDP config.
+	data.Set("client_id", idp.Client.ID)
+	data.Set("client_secret", idp.Client.Secret)
 	data.Set("code", q["code"][0])
 
 	var client http.Client
-	r, err = http.NewRequest("POST", endpoint, strings.NewReader(data.Encode())) // URL-encoded payload
+	r, err := http.NewRequest("POST", endpoint, strings.NewReader(data.Encode())) // URL-encoded payload
 	if err != nil {
 		t.Error(err)
 	}
 	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
 
-	res, err = client.Do(r)
+	res, err := client.Do(r)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
@@ -182,68 +150,27 @@ func TestAuthAccessToken(t *testing.T) {
 	if len(auth.AccessToken) < 1 {
 		t.Error("AccessToken not valid", auth.AccessToken)
 	}
-}
 
-func TestOAuth2Handler(t *testing.T) {
-	err := godotenv.Load("../.env")
+	// Codes are used once.
+	res, err = http.PostForm(endpoint, data)
 	if err != nil {
-		log.Println("Error loading .env file")
 		t.Fatal(err)
 	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
 
-	// ====================== AUTHORIZE =======================================
-	// POST form data
-	data := url.Values{}
-	data.Set("response_type", "code")
-	data.Set("loginId", os.Getenv("MVP_USERNAME"))
-	data.Set("password", os.Getenv("MVP_PASSWORD"))
-	data.Set("client_id", os.Getenv("CLIENT_ID"))       // Test application
-	data.Set("redirect_uri", os.Getenv("REDIRECT_URI")) // Must match FA config.
-
-	// FA authorize:
-	endpoint := "http://localhost:9011/oauth2/authorize"
-	r, err := http.NewRequest("POST", endpoint, strings.NewReader(data.Encode())) // URL-encoded payload
-	if err != nil {
-		t.Error(err)
-	}
-	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
+func TestOAuth2Handler(t *testing.T) {
+	idp := stubtest.Start(t)
 
-	var noRedirect http.RoundTripper = &http.Transport{}
-	res, err := noRedirect.RoundTrip(r)
-	if err != nil {
-		t.Fatal(err)
-	}
-	res.Body.Close()
-	if res.StatusCode != http.StatusFound {
-		t.Error("Unexpected response status", res.StatusCode)
-	}
-	if _, ok := res.Header["Location"]; !ok {
-		t.Fatal("Location header not available")
-	}
-	if loc, ok := res.Header["Location"]; ok {
-		if len(loc) < 1 {
-			t.Fatal("Location value missing")
-		}
-	}
-	loc := res.Header["Location"][0]
-	//t.Log("Location", loc)
-	u, err := url.Parse(loc)
-	if err != nil {
-		t.Error(err)
-	}
-	q := u.Query()
-	if us, ok := q["userState"]; ok {
-		if us[0] != "Authenticated" {
-			t.Error("Unexpected user state", us)
-		}
-	}
+	// ====================== AUTHORIZE =======================================
+	loc := authorize(t, idp).String()
 
 	//=========================================================================
 	// Exchange access code grant with JWT access token
 
 	rr := httptest.NewRecorder()
-	a := rest.NewAdapter()
+	a := rest.NewAdapter().WithConfig(idp.Config())
 	// IDP redirects to this URI after user authentication
 	a.Handle("/auth/token", rest.OAuth2AccessCodeGrant(a.OAuth2AccessTokenHandler())).Methods("GET")
 
@@ -271,7 +198,7 @@ func TestOAuth2Handler(t *testing.T) {
 	if auth.ExpiresIn < 0 {
 		t.Error("Token expired", auth.ExpiresIn)
 	}
-	if len(auth.UserID) < 1 {
+	if auth.UserID != idp.User.ID {
 		t.Error("UserID not valid", auth.UserID)
 	}
 	if len(auth.AccessToken) < 1 {
@@ -0,0 +1,448 @@
+package rest
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries
+// no credentials for its scheme, so the next authenticator in a chain may try.
+var ErrNoCredentials = errors.New("no credentials for authentication scheme")
+
+// Authenticator verifies the credentials of a request for one authentication
+// scheme and converts them into claims.
+type Authenticator interface {
+	// Authenticate returns the claims of the authenticated principal.
+	Authenticate(r *http.Request) (Claims, error)
+	// Challenge adds the WWW-Authenticate challenge of the scheme.
+	Challenge(w http.ResponseWriter)
+}
+
+// StaleChallenger is implemented by authenticators that can ask the client
+// to retry with fresh server state, see ErrStaleNonce.
+type StaleChallenger interface {
+	ChallengeStale(w http.ResponseWriter)
+}
+
+// AuthChain tries its authenticators in order. The first authenticator that
+// finds credentials in the request decides about success or failure.
+type AuthChain []Authenticator
+
+// Authenticate decorator stores the claims of the authenticated principal in
+// the context, so role assertions work regardless of the scheme used.
+func (c AuthChain) Authenticate(next Handler) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		failed := -1
+		var err error
+		for i, a := range c {
+			var claims Claims
+			claims, err = a.Authenticate(r)
+			if err == ErrNoCredentials {
+				continue
+			}
+			if err != nil {
+				log.Println("authentication failed:", err)
+				failed = i
+				break
+			}
+			// Add claims to the context so they can be retrieved later.
+			ctx = context.WithValue(ctx, Key, claims)
+			next(ctx, w, r)
+			return
+		}
+		for i, a := range c {
+			if sc, ok := a.(StaleChallenger); ok && err == ErrStaleNonce && i == failed {
+				sc.ChallengeStale(w)
+				continue
+			}
+			a.Challenge(w)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+// Credentials verifies users for the Basic and Digest schemes.
+type Credentials interface {
+	PasswordVerifier
+	DigestCredentials
+	RoleDirectory
+}
+
+// LoginObserver is implemented by credential stores that track failed
+// logins by username and client address, e.g. to lock out a client that
+// keeps guessing the password of a user. Other clients of the user are not
+// affected.
+type LoginObserver interface {
+	LockedOut(username, addr string) bool
+	LoginFailed(username, addr string)
+	LoginSucceeded(username, addr string)
+}
+
+// clientAddr returns the IP address of the client of the request.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NewAuthChain builds the chain of the configured schemes, e.g. jwt and
+// apikey. Supported names are basic, digest, jwt and apikey. Basic and
+// Digest users default to the single user of the configuration when users
+// is nil. JWT tokens are verified with the keys provided by kf, e.g. of
+// KeyFunc, and an API key store is required when apikey is listed.
+func NewAuthChain(cfg config.Auth, kf jwt.Keyfunc, users Credentials, keys APIKeyStore) (AuthChain, error) {
+	if users == nil {
+		users = SingleUser(cfg.User)
+	}
+	var c AuthChain
+	for _, s := range cfg.Schemes {
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "":
+		case "basic":
+			c = append(c, NewBasicAuthenticator(cfg.Realm, users))
+		case "digest":
+			c = append(c, NewDigestAuthenticator(cfg.Realm, users))
+		case "jwt", "bearer":
+			if kf == nil {
+				return nil, errors.New("scheme jwt requires a key func")
+			}
+			c = append(c, NewJWTAuthenticator(cfg.Realm, cfg.Issuer, cfg.Audience, kf))
+		case "apikey":
+			if keys == nil {
+				return nil, errors.New("scheme apikey requires an API key store")
+			}
+			c = append(c, NewAPIKeyAuthenticator(cfg.Realm, keys))
+		default:
+			return nil, errors.Errorf("unknown authentication scheme %q", s)
+		}
+	}
+	if len(c) < 1 {
+		return nil, errors.New("no authentication scheme configured")
+	}
+	return c, nil
+}
+
+// ===== BASIC ================================================================
+
+// PasswordVerifier checks a username and password pair.
+type PasswordVerifier interface {
+	VerifyPassword(username, password string) (Claims, bool)
+}
+
+// SingleUser is the single user of the configuration, e.g. set by the
+// environment variables "MVP_USERNAME" and "MVP_PASSWORD". The claims
+// subject is the user ID when set.
+type SingleUser config.User
+
+// VerifyPassword implements the PasswordVerifier interface.
+func (u SingleUser) VerifyPassword(username, password string) (Claims, bool) {
+	if len(u.Username) < 1 || len(u.Password) < 1 {
+		return Claims{}, false
+	}
+	okUser := subtle.ConstantTimeCompare([]byte(username), []byte(u.Username))
+	okPass := subtle.ConstantTimeCompare([]byte(password), []byte(u.Password))
+	if okUser&okPass != 1 {
+		return Claims{}, false
+	}
+	return u.claims(), true
+}
+
+// RolesOf implements the RoleDirectory interface.
+func (u SingleUser) RolesOf(subject string) ([]string, bool) {
+	c := u.claims()
+	if len(u.Username) < 1 || subject != c.Subject {
+		return nil, false
+	}
+	return c.Roles, true
+}
+
+func (u SingleUser) claims() Claims {
+	var c Claims
+	c.Subject = u.Username
+	if len(u.ID) > 0 {
+		c.Subject = u.ID
+	}
+	c.Roles = []string{RoleUser}
+	return c
+}
+
+// BasicAuthenticator implements the "Basic" HTTP authentication scheme.
+type BasicAuthenticator struct {
+	realm string
+	users PasswordVerifier
+}
+
+// NewBasicAuthenticator instantiates a basic authenticator.
+func NewBasicAuthenticator(realm string, users PasswordVerifier) BasicAuthenticator {
+	return BasicAuthenticator{realm: realm, users: users}
+}
+
+// Authenticate implements the Authenticator interface.
+func (a BasicAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return Claims{}, ErrNoCredentials
+	}
+	addr := clientAddr(r)
+	o, observed := a.users.(LoginObserver)
+	if observed && o.LockedOut(username, addr) {
+		return Claims{}, errors.Errorf("user %q locked out for %s", username, addr)
+	}
+	c, ok := a.users.VerifyPassword(username, password)
+	if !ok {
+		if observed {
+			o.LoginFailed(username, addr)
+		}
+		return c, errors.Errorf("invalid credentials for user %q", username)
+	}
+	if observed {
+		o.LoginSucceeded(username, addr)
+	}
+	return issuedNow(c), nil
+}
+
+// issuedNow stamps the time of the request as "iat" on claims of
+// credentials sent with every request, which have no issue time of their
+// own, so a revocation of their subject does not reject them for good. Such
+// users can not be logged out, see RevokeSubjectHandler.
+func issuedNow(c Claims) Claims {
+	if c.IssuedAt == nil {
+		c.IssuedAt = jwt.At(time.Now())
+	}
+	return c
+}
+
+// Challenge implements the Authenticator interface.
+func (a BasicAuthenticator) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", a.realm))
+}
+
+// ===== JWT BEARER ===========================================================
+
+// JWTAuthenticator implements the "Bearer" scheme for JWT access tokens.
+type JWTAuthenticator struct {
+	realm    string
+	issuer   string
+	audience string
+	keyFunc  jwt.Keyfunc
+}
+
+// NewJWTAuthenticator instantiates a JWT authenticator that verifies the
+// token signature with keys provided by kf.
+func NewJWTAuthenticator(realm, issuer, audience string, kf jwt.Keyfunc) JWTAuthenticator {
+	return JWTAuthenticator{realm: realm, issuer: issuer, audience: audience, keyFunc: kf}
+}
+
+// Authenticate implements the Authenticator interface.
+func (a JWTAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	token := ExtractJwt(r.Header)
+	if len(token) < 1 {
+		return Claims{}, ErrNoCredentials
+	}
+	return a.verify(token)
+}
+
+// Challenge implements the Authenticator interface.
+func (a JWTAuthenticator) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", a.realm))
+}
+
+func (a JWTAuthenticator) verify(s string) (Claims, error) {
+	var claims Claims
+
+	// Verify unparsed token parts.
+	parts := strings.Split(s, ".")
+	if len(parts) < 3 {
+		return claims, errors.New("invalid token parts count")
+	}
+
+	var po []jwt.ParserOption
+	po = append(po, jwt.WithIssuer(a.issuer))
+	po = append(po, jwt.WithAudience(a.audience))
+	t, err := jwt.ParseWithClaims(s, &claims, a.keyFunc, po...)
+	if err != nil {
+		return claims, errors.Wrap(err, "token parsing")
+	}
+	if !t.Valid {
+		return claims, errors.New("token is not valid")
+	}
+	claims.accessToken = s
+	return claims, nil
+}
+
+// ===== API KEY ==============================================================
+
+// APIKeyHeader is the request header carrying an API key.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyStore resolves an API key to the claims of its owner.
+type APIKeyStore interface {
+	ClaimsForAPIKey(key string) (Claims, bool)
+}
+
+// APIKeys is a static API key store mapping the hex encoded SHA-256 digest
+// of a key to the claims granted to it.
+type APIKeys map[string]Claims
+
+// LoadAPIKeys reads a JSON file holding an APIKeys map.
+func LoadAPIKeys(path string) (APIKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading api key file")
+	}
+	var keys APIKeys
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling api key file")
+	}
+	return keys, nil
+}
+
+// ClaimsForAPIKey implements the APIKeyStore interface.
+func (ks APIKeys) ClaimsForAPIKey(key string) (Claims, bool) {
+	sum := sha256.Sum256([]byte(key))
+	c, ok := ks[hex.EncodeToString(sum[:])]
+	return c, ok
+}
+
+// RolesOf implements the RoleDirectory interface for the subjects of the
+// keys.
+func (ks APIKeys) RolesOf(subject string) ([]string, bool) {
+	for _, c := range ks {
+		if c.Subject == subject {
+			return c.Roles, true
+		}
+	}
+	return nil, false
+}
+
+// APIKeyStores tries each store in turn.
+type APIKeyStores []APIKeyStore
+
+// ClaimsForAPIKey implements the APIKeyStore interface.
+func (ss APIKeyStores) ClaimsForAPIKey(key string) (Claims, bool) {
+	for _, s := range ss {
+		if c, ok := s.ClaimsForAPIKey(key); ok {
+			return c, true
+		}
+	}
+	return Claims{}, false
+}
+
+// PersonalAPIKeys resolves the API keys users create via the API. The
+// claims carry the roles the owner has now, limited to those at creation of
+// the key, the scopes of the key, the key ID as "jti" and its expiry. Keys of
+// owners unknown to the directory are rejected.
+type PersonalAPIKeys struct {
+	uc     usecase.AuthenticateAPIKey
+	owners RoleDirectory
+}
+
+// NewPersonalAPIKeys instantiates the store of personal API keys.
+func NewPersonalAPIKeys(uc usecase.AuthenticateAPIKey, owners RoleDirectory) PersonalAPIKeys {
+	return PersonalAPIKeys{uc: uc, owners: owners}
+}
+
+// ClaimsForAPIKey implements the APIKeyStore interface.
+func (p PersonalAPIKeys) ClaimsForAPIKey(key string) (Claims, bool) {
+	var c Claims
+	if !strings.HasPrefix(key, domain.APIKeyPrefix) {
+		return c, false
+	}
+	k, ok := p.uc.Run(key)
+	if !ok {
+		return c, false
+	}
+	roles, ok := p.owners.RolesOf(k.UserID)
+	if !ok {
+		return c, false
+	}
+	c.Subject = k.UserID
+	for _, r := range roles {
+		if contains(k.Roles, r) {
+			c.Roles = append(c.Roles, r)
+		}
+	}
+	c.Scopes = k.Scopes
+	c.ID = fmt.Sprintf("apikey-%d", k.ID)
+	c.IssuedAt = jwt.At(k.Created)
+	if !k.Expires.IsZero() {
+		c.ExpiresAt = jwt.At(k.Expires)
+	}
+	return c, true
+}
+
+// APIKeyAuthenticator accepts API keys sent in the X-API-Key header or as
+// "Authorization: ApiKey <key>".
+type APIKeyAuthenticator struct {
+	realm string
+	keys  APIKeyStore
+}
+
+// NewAPIKeyAuthenticator instantiates an API key authenticator.
+func NewAPIKeyAuthenticator(realm string, keys APIKeyStore) APIKeyAuthenticator {
+	return APIKeyAuthenticator{realm: realm, keys: keys}
+}
+
+// Authenticate implements the Authenticator interface.
+func (a APIKeyAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "ApiKey ") {
+		key = strings.TrimSpace(auth[len("ApiKey "):])
+	}
+	if len(key) < 1 {
+		return Claims{}, ErrNoCredentials
+	}
+	c, ok := a.keys.ClaimsForAPIKey(key)
+	if !ok {
+		return c, errors.New("unknown api key")
+	}
+	c.apiKey = true
+	return issuedNow(c), nil
+}
+
+// Challenge implements the Authenticator interface.
+func (a APIKeyAuthenticator) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf("ApiKey realm=%q", a.realm))
+}
+
+// ===== ROLE DIRECTORY =======================================================
+
+// RoleDirectory knows the roles users have now, so credentials issued to them
+// earlier, e.g. API keys, follow changes of their roles. Implementations are
+// the credential store, SingleUser and the users of the identity provider.
+type RoleDirectory interface {
+	// RolesOf returns the roles of the user, false for unknown or disabled
+	// users.
+	RolesOf(subject string) ([]string, bool)
+}
+
+// RoleDirectories asks each directory in turn.
+type RoleDirectories []RoleDirectory
+
+// RolesOf implements the RoleDirectory interface.
+func (ds RoleDirectories) RolesOf(subject string) ([]string, bool) {
+	for _, d := range ds {
+		if roles, ok := d.RolesOf(subject); ok {
+			return roles, true
+		}
+	}
+	return nil, false
+}
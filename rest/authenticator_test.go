@@ -0,0 +1,162 @@
+package rest_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/identityprovider/stub/stubtest"
+	"github.com/tullo/invoice-mvp/rest"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+func TestAuthChain(t *testing.T) {
+	sum := sha256.Sum256([]byte("s3cr3t-api-key"))
+	var bot rest.Claims
+	bot.Subject = "integration-bot"
+	bot.Roles = []string{rest.RoleUser}
+	keys := rest.APIKeys{hex.EncodeToString(sum[:]): bot}
+
+	cfg := config.Default().Auth
+	cfg.Schemes = []string{"basic", "apikey"}
+	cfg.User = config.User{Username: "go", Password: "time", ID: "f8c39a31-9ced-4761-8a33-b9c628a67510"}
+	chain, err := rest.NewAuthChain(cfg, nil, nil, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(r *http.Request)
+		status  int
+		subject string
+	}{
+		{"no credentials", func(r *http.Request) {}, http.StatusUnauthorized, ""},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("go", "time") }, http.StatusOK, "f8c39a31-9ced-4761-8a33-b9c628a67510"},
+		{"basic wrong password", func(r *http.Request) { r.SetBasicAuth("go", "tim") }, http.StatusUnauthorized, ""},
+		{"api key header", func(r *http.Request) { r.Header.Set(rest.APIKeyHeader, "s3cr3t-api-key") }, http.StatusOK, "integration-bot"},
+		{"api key authorization", func(r *http.Request) { r.Header.Set("Authorization", "ApiKey s3cr3t-api-key") }, http.StatusOK, "integration-bot"},
+		{"unknown api key", func(r *http.Request) { r.Header.Set(rest.APIKeyHeader, "guess") }, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subject string
+			h := chain.Authenticate(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				subject = ctx.Value(rest.Key).(rest.Claims).Subject
+			})
+
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/activities", nil)
+			tt.prepare(req)
+			h(req.Context(), res, req)
+
+			assert.Equal(t, tt.status, res.Result().StatusCode)
+			assert.Equal(t, tt.subject, subject)
+			if tt.status == http.StatusUnauthorized {
+				assert.Len(t, res.Result().Header["Www-Authenticate"], len(chain))
+			}
+		})
+	}
+}
+
+// staleAuthenticator rejects requests with its header as stale. Slices are
+// not comparable.
+type staleAuthenticator []string
+
+func (a staleAuthenticator) Authenticate(r *http.Request) (rest.Claims, error) {
+	if len(r.Header.Get(a[0])) < 1 {
+		return rest.Claims{}, rest.ErrNoCredentials
+	}
+	return rest.Claims{}, rest.ErrStaleNonce
+}
+
+func (a staleAuthenticator) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", a[0])
+}
+
+func (a staleAuthenticator) ChallengeStale(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", a[0]+" stale=true")
+}
+
+func TestAuthChainStale(t *testing.T) {
+	chain := rest.AuthChain{staleAuthenticator{"First"}, staleAuthenticator{"Second"}}
+	h := chain.Authenticate(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {})
+
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/activities", nil)
+	req.Header.Set("Second", "nonce")
+	h(req.Context(), res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, []string{"First", "Second stale=true"}, res.Header()["Www-Authenticate"])
+}
+
+func TestNewAuthChain(t *testing.T) {
+	schemes := func(s ...string) config.Auth {
+		cfg := config.Default().Auth
+		cfg.Schemes = s
+		return cfg
+	}
+	kf := rest.KeyFunc([]string{"RS256"}, nil, nil)
+	_, err := rest.NewAuthChain(schemes("jwt", "kerberos"), kf, nil, nil)
+	assert.Error(t, err)
+	_, err = rest.NewAuthChain(schemes("jwt"), nil, nil, nil)
+	assert.Error(t, err, "jwt without key func")
+	_, err = rest.NewAuthChain(schemes("apikey"), nil, nil, nil)
+	assert.Error(t, err)
+	_, err = rest.NewAuthChain(schemes(), nil, nil, nil)
+	assert.Error(t, err)
+	c, err := rest.NewAuthChain(schemes("jwt", "digest"), kf, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, c, 2)
+}
+
+func TestPersonalAPIKeysFollowOwnerRoles(t *testing.T) {
+	idp := stubtest.Start(t)
+	owner := idp.User
+	owner.Roles = []string{rest.RoleAdmin, rest.RoleUser}
+	idp.AddUser(owner)
+
+	r := database.NewFakeRepository()
+	create := func(uid string) string {
+		k := domain.APIKey{Name: "ci", UserID: uid, Roles: []string{rest.RoleAdmin, rest.RoleUser}, Scopes: []string{"*"}}
+		_, secret, err := usecase.NewCreateAPIKey(r).Run(k)
+		require.NoError(t, err)
+		return secret
+	}
+	secret := create(owner.ID)
+	// The roles are asked for on use, nothing is remembered from logins.
+	owners := idp.Provider().Users(idp.APIKey, idp.Client.ID)
+	keys := rest.NewPersonalAPIKeys(usecase.NewAuthenticateAPIKey(r), owners)
+
+	c, ok := keys.ClaimsForAPIKey(secret)
+	assert.True(t, ok)
+	assert.Equal(t, []string{rest.RoleAdmin, rest.RoleUser}, c.Roles)
+
+	// The owner lost a role.
+	owner.Roles = []string{rest.RoleUser}
+	idp.AddUser(owner)
+	c, _ = keys.ClaimsForAPIKey(secret)
+	assert.Equal(t, []string{rest.RoleUser}, c.Roles)
+
+	// Roles granted later are not passed on.
+	owner.Roles = []string{rest.RoleAdmin, rest.RoleUser, "ACCOUNTANT"}
+	idp.AddUser(owner)
+	c, _ = keys.ClaimsForAPIKey(secret)
+	assert.Equal(t, []string{rest.RoleAdmin, rest.RoleUser}, c.Roles)
+
+	// Keys of owners unknown to the identity provider are rejected, as
+	// are all keys if it can not be asked.
+	_, ok = keys.ClaimsForAPIKey(create("d68df2ec-d79a-4fbd-b290-22ae3a91532b"))
+	assert.False(t, ok)
+	denied := rest.NewPersonalAPIKeys(usecase.NewAuthenticateAPIKey(r), idp.Provider().Users("guess", idp.Client.ID))
+	_, ok = denied.ClaimsForAPIKey(secret)
+	assert.False(t, ok)
+}
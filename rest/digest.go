@@ -0,0 +1,262 @@
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/digest"
+)
+
+// ===== DIGEST AUTH (RFC 7616) ===============================================
+
+// Supported digest algorithms, in order of preference.
+const (
+	AlgSHA256     = digest.SHA256
+	AlgSHA256Sess = digest.SHA256Sess
+	AlgMD5        = digest.MD5
+	AlgMD5Sess    = digest.MD5Sess
+)
+
+// DefaultNonceTTL is the lifetime of a server nonce.
+const DefaultNonceTTL = 5 * time.Minute
+
+// ErrStaleNonce is returned when the digest response was computed correctly
+// but with an expired nonce. The client should retry with a fresh nonce
+// without prompting the user again.
+var ErrStaleNonce = errors.New("stale nonce")
+
+// DigestCredentials provides precomputed HA1 values, i.e. the digest of
+// "username:realm:password", for the base algorithms MD5 and SHA-256.
+type DigestCredentials interface {
+	HA1(username, realm, algorithm string) (string, Claims, bool)
+}
+
+// HA1 implements the DigestCredentials interface.
+func (u SingleUser) HA1(username, realm, algorithm string) (string, Claims, bool) {
+	if len(u.Username) < 1 || len(u.Password) < 1 || subtle.ConstantTimeCompare([]byte(username), []byte(u.Username)) != 1 {
+		return "", Claims{}, false
+	}
+	return DigestHash(algorithm, username+":"+realm+":"+u.Password), u.claims(), true
+}
+
+// DigestHash returns the hex encoded digest of s for the algorithm.
+func DigestHash(algorithm, s string) string {
+	return digest.Hash(algorithm, s)
+}
+
+// DigestAuth decorator authenticates the single user of the configuration.
+// Nonces are valid for the decorated route only; share a
+// DigestAuthenticator, e.g. in an AuthChain, to accept them on all routes.
+func DigestAuth(cfg config.Auth, next Handler) Handler {
+	return AuthChain{NewDigestAuthenticator(cfg.Realm, SingleUser(cfg.User))}.Authenticate(next)
+}
+
+// DigestAuthenticator implements the "Digest" HTTP authentication scheme
+// with server generated, time limited nonces and nonce count tracking to
+// reject replayed requests.
+type DigestAuthenticator struct {
+	realm  string
+	users  DigestCredentials
+	ttl    time.Duration
+	key    []byte // signs nonces
+	opaque string
+	now    func() time.Time
+
+	mu     sync.Mutex
+	counts map[string]uint64 // highest nonce count seen per nonce
+}
+
+// NewDigestAuthenticator instantiates a digest authenticator.
+func NewDigestAuthenticator(realm string, users DigestCredentials) *DigestAuthenticator {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	o := make([]byte, 16)
+	if _, err := rand.Read(o); err != nil {
+		panic(err)
+	}
+	return &DigestAuthenticator{
+		realm:  realm,
+		users:  users,
+		ttl:    DefaultNonceTTL,
+		key:    key,
+		opaque: hex.EncodeToString(o),
+		now:    time.Now,
+		counts: make(map[string]uint64),
+	}
+}
+
+// SetNonceTTL changes how long issued nonces are accepted before they are
+// reported stale. It must be called before the authenticator is in use.
+func (a *DigestAuthenticator) SetNonceTTL(d time.Duration) {
+	a.ttl = d
+}
+
+// Authenticate implements the Authenticator interface.
+func (a *DigestAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	var c Claims
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, digest.Scheme+" ") {
+		return c, ErrNoCredentials
+	}
+	m, err := digest.ParseParams(auth[len(digest.Scheme)+1:])
+	if err != nil {
+		return c, errors.Wrap(err, "parsing digest credentials")
+	}
+	for _, p := range []string{"username", "realm", "nonce", "uri", "response", "qop", "nc", "cnonce"} {
+		if len(m[p]) < 1 {
+			return c, errors.Errorf("digest parameter %q missing", p)
+		}
+	}
+	if m["realm"] != a.realm {
+		return c, errors.Errorf("unexpected realm %q", m["realm"])
+	}
+	if o, ok := m["opaque"]; ok && o != a.opaque {
+		return c, errors.New("opaque value mismatch")
+	}
+	if m["qop"] != "auth" {
+		return c, errors.Errorf("unsupported qop %q", m["qop"])
+	}
+	if m["uri"] != r.URL.RequestURI() {
+		return c, errors.Errorf("digest uri %q does not match request uri", m["uri"])
+	}
+	alg := m["algorithm"]
+	switch alg {
+	case "":
+		alg = AlgMD5
+	case AlgMD5, AlgMD5Sess, AlgSHA256, AlgSHA256Sess:
+	default:
+		return c, errors.Errorf("unsupported algorithm %q", alg)
+	}
+	nc, err := strconv.ParseUint(m["nc"], 16, 32)
+	if err != nil || len(m["nc"]) != 8 {
+		return c, errors.Errorf("malformed nonce count %q", m["nc"])
+	}
+	issued, err := a.verifyNonce(m["nonce"])
+	if err != nil {
+		return c, err
+	}
+
+	addr := clientAddr(r)
+	o, observed := a.users.(LoginObserver)
+	if observed && o.LockedOut(m["username"], addr) {
+		return c, errors.Errorf("user %q locked out for %s", m["username"], addr)
+	}
+	ha1, claims, ok := a.users.HA1(m["username"], a.realm, alg)
+	if !ok {
+		return c, errors.Errorf("unknown user %q", m["username"])
+	}
+	if strings.HasSuffix(alg, "-sess") {
+		ha1 = DigestHash(alg, fmt.Sprintf("%s:%s:%s", ha1, m["nonce"], m["cnonce"]))
+	}
+	ha2 := DigestHash(alg, fmt.Sprintf("%s:%s", r.Method, m["uri"]))
+	want := DigestHash(alg, fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, m["nonce"], m["nc"], m["cnonce"], m["qop"], ha2))
+	// client and server response hashes must match
+	if subtle.ConstantTimeCompare([]byte(want), []byte(m["response"])) != 1 {
+		if observed {
+			o.LoginFailed(m["username"], addr)
+		}
+		return c, errors.Errorf("digest response mismatch for user %q", m["username"])
+	}
+	if observed {
+		o.LoginSucceeded(m["username"], addr)
+	}
+
+	if a.now().Sub(issued) > a.ttl {
+		return c, ErrStaleNonce
+	}
+	if !a.countNonce(m["nonce"], nc) {
+		return c, errors.Errorf("replayed nonce count %s", m["nc"])
+	}
+	return issuedNow(claims), nil
+}
+
+// Challenge implements the Authenticator interface. Challenges are listed
+// in order of preference.
+func (a *DigestAuthenticator) Challenge(w http.ResponseWriter) {
+	a.challenge(w, false)
+}
+
+// ChallengeStale implements the StaleChallenger interface.
+func (a *DigestAuthenticator) ChallengeStale(w http.ResponseWriter) {
+	a.challenge(w, true)
+}
+
+func (a *DigestAuthenticator) challenge(w http.ResponseWriter, stale bool) {
+	nonce := a.newNonce()
+	for _, alg := range []string{AlgSHA256, AlgMD5} {
+		v := fmt.Sprintf(`Digest realm=%q, qop="auth", algorithm=%s, nonce=%q, opaque=%q`, a.realm, alg, nonce, a.opaque)
+		if stale {
+			v += ", stale=true"
+		}
+		w.Header().Add("WWW-Authenticate", v)
+	}
+}
+
+// newNonce creates a nonce of the form base64(timestamp | random | mac).
+func (a *DigestAuthenticator) newNonce() string {
+	b := make([]byte, 16, 32)
+	binary.BigEndian.PutUint64(b, uint64(a.now().UnixNano()))
+	if _, err := rand.Read(b[8:16]); err != nil {
+		panic(err)
+	}
+	b = append(b, a.mac(b)...)
+	a.prune()
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// verifyNonce checks the nonce was issued by this server and returns the
+// time it was issued.
+func (a *DigestAuthenticator) verifyNonce(nonce string) (time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 32 {
+		return time.Time{}, errors.New("malformed nonce")
+	}
+	if !hmac.Equal(b[16:], a.mac(b[:16])) {
+		return time.Time{}, errors.New("nonce not issued by this server")
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))), nil
+}
+
+func (a *DigestAuthenticator) mac(b []byte) []byte {
+	h := hmac.New(sha256.New, a.key)
+	h.Write(b)
+	return h.Sum(nil)[:16]
+}
+
+// countNonce records the nonce count, which must increase with every
+// request using the same nonce.
+func (a *DigestAuthenticator) countNonce(nonce string, nc uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if nc <= a.counts[nonce] {
+		return false
+	}
+	a.counts[nonce] = nc
+	return true
+}
+
+// prune forgets the nonce counts of expired nonces.
+func (a *DigestAuthenticator) prune() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for n := range a.counts {
+		if issued, err := a.verifyNonce(n); err != nil || a.now().Sub(issued) > a.ttl {
+			delete(a.counts, n)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package rest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+const digestRealm = "invoice.mvp"
+
+var nonceRegex = regexp.MustCompile(`nonce="([^"]+)"`)
+
+// digestAuthorization computes the Authorization header a client sends.
+func digestAuthorization(alg, user, pass, method, uri, nonce, nc string) string {
+	cnonce := "0a4f113b"
+	ha1 := rest.DigestHash(alg, fmt.Sprintf("%s:%s:%s", user, digestRealm, pass))
+	if strings.HasSuffix(alg, "-sess") {
+		ha1 = rest.DigestHash(alg, fmt.Sprintf("%s:%s:%s", ha1, nonce, cnonce))
+	}
+	ha2 := rest.DigestHash(alg, fmt.Sprintf("%s:%s", method, uri))
+	res := rest.DigestHash(alg, fmt.Sprintf("%s:%s:%s:%s:auth:%s", ha1, nonce, nc, cnonce, ha2))
+	format := `Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, cnonce=%q, nc=%s, qop=auth, response=%q`
+	return fmt.Sprintf(format, user, digestRealm, nonce, uri, alg, cnonce, nc, res)
+}
+
+func serveDigest(a *rest.DigestAuthenticator, authorization string) *http.Response {
+	h := rest.AuthChain{a}.Authenticate(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/customers", nil)
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	h(req.Context(), res, req)
+	return res.Result()
+}
+
+func challengeNonce(t *testing.T, res *http.Response) string {
+	t.Helper()
+	cs := res.Header["Www-Authenticate"]
+	if len(cs) != 2 {
+		t.Fatalf("expected SHA-256 and MD5 challenges, got %v", cs)
+	}
+	assert.Contains(t, cs[0], "algorithm=SHA-256")
+	assert.Contains(t, cs[1], "algorithm=MD5")
+	m := nonceRegex.FindStringSubmatch(cs[0])
+	if m == nil {
+		t.Fatalf("nonce missing in challenge %q", cs[0])
+	}
+	return m[1]
+}
+
+func TestDigestAuth(t *testing.T) {
+	user, pass := "doe, john", "time"
+
+	for _, alg := range []string{rest.AlgSHA256, rest.AlgSHA256Sess, rest.AlgMD5, rest.AlgMD5Sess} {
+		t.Run(alg, func(t *testing.T) {
+			a := rest.NewDigestAuthenticator(digestRealm, rest.SingleUser{Username: user, Password: pass})
+			res := serveDigest(a, "")
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+			nonce := challengeNonce(t, res)
+
+			res = serveDigest(a, digestAuthorization(alg, user, pass, "POST", "/customers", nonce, "00000001"))
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+			// Replayed nonce count.
+			res = serveDigest(a, digestAuthorization(alg, user, pass, "POST", "/customers", nonce, "00000001"))
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+			res = serveDigest(a, digestAuthorization(alg, user, pass, "POST", "/customers", nonce, "00000002"))
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+		})
+	}
+}
+
+func TestDigestAuthRejects(t *testing.T) {
+	user, pass := "go", "time"
+	a := rest.NewDigestAuthenticator(digestRealm, rest.SingleUser{Username: user, Password: pass})
+	nonce := challengeNonce(t, serveDigest(a, ""))
+
+	tests := []struct {
+		name string
+		auth string
+	}{
+		{"wrong password", digestAuthorization(rest.AlgSHA256, user, "tim", "POST", "/customers", nonce, "00000001")},
+		{"wrong uri", digestAuthorization(rest.AlgSHA256, user, pass, "POST", "/activities", nonce, "00000001")},
+		{"forged nonce", digestAuthorization(rest.AlgSHA256, user, pass, "POST", "/customers", "UAZs1dp3wX5BtXEpoCXKO2lHhap564rX", "00000001")},
+		{"malformed nc", digestAuthorization(rest.AlgSHA256, user, pass, "POST", "/customers", nonce, "zz")},
+		{"unterminated quote", `Digest username="go, realm="invoice.mvp"`},
+		{"unknown user", digestAuthorization(rest.AlgSHA256, "root", pass, "POST", "/customers", nonce, "00000001")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serveDigest(a, tt.auth)
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		})
+	}
+}
+
+func TestDigestAuthStaleNonce(t *testing.T) {
+	user, pass := "go", "time"
+	a := rest.NewDigestAuthenticator(digestRealm, rest.SingleUser{Username: user, Password: pass})
+	nonce := challengeNonce(t, serveDigest(a, ""))
+	a.SetNonceTTL(-time.Second)
+
+	res := serveDigest(a, digestAuthorization(rest.AlgSHA256, user, pass, "POST", "/customers", nonce, "00000001"))
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	for _, c := range res.Header["Www-Authenticate"] {
+		assert.Contains(t, c, "stale=true")
+	}
+}
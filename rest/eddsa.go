@@ -0,0 +1,57 @@
+package rest
+
+import (
+	"crypto"
+	"crypto/ed25519"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method (RFC 8037) for
+// Ed25519 keys, which jwt-go does not provide.
+type SigningMethodEdDSA struct{}
+
+// EdDSA is the registered EdDSA signing method.
+var EdDSA = &SigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(EdDSA.Alg(), func() jwt.SigningMethod {
+		return EdDSA
+	})
+}
+
+// Alg implements the jwt.SigningMethod interface.
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify implements the jwt.SigningMethod interface. The key must be an
+// ed25519.PublicKey.
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok || len(pub) != ed25519.PublicKeySize {
+		return jwt.NewInvalidKeyTypeError("ed25519.PublicKey", key)
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return new(jwt.InvalidSignatureError)
+	}
+	return nil
+}
+
+// Sign implements the jwt.SigningMethod interface. The key must be an
+// ed25519.PrivateKey.
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.NewInvalidKeyTypeError("ed25519.PrivateKey", key)
+	}
+	sig, err := priv.Sign(nil, []byte(signingString), crypto.Hash(0))
+	if err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(sig), nil
+}
@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/einvoice"
+)
+
+// Media types of electronic invoices. The parameter "profile=xrechnung"
+// asks for invoices conforming to XRechnung rather than to EN 16931.
+const (
+	UBLMediaType = "application/ubl+xml"
+	CIIMediaType = "application/cii+xml"
+)
+
+// EInvoicePresenter presents electronic invoices as UBL or CII.
+type EInvoicePresenter struct {
+	writer  http.ResponseWriter
+	name    string // of the file, without extension
+	syntax  einvoice.Syntax
+	profile einvoice.Profile
+}
+
+// NewEInvoicePresenter instantiates a presenter of electronic invoices of
+// the syntax and profile, written to a file of the name.
+func NewEInvoicePresenter(w http.ResponseWriter, name string, s einvoice.Syntax, p einvoice.Profile) EInvoicePresenter {
+	return EInvoicePresenter{writer: w, name: name, syntax: s, profile: p}
+}
+
+// eInvoicePresenter returns the presenter of electronic invoices of the
+// media range.
+func eInvoicePresenter(w http.ResponseWriter, name string, mr mediaRange) EInvoicePresenter {
+	s := einvoice.UBL
+	if mr.mediaType == CIIMediaType {
+		s = einvoice.CII
+	}
+	p := einvoice.EN16931
+	if mr.params["profile"] == "xrechnung" {
+		p = einvoice.XRechnung
+	}
+	return NewEInvoicePresenter(w, name, s, p)
+}
+
+// Present knows how to present an electronic invoice. Invoices violating
+// business rules of the profile are answered with the violated rules.
+func (p EInvoicePresenter) Present(i interface{}) {
+	b, err := einvoice.Marshal(i.(einvoice.Invoice), p.syntax, p.profile)
+	var vs einvoice.Violations
+	switch {
+	case errors.As(err, &vs):
+		writeProblem(p.writer, Problem{
+			Title:      "Invalid electronic invoice",
+			Status:     http.StatusUnprocessableEntity,
+			Detail:     fmt.Sprintf("the invoice violates %d business rules, complete the profile and customer", len(vs)),
+			Violations: vs,
+		})
+		return
+	case err != nil:
+		p.writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	mt := UBLMediaType
+	if p.syntax == einvoice.CII {
+		mt = CIIMediaType
+	}
+	if p.profile == einvoice.XRechnung {
+		mt += "; profile=xrechnung"
+	}
+	p.writer.Header().Set("Content-Type", mt)
+	p.writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.name+".xml"))
+	_, _ = p.writer.Write(b)
+}
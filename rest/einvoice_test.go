@@ -0,0 +1,126 @@
+package rest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/einvoice"
+	"github.com/tullo/invoice-mvp/rest"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+// eInvoicer serves the invoices, customer and profile of the user "go",
+// invoice 1 charged and invoice 2 open.
+func eInvoicer(t *testing.T) (http.Handler, *database.FakeRepository) {
+	t.Helper()
+	r := database.NewFakeRepository()
+	cu, _ := r.CreateCustomer(domain.Customer{Name: "3skills", UserID: "go"})
+	p, _ := r.CreateProject(domain.Project{Name: "Instanfoo.com", CustomerID: cu.ID})
+	oct, _ := r.CreateInvoice(domain.Invoice{CustomerID: cu.ID, Month: 10, Year: 2020})
+	_, _ = r.CreateInvoice(domain.Invoice{CustomerID: cu.ID, Month: 11, Year: 2020})
+	oct.AddPosition(p.ID, "Programming", 8, 60)
+	oct.Status = "payment expected"
+	require.NoError(t, r.UpdateInvoice(oct))
+
+	ad := rest.NewAdapter()
+	as := func(h rest.Handler) rest.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+			var c rest.Claims
+			c.Subject = "go"
+			h(context.WithValue(ctx, rest.Key, c), w, req)
+		}
+	}
+	ad.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", as(ad.GetInvoiceHandler(usecase.NewGetInvoice(r))))
+	ad.Handle("/customers/{customerId:[0-9]+}", as(ad.UpdateCustomerHandler(usecase.NewUpdateCustomer(r)))).Methods("PUT")
+	ad.Handle("/profile", as(ad.ProfileHandler(usecase.NewGetProfile(r)))).Methods("GET")
+	ad.Handle("/profile", as(ad.SaveProfileHandler(usecase.NewSaveProfile(r)))).Methods("PUT")
+	return ad.R, r
+}
+
+func put(h http.Handler, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PUT", target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestMasterData(t *testing.T) {
+	h, r := eInvoicer(t)
+	res := get(h, "/profile", "application/json")
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"userId":"go","name":"","address":{},"vatRate":0,"paymentTermDays":0}`, res.Body.String())
+
+	res = put(h, "/profile", `{"name":"Tullo","address":{"countryCode":"de"}}`)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Contains(t, res.Body.String(), "country code must be two upper case letters")
+	res = put(h, "/profile", `{"userId":"mallory","name":"Tullo","address":{"city":"Hamburg","countryCode":"DE"},"vatRate":19}`)
+	require.Equal(t, http.StatusNoContent, res.Code)
+	assert.Equal(t, "Hamburg", r.Profile("go").Address.City, "saved for the current user")
+	assert.Empty(t, r.Profile("mallory").Name)
+
+	res = put(h, "/customers/1", `{"name":"3skills GmbH","address":{"city":"Kiel","countryCode":"DE"},"buyerReference":"04011000-12345-34"}`)
+	require.Equal(t, http.StatusOK, res.Code)
+	var c domain.Customer
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &c))
+	assert.Equal(t, domain.Customer{ID: 1, Name: "3skills GmbH", UserID: "go", Address: &domain.Address{City: "Kiel", CountryCode: "DE"}, BuyerReference: "04011000-12345-34"}, c)
+	assert.Equal(t, http.StatusNotFound, put(h, "/customers/2", `{"name":"nobody"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put(h, "/customers/1", `{"name":`).Code)
+}
+
+func TestEInvoice(t *testing.T) {
+	h, r := eInvoicer(t)
+	res := get(h, "/customers/1/invoices/1", rest.UBLMediaType)
+	require.Equal(t, http.StatusUnprocessableEntity, res.Code, "without master data")
+	assert.Equal(t, "application/problem+json", res.Header().Get("Content-Type"))
+	var p rest.Problem
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &p))
+	assert.Contains(t, p.Violations, einvoice.Violation{Rule: "BR-06", Message: "seller name missing"})
+
+	require.NoError(t, r.SaveProfile(domain.Profile{
+		UserID:          "go",
+		Name:            "Tullo & Partner",
+		Address:         domain.Address{City: "Hamburg", PostCode: "20095", CountryCode: "DE"},
+		VATID:           "DE123456789",
+		ContactName:     "Andreas Tullo",
+		Phone:           "+49 40 123456",
+		Email:           "at@tullo.example",
+		IBAN:            "DE02120300000000202051",
+		VATRate:         19,
+		PaymentTermDays: 14,
+	}))
+	c := r.CustomerByID(1)
+	c.Address = &domain.Address{City: "Kiel", PostCode: "24103", CountryCode: "DE"}
+	c.Email = "billing@3skills.example"
+	require.NoError(t, r.UpdateCustomer(c))
+
+	res = get(h, "/customers/1/invoices/1", "text/html, application/ubl+xml")
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, rest.UBLMediaType, res.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="invoice-1.xml"`, res.Header().Get("Content-Disposition"))
+	assert.Contains(t, res.Body.String(), "<cbc:CustomizationID>urn:cen.eu:en16931:2017</cbc:CustomizationID>")
+	assert.Contains(t, res.Body.String(), `<cbc:PayableAmount currencyID="EUR">571.20</cbc:PayableAmount>`)
+
+	res = get(h, "/customers/1/invoices/1", "application/cii+xml; profile=xrechnung")
+	require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	assert.Contains(t, res.Body.String(), `{"rule":"BR-DE-15","message":"buyer reference missing"}`)
+
+	c.BuyerReference = "04011000-12345-34"
+	require.NoError(t, r.UpdateCustomer(c))
+	res = get(h, "/customers/1/invoices/1", "application/cii+xml; profile=xrechnung")
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/cii+xml; profile=xrechnung", res.Header().Get("Content-Type"))
+	assert.Contains(t, res.Body.String(), "<ram:ID>"+string(einvoice.XRechnung)+"</ram:ID>")
+	assert.Contains(t, res.Body.String(), "<ram:BuyerReference>04011000-12345-34</ram:BuyerReference>")
+
+	res = get(h, "/customers/1/invoices/2", rest.CIIMediaType)
+	assert.Equal(t, http.StatusConflict, res.Code, "open invoice")
+}
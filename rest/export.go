@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tullo/invoice-mvp/usecase"
+	"github.com/tullo/invoice-mvp/xlsx"
+)
+
+// exportColumns are the columns of CSV exports. Bookings and positions are
+// rows of the same table, told apart by the first column.
+var exportColumns = []string{"type", "period", "date", "customer", "invoice", "project", "activity", "hours", "price", "description"}
+
+// CSVPresenter presents the bookings and positions of an export as CSV.
+type CSVPresenter struct {
+	writer http.ResponseWriter
+	name   string // of the file, without extension
+}
+
+// NewCSVPresenter instantiates a CSV presenter of the file name.
+func NewCSVPresenter(w http.ResponseWriter, name string) CSVPresenter {
+	return CSVPresenter{writer: w, name: name}
+}
+
+// XLSXPresenter presents the bookings and positions of an export as XLSX
+// workbook with a sheet of each.
+type XLSXPresenter struct {
+	writer http.ResponseWriter
+	name   string // of the file, without extension
+}
+
+// NewXLSXPresenter instantiates a XLSX presenter of the file name.
+func NewXLSXPresenter(w http.ResponseWriter, name string) XLSXPresenter {
+	return XLSXPresenter{writer: w, name: name}
+}
+
+// Present knows how to present an export as CSV.
+func (p CSVPresenter) Present(i interface{}) {
+	e := i.(usecase.Export)
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	_ = cw.Write(exportColumns)
+	for _, bk := range e.Bookings {
+		_ = cw.Write([]string{
+			"booking",
+			bk.Date.Format("2006-01"),
+			bk.Date.Format("2006-01-02"),
+			csvText(bk.Customer),
+			strconv.Itoa(bk.InvoiceID),
+			csvText(bk.Project),
+			csvText(bk.Activity),
+			formatFloat(bk.Hours),
+			"",
+			csvText(bk.Description),
+		})
+	}
+	for _, pos := range e.Positions {
+		_ = cw.Write([]string{
+			"position",
+			pos.Period.Format("2006-01"),
+			"",
+			csvText(pos.Customer),
+			strconv.Itoa(pos.InvoiceID),
+			csvText(pos.Project),
+			csvText(pos.Activity),
+			formatFloat(pos.Hours),
+			formatFloat(pos.Price),
+			"",
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		p.writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	p.writer.Header().Set("Content-Type", "text/csv; charset=utf-8; header=present")
+	p.writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.name+".csv"))
+	_, _ = p.writer.Write(b.Bytes())
+}
+
+// csvText escapes text a spreadsheet would take for a formula, e.g.
+// "=HYPERLINK(...)", by prefixing it with an apostrophe.
+func csvText(s string) string {
+	if len(s) > 0 && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// Present knows how to present an export as XLSX workbook.
+func (p XLSXPresenter) Present(i interface{}) {
+	e := i.(usecase.Export)
+	wb := xlsx.New()
+	s := wb.AddSheet("Bookings")
+	s.AddHeader("Date", "Customer", "Invoice", "Project", "Activity", "Hours", "Description")
+	for _, bk := range e.Bookings {
+		s.AddRow(bk.Date, bk.Customer, bk.InvoiceID, bk.Project, bk.Activity, bk.Hours, bk.Description)
+	}
+	s = wb.AddSheet("Positions")
+	s.AddHeader("Period", "Customer", "Invoice", "Project", "Activity", "Hours", "Price")
+	for _, pos := range e.Positions {
+		s.AddRow(xlsx.Month(pos.Period), pos.Customer, pos.InvoiceID, pos.Project, pos.Activity, pos.Hours, pos.Price)
+	}
+	var b bytes.Buffer
+	if err := wb.Write(&b); err != nil {
+		p.writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	p.writer.Header().Set("Content-Type", xlsx.ContentType)
+	p.writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", p.name+".xlsx"))
+	_, _ = p.writer.Write(b.Bytes())
+}
+
+// ExportPresenter returns a presenter of exports matching the 'Accept'
+// request header: JSON, CSV or XLSX.
+func (a Adapter) ExportPresenter(w http.ResponseWriter, r *http.Request, name string) (InvoicePresenter, bool) {
+	for _, mr := range accepted(r) {
+		switch mr.mediaType {
+		case "application/json":
+			return NewJSONInvoicePresenter(w), true
+		case "text/csv":
+			return NewCSVPresenter(w, name), true
+		case xlsx.ContentType:
+			return NewXLSXPresenter(w, name), true
+		}
+	}
+	return NewDefaultPresenter(), false
+}
+
+// ExportBookingsHandler returns a handler that knows how to export the
+// bookings and positions of the invoices of the current user of a period,
+// the months of the query parameters "from" through "to", e.g. "2020-11".
+// Both default to the current month.
+func (a Adapter) ExportBookingsHandler(uc usecase.ExportBookings) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		y, m, _ := time.Now().UTC().Date()
+		now := time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+		months := make(map[string]time.Time, 2)
+		for _, name := range []string{"from", "to"} {
+			s := r.URL.Query().Get(name)
+			if len(s) < 1 {
+				months[name] = now
+				continue
+			}
+			m, err := time.Parse("2006-01", s)
+			if err != nil {
+				writeProblem(w, Problem{Title: "Invalid period", Status: http.StatusBadRequest, Detail: fmt.Sprintf("%s must be a month like 2020-11", name)})
+				return
+			}
+			months[name] = m
+		}
+		from, to := months["from"], months["to"]
+		if to.Before(from) {
+			writeProblem(w, Problem{Title: "Invalid period", Status: http.StatusBadRequest, Detail: "from must not be after to"})
+			return
+		}
+		name := "bookings-" + from.Format("2006-01")
+		if to.Format("2006-01") != from.Format("2006-01") {
+			name += "-" + to.Format("2006-01")
+		}
+		p, ok := a.ExportPresenter(w, r, name)
+		if !ok {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		// Runs the usecase to export the bookings of the period.
+		p.Present(uc.Run(uid, from, to))
+	}
+}
+
+// formatFloat formats hours and prices as plain decimals.
+func formatFloat(f float32) string {
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
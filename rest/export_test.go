@@ -0,0 +1,121 @@
+package rest_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/rest"
+	"github.com/tullo/invoice-mvp/usecase"
+	"github.com/tullo/invoice-mvp/xlsx"
+)
+
+// exporter serves the invoices of the user "go", the one of October 2020
+// charged, and their export.
+func exporter(t *testing.T) http.Handler {
+	t.Helper()
+	r := database.NewFakeRepository()
+	cu, _ := r.CreateCustomer(domain.Customer{Name: "3skills", UserID: "go"})
+	p, _ := r.CreateProject(domain.Project{Name: "Instanfoo.com", CustomerID: cu.ID})
+	a, _ := r.CreateActivity(domain.Activity{Name: "Programming", UserID: "go"})
+	oct, _ := r.CreateInvoice(domain.Invoice{CustomerID: cu.ID, Month: 10, Year: 2020})
+	nov, _ := r.CreateInvoice(domain.Invoice{CustomerID: cu.ID, Month: 11, Year: 2020})
+	_, err := r.CreateBookings([]domain.Booking{
+		{Day: 30, Hours: 3.5, Description: "Front: bugfix #6789", InvoiceID: nov.ID, ProjectID: p.ID, ActivityID: a.ID},
+		{Day: 2, Hours: 8, Description: "Setup, \"first\" day", InvoiceID: oct.ID, ProjectID: p.ID, ActivityID: a.ID},
+	})
+	require.NoError(t, err)
+	oct.AddPosition(p.ID, a.Name, 8, 60)
+	oct.Status = "payment expected"
+	require.NoError(t, r.UpdateInvoice(oct))
+
+	ad := rest.NewAdapter()
+	as := func(h rest.Handler) rest.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+			var c rest.Claims
+			c.Subject = "go"
+			h(context.WithValue(ctx, rest.Key, c), w, req)
+		}
+	}
+	ad.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", as(ad.GetInvoiceHandler(usecase.NewGetInvoice(r))))
+	ad.Handle("/exports/bookings", as(ad.ExportBookingsHandler(usecase.NewExportBookings(r))))
+	return ad.R
+}
+
+func get(h http.Handler, target, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("Accept", accept)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestExportCSV(t *testing.T) {
+	h := exporter(t)
+	res := get(h, "/customers/1/invoices/1", "text/csv")
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "text/csv; charset=utf-8; header=present", res.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="invoice-1.csv"`, res.Header().Get("Content-Disposition"))
+	assert.Equal(t, "type,period,date,customer,invoice,project,activity,hours,price,description\n"+
+		"booking,2020-10,2020-10-02,3skills,1,Instanfoo.com,Programming,8,,\"Setup, \"\"first\"\" day\"\n"+
+		"position,2020-10,,3skills,1,Instanfoo.com,Programming,8,480,\n", res.Body.String())
+
+	res = get(h, "/exports/bookings?from=2020-10&to=2020-11", "application/xml;q=0.9, text/csv;q=0.8")
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `attachment; filename="bookings-2020-10-2020-11.csv"`, res.Header().Get("Content-Disposition"))
+	assert.Contains(t, res.Body.String(), "\nbooking,2020-11,2020-11-30,3skills,2,Instanfoo.com,Programming,3.5,,Front: bugfix #6789\n")
+
+	res = get(h, "/exports/bookings?from=2020-09&to=2020-09", "text/csv")
+	assert.Equal(t, "type,period,date,customer,invoice,project,activity,hours,price,description\n", res.Body.String())
+}
+
+func TestExportCSVFormulas(t *testing.T) {
+	res := httptest.NewRecorder()
+	rest.NewCSVPresenter(res, "bookings").Present(usecase.Export{Bookings: []usecase.ExportedBooking{
+		{Customer: "=cmd|' /C calc'!A0", Project: "+1", Activity: "@SUM(A1)", Hours: -1, Description: "-2+3"},
+		{Customer: "\t=1", Project: "\r=1", Activity: "Programming", Description: "a=b"},
+	}})
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "type,period,date,customer,invoice,project,activity,hours,price,description\n"+
+		"booking,0001-01,0001-01-01,'=cmd|' /C calc'!A0,0,'+1,'@SUM(A1),-1,,'-2+3\n"+
+		"booking,0001-01,0001-01-01,'\t=1,0,\"'\r=1\",Programming,0,,a=b\n", res.Body.String())
+}
+
+func TestExportXLSX(t *testing.T) {
+	h := exporter(t)
+	for _, target := range []string{"/customers/1/invoices/2", "/exports/bookings?from=2020-11&to=2020-11"} {
+		res := get(h, target, xlsx.ContentType)
+		require.Equal(t, http.StatusOK, res.Code, target)
+		assert.Equal(t, xlsx.ContentType, res.Header().Get("Content-Type"))
+		z, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+		require.NoError(t, err)
+		var names []string
+		for _, f := range z.File {
+			names = append(names, f.Name)
+		}
+		assert.Contains(t, names, "xl/worksheets/sheet1.xml")
+		assert.Contains(t, names, "xl/worksheets/sheet2.xml")
+	}
+}
+
+func TestExportNegotiation(t *testing.T) {
+	h := exporter(t)
+	assert.Equal(t, http.StatusNotAcceptable, get(h, "/customers/1/invoices/1", "image/png").Code)
+	assert.Equal(t, http.StatusNotAcceptable, get(h, "/exports/bookings", "application/pdf").Code)
+
+	res := get(h, "/customers/1/invoices/1", "application/json;q=0.8, text/csv")
+	assert.Equal(t, "application/json", res.Header().Get("Content-Type"), "first supported type")
+	res = get(h, "/exports/bookings?from=2020-10", "application/json")
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), `"positions":[{"period":"2020-10-01T00:00:00Z"`)
+
+	assert.Equal(t, http.StatusBadRequest, get(h, "/exports/bookings?from=10/2020", "text/csv").Code)
+	assert.Equal(t, http.StatusBadRequest, get(h, "/exports/bookings?from=2020-11&to=2020-10", "text/csv").Code)
+}
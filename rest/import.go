@@ -0,0 +1,351 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/einvoice"
+	"github.com/tullo/invoice-mvp/timesheet"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+// maxImportSize limits the size of imported timesheets.
+const maxImportSize = 5 << 20
+
+// importFields are the fields of imported bookings. The columns, or JSON
+// keys, of a field are named like the field unless mapped otherwise.
+var importFields = []string{"date", "project", "activity", "hours", "description"}
+
+// Problem is a problem details response (RFC 7807).
+type Problem struct {
+	Type   string `json:"type,omitempty"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors lists the invalid fields of an import.
+	Errors []usecase.RecordError `json:"errors,omitempty"`
+	// Violations lists the business rules an electronic invoice violates.
+	Violations einvoice.Violations `json:"violations,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, p Problem) {
+	bs, err := json.Marshal(p)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	_, _ = w.Write(bs)
+}
+
+// ImportBookingsHandler returns a handler that knows how to book the
+// records of a timesheet, sent as CSV with a header row or as JSON Lines.
+// The query parameter "mapping" names the columns of the fields, e.g.
+// "date:Datum,hours:Stunden", "dateFormat" is the Go layout of the dates
+// and "delimiter" the field delimiter of CSV. Invalid records are reported
+// with status 422 and nothing is booked.
+func (a Adapter) ImportBookingsHandler(uc usecase.ImportBookings) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		id, err := strconv.Atoi(mux.Vars(r)["invoiceId"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		columns, err := importMapping(q.Get("mapping"))
+		if err != nil {
+			writeProblem(w, Problem{Title: "Invalid mapping", Status: http.StatusBadRequest, Detail: err.Error()})
+			return
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxImportSize)
+		var rs []usecase.BookingRecord
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mt {
+		case "text/csv":
+			delim := ','
+			if d := q.Get("delimiter"); len(d) > 0 {
+				if d == `\t` {
+					d = "\t"
+				}
+				if utf8.RuneCountInString(d) != 1 {
+					writeProblem(w, Problem{Title: "Invalid delimiter", Status: http.StatusBadRequest, Detail: "the delimiter must be one character"})
+					return
+				}
+				delim, _ = utf8.DecodeRuneInString(d)
+			}
+			rs, err = readCSVRecords(body, delim, columns)
+		case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+			rs, err = readJSONRecords(body, columns)
+		default:
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var ie usecase.ImportError
+		var me *http.MaxBytesError
+		switch {
+		case errors.As(err, &ie):
+			invalidBookings(w, ie)
+			return
+		case errors.As(err, &me):
+			writeProblem(w, Problem{Title: "Timesheet too large", Status: http.StatusRequestEntityTooLarge, Detail: err.Error()})
+			return
+		case err != nil:
+			writeProblem(w, Problem{Title: "Malformed timesheet", Status: http.StatusBadRequest, Detail: err.Error()})
+			return
+		}
+
+		// Runs the usecase to import the bookings.
+		created, err := uc.Run(uid, id, q.Get("dateFormat"), rs)
+		switch {
+		case errors.As(err, &ie):
+			invalidBookings(w, ie)
+			return
+		case errors.Is(err, usecase.ErrInvoiceNotOpen):
+			writeProblem(w, Problem{Title: "Invoice not open", Status: http.StatusConflict, Detail: err.Error()})
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		bs, err := json.Marshal(created)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(bs)
+	}
+}
+
+// ImportTimesheetHandler returns a handler that knows how to book the
+// entries of a CSV export of the time tracking tool named by the route
+// variable "format", e.g. "toggl". The query parameter "create=true" creates
+// missing customers, projects, activities and invoices, "dryRun=true" only
+// reports what the import would create and "activity" names the activity of
+// entries without task.
+func (a Adapter) ImportTimesheetHandler(uc usecase.ImportTimesheet) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		uid := a.currentUser(ctx)
+		if len(uid) < 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f, ok := timesheet.Lookup(mux.Vars(r)["format"])
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "text/csv" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		q := r.URL.Query()
+		o := usecase.TimesheetOptions{Activity: strings.TrimSpace(q.Get("activity"))}
+		for name, v := range map[string]*bool{"create": &o.Create, "dryRun": &o.DryRun} {
+			if s := q.Get(name); len(s) > 0 {
+				b, err := strconv.ParseBool(s)
+				if err != nil {
+					writeProblem(w, Problem{Title: "Invalid parameter", Status: http.StatusBadRequest, Detail: name + " must be true or false"})
+					return
+				}
+				*v = b
+			}
+		}
+
+		es, err := f.Parse(http.MaxBytesReader(w, r.Body, maxImportSize))
+		var te timesheet.Errors
+		var me *http.MaxBytesError
+		switch {
+		case errors.As(err, &te):
+			ie := make(usecase.ImportError, 0, len(te))
+			for _, fe := range te {
+				ie = append(ie, usecase.RecordError{Line: fe.Line, Field: fe.Field, Message: fe.Message})
+			}
+			invalidBookings(w, ie)
+			return
+		case errors.As(err, &me):
+			writeProblem(w, Problem{Title: "Timesheet too large", Status: http.StatusRequestEntityTooLarge, Detail: err.Error()})
+			return
+		case err != nil:
+			writeProblem(w, Problem{Title: "Malformed timesheet", Status: http.StatusBadRequest, Detail: err.Error()})
+			return
+		}
+
+		// Runs the usecase to import the timesheet.
+		plan, err := uc.Run(uid, es, o)
+		var ie usecase.ImportError
+		switch {
+		case errors.As(err, &ie):
+			invalidBookings(w, ie)
+			return
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		bs, err := json.Marshal(plan)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if o.DryRun {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_, _ = w.Write(bs)
+	}
+}
+
+// invalidBookings reports the invalid fields of an import.
+func invalidBookings(w http.ResponseWriter, ie usecase.ImportError) {
+	writeProblem(w, Problem{
+		Type:   "/problems/invalid-bookings",
+		Title:  "Invalid bookings",
+		Status: http.StatusUnprocessableEntity,
+		Detail: ie.Error(),
+		Errors: ie,
+	})
+}
+
+// importMapping returns the columns of the fields, with the mapping of the
+// form "field:column,...".
+func importMapping(s string) (map[string]string, error) {
+	columns := make(map[string]string, len(importFields))
+	for _, f := range importFields {
+		columns[f] = f
+	}
+	if len(strings.TrimSpace(s)) < 1 {
+		return columns, nil
+	}
+	for _, m := range strings.Split(s, ",") {
+		f, c, ok := strings.Cut(m, ":")
+		f = strings.ToLower(strings.TrimSpace(f))
+		if _, known := columns[f]; !ok || !known || len(strings.TrimSpace(c)) < 1 {
+			return nil, errors.Errorf("invalid mapping %q, expected <field>:<column> of the fields %s", m, strings.Join(importFields, ", "))
+		}
+		columns[f] = strings.TrimSpace(c)
+	}
+	return columns, nil
+}
+
+// record returns the booking record of the values by field.
+func record(line int, v map[string]string) usecase.BookingRecord {
+	return usecase.BookingRecord{
+		Line:        line,
+		Date:        v["date"],
+		Project:     v["project"],
+		Activity:    v["activity"],
+		Hours:       v["hours"],
+		Description: v["description"],
+	}
+}
+
+// readCSVRecords reads the records of CSV with a header row. Column names
+// are matched ignoring case. The description column is optional.
+func readCSVRecords(r io.Reader, delim rune, columns map[string]string) ([]usecase.BookingRecord, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = delim
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		// Spreadsheet exports often start with a byte order mark.
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	index := make(map[string]int)
+	for _, f := range importFields {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), columns[f]) {
+				index[f] = i
+				break
+			}
+		}
+		if _, ok := index[f]; !ok && f != "description" {
+			return nil, errors.Errorf("missing column %q of field %s", columns[f], f)
+		}
+	}
+
+	var rs []usecase.BookingRecord
+	for {
+		values, err := cr.Read()
+		if err == io.EOF {
+			return rs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		v := make(map[string]string, len(index))
+		for f, i := range index {
+			if i < len(values) {
+				v[f] = values[i]
+			}
+		}
+		rs = append(rs, record(line, v))
+	}
+}
+
+// readJSONRecords reads the records of JSON Lines, an object per line.
+// Blank lines are skipped, malformed ones are returned as ImportError.
+func readJSONRecords(r io.Reader, columns map[string]string) ([]usecase.BookingRecord, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var rs []usecase.BookingRecord
+	var errs usecase.ImportError
+	for n, l := range bytes.Split(b, []byte("\n")) {
+		l = bytes.TrimSpace(l)
+		if len(l) < 1 {
+			continue
+		}
+		d := json.NewDecoder(bytes.NewReader(l))
+		d.UseNumber()
+		var o map[string]interface{}
+		if err := d.Decode(&o); err != nil {
+			errs = append(errs, usecase.RecordError{Line: n + 1, Message: "malformed JSON: " + err.Error()})
+			continue
+		}
+		v := make(map[string]string, len(importFields))
+		for _, f := range importFields {
+			switch x := o[columns[f]].(type) {
+			case nil:
+			case string:
+				v[f] = x
+			case json.Number:
+				v[f] = x.String()
+			default:
+				errs = append(errs, usecase.RecordError{Line: n + 1, Field: f, Message: columns[f] + " is neither a string nor a number"})
+			}
+		}
+		rs = append(rs, record(n+1, v))
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return rs, nil
+}
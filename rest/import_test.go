@@ -0,0 +1,176 @@
+package rest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/rest"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+// importer serves the import of bookings of the user "go" into the open
+// invoice 1 of November 2020.
+func importer(t *testing.T) (*database.FakeRepository, http.Handler) {
+	t.Helper()
+	r := database.NewFakeRepository()
+	cu, _ := r.CreateCustomer(domain.Customer{Name: "3skills", UserID: "go"})
+	_, _ = r.CreateProject(domain.Project{Name: "Instanfoo.com", CustomerID: cu.ID})
+	_, _ = r.CreateActivity(domain.Activity{Name: "Programming", UserID: "go"})
+	_, _ = r.CreateActivity(domain.Activity{Name: "Quality control", UserID: "go"})
+	_, err := r.CreateInvoice(domain.Invoice{CustomerID: cu.ID, Month: 11, Year: 2020})
+	require.NoError(t, err)
+
+	a := rest.NewAdapter()
+	h := a.ImportBookingsHandler(usecase.NewImportBookings(r))
+	a.Handle("/invoices/{invoiceId:[0-9]+}/bookings", func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		var c rest.Claims
+		c.Subject = "go"
+		h(context.WithValue(ctx, rest.Key, c), w, req)
+	})
+	return r, a.R
+}
+
+func post(h http.Handler, query, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/invoices/1/bookings"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestImportBookingsCSV(t *testing.T) {
+	r, h := importer(t)
+	csv := "\ufeffDatum;Projekt;Tätigkeit;Stunden;Beschreibung\n" +
+		"30.11.2020;Instanfoo.com;programming;3,5;\"Front: bugfix #6789\"\n" +
+		"30.11.2020;1;2;0:45;Review\n"
+	res := post(h, "?delimiter=%3B&dateFormat=02.01.2006&mapping=date:Datum,project:Projekt,activity:T%C3%A4tigkeit,hours:Stunden,description:Beschreibung", "text/csv; charset=utf-8", csv)
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+	var bs []domain.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &bs))
+	if assert.Len(t, bs, 2) {
+		assert.Equal(t, domain.Booking{ID: 1, Day: 30, Hours: 3.5, Description: "Front: bugfix #6789", InvoiceID: 1, ProjectID: 1, ActivityID: 1}, bs[0])
+		assert.Equal(t, float32(0.75), bs[1].Hours)
+		assert.Equal(t, 2, bs[1].ActivityID)
+	}
+	assert.Len(t, r.BookingsByInvoiceID(1), 2)
+
+	res = post(h, "", "text/csv", "date,project,hours\n2020-11-30,1,1\n")
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Contains(t, res.Body.String(), `missing column \"activity\" of field activity`)
+	res = post(h, "?mapping=day:Datum", "text/csv", "")
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	res = post(h, "", "application/json", "{}")
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.Code)
+}
+
+func TestImportBookingsJSONLines(t *testing.T) {
+	_, h := importer(t)
+	jsonl := `{"date": "2020-11-02", "project": "Instanfoo.com", "activity": "Programming", "hours": 8, "description": "Setup"}
+
+{"date": "2020-11-03", "project": 1, "activity": "Quality control", "hours": "2.5"}
+`
+	res := post(h, "", "application/x-ndjson", jsonl)
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+	var bs []domain.Booking
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &bs))
+	if assert.Len(t, bs, 2) {
+		assert.Equal(t, float32(8), bs[0].Hours)
+		assert.Equal(t, 3, bs[1].Day)
+	}
+}
+
+func TestImportBookingsReport(t *testing.T) {
+	r, h := importer(t)
+	csv := "date,project,activity,hours,description\n" +
+		"2020-11-30,Instanfoo.com,Programming,3.5,ok\n" +
+		"2020-12-01,Unknown,Testing,25,all wrong\n" +
+		"30/11/2020,1,1,x,\n"
+	res := post(h, "", "text/csv", csv)
+	require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	assert.Equal(t, "application/problem+json", res.Header().Get("Content-Type"))
+	var p rest.Problem
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &p))
+	assert.Equal(t, "/problems/invalid-bookings", p.Type)
+	assert.Equal(t, []usecase.RecordError{
+		{Line: 3, Field: "date", Message: "date 2020-12-01 is not in the invoice period 2020-11"},
+		{Line: 3, Field: "project", Message: `unknown project "Unknown"`},
+		{Line: 3, Field: "activity", Message: `unknown activity "Testing"`},
+		{Line: 3, Field: "hours", Message: "hours 25 are not within a day"},
+		{Line: 4, Field: "date", Message: `invalid date "30/11/2020", expected layout 2006-01-02`},
+		{Line: 4, Field: "hours", Message: `invalid hours "x"`},
+	}, p.Errors)
+	assert.Empty(t, r.BookingsByInvoiceID(1), "nothing is booked")
+
+	res = post(h, "", "application/jsonl", "{\"date\": \"2020-11-30\"}\n{oops}\n")
+	require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &p))
+	if assert.Len(t, p.Errors, 1) {
+		assert.Equal(t, 2, p.Errors[0].Line)
+	}
+
+	i := r.GetInvoice(1)
+	i.Status = "payment expected"
+	require.NoError(t, r.UpdateInvoice(i))
+	res = post(h, "", "text/csv", "date,project,activity,hours\n2020-11-30,1,1,1\n")
+	assert.Equal(t, http.StatusConflict, res.Code)
+}
+
+func TestImportTimesheet(t *testing.T) {
+	r, _ := importer(t)
+	a := rest.NewAdapter()
+	h := a.ImportTimesheetHandler(usecase.NewImportTimesheet(r))
+	a.Handle("/timesheets/{format}", func(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+		var c rest.Claims
+		c.Subject = "go"
+		h(context.WithValue(ctx, rest.Key, c), w, req)
+	})
+	send := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		res := httptest.NewRecorder()
+		a.R.ServeHTTP(res, req)
+		return res
+	}
+	harvest := "Date,Client,Project,Task,Notes,Hours\n" +
+		"2020-11-30,3skills,Instanfoo.com,Programming,Front: bugfix #6789,3.5\n" +
+		"2020-12-01,3skills,Instanfoo.com,Code review,,1\n"
+
+	res := send("/timesheets/harvest", harvest)
+	require.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	var p rest.Problem
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &p))
+	assert.Equal(t, []usecase.RecordError{
+		{Line: 3, Field: "date", Message: `no invoice of client "3skills" for 2020-12`},
+		{Line: 3, Field: "task", Message: `unknown task "Code review"`},
+	}, p.Errors)
+
+	res = send("/timesheets/harvest?create=true&dryRun=true", harvest)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var plan usecase.TimesheetPlan
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &plan))
+	assert.True(t, plan.DryRun)
+	assert.Len(t, plan.Invoices, 1)
+	assert.Len(t, plan.Activities, 1)
+	assert.Len(t, plan.Bookings, 2)
+	assert.Len(t, r.Invoices("go"), 1, "dry run creates nothing")
+
+	res = send("/timesheets/harvest?create=true", harvest)
+	require.Equal(t, http.StatusCreated, res.Code, res.Body.String())
+	assert.Len(t, r.Invoices("go"), 2)
+	assert.Len(t, r.BookingsByInvoiceID(1), 1)
+
+	res = send("/timesheets/toggl?create=maybe", harvest)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	res = send("/timesheets/toggl", harvest)
+	assert.Equal(t, http.StatusBadRequest, res.Code, "missing columns")
+	res = send("/timesheets/excel", harvest)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,149 @@
+package rest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/secret"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+func TestKeyFunc(t *testing.T) {
+	rk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ek, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ek384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := jwks.New(func(ctx context.Context) (map[string]jwks.Key, error) {
+		return map[string]jwks.Key{
+			"rs":    {ID: "rs", Alg: "RS256", Instance: &rk.PublicKey},
+			"ps":    {ID: "ps", Alg: "PS256", Instance: &rk.PublicKey},
+			"es":    {ID: "es", Alg: "ES256", Instance: &ek.PublicKey},
+			"es384": {ID: "es384", Alg: "ES384", Instance: &ek384.PublicKey},
+			"ed":    {ID: "ed", Alg: "EdDSA", Instance: pub},
+			"bad":   {ID: "bad", Alg: "ES256", Instance: &rk.PublicKey},
+		}, nil
+	}, jwks.Options{})
+
+	der, _ := x509.MarshalPKIXPublicKey(&rk.PublicKey)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	all := []string{"RS256", "PS256", "ES256", "ES384", "EdDSA"}
+	tests := []struct {
+		name    string
+		allowed []string
+		method  jwt.SigningMethod
+		kid     string
+		key     interface{}
+		valid   bool
+	}{
+		{"RS256", all, jwt.SigningMethodRS256, "rs", rk, true},
+		{"PS256", all, jwt.SigningMethodPS256, "ps", rk, true},
+		{"ES256", all, jwt.SigningMethodES256, "es", ek, true},
+		{"ES384", all, jwt.SigningMethodES384, "es384", ek384, true},
+		{"EdDSA", all, rest.EdDSA, "ed", priv, true},
+		{"algorithm not allowed", []string{"RS256"}, jwt.SigningMethodES256, "es", ek, false},
+		{"HS256 with public key as secret", append(all, "HS256"), jwt.SigningMethodHS256, "rs", rsaPEM, false},
+		{"none", append(all, "none"), jwt.SigningMethodNone, "rs", jwt.UnsafeAllowNoneSignatureType, false},
+		{"alg differs from key alg", all, jwt.SigningMethodPS256, "rs", rk, false},
+		{"RS256 token for EC key", all, jwt.SigningMethodRS256, "es", rk, false},
+		{"ES384 token for P-256 key", all, jwt.SigningMethodES384, "es", ek384, false},
+		{"key type does not fit alg", all, jwt.SigningMethodES256, "bad", ek, false},
+		{"unknown kid", all, jwt.SigningMethodRS256, "missing", rk, false},
+		{"kid is required", all, jwt.SigningMethodRS256, "", rk, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c rest.Claims
+			c.Issuer = "invoice.mvp"
+			c.Audience = jwt.ClaimStrings{"invoice-mvp"}
+			c.Subject = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+			c.ExpiresAt = jwt.At(time.Now().Add(time.Minute))
+			c.Roles = []string{rest.RoleUser}
+			tok := jwt.NewWithClaims(tt.method, c)
+			tok.Header["kid"] = tt.kid
+			s, err := tok.SignedString(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := rest.NewJWTAuthenticator("invoice.mvp", "invoice.mvp", "invoice-mvp", rest.KeyFunc(tt.allowed, keys, nil))
+			req := httptest.NewRequest("GET", "/activities", nil)
+			req.Header.Set("Authorization", "Bearer "+s)
+			got, err := a.Authenticate(req)
+			if !tt.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.Subject, got.Subject)
+		})
+	}
+}
+
+func TestKeyFuncHMAC(t *testing.T) {
+	k1 := bytes.Repeat([]byte{1}, secret.MinKeySize)
+	k2 := bytes.Repeat([]byte{2}, secret.MinKeySize)
+	ring, err := secret.ParseKeyring("k2:" + base64.StdEncoding.EncodeToString(k2) +
+		",k1:" + base64.StdEncoding.EncodeToString(k1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		kid     string
+		key     []byte
+		valid   bool
+	}{
+		{"primary key", []string{"HS256"}, "k2", k2, true},
+		{"rotated key", []string{"HS256"}, "k1", k1, true},
+		{"without kid primary key", []string{"HS256"}, "", k2, true},
+		{"without kid rotated key", []string{"HS256"}, "", k1, false},
+		{"wrong key for kid", []string{"HS256"}, "k2", k1, false},
+		{"unknown kid", []string{"HS256"}, "k3", k2, false},
+		{"algorithm not allowed", []string{"RS256"}, "k2", k2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c rest.Claims
+			c.Issuer = "invoice.mvp"
+			c.Audience = jwt.ClaimStrings{"invoice-mvp"}
+			c.Subject = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+			c.ExpiresAt = jwt.At(time.Now().Add(time.Minute))
+			tok := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+			if len(tt.kid) > 0 {
+				tok.Header["kid"] = tt.kid
+			}
+			s, err := tok.SignedString(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := rest.NewJWTAuthenticator("invoice.mvp", "invoice.mvp", "invoice-mvp", rest.KeyFunc(tt.allowed, nil, ring))
+			req := httptest.NewRequest("GET", "/activities", nil)
+			req.Header.Set("Authorization", "Bearer "+s)
+			_, err = a.Authenticate(req)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/pkg/errors"
+)
+
+// CertIdentity maps client certificates to a user. A certificate matches
+// when all of the non-empty match fields are found in it.
+type CertIdentity struct {
+	CommonName string   `json:"commonName,omitempty"`
+	DNSName    string   `json:"dnsName,omitempty"`
+	Email      string   `json:"email,omitempty"`
+	URI        string   `json:"uri,omitempty"`
+	UserID     string   `json:"userId"`
+	Roles      []string `json:"roles"`
+}
+
+// matches reports whether the certificate carries the subject common name
+// and subject alternative names of the identity.
+func (ci CertIdentity) matches(cert *x509.Certificate) bool {
+	if len(ci.CommonName)+len(ci.DNSName)+len(ci.Email)+len(ci.URI) < 1 {
+		return false
+	}
+	if len(ci.CommonName) > 0 && cert.Subject.CommonName != ci.CommonName {
+		return false
+	}
+	if len(ci.DNSName) > 0 && !contains(cert.DNSNames, ci.DNSName) {
+		return false
+	}
+	if len(ci.Email) > 0 && !contains(cert.EmailAddresses, ci.Email) {
+		return false
+	}
+	if len(ci.URI) > 0 {
+		var uris []string
+		for _, u := range cert.URIs {
+			uris = append(uris, u.String())
+		}
+		if !contains(uris, ci.URI) {
+			return false
+		}
+	}
+	return true
+}
+
+// CertMapping lists the identities of known client certificates.
+type CertMapping []CertIdentity
+
+// LoadCertMapping reads a JSON file holding a CertMapping.
+func LoadCertMapping(path string) (CertMapping, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading certificate mapping file")
+	}
+	var m CertMapping
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling certificate mapping file")
+	}
+	for i, ci := range m {
+		if len(ci.UserID) < 1 {
+			return nil, errors.Errorf("certificate mapping %d lacks a userId", i)
+		}
+	}
+	return m, nil
+}
+
+// ClaimsForCertificate returns the claims of the first matching identity.
+// The certificate serial number becomes the "jti" and its expiry the "exp".
+func (m CertMapping) ClaimsForCertificate(cert *x509.Certificate) (Claims, bool) {
+	var c Claims
+	for _, ci := range m {
+		if !ci.matches(cert) {
+			continue
+		}
+		c.Subject = ci.UserID
+		c.Roles = ci.Roles
+		c.ID = fmt.Sprintf("cert-%x", cert.SerialNumber)
+		c.IssuedAt = jwt.At(cert.NotBefore)
+		c.ExpiresAt = jwt.At(cert.NotAfter)
+		return c, true
+	}
+	return c, false
+}
+
+// LoadCertPool reads a PEM encoded CA bundle.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading CA bundle")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// ClientCertTLSConfig requests client certificates signed by the CAs. Clients
+// without a certificate may still authenticate with another scheme.
+func ClientCertTLSConfig(cfg *tls.Config, clientCAs *x509.CertPool) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg.ClientCAs = clientCAs
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg
+}
+
+// CertAuthenticator authenticates clients by the certificate verified during
+// the TLS handshake.
+type CertAuthenticator struct {
+	mapping CertMapping
+}
+
+// NewCertAuthenticator instantiates a client certificate authenticator.
+func NewCertAuthenticator(m CertMapping) CertAuthenticator {
+	return CertAuthenticator{mapping: m}
+}
+
+// Authenticate implements the Authenticator interface. Only certificates
+// verified against the client CAs are considered.
+func (a CertAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 || len(r.TLS.VerifiedChains[0]) < 1 {
+		return Claims{}, ErrNoCredentials
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	c, ok := a.mapping.ClaimsForCertificate(cert)
+	if !ok {
+		return c, errors.Errorf("no identity mapped to client certificate %q", cert.Subject)
+	}
+	return c, nil
+}
+
+// Challenge implements the Authenticator interface. Client certificates are
+// requested in the TLS handshake, there is no HTTP challenge.
+func (a CertAuthenticator) Challenge(w http.ResponseWriter) {}
@@ -0,0 +1,120 @@
+package rest_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+// issue creates a certificate signed by the parent, or a self-signed CA when
+// parent is nil.
+func issue(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Minute)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	ca, caKey := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "B2B CA"}}, nil, nil)
+	partner, partnerKey := issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing-partner"},
+		DNSNames: []string{"billing.partner.example"},
+	}, ca, caKey)
+	unknown, unknownKey := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}}, ca, caKey)
+	rogueCA, rogueKey := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Rogue CA"}}, nil, nil)
+	rogue, rogueClientKey := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing-partner"}}, rogueCA, rogueKey)
+
+	mapping := rest.CertMapping{{
+		CommonName: "billing-partner",
+		DNSName:    "billing.partner.example",
+		UserID:     "5e4f6d2a-8a1b-4f2e-9c3d-7b6a5e4d3c2b",
+		Roles:      []string{"ACCOUNTANT"},
+	}}
+	chain := rest.AuthChain{rest.NewCertAuthenticator(mapping)}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chain.Authenticate(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			c := ctx.Value(rest.Key).(rest.Claims)
+			w.Header().Set("X-Subject", c.Subject)
+			w.Header().Set("X-Roles", c.Roles[0])
+		})(r.Context(), w, r)
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	srv.TLS = rest.ClientCertTLSConfig(nil, pool)
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := func(cert *x509.Certificate, key *ecdsa.PrivateKey) *http.Client {
+		c := srv.Client()
+		tr := c.Transport.(*http.Transport).Clone()
+		if cert != nil {
+			tr.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}
+		}
+		c.Transport = tr
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		key     *ecdsa.PrivateKey
+		status  int
+		subject string
+	}{
+		{"mapped certificate", partner, partnerKey, http.StatusOK, "5e4f6d2a-8a1b-4f2e-9c3d-7b6a5e4d3c2b"},
+		{"unmapped certificate", unknown, unknownKey, http.StatusUnauthorized, ""},
+		{"no certificate", nil, nil, http.StatusUnauthorized, ""},
+		// Certificates of other CAs are never accepted.
+		{"certificate of other CA", rogue, rogueClientKey, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := client(tt.cert, tt.key).Get(srv.URL + "/activities")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+			assert.Equal(t, tt.subject, res.Header.Get("X-Subject"))
+			if tt.status == http.StatusOK {
+				assert.Equal(t, "ACCOUNTANT", res.Header.Get("X-Roles"))
+			}
+		})
+	}
+
+	// A certificate of another CA sent regardless fails the handshake.
+	c := client(nil, nil)
+	c.Transport.(*http.Transport).TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &tls.Certificate{Certificate: [][]byte{rogue.Raw}, PrivateKey: rogueClientKey}, nil
+	}
+	_, err := c.Get(srv.URL + "/activities")
+	assert.Error(t, err)
+}
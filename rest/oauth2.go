@@ -0,0 +1,497 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+)
+
+// Cookies of the authorization code flow.
+const (
+	StateCookie   = "mvp_oauth_state"
+	RefreshCookie = "mvp_refresh"
+)
+
+// OAuth2 flow defaults.
+const (
+	// DefaultLoginTTL bounds the time between login and callback.
+	DefaultLoginTTL = 10 * time.Minute
+	// DefaultRefreshTokenTTL bounds the use of a refresh token, like the
+	// default refresh token lifetime of FusionAuth.
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	// maxPendingLogins caps the unfinished logins kept in memory.
+	maxPendingLogins = 10000
+)
+
+// RefreshToken is a refresh token held server-side on behalf of a client.
+type RefreshToken struct {
+	Token   string
+	Subject string
+	Issued  time.Time
+}
+
+// RefreshTokenStore keeps refresh tokens by the opaque ID handed to the
+// client. Clients never see the refresh token itself.
+type RefreshTokenStore interface {
+	Save(id string, t RefreshToken) error
+	Get(id string) (RefreshToken, bool)
+	Delete(id string)
+}
+
+// MemoryRefreshTokens is a RefreshTokenStore lost on restart. IDs are kept
+// as SHA-256 digests. Tokens expire DefaultRefreshTokenTTL after they were
+// issued and are dropped when a token is saved.
+type MemoryRefreshTokens struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+// NewMemoryRefreshTokens instantiates an empty in-memory store.
+func NewMemoryRefreshTokens() *MemoryRefreshTokens {
+	return &MemoryRefreshTokens{now: time.Now, tokens: make(map[string]RefreshToken)}
+}
+
+// Save implements the RefreshTokenStore interface.
+func (m *MemoryRefreshTokens) Save(id string, t RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for k, v := range m.tokens {
+		if m.expired(v, now) {
+			delete(m.tokens, k)
+		}
+	}
+	m.tokens[digestID(id)] = t
+	return nil
+}
+
+// Len returns the number of refresh tokens kept.
+func (m *MemoryRefreshTokens) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.tokens)
+}
+
+// Get implements the RefreshTokenStore interface.
+func (m *MemoryRefreshTokens) Get(id string) (RefreshToken, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[digestID(id)]
+	if !ok || m.expired(t, m.now()) {
+		return RefreshToken{}, false
+	}
+	return t, true
+}
+
+func (m *MemoryRefreshTokens) expired(t RefreshToken, now time.Time) bool {
+	return now.After(t.Issued.Add(DefaultRefreshTokenTTL))
+}
+
+// Delete implements the RefreshTokenStore interface.
+func (m *MemoryRefreshTokens) Delete(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tokens, digestID(id))
+}
+
+func digestID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// pendingLogin is a login started at /auth/login awaiting the callback.
+type pendingLogin struct {
+	verifier string
+	started  time.Time
+}
+
+// OAuth2Flow implements the authorization code flow with state and PKCE
+// (RFC 7636) against the identity provider of the adapter.
+type OAuth2Flow struct {
+	idp    fusionauth.AuthConfig
+	tokens RefreshTokenStore
+	client *http.Client
+	ttl    time.Duration
+	now    func() time.Time
+
+	// Session mode, see WithSessions.
+	sessions *Sessions
+	verifier JWTAuthenticator
+
+	mu      sync.Mutex
+	pending map[string]pendingLogin // by state
+}
+
+// NewOAuth2Flow instantiates the login flow. Refresh tokens issued by the
+// identity provider are kept in tokens.
+func (a Adapter) NewOAuth2Flow(tokens RefreshTokenStore) *OAuth2Flow {
+	return &OAuth2Flow{
+		idp:     a.idp,
+		tokens:  tokens,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		ttl:     DefaultLoginTTL,
+		now:     time.Now,
+		pending: make(map[string]pendingLogin),
+	}
+}
+
+// WithSessions switches the flow to session mode: the callback verifies the
+// access token with the verifier and starts a browser session holding its
+// claims instead of returning the token. Logout ends the session.
+func (f *OAuth2Flow) WithSessions(s *Sessions, verifier JWTAuthenticator) *OAuth2Flow {
+	f.sessions = s
+	f.verifier = verifier
+	return f
+}
+
+// LoginHandler starts a login: it remembers a fresh state and PKCE verifier
+// and redirects the user agent to the authorization endpoint.
+func (f *OAuth2Flow) LoginHandler() Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		state, verifier := randomToken(), randomToken()
+		f.begin(state, verifier)
+
+		q := make(url.Values)
+		q.Set("response_type", "code")
+		q.Set("client_id", f.idp.ClientID)
+		q.Set("redirect_uri", f.idp.RedirectURI)
+		q.Set("scope", "openid offline_access")
+		q.Set("state", state)
+		q.Set("code_challenge", codeChallenge(verifier))
+		q.Set("code_challenge_method", "S256")
+
+		// Binds the state to the user agent, so a callback can not be
+		// replayed into another browser (login CSRF).
+		http.SetCookie(w, &http.Cookie{
+			Name:     StateCookie,
+			Value:    state,
+			Path:     "/auth",
+			MaxAge:   int(f.ttl.Seconds()),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, f.idp.AuthorizeURI+"?"+q.Encode(), http.StatusFound)
+	}
+}
+
+// CallbackHandler validates state and exchanges the code grant together
+// with the PKCE verifier for an access token. The refresh token is kept
+// server-side and the client receives an opaque cookie referencing it.
+func (f *OAuth2Flow) CallbackHandler() Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		clearCookie(w, StateCookie, "/auth")
+		if e := q.Get("error"); len(e) > 0 {
+			log.Printf("authorization failed: %s %s", e, q.Get("error_description"))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		state := q.Get("state")
+		c, err := r.Cookie(StateCookie)
+		if err != nil || len(state) < 1 || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		login, ok := f.finish(state)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code := q.Get("code")
+		if len(code) < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		form := make(url.Values)
+		form.Set("grant_type", "authorization_code")
+		form.Set("code", code)
+		form.Set("redirect_uri", f.idp.RedirectURI)
+		form.Set("code_verifier", login.verifier)
+		t, err := f.token(ctx, form)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if f.sessions == nil {
+			f.respond(w, t)
+			return
+		}
+
+		claims, err := f.verifier.verify(t.AccessToken)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := f.saveRefreshToken(w, &t); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sess, err := f.sessions.Start(w, claims)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeSessionInfo(w, sess)
+	}
+}
+
+// RefreshHandler exchanges the refresh token referenced by the refresh
+// cookie for a new access token. In session mode the session takes the
+// claims of the new token instead.
+func (f *OAuth2Flow) RefreshHandler() Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(RefreshCookie)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rt, ok := f.tokens.Get(c.Value)
+		if !ok {
+			clearCookie(w, RefreshCookie, "/auth")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// The old ID is retired, a new one is handed out by respond.
+		f.tokens.Delete(c.Value)
+
+		form := make(url.Values)
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", rt.Token)
+		t, err := f.token(ctx, form)
+		if err != nil {
+			log.Println(err)
+			clearCookie(w, RefreshCookie, "/auth")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if len(t.RefreshToken) < 1 {
+			// No rotation by the identity provider, keep the current token.
+			t.RefreshToken = rt.Token
+		}
+		if f.sessions == nil {
+			f.respond(w, t)
+			return
+		}
+
+		claims, err := f.verifier.verify(t.AccessToken)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := f.saveRefreshToken(w, &t); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		sess, err := f.sessions.Refresh(w, r, claims)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeSessionInfo(w, sess)
+	}
+}
+
+// LogoutHandler forgets the refresh token referenced by the refresh cookie
+// and revokes it at the identity provider, if supported.
+func (f *OAuth2Flow) LogoutHandler() Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(RefreshCookie); err == nil {
+			if rt, ok := f.tokens.Get(c.Value); ok {
+				f.tokens.Delete(c.Value)
+				if err := f.revoke(ctx, rt.Token); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+		clearCookie(w, RefreshCookie, "/auth")
+		if f.sessions != nil {
+			f.sessions.End(w, r)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// respond stores the refresh token and writes the access token response.
+func (f *OAuth2Flow) respond(w http.ResponseWriter, t fusionauth.AuthInfo) {
+	if err := f.saveRefreshToken(w, &t); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(&t)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
+
+// saveRefreshToken moves the refresh token of t into the store and sets the
+// refresh cookie referencing it.
+func (f *OAuth2Flow) saveRefreshToken(w http.ResponseWriter, t *fusionauth.AuthInfo) error {
+	if len(t.RefreshToken) < 1 {
+		return nil
+	}
+	id := randomToken()
+	rt := RefreshToken{Token: t.RefreshToken, Subject: t.UserID, Issued: f.now()}
+	if err := f.tokens.Save(id, rt); err != nil {
+		return errors.Wrap(err, "saving refresh token")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookie,
+		Value:    id,
+		Path:     "/auth",
+		MaxAge:   int(DefaultRefreshTokenTTL / time.Second),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	t.RefreshToken = ""
+	return nil
+}
+
+// begin remembers the pending login. When the cap is reached, expired
+// logins are dropped, or else the oldest one, so a flood of unfinished
+// logins can not lock out new ones.
+func (f *OAuth2Flow) begin(state, verifier string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	if len(f.pending) >= maxPendingLogins {
+		var oldest string
+		for s, l := range f.pending {
+			if now.Sub(l.started) > f.ttl {
+				delete(f.pending, s)
+				continue
+			}
+			if len(oldest) < 1 || l.started.Before(f.pending[oldest].started) {
+				oldest = s
+			}
+		}
+		if len(f.pending) >= maxPendingLogins {
+			delete(f.pending, oldest)
+		}
+	}
+	f.pending[state] = pendingLogin{verifier: verifier, started: now}
+}
+
+// finish removes the pending login, so a state can only be used once.
+func (f *OAuth2Flow) finish(state string) (pendingLogin, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l, ok := f.pending[state]
+	delete(f.pending, state)
+	if !ok || f.now().Sub(l.started) > f.ttl {
+		return l, false
+	}
+	return l, true
+}
+
+// token posts the grant to the token endpoint.
+func (f *OAuth2Flow) token(ctx context.Context, form url.Values) (fusionauth.AuthInfo, error) {
+	var ai fusionauth.AuthInfo
+	form.Set("client_id", f.idp.ClientID)
+	form.Set("client_secret", f.idp.ClientSecret)
+	body, err := f.post(ctx, f.idp.TokenURI, form)
+	if err != nil {
+		return ai, errors.Wrap(err, "requesting token")
+	}
+	if err := json.Unmarshal(body, &ai); err != nil {
+		return ai, errors.Wrap(err, "unmarshalling token response")
+	}
+	if !strings.EqualFold(ai.TokenType, "Bearer") || len(ai.AccessToken) < 1 {
+		return ai, errors.Errorf("unexpected token response of type %q", ai.TokenType)
+	}
+	return ai, nil
+}
+
+// revoke revokes the refresh token (RFC 7009).
+func (f *OAuth2Flow) revoke(ctx context.Context, token string) error {
+	if len(f.idp.RevokeURI) < 1 {
+		return nil
+	}
+	form := make(url.Values)
+	form.Set("token", token)
+	form.Set("token_type_hint", "refresh_token")
+	form.Set("client_id", f.idp.ClientID)
+	form.Set("client_secret", f.idp.ClientSecret)
+	_, err := f.post(ctx, f.idp.RevokeURI, form)
+	return errors.Wrap(err, "revoking refresh token")
+}
+
+func (f *OAuth2Flow) post(ctx context.Context, uri string, form url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response body")
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Unexpected response status %d", res.StatusCode)
+	}
+	return body, nil
+}
+
+// codeChallenge derives the S256 PKCE code challenge of the verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomToken returns 256 random bits, base64url encoded. This also makes a
+// valid PKCE code verifier of 43 characters.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func clearCookie(w http.ResponseWriter, name, path string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Path:     path,
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+	})
+}
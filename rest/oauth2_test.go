@@ -0,0 +1,327 @@
+package rest_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+// stubTokenEndpoint accepts the code "code-1" when the PKCE verifier matches
+// the challenge sent to the authorization endpoint, and rotates refresh
+// tokens.
+func stubTokenEndpoint(t *testing.T, challenge *string, revoked *[]string) *httptest.Server {
+	t.Helper()
+	refreshes := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("client_id") != "invoice-mvp" || r.Form.Get("client_secret") != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if r.Form.Get("code") != "code-1" || base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh-"+string(rune('0'+refreshes)) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			refreshes++
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(fusionauth.AuthInfo{
+			AccessToken:  "access-" + string(rune('0'+refreshes)),
+			ExpiresIn:    3600,
+			RefreshToken: "refresh-" + string(rune('0'+refreshes)),
+			TokenType:    "Bearer",
+			UserID:       "f8c39a31-9ced-4761-8a33-b9c628a67510",
+		})
+	})
+	mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		*revoked = append(*revoked, r.Form.Get("token"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func cookie(res *http.Response, name string) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestOAuth2Flow(t *testing.T) {
+	var challenge string
+	var revoked []string
+	idp := stubTokenEndpoint(t, &challenge, &revoked)
+	a := rest.NewAdapter().WithConfig(config.IDP{
+		ClientID:     "invoice-mvp",
+		ClientSecret: "s3cr3t",
+		RedirectURI:  "https://127.0.0.1:8443/auth/token",
+		AuthorizeURI: idp.URL + "/authorize",
+		TokenURI:     idp.URL + "/token",
+		RevokeURI:    idp.URL + "/revoke",
+	})
+	flow := a.NewOAuth2Flow(rest.NewMemoryRefreshTokens())
+	a.Handle("/auth/login", flow.LoginHandler()).Methods("GET")
+	a.Handle("/auth/token", flow.CallbackHandler()).Methods("GET")
+	a.Handle("/auth/refresh", flow.RefreshHandler()).Methods("POST")
+	a.Handle("/auth/logout", flow.LogoutHandler()).Methods("POST")
+
+	// Login redirects to the IDP with state and PKCE challenge.
+	rr := httptest.NewRecorder()
+	a.R.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/login", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, "invoice-mvp", q.Get("client_id"))
+	challenge = q.Get("code_challenge")
+	state := q.Get("state")
+	stateCookie := cookie(rr.Result(), rest.StateCookie)
+	if stateCookie == nil {
+		t.Fatal("state cookie missing")
+	}
+	assert.Equal(t, state, stateCookie.Value)
+	assert.True(t, stateCookie.HttpOnly)
+
+	callback := func(state string, c *http.Cookie) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/auth/token?code=code-1&userState=Authenticated&state="+url.QueryEscape(state), nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		a.R.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Callbacks without the matching state are rejected.
+	assert.Equal(t, http.StatusBadRequest, callback("forged", stateCookie).Code)
+	assert.Equal(t, http.StatusBadRequest, callback(state, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, callback(state, &http.Cookie{Name: rest.StateCookie, Value: "other"}).Code)
+
+	rr = callback(state, stateCookie)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	var ai fusionauth.AuthInfo
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ai))
+	assert.Equal(t, "access-0", ai.AccessToken)
+	assert.Empty(t, ai.RefreshToken, "refresh token stays server-side")
+	refresh := cookie(rr.Result(), rest.RefreshCookie)
+	if refresh == nil {
+		t.Fatal("refresh cookie missing")
+	}
+	assert.Equal(t, http.SameSiteStrictMode, refresh.SameSite)
+	assert.Equal(t, int(rest.DefaultRefreshTokenTTL/time.Second), refresh.MaxAge)
+
+	// A state can only be used once.
+	assert.Equal(t, http.StatusBadRequest, callback(state, stateCookie).Code)
+
+	// Refresh rotates the cookie and the stored refresh token.
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.AddCookie(refresh)
+	a.R.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &ai))
+	assert.Equal(t, "access-1", ai.AccessToken)
+	rotated := cookie(rr.Result(), rest.RefreshCookie)
+	if rotated == nil {
+		t.Fatal("rotated refresh cookie missing")
+	}
+	assert.NotEqual(t, refresh.Value, rotated.Value)
+
+	// The previous refresh ID is no longer valid.
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.AddCookie(refresh)
+	a.R.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Logout forgets the refresh token.
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(rotated)
+	a.R.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, []string{"refresh-1"}, revoked)
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.AddCookie(rotated)
+	a.R.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestMemoryRefreshTokensExpire(t *testing.T) {
+	m := rest.NewMemoryRefreshTokens()
+	assert.NoError(t, m.Save("expired", rest.RefreshToken{Token: "refresh-0", Issued: time.Now().Add(-rest.DefaultRefreshTokenTTL - time.Minute)}))
+	_, ok := m.Get("expired")
+	assert.False(t, ok)
+	assert.NoError(t, m.Save("active", rest.RefreshToken{Token: "refresh-1", Issued: time.Now()}))
+	assert.Equal(t, 1, m.Len())
+	rt, ok := m.Get("active")
+	assert.True(t, ok)
+	assert.Equal(t, "refresh-1", rt.Token)
+}
+
+func TestOAuth2FlowPendingLoginFlood(t *testing.T) {
+	var challenge string
+	var revoked []string
+	idp := stubTokenEndpoint(t, &challenge, &revoked)
+	a := rest.NewAdapter().WithConfig(config.IDP{
+		ClientID:     "invoice-mvp",
+		ClientSecret: "s3cr3t",
+		RedirectURI:  "https://127.0.0.1:8443/auth/token",
+		AuthorizeURI: idp.URL + "/authorize",
+		TokenURI:     idp.URL + "/token",
+	})
+	flow := a.NewOAuth2Flow(rest.NewMemoryRefreshTokens())
+	a.Handle("/auth/login", flow.LoginHandler()).Methods("GET")
+	a.Handle("/auth/token", flow.CallbackHandler()).Methods("GET")
+
+	// Unauthenticated logins that are never finished.
+	for i := 0; i < 10001; i++ {
+		rr := httptest.NewRecorder()
+		a.R.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/login", nil))
+		if rr.Code != http.StatusFound {
+			t.Fatalf("login %d: status %d", i, rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	a.R.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/login", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge = loc.Query().Get("code_challenge")
+	req := httptest.NewRequest("GET", "/auth/token?code=code-1&state="+url.QueryEscape(loc.Query().Get("state")), nil)
+	req.AddCookie(cookie(rr.Result(), rest.StateCookie))
+	rr = httptest.NewRecorder()
+	a.R.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestOAuth2FlowSessions(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	roles := []string{rest.RoleUser}
+	grants := 0
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grants++
+		var c rest.Claims
+		c.ID = strconv.Itoa(grants)
+		c.Issuer = "invoice.mvp"
+		c.Audience = jwt.ClaimStrings{"invoice-mvp"}
+		c.Subject = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+		c.ExpiresAt = jwt.At(time.Now().Add(time.Duration(grants) * time.Minute).Truncate(time.Second))
+		c.Roles = roles
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(fusionauth.AuthInfo{
+			AccessToken:  s,
+			ExpiresIn:    60,
+			RefreshToken: "refresh-" + c.ID,
+			TokenType:    "Bearer",
+			UserID:       c.Subject,
+		})
+	}))
+	defer idp.Close()
+
+	a := rest.NewAdapter().WithConfig(config.IDP{
+		ClientID:     "invoice-mvp",
+		ClientSecret: "s3cr3t",
+		RedirectURI:  "https://127.0.0.1:8443/auth/token",
+		AuthorizeURI: idp.URL + "/authorize",
+		TokenURI:     idp.URL + "/token",
+	})
+	sessions, err := rest.NewSessions(rest.NewMemorySessions(), make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := rest.NewJWTAuthenticator("invoice.mvp", "invoice.mvp", "invoice-mvp",
+		func(*jwt.Token) (interface{}, error) { return key, nil })
+	flow := a.NewOAuth2Flow(rest.NewMemoryRefreshTokens()).WithSessions(sessions, verifier)
+	a.Handle("/auth/login", flow.LoginHandler()).Methods("GET")
+	a.Handle("/auth/token", flow.CallbackHandler()).Methods("GET")
+	a.Handle("/auth/refresh", flow.RefreshHandler()).Methods("POST")
+
+	type info struct {
+		Subject   string    `json:"subject"`
+		Roles     []string  `json:"roles"`
+		CSRFToken string    `json:"csrfToken"`
+		Expires   time.Time `json:"expires"`
+	}
+	rr := httptest.NewRecorder()
+	a.R.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/login", nil))
+	loc, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/auth/token?code=code-1&state="+url.QueryEscape(loc.Query().Get("state")), nil)
+	req.AddCookie(cookie(rr.Result(), rest.StateCookie))
+	rr = httptest.NewRecorder()
+	a.R.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var login info
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &login))
+	session, refresh := cookie(rr.Result(), rest.SessionCookie), cookie(rr.Result(), rest.RefreshCookie)
+	if session == nil || refresh == nil {
+		t.Fatal("session or refresh cookie missing")
+	}
+
+	// The session ends with the access token.
+	assert.WithinDuration(t, time.Now().Add(time.Minute), login.Expires, 2*time.Second)
+
+	// Refresh updates the session instead of handing out the access token.
+	roles = []string{rest.RoleUser, rest.RoleAdmin}
+	req = httptest.NewRequest("POST", "/auth/refresh", nil)
+	req.AddCookie(session)
+	req.AddCookie(refresh)
+	rr = httptest.NewRecorder()
+	a.R.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "access_token")
+	var refreshed info
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &refreshed))
+	assert.Equal(t, login.CSRFToken, refreshed.CSRFToken)
+	assert.Equal(t, roles, refreshed.Roles)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), refreshed.Expires, 2*time.Second)
+
+	req = httptest.NewRequest("GET", "/activities", nil)
+	req.AddCookie(cookie(rr.Result(), rest.SessionCookie))
+	c, err := sessions.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, roles, c.Roles)
+}
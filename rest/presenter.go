@@ -94,7 +94,13 @@ func (p JSONInvoicePresenter) Present(i interface{}) {
 // Present ...
 func (p PDFInvoicePresenter) Present(i interface{}) {
 	modTime := time.Now()
-	invoice := i.(domain.Invoice)
+	var invoice domain.Invoice
+	switch v := i.(type) {
+	case HALInvoice:
+		invoice = v.Invoice
+	case domain.Invoice:
+		invoice = v
+	}
 	content := bytes.NewReader(invoice.ToPDF())
 	http.ServeContent(p.writer, p.request, "invoice.pdf", modTime, content)
 }
@@ -0,0 +1,311 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// ErrRevoked is returned for credentials listed in the revocation store.
+var ErrRevoked = errors.New("credentials have been revoked")
+
+// RevocationStore lists revoked tokens by "jti" and subjects whose tokens
+// issued before a point in time are revoked.
+type RevocationStore interface {
+	// RevokeToken revokes the token with the ID. The entry may be dropped
+	// after the token expired.
+	RevokeToken(jti string, expires time.Time) error
+	// RevokeSubject revokes all tokens of the subject issued before the time.
+	RevokeSubject(subject string, before time.Time) error
+	// Revoked reports whether the claims have been revoked.
+	Revoked(c Claims) bool
+}
+
+// Revocations is a RevocationStore, optionally persisted as JSON file.
+type Revocations struct {
+	path string
+	now  func() time.Time
+
+	mu   sync.RWMutex
+	data revocationData
+}
+
+type revocationData struct {
+	Tokens   map[string]time.Time `json:"tokens"`   // jti to token expiry
+	Subjects map[string]time.Time `json:"subjects"` // subject to issued before
+}
+
+// NewRevocations instantiates an in-memory revocation store.
+func NewRevocations() *Revocations {
+	return &Revocations{
+		now: time.Now,
+		data: revocationData{
+			Tokens:   make(map[string]time.Time),
+			Subjects: make(map[string]time.Time),
+		},
+	}
+}
+
+// OpenRevocations loads the revocation store of the file, which is created
+// on the first revocation.
+func OpenRevocations(path string) (*Revocations, error) {
+	rs := NewRevocations()
+	rs.path = path
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return rs, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading revocation store")
+	}
+	if err := json.Unmarshal(b, &rs.data); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling revocation store")
+	}
+	if rs.data.Tokens == nil {
+		rs.data.Tokens = make(map[string]time.Time)
+	}
+	if rs.data.Subjects == nil {
+		rs.data.Subjects = make(map[string]time.Time)
+	}
+	return rs, nil
+}
+
+// RevokeToken implements the RevocationStore interface.
+func (rs *Revocations) RevokeToken(jti string, expires time.Time) error {
+	if len(jti) < 1 {
+		return errors.New("token id is required")
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.data.Tokens[jti] = expires
+	return rs.save()
+}
+
+// RevokeSubject implements the RevocationStore interface.
+func (rs *Revocations) RevokeSubject(subject string, before time.Time) error {
+	if len(subject) < 1 {
+		return errors.New("subject is required")
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if t, ok := rs.data.Subjects[subject]; !ok || before.After(t) {
+		rs.data.Subjects[subject] = before
+	}
+	return rs.save()
+}
+
+// Revoked implements the RevocationStore interface. Tokens without "iat"
+// count as issued before any subject revocation.
+func (rs *Revocations) Revoked(c Claims) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	if len(c.ID) > 0 {
+		if _, ok := rs.data.Tokens[c.ID]; ok {
+			return true
+		}
+	}
+	before, ok := rs.data.Subjects[c.Subject]
+	if !ok {
+		return false
+	}
+	return c.IssuedAt == nil || c.IssuedAt.Before(before)
+}
+
+// save drops expired token entries and writes the file, if any.
+func (rs *Revocations) save() error {
+	now := rs.now()
+	for jti, exp := range rs.data.Tokens {
+		if !exp.IsZero() && now.After(exp) {
+			delete(rs.data.Tokens, jti)
+		}
+	}
+	if len(rs.path) < 1 {
+		return nil
+	}
+	b, err := json.Marshal(rs.data)
+	if err != nil {
+		return errors.Wrap(err, "marshalling revocation store")
+	}
+	tmp := rs.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "writing revocation store")
+	}
+	return errors.Wrap(os.Rename(tmp, rs.path), "replacing revocation store")
+}
+
+// revocable rejects revoked claims of the wrapped authenticator.
+type revocable struct {
+	Authenticator
+	store RevocationStore
+}
+
+// Authenticate implements the Authenticator interface.
+func (a revocable) Authenticate(r *http.Request) (Claims, error) {
+	c, err := a.Authenticator.Authenticate(r)
+	if err != nil {
+		return c, err
+	}
+	if a.store.Revoked(c) {
+		return Claims{}, ErrRevoked
+	}
+	return c, nil
+}
+
+// ChallengeStale implements the StaleChallenger interface.
+func (a revocable) ChallengeStale(w http.ResponseWriter) {
+	if sc, ok := a.Authenticator.(StaleChallenger); ok {
+		sc.ChallengeStale(w)
+		return
+	}
+	a.Challenge(w)
+}
+
+// WithRevocations returns a chain that rejects claims revoked in the store.
+func (c AuthChain) WithRevocations(rs RevocationStore) AuthChain {
+	rc := make(AuthChain, len(c))
+	for i, a := range c {
+		rc[i] = revocable{Authenticator: a, store: rs}
+	}
+	return rc
+}
+
+// RevokeTokenHandler returns a handler that knows how to revoke a single
+// token by its ID, e.g. {"jti": "...", "expires": "2021-01-01T00:00:00Z"}.
+func (a Adapter) RevokeTokenHandler(rs RevocationStore) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			JTI     string    `json:"jti"`
+			Expires time.Time `json:"expires"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.JTI) < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := rs.RevokeToken(body.JTI, body.Expires); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log.Printf("revoked token %s by %s", body.JTI, a.currentUser(ctx))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RevokeSubjectHandler returns a handler that knows how to log out a user
+// everywhere: all tokens, sessions and API keys of the user issued before
+// now are revoked. Users of perRequest send their credentials with every
+// request, e.g. Basic users and static API keys, and can not be logged out;
+// they are rejected with 409 Conflict.
+func (a Adapter) RevokeSubjectHandler(rs RevocationStore, perRequest RoleDirectory) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		sub := mux.Vars(r)["userId"]
+		if len(sub) < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if _, ok := perRequest.RolesOf(sub); ok {
+			writeProblem(w, Problem{
+				Title:  "User can not be logged out",
+				Status: http.StatusConflict,
+				Detail: "The user sends credentials with every request. Disable the user in the credential store or remove the API key instead.",
+			})
+			return
+		}
+		// Tokens issued within the current second count as revoked.
+		if err := rs.RevokeSubject(sub, time.Now().Add(time.Second).Truncate(time.Second)); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log.Printf("revoked credentials of %s by %s", sub, a.currentUser(ctx))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ===== INTROSPECTION ========================================================
+
+// Introspector asks the identity provider whether bearer tokens are still
+// active (RFC 7662), for routes where a revoked token must not be accepted
+// until it expires.
+type Introspector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+// NewIntrospector instantiates an introspector for the endpoint, which
+// authenticates with the client credentials.
+func NewIntrospector(endpoint, clientID, clientSecret string) *Introspector {
+	return &Introspector{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Require decorator introspects the access token the claims of the request
+// were taken from, the bearer token or the one behind the session. Requests
+// authenticated otherwise, e.g. by API key, pass.
+func (i *Introspector) Require(next Handler) Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		claims, _ := ctx.Value(Key).(Claims)
+		if len(claims.accessToken) < 1 {
+			next(ctx, w, r)
+			return
+		}
+		if err := i.introspect(r.Context(), claims.accessToken, claims); err != nil {
+			log.Println(err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(ctx, w, r)
+	}
+}
+
+func (i *Introspector) introspect(ctx context.Context, token string, c Claims) error {
+	form := make(url.Values)
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+	req, err := http.NewRequestWithContext(ctx, "POST", i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "creating introspection request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	res, err := i.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "introspecting token")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("Unexpected introspection response status %d", res.StatusCode)
+	}
+	var ir struct {
+		Active bool   `json:"active"`
+		Sub    string `json:"sub"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&ir); err != nil {
+		return errors.Wrap(err, "unmarshalling introspection response")
+	}
+	if !ir.Active {
+		return errors.New("token is not active")
+	}
+	if len(ir.Sub) > 0 && len(c.Subject) > 0 && ir.Sub != c.Subject {
+		return errors.Errorf("introspected subject %s does not match %s", ir.Sub, c.Subject)
+	}
+	return nil
+}
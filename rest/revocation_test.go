@@ -0,0 +1,225 @@
+package rest_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+func TestRevocations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	rs, err := rest.OpenRevocations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims := func(jti, sub string, iat time.Time) rest.Claims {
+		var c rest.Claims
+		c.ID = jti
+		c.Subject = sub
+		c.IssuedAt = jwt.At(iat)
+		return c
+	}
+
+	assert.NoError(t, rs.RevokeToken("t-1", now.Add(time.Hour)))
+	assert.NoError(t, rs.RevokeSubject("alice", now))
+	assert.Error(t, rs.RevokeToken("", now))
+
+	// Revocations survive a restart.
+	rs, err = rest.OpenRevocations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, rs.Revoked(claims("t-1", "bob", now)))
+	assert.False(t, rs.Revoked(claims("t-2", "bob", now)))
+	assert.True(t, rs.Revoked(claims("t-3", "alice", now.Add(-time.Minute))))
+	assert.False(t, rs.Revoked(claims("t-4", "alice", now.Add(time.Minute))))
+
+	// A later revocation of the subject is never undone by an earlier one.
+	assert.NoError(t, rs.RevokeSubject("alice", now.Add(-time.Hour)))
+	assert.True(t, rs.Revoked(claims("t-3", "alice", now.Add(-time.Minute))))
+}
+
+func TestAuthChainWithRevocations(t *testing.T) {
+	sum := sha256.Sum256([]byte("s3cr3t-api-key"))
+	var bot rest.Claims
+	bot.ID = "bot-key"
+	bot.Subject = "integration-bot"
+	bot.Roles = []string{rest.RoleUser}
+	keys := rest.APIKeys{hex.EncodeToString(sum[:]): bot}
+	cfg := config.Default().Auth
+	cfg.Schemes = []string{"apikey"}
+	chain, err := rest.NewAuthChain(cfg, nil, nil, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := rest.NewRevocations()
+	h := chain.WithRevocations(rs).Authenticate(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {})
+
+	do := func() int {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/activities", nil)
+		req.Header.Set(rest.APIKeyHeader, "s3cr3t-api-key")
+		h(req.Context(), res, req)
+		return res.Code
+	}
+	assert.Equal(t, http.StatusOK, do())
+
+	// Static keys can not be logged out, but revoked by their ID.
+	a := rest.NewAdapter()
+	a.Handle("/admin/users/{userId}/revoke", a.RevokeSubjectHandler(rs, keys)).Methods("POST")
+	a.Handle("/admin/revocations", a.RevokeTokenHandler(rs)).Methods("POST")
+	res := httptest.NewRecorder()
+	a.R.ServeHTTP(res, httptest.NewRequest("POST", "/admin/users/integration-bot/revoke", nil))
+	assert.Equal(t, http.StatusConflict, res.Code)
+	assert.Equal(t, http.StatusOK, do())
+	res = httptest.NewRecorder()
+	a.R.ServeHTTP(res, httptest.NewRequest("POST", "/admin/revocations", strings.NewReader(`{"jti":"bot-key"}`)))
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	assert.Equal(t, http.StatusUnauthorized, do())
+}
+
+func TestForcedLogoutOfBasicUser(t *testing.T) {
+	cfg := config.Default().Auth
+	cfg.Schemes = []string{"basic"}
+	cfg.User = config.User{ID: "alice", Username: "alice", Password: "s3cr3t"}
+	chain, err := rest.NewAuthChain(cfg, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := rest.NewRevocations()
+	h := chain.WithRevocations(rs).Authenticate(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {})
+	do := func() int {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/activities", nil)
+		req.SetBasicAuth("alice", "s3cr3t")
+		h(req.Context(), res, req)
+		return res.Code
+	}
+	assert.Equal(t, http.StatusOK, do())
+
+	// The endpoint refuses to pretend a logout of users sending their
+	// credentials with every request.
+	a := rest.NewAdapter()
+	a.Handle("/admin/users/{userId}/revoke", a.RevokeSubjectHandler(rs, rest.SingleUser(cfg.User))).Methods("POST")
+	res := httptest.NewRecorder()
+	a.R.ServeHTTP(res, httptest.NewRequest("POST", "/admin/users/alice/revoke", nil))
+	assert.Equal(t, http.StatusConflict, res.Code)
+	assert.Equal(t, "application/problem+json", res.Header().Get("Content-Type"))
+	assert.Equal(t, http.StatusOK, do())
+
+	// Revoking the subject anyway does not lock the user out for good.
+	assert.NoError(t, rs.RevokeSubject("alice", time.Now().Add(time.Second).Truncate(time.Second)))
+	assert.Eventually(t, func() bool { return do() == http.StatusOK }, 3*time.Second, 100*time.Millisecond)
+
+	// Other users are logged out.
+	res = httptest.NewRecorder()
+	a.R.ServeHTTP(res, httptest.NewRequest("POST", "/admin/users/bob/revoke", nil))
+	assert.Equal(t, http.StatusNoContent, res.Code)
+}
+
+func TestIntrospector(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	sign := func(jti, sub string) string {
+		var c rest.Claims
+		c.ID = jti
+		c.Issuer = "invoice.mvp"
+		c.Audience = jwt.ClaimStrings{"invoice-mvp"}
+		c.Subject = sub
+		c.ExpiresAt = jwt.At(time.Now().Add(time.Minute))
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	active, revoked, other := sign("1", "alice"), sign("2", "alice"), sign("3", "bob")
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "invoice-mvp" || p != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = r.ParseForm()
+		token := r.Form.Get("token")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"active": token == active || token == other, "sub": "alice"})
+	}))
+	defer idp.Close()
+
+	sessions, err := rest.NewSessions(rest.NewMemorySessions(), make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtAuth := rest.NewJWTAuthenticator("invoice.mvp", "invoice.mvp", "invoice-mvp",
+		func(*jwt.Token) (interface{}, error) { return key, nil })
+	login := func(token string) *http.Cookie {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		c, err := jwtAuth.Authenticate(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		if _, err := sessions.Start(rr, c); err != nil {
+			t.Fatal(err)
+		}
+		return cookie(rr.Result(), rest.SessionCookie)
+	}
+	var apiKey rest.Claims
+	apiKey.Subject = "bob"
+	chain := rest.AuthChain{jwtAuth, sessions, staticClaims(apiKey)}
+
+	i := rest.NewIntrospector(idp.URL, "invoice-mvp", "s3cr3t")
+	var called bool
+	h := chain.Authenticate(i.Require(func(ctx context.Context, w http.ResponseWriter, r *http.Request) { called = true }))
+
+	tests := []struct {
+		name   string
+		auth   string
+		cookie *http.Cookie
+		ok     bool
+	}{
+		{"active", "Bearer " + active, nil, true},
+		{"inactive", "Bearer " + revoked, nil, false},
+		{"subject mismatch", "Bearer " + other, nil, false},
+		{"session with active token", "", login(active), true},
+		{"session with inactive token", "", login(revoked), false},
+		{"no token", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/invoices", nil)
+			if len(tt.auth) > 0 {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			h(req.Context(), res, req)
+			assert.Equal(t, tt.ok, called)
+			if !tt.ok {
+				assert.Equal(t, http.StatusUnauthorized, res.Code)
+			}
+		})
+	}
+}
+
+// staticClaims authenticates every request with the claims, like an API key.
+type staticClaims rest.Claims
+
+func (c staticClaims) Authenticate(*http.Request) (rest.Claims, error) { return rest.Claims(c), nil }
+func (c staticClaims) Challenge(http.ResponseWriter)                   {}
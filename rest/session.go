@@ -0,0 +1,358 @@
+package rest
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Session cookie and CSRF header names.
+const (
+	SessionCookie = "mvp_session"
+	CSRFHeader    = "X-CSRF-Token"
+)
+
+// DefaultSessionTTL is the lifetime of a browser session.
+const DefaultSessionTTL = 8 * time.Hour
+
+// Session errors.
+var (
+	ErrNoSession   = errors.New("session not found or expired")
+	ErrInvalidCSRF = errors.New("missing or invalid csrf token")
+)
+
+// Session is a browser session established by the login flow.
+type Session struct {
+	Claims  Claims    `json:"claims"`
+	CSRF    string    `json:"csrf"`
+	Expires time.Time `json:"expires"`
+	// AccessToken the claims were taken from, for introspection.
+	AccessToken string `json:"accessToken,omitempty"`
+}
+
+// SessionStore keeps sessions by session ID.
+type SessionStore interface {
+	Save(id string, s Session) error
+	Get(id string) (Session, bool)
+	Delete(id string)
+}
+
+// MemorySessions is a SessionStore lost on restart. IDs are kept as SHA-256
+// digests. Expired sessions are dropped when a session is saved.
+type MemorySessions struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// NewMemorySessions instantiates an empty in-memory store.
+func NewMemorySessions() *MemorySessions {
+	return &MemorySessions{now: time.Now, sessions: make(map[string]Session)}
+}
+
+// Save implements the SessionStore interface.
+func (m *MemorySessions) Save(id string, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for k, v := range m.sessions {
+		if now.After(v.Expires) {
+			delete(m.sessions, k)
+		}
+	}
+	m.sessions[digestID(id)] = s
+	return nil
+}
+
+// Len returns the number of sessions kept.
+func (m *MemorySessions) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// Get implements the SessionStore interface.
+func (m *MemorySessions) Get(id string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[digestID(id)]
+	return s, ok
+}
+
+// Delete implements the SessionStore interface.
+func (m *MemorySessions) Delete(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, digestID(id))
+}
+
+// FileSessions is a SessionStore persisted as JSON file, so sessions survive
+// restarts. Expired sessions are dropped when the file is written.
+type FileSessions struct {
+	path string
+	now  func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// OpenFileSessions loads the sessions of the file, which is created on the
+// first save.
+func OpenFileSessions(path string) (*FileSessions, error) {
+	f := FileSessions{path: path, now: time.Now, sessions: make(map[string]Session)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &f, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading session store")
+	}
+	if err := json.Unmarshal(b, &f.sessions); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling session store")
+	}
+	return &f, nil
+}
+
+// Save implements the SessionStore interface.
+func (f *FileSessions) Save(id string, s Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions[digestID(id)] = s
+	return f.save()
+}
+
+// Get implements the SessionStore interface.
+func (f *FileSessions) Get(id string) (Session, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.sessions[digestID(id)]
+	return s, ok
+}
+
+// Delete implements the SessionStore interface.
+func (f *FileSessions) Delete(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sessions, digestID(id))
+	if err := f.save(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (f *FileSessions) save() error {
+	now := f.now()
+	for id, s := range f.sessions {
+		if now.After(s.Expires) {
+			delete(f.sessions, id)
+		}
+	}
+	b, err := json.Marshal(f.sessions)
+	if err != nil {
+		return errors.Wrap(err, "marshalling session store")
+	}
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "writing session store")
+	}
+	return errors.Wrap(os.Rename(tmp, f.path), "replacing session store")
+}
+
+// Sessions authenticates browsers by an encrypted session cookie. It
+// implements the Authenticator interface, state-changing requests must
+// carry the CSRF token of the session in the X-CSRF-Token header.
+type Sessions struct {
+	store SessionStore
+	aead  cipher.AEAD
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewSessions instantiates the session authenticator. The cookie is
+// encrypted with AES-GCM using the 16, 24 or 32 byte key.
+func NewSessions(store SessionStore, key []byte) (*Sessions, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating session cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating session cipher")
+	}
+	return &Sessions{store: store, aead: aead, ttl: DefaultSessionTTL, now: time.Now}, nil
+}
+
+// SetTTL changes the lifetime of new sessions.
+func (s *Sessions) SetTTL(d time.Duration) {
+	s.ttl = d
+}
+
+// Start creates a session for the claims and sets the session cookie. The
+// session ends with the claims at the latest.
+func (s *Sessions) Start(w http.ResponseWriter, claims Claims) (Session, error) {
+	id := randomToken()
+	sess := Session{Claims: claims, CSRF: randomToken(), Expires: s.expires(claims), AccessToken: claims.accessToken}
+	return sess, s.save(w, id, sess)
+}
+
+// Refresh replaces the claims of the session of the request, e.g. after the
+// access token was refreshed, and extends the session accordingly. A new
+// session is started when the request has none of the subject.
+func (s *Sessions) Refresh(w http.ResponseWriter, r *http.Request, claims Claims) (Session, error) {
+	id, ok := s.id(r)
+	if !ok {
+		return s.Start(w, claims)
+	}
+	sess, ok := s.store.Get(id)
+	if !ok || sess.Claims.Subject != claims.Subject {
+		return s.Start(w, claims)
+	}
+	sess.Claims, sess.AccessToken, sess.Expires = claims, claims.accessToken, s.expires(claims)
+	return sess, s.save(w, id, sess)
+}
+
+// expires caps the lifetime of a session at the expiry of the claims.
+func (s *Sessions) expires(claims Claims) time.Time {
+	t := s.now().Add(s.ttl)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(t) {
+		return claims.ExpiresAt.Time
+	}
+	return t
+}
+
+// save stores the session and sets the session cookie.
+func (s *Sessions) save(w http.ResponseWriter, id string, sess Session) error {
+	if err := s.store.Save(id, sess); err != nil {
+		return errors.Wrap(err, "saving session")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    s.seal(id),
+		Path:     "/",
+		Expires:  sess.Expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// End deletes the session of the request and clears the cookie.
+func (s *Sessions) End(w http.ResponseWriter, r *http.Request) {
+	if id, ok := s.id(r); ok {
+		s.store.Delete(id)
+	}
+	clearCookie(w, SessionCookie, "/")
+}
+
+// Authenticate implements the Authenticator interface.
+func (s *Sessions) Authenticate(r *http.Request) (Claims, error) {
+	sess, err := s.session(r)
+	if err != nil {
+		return Claims{}, err
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+	default:
+		token := r.Header.Get(CSRFHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRF)) != 1 {
+			return Claims{}, ErrInvalidCSRF
+		}
+	}
+	sess.Claims.accessToken = sess.AccessToken
+	return sess.Claims, nil
+}
+
+// Challenge implements the Authenticator interface. There is no challenge
+// for cookies, browsers are sent to /auth/login instead.
+func (s *Sessions) Challenge(w http.ResponseWriter) {}
+
+// Auth decorator populates the claims from the session, like JWTAuth does
+// from the bearer token.
+func (s *Sessions) Auth(next Handler) Handler {
+	return AuthChain{s}.Authenticate(next)
+}
+
+// InfoHandler returns the subject, roles and CSRF token of the session, so
+// a browser frontend can pick up the CSRF token after a reload.
+func (s *Sessions) InfoHandler() Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		sess, err := s.session(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeSessionInfo(w, sess)
+	}
+}
+
+func (s *Sessions) session(r *http.Request) (Session, error) {
+	id, ok := s.id(r)
+	if !ok {
+		return Session{}, ErrNoCredentials
+	}
+	sess, ok := s.store.Get(id)
+	if !ok || s.now().After(sess.Expires) {
+		return Session{}, ErrNoSession
+	}
+	if exp := sess.Claims.ExpiresAt; exp != nil && s.now().After(exp.Time) {
+		return Session{}, ErrNoSession
+	}
+	return sess, nil
+}
+
+// id decrypts the session ID from the cookie.
+func (s *Sessions) id(r *http.Request) (string, bool) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return "", false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || len(b) < s.aead.NonceSize() {
+		return "", false
+	}
+	nonce, sealed := b[:s.aead.NonceSize()], b[s.aead.NonceSize():]
+	id, err := s.aead.Open(nil, nonce, sealed, []byte(SessionCookie))
+	if err != nil {
+		return "", false
+	}
+	return string(id), true
+}
+
+func (s *Sessions) seal(id string) string {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	b := s.aead.Seal(nonce, nonce, []byte(id), []byte(SessionCookie))
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeSessionInfo(w http.ResponseWriter, sess Session) {
+	b, err := json.Marshal(struct {
+		Subject   string    `json:"subject"`
+		Roles     []string  `json:"roles"`
+		CSRFToken string    `json:"csrfToken"`
+		Expires   time.Time `json:"expires"`
+	}{sess.Claims.Subject, sess.Claims.Roles, sess.CSRF, sess.Expires})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,153 @@
+package rest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+func TestSessions(t *testing.T) {
+	key := make([]byte, 32)
+	s, err := rest.NewSessions(rest.NewMemorySessions(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c rest.Claims
+	c.Subject = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+	c.Roles = []string{rest.RoleUser}
+
+	rr := httptest.NewRecorder()
+	sess, err := s.Start(rr, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := cookie(rr.Result(), rest.SessionCookie)
+	if session == nil {
+		t.Fatal("session cookie missing")
+	}
+	assert.True(t, session.HttpOnly)
+	assert.True(t, session.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, session.SameSite)
+	assert.NotContains(t, session.Value, sess.CSRF)
+
+	var subject string
+	h := s.Auth(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		subject = ctx.Value(rest.Key).(rest.Claims).Subject
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		cookie *http.Cookie
+		csrf   string
+		status int
+	}{
+		{"no cookie", "GET", nil, "", http.StatusUnauthorized},
+		{"get", "GET", session, "", http.StatusOK},
+		{"post without csrf token", "POST", session, "", http.StatusUnauthorized},
+		{"post with wrong csrf token", "POST", session, "guess", http.StatusUnauthorized},
+		{"post with csrf token", "POST", session, sess.CSRF, http.StatusOK},
+		{"tampered cookie", "GET", &http.Cookie{Name: rest.SessionCookie, Value: session.Value[:len(session.Value)-2] + "AA"}, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject = ""
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/activities", nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			if len(tt.csrf) > 0 {
+				req.Header.Set(rest.CSRFHeader, tt.csrf)
+			}
+			h(req.Context(), rr, req)
+			assert.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, c.Subject, subject)
+			}
+		})
+	}
+
+	// The session ends on logout.
+	req := httptest.NewRequest("POST", "/auth/logout", nil)
+	req.AddCookie(session)
+	s.End(httptest.NewRecorder(), req)
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/activities", nil)
+	req.AddCookie(session)
+	h(req.Context(), rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestMemorySessionsPrune(t *testing.T) {
+	m := rest.NewMemorySessions()
+	assert.NoError(t, m.Save("expired", rest.Session{Expires: time.Now().Add(-time.Minute)}))
+	assert.NoError(t, m.Save("active", rest.Session{Expires: time.Now().Add(time.Hour)}))
+	assert.Equal(t, 1, m.Len())
+	_, ok := m.Get("expired")
+	assert.False(t, ok)
+	_, ok = m.Get("active")
+	assert.True(t, ok)
+}
+
+func TestFileSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	fs, err := rest.OpenFileSessions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, 32)
+	s, _ := rest.NewSessions(fs, key)
+	var c rest.Claims
+	c.Subject = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+	c.Roles = []string{rest.RoleAdmin}
+	rr := httptest.NewRecorder()
+	if _, err := s.Start(rr, c); err != nil {
+		t.Fatal(err)
+	}
+	session := cookie(rr.Result(), rest.SessionCookie)
+
+	// Sessions survive a restart.
+	fs, err = rest.OpenFileSessions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ = rest.NewSessions(fs, key)
+	req := httptest.NewRequest("GET", "/activities", nil)
+	req.AddCookie(session)
+	got, err := s.Authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, c.Subject, got.Subject)
+	assert.Equal(t, c.Roles, got.Roles)
+}
+
+func TestSessionsEndWithClaims(t *testing.T) {
+	s, err := rest.NewSessions(rest.NewMemorySessions(), make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c rest.Claims
+	c.Subject = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+	c.ExpiresAt = jwt.At(time.Now().Add(time.Second))
+	rr := httptest.NewRecorder()
+	sess, err := s.Start(rr, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, c.ExpiresAt.Time, sess.Expires)
+
+	req := httptest.NewRequest("GET", "/activities", nil)
+	req.AddCookie(cookie(rr.Result(), rest.SessionCookie))
+	_, err = s.Authenticate(req)
+	assert.NoError(t, err)
+	time.Sleep(1100 * time.Millisecond)
+	_, err = s.Authenticate(req)
+	assert.Equal(t, rest.ErrNoSession, err)
+}
@@ -0,0 +1,285 @@
+package rest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/config"
+)
+
+// TLSOptions configure the TLS server.
+type TLSOptions struct {
+	Addr         string
+	CertFile     string
+	KeyFile      string
+	MinVersion   uint16
+	CipherSuites []uint16 // TLS 1.2 only, nil takes the Go defaults
+	// ReloadInterval is the period of checking the certificate files for
+	// changes. Reloads are also triggered by SIGHUP.
+	ReloadInterval time.Duration
+	// Dev serves a generated self-signed certificate when the certificate
+	// files do not exist.
+	Dev bool
+
+	// Timeouts of the http.Server, see there.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is the deadline for in-flight requests to complete
+	// once the server is shut down.
+	ShutdownTimeout time.Duration
+}
+
+// NewTLSOptions converts the server configuration.
+func NewTLSOptions(c config.Server) (TLSOptions, error) {
+	opts := TLSOptions{
+		Addr:              c.Addr,
+		CertFile:          c.CertFile,
+		KeyFile:           c.KeyFile,
+		ReloadInterval:    time.Duration(c.ReloadInterval),
+		Dev:               c.Dev,
+		ReadHeaderTimeout: time.Duration(c.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(c.ReadTimeout),
+		WriteTimeout:      time.Duration(c.WriteTimeout),
+		IdleTimeout:       time.Duration(c.IdleTimeout),
+		ShutdownTimeout:   time.Duration(c.ShutdownTimeout),
+	}
+	var err error
+	if opts.MinVersion, err = ParseTLSVersion(c.MinVersion); err != nil {
+		return opts, err
+	}
+	if opts.CipherSuites, err = ParseCipherSuites(strings.Join(c.CipherSuites, ",")); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// ParseTLSVersion parses "1.2" or "1.3".
+func ParseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimSpace(s) {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, errors.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", s)
+}
+
+// ParseCipherSuites parses a comma separated list of cipher suite names,
+// e.g. "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256". Insecure suites are
+// rejected.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) < 1 {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, errors.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// CertReloader serves the certificate of a key pair and reloads it when the
+// files change, so renewed certificates are used for new handshakes without
+// a restart. Established connections are not affected.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCertReloader loads the key pair.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
+
+// Reload reads the key pair. On error the current certificate is kept.
+func (cr *CertReloader) Reload() error {
+	mod := cr.lastModified()
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "loading key pair")
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert = &cert
+	cr.modTime = mod
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// Watch reloads the key pair when the files changed or SIGHUP is received,
+// until Stop is called.
+func (cr *CertReloader) Watch(interval time.Duration) {
+	cr.stop = make(chan struct{})
+	cr.done = make(chan struct{})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer close(cr.done)
+		defer signal.Stop(hup)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				cr.mu.RLock()
+				changed := cr.lastModified().After(cr.modTime)
+				cr.mu.RUnlock()
+				if !changed {
+					continue
+				}
+			case <-hup:
+			case <-cr.stop:
+				return
+			}
+			if err := cr.Reload(); err != nil {
+				log.Println("tls: keeping current certificate:", err)
+				continue
+			}
+			log.Println("tls: reloaded certificate", cr.certFile)
+		}
+	}()
+}
+
+// Stop ends watching the files.
+func (cr *CertReloader) Stop() {
+	if cr.stop == nil {
+		return
+	}
+	close(cr.stop)
+	<-cr.done
+	cr.stop = nil
+}
+
+// lastModified returns the latest modification time of the files.
+func (cr *CertReloader) lastModified() time.Time {
+	var t time.Time
+	for _, f := range []string{cr.certFile, cr.keyFile} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+// DevCertificate generates an in-memory CA and a server certificate signed
+// by it for the hosts. The CA certificate is returned PEM encoded, so
+// clients can be told to trust it.
+func DevCertificate(hosts ...string) (tls.Certificate, []byte, error) {
+	var cert tls.Certificate
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return cert, nil, err
+	}
+	now := time.Now()
+	ca := x509.Certificate{
+		SerialNumber:          big.NewInt(now.UnixNano()),
+		Subject:               pkix.Name{Organization: []string{"invoice-mvp development CA"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, &ca, &ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return cert, nil, errors.Wrap(err, "creating CA certificate")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return cert, nil, err
+	}
+	leaf := x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano() + 1),
+		Subject:      pkix.Name{Organization: []string{"invoice-mvp development"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			leaf.IPAddresses = append(leaf.IPAddresses, ip)
+		} else {
+			leaf.DNSNames = append(leaf.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &leaf, &ca, &key.PublicKey, caKey)
+	if err != nil {
+		return cert, nil, errors.Wrap(err, "creating server certificate")
+	}
+	cert = tls.Certificate{Certificate: [][]byte{der, caDER}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), nil
+}
+
+// TLSConfig builds the server TLS config of the options. The returned
+// reloader is nil in dev mode without certificate files; otherwise the
+// caller starts and stops watching.
+func (a Adapter) TLSConfig(opts TLSOptions) (*tls.Config, *CertReloader, error) {
+	cfg := &tls.Config{}
+	if a.tls != nil {
+		cfg = a.tls.Clone()
+	}
+	cfg.MinVersion = opts.MinVersion
+	cfg.CipherSuites = opts.CipherSuites
+
+	_, err := os.Stat(opts.CertFile)
+	if os.IsNotExist(err) && opts.Dev {
+		cert, caPEM, err := DevCertificate("localhost", "127.0.0.1", "::1")
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("dev mode: serving a generated certificate, trust this CA:\n%s", caPEM)
+		cfg.Certificates = []tls.Certificate{cert}
+		return cfg, nil, nil
+	}
+	cr, err := NewCertReloader(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.GetCertificate = cr.GetCertificate
+	return cfg, cr, nil
+}
@@ -0,0 +1,166 @@
+package rest_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+func TestParseTLSOptions(t *testing.T) {
+	v, err := rest.ParseTLSVersion("1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+	_, err = rest.ParseTLSVersion("1.0")
+	assert.Error(t, err)
+
+	cs, err := rest.ParseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, cs)
+	_, err = rest.ParseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	assert.Error(t, err, "insecure suites are rejected")
+}
+
+// writeKeyPair writes a generated key pair to the files.
+func writeKeyPair(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+	cert, _, err := rest.DevCertificate("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	return leaf
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	first := writeKeyPair(t, certFile, keyFile)
+
+	cr, err := rest.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := cr.GetCertificate(nil)
+	assert.Equal(t, first.Raw, cert.Certificate[0])
+
+	second := writeKeyPair(t, certFile, keyFile)
+	assert.NoError(t, cr.Reload())
+	cert, _ = cr.GetCertificate(nil)
+	assert.Equal(t, second.Raw, cert.Certificate[0])
+
+	// A broken key pair keeps the current certificate.
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+	assert.Error(t, cr.Reload())
+	cert, _ = cr.GetCertificate(nil)
+	assert.Equal(t, second.Raw, cert.Certificate[0])
+}
+
+func TestDevCertificate(t *testing.T) {
+	cert, caPEM, err := rest.DevCertificate("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	assert.True(t, pool.AppendCertsFromPEM(caPEM))
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool})
+		assert.NoError(t, err, host)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: pool})
+	assert.Error(t, err)
+}
+
+func TestAdapterTLSConfig(t *testing.T) {
+	opts, _ := rest.NewTLSOptions(config.Default().Server)
+	opts.CertFile = filepath.Join(t.TempDir(), "missing.pem")
+	opts.KeyFile = filepath.Join(t.TempDir(), "missing-key.pem")
+
+	a := rest.NewAdapter()
+	_, _, err := a.TLSConfig(opts)
+	assert.Error(t, err, "certificate files are required outside dev mode")
+
+	opts.Dev = true
+	cfg, cr, err := a.TLSConfig(opts)
+	assert.NoError(t, err)
+	assert.Nil(t, cr)
+	assert.Len(t, cfg.Certificates, 1)
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+}
+
+func TestServeTLSShutdown(t *testing.T) {
+	serve := func(delay, shutdown time.Duration) (int, error) {
+		started := make(chan struct{})
+		a := rest.NewAdapter()
+		a.Handle("/slow", func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(delay)
+			w.WriteHeader(http.StatusOK)
+		})
+		opts, _ := rest.NewTLSOptions(config.Default().Server)
+		opts.CertFile = filepath.Join(t.TempDir(), "missing.pem")
+		opts.Dev = true
+		opts.ShutdownTimeout = shutdown
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan error, 1)
+		go func() { done <- a.ServeTLS(ctx, ln, opts) }()
+
+		status := make(chan int, 1)
+		go func() {
+			c := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+			res, err := c.Get("https://" + ln.Addr().String() + "/slow")
+			if err != nil {
+				status <- 0
+				return
+			}
+			res.Body.Close()
+			status <- res.StatusCode
+		}()
+		<-started
+		cancel() // e.g. SIGTERM
+		err = <-done
+		return <-status, err
+	}
+
+	// In-flight requests complete within the shutdown timeout.
+	code, err := serve(100*time.Millisecond, 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	// Requests exceeding the shutdown timeout are cut.
+	code, err = serve(500*time.Millisecond, 50*time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, 0, code)
+}
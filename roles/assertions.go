@@ -18,11 +18,11 @@ type RoleRepository interface {
 	GetInvoice(id int, join ...string) domain.Invoice
 }
 
-// AssertAdmin decorator.
-func AssertAdmin(next rest.Handler) rest.Handler {
+// AssertAdmin decorator challenges non-admins for the realm.
+func AssertAdmin(next rest.Handler, realm string) rest.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		if !isAdmin(ctx) {
-			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", rest.Realm()))
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
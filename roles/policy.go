@@ -0,0 +1,330 @@
+package roles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/rest"
+)
+
+// Permission names an action on a resource type, e.g. "invoice:charge".
+type Permission string
+
+// These are the permissions handlers declare requirements against.
+const (
+	APIKeyRead     Permission = "apikey:read"
+	APIKeyWrite    Permission = "apikey:write"
+	ActivityRead   Permission = "activity:read"
+	ActivityWrite  Permission = "activity:write"
+	BookingWrite   Permission = "booking:write"
+	CustomerWrite  Permission = "customer:write"
+	InvoiceRead    Permission = "invoice:read"
+	InvoiceWrite   Permission = "invoice:write"
+	InvoiceCharge  Permission = "invoice:charge"
+	InvoicePayment Permission = "invoice:payment"
+	ProfileRead    Permission = "profile:read"
+	ProfileWrite   Permission = "profile:write"
+	ProjectWrite   Permission = "project:write"
+	RateWrite      Permission = "rate:write"
+	TokenRevoke    Permission = "token:revoke"
+)
+
+// Scope restricts a granted permission to a set of resources.
+type Scope string
+
+// These are the supported grant scopes.
+const (
+	// ScopeAny grants the permission on every resource.
+	ScopeAny Scope = "any"
+	// ScopeOwn grants the permission only on resources owned by the user.
+	ScopeOwn Scope = "own"
+)
+
+// Grant binds a permission pattern to a scope. The pattern is either a
+// permission, a resource wildcard like "invoice:*" or the catch-all "*".
+type Grant struct {
+	Pattern string
+	Scope   Scope
+}
+
+// ParseGrant parses the config representation of a grant. A trailing "@own"
+// restricts the grant to owned resources, e.g. "booking:write@own".
+func ParseGrant(s string) (Grant, error) {
+	g := Grant{Pattern: strings.TrimSpace(s), Scope: ScopeAny}
+	if i := strings.LastIndex(g.Pattern, "@"); i >= 0 {
+		g.Scope = Scope(g.Pattern[i+1:])
+		g.Pattern = g.Pattern[:i]
+	}
+	if g.Scope != ScopeAny && g.Scope != ScopeOwn {
+		return g, errors.Errorf("unknown scope %q in grant %q", g.Scope, s)
+	}
+	if g.Pattern == "*" {
+		return g, nil
+	}
+	parts := strings.Split(g.Pattern, ":")
+	if len(parts) != 2 || len(parts[0]) < 1 || len(parts[1]) < 1 {
+		return g, errors.Errorf("malformed permission %q, expected <resource>:<action>", g.Pattern)
+	}
+	return g, nil
+}
+
+// Matches reports whether the grant covers the permission.
+func (g Grant) Matches(p Permission) bool {
+	if g.Pattern == "*" || g.Pattern == string(p) {
+		return true
+	}
+	if strings.HasSuffix(g.Pattern, ":*") {
+		return strings.HasPrefix(string(p), strings.TrimSuffix(g.Pattern, "*"))
+	}
+	return false
+}
+
+// InScopes reports whether one of the scopes, given as permission patterns
+// like "invoice:*", covers the permission.
+func InScopes(scopes []string, perm Permission) bool {
+	for _, s := range scopes {
+		if (Grant{Pattern: s, Scope: ScopeAny}).Matches(perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy maps roles to the permissions they grant.
+type Policy struct {
+	grants map[string][]Grant
+	realm  string // challenged when claims are missing
+}
+
+// PolicyConfig is the JSON representation of a policy.
+//
+//	{"roles": {"ACCOUNTANT": ["invoice:read", "invoice:payment"]}}
+type PolicyConfig struct {
+	Roles map[string][]string `json:"roles"`
+}
+
+// NewPolicy builds a policy from a role to grants mapping.
+func NewPolicy(cfg PolicyConfig) (*Policy, error) {
+	p := Policy{grants: make(map[string][]Grant, len(cfg.Roles))}
+	for role, gs := range cfg.Roles {
+		if len(role) < 1 {
+			return nil, errors.New("policy contains an empty role name")
+		}
+		for _, s := range gs {
+			g, err := ParseGrant(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "role %s", role)
+			}
+			p.grants[role] = append(p.grants[role], g)
+		}
+	}
+	return &p, nil
+}
+
+// LoadPolicy reads a JSON policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading policy file")
+	}
+	var cfg PolicyConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling policy file")
+	}
+	return NewPolicy(cfg)
+}
+
+// DefaultPolicy mirrors the built-in ADMIN and USER roles: admins may do
+// everything, users manage their own customers and invoices but may not
+// create projects.
+func DefaultPolicy() *Policy {
+	p, err := NewPolicy(PolicyConfig{Roles: map[string][]string{
+		rest.RoleAdmin: {"*"},
+		rest.RoleUser: {
+			"activity:*",
+			"apikey:*",
+			"booking:write@own",
+			"customer:write",
+			"invoice:*@own",
+			"profile:*@own",
+			"rate:write@own",
+		},
+	}})
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// SetRealm sets the realm challenged for unauthenticated requests.
+func (p *Policy) SetRealm(realm string) {
+	p.realm = realm
+}
+
+// Roles returns the role names known to the policy.
+func (p *Policy) Roles() []string {
+	rs := make([]string, 0, len(p.grants))
+	for r := range p.grants {
+		rs = append(rs, r)
+	}
+	sort.Strings(rs)
+	return rs
+}
+
+// Allowed returns the widest scope in which any of the roles is granted the
+// permission.
+func (p *Policy) Allowed(roles []string, perm Permission) (Scope, bool) {
+	var scope Scope
+	for _, r := range roles {
+		for _, g := range p.grants[r] {
+			if !g.Matches(perm) {
+				continue
+			}
+			if g.Scope == ScopeAny {
+				return ScopeAny, true
+			}
+			scope = g.Scope
+		}
+	}
+	return scope, len(scope) > 0
+}
+
+// Owner resolves the ID of the user owning the resource addressed by the
+// request.
+type Owner func(r *http.Request) (string, bool)
+
+// CustomerOwner resolves ownership through the customerId route variable.
+func CustomerOwner(rep RoleRepository) Owner {
+	return func(r *http.Request) (string, bool) {
+		id, err := strconv.Atoi(mux.Vars(r)["customerId"])
+		if err != nil {
+			return "", false
+		}
+		c := rep.CustomerByID(id)
+		return c.UserID, len(c.UserID) > 0
+	}
+}
+
+// InvoiceOwner resolves ownership through the invoiceId route variable. On
+// routes with a customerId variable too, the invoice must belong to that
+// customer.
+func InvoiceOwner(rep RoleRepository) Owner {
+	return func(r *http.Request) (string, bool) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["invoiceId"])
+		if err != nil {
+			return "", false
+		}
+		i := rep.GetInvoice(id)
+		if v, ok := vars["customerId"]; ok && v != strconv.Itoa(i.CustomerID) {
+			return "", false
+		}
+		c := rep.CustomerByID(i.CustomerID)
+		return c.UserID, len(c.UserID) > 0
+	}
+}
+
+// Charges reports whether the invoice update of the request charges the
+// invoice, i.e. moves it to "ready for aggregation" or "payment expected".
+func Charges(r *http.Request) bool {
+	s := updatedStatus(r)
+	return s == "ready for aggregation" || s == "payment expected"
+}
+
+// Pays reports whether the invoice update of the request records the
+// payment of the invoice.
+func Pays(r *http.Request) bool {
+	return updatedStatus(r) == "paid"
+}
+
+// maxPeek limits the body read to tell the status of an invoice update.
+const maxPeek = 1 << 20
+
+// updatedStatus returns the status of the invoice in the body of the
+// request. The body is restored for the handler.
+func updatedStatus(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPeek))
+	r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(b), r.Body))
+	if err != nil {
+		return ""
+	}
+	var i struct {
+		Status string `json:"status"`
+	}
+	_ = json.Unmarshal(b, &i)
+	return i.Status
+}
+
+// Self resolves the current user as owner, for routes that only address
+// resources of the user, e.g. listings filtered by the user.
+func Self(r *http.Request) (string, bool) {
+	c, ok := r.Context().Value(rest.Key).(rest.Claims)
+	return c.Subject, ok && len(c.Subject) > 0
+}
+
+// Require decorator asserts the roles in the claims grant the permission.
+// Grants scoped to owned resources are checked against the owner resolved
+// from the request; without an owner resolver they never apply. Claims
+// carrying scopes must also list the permission in one of them.
+func (p *Policy) Require(next rest.Handler, perm Permission, owner ...Owner) rest.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		claims, ok := ctx.Value(rest.Key).(rest.Claims)
+		if !ok {
+			log.Println("claims missing from context")
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", p.realm))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !p.permits(claims, perm, r.WithContext(ctx), owner) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(ctx, w, r) // call request handler
+	}
+}
+
+// RequireIf decorator asserts the permission like Require, but only for
+// requests the condition holds for, e.g. ones creating further resources.
+func (p *Policy) RequireIf(next rest.Handler, cond func(r *http.Request) bool, perm Permission, owner ...Owner) rest.Handler {
+	required := p.Require(next, perm, owner...)
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		if cond(r) {
+			required(ctx, w, r)
+			return
+		}
+		next(ctx, w, r)
+	}
+}
+
+func (p *Policy) permits(c rest.Claims, perm Permission, r *http.Request, owner []Owner) bool {
+	if len(c.Scopes) > 0 && !InScopes(c.Scopes, perm) {
+		return false
+	}
+	scope, ok := p.Allowed(c.Roles, perm)
+	if !ok {
+		return false
+	}
+	if scope == ScopeAny {
+		return true
+	}
+	for _, o := range owner {
+		if uid, ok := o(r); !ok || uid != c.Subject {
+			return false
+		}
+	}
+	return len(owner) > 0
+}
@@ -0,0 +1,221 @@
+package roles_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/rest"
+	"github.com/tullo/invoice-mvp/roles"
+)
+
+const (
+	owner    = "f8c39a31-9ced-4761-8a33-b9c628a67510"
+	stranger = "d68df2ec-d79a-4fbd-b290-22ae3a91532b"
+)
+
+func TestParseGrant(t *testing.T) {
+	tests := []struct {
+		grant   string
+		want    roles.Grant
+		wantErr bool
+	}{
+		{"*", roles.Grant{Pattern: "*", Scope: roles.ScopeAny}, false},
+		{"invoice:charge", roles.Grant{Pattern: "invoice:charge", Scope: roles.ScopeAny}, false},
+		{"invoice:*@own", roles.Grant{Pattern: "invoice:*", Scope: roles.ScopeOwn}, false},
+		{"invoice", roles.Grant{}, true},
+		{"invoice:read@team", roles.Grant{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.grant, func(t *testing.T) {
+			g, err := roles.ParseGrant(tt.grant)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGrant() error: %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.want, g)
+			}
+		})
+	}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	p, err := roles.LoadPolicy("../auth/policy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		roles []string
+		perm  roles.Permission
+		scope roles.Scope
+		ok    bool
+	}{
+		{"admin charges any invoice", []string{rest.RoleAdmin}, roles.InvoiceCharge, roles.ScopeAny, true},
+		{"user charges own invoice", []string{rest.RoleUser}, roles.InvoiceCharge, roles.ScopeOwn, true},
+		{"user can not create projects", []string{rest.RoleUser}, roles.ProjectWrite, "", false},
+		{"accountant records payments", []string{"ACCOUNTANT"}, roles.InvoicePayment, roles.ScopeAny, true},
+		{"accountant can not charge", []string{"ACCOUNTANT"}, roles.InvoiceCharge, "", false},
+		{"widest scope wins", []string{rest.RoleUser, "ACCOUNTANT"}, roles.InvoiceRead, roles.ScopeAny, true},
+		{"unknown role", []string{"GUEST"}, roles.InvoiceRead, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, ok := p.Allowed(tt.roles, tt.perm)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.scope, scope)
+		})
+	}
+}
+
+func TestPolicyRequire(t *testing.T) {
+	r := database.NewFakeRepository()
+	c, _ := r.CreateCustomer(domain.Customer{Name: "3skills", UserID: owner})
+	_, _ = r.CreateInvoice(domain.Invoice{CustomerID: c.ID})
+
+	tests := []struct {
+		name   string
+		claims *rest.Claims
+		want   int
+	}{
+		{"no claims", nil, http.StatusUnauthorized},
+		{"owner", claims(owner, rest.RoleUser), http.StatusNoContent},
+		{"not the owner", claims(stranger, rest.RoleUser), http.StatusForbidden},
+		{"admin", claims(stranger, rest.RoleAdmin), http.StatusNoContent},
+		{"unknown role", claims(owner, "GUEST"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := rest.NewAdapter()
+			h := roles.DefaultPolicy().Require(noContent, roles.InvoiceCharge, roles.InvoiceOwner(r))
+			if tt.claims != nil {
+				h = withClaims(h, *tt.claims)
+			}
+			a.Handle("/charge/{invoiceId:[0-9]+}", h)
+
+			res := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/charge/1", nil)
+			a.R.ServeHTTP(res, req)
+			assert.Equal(t, tt.want, res.Result().StatusCode)
+		})
+	}
+}
+
+func TestInvoiceOwnerOfCustomer(t *testing.T) {
+	r := database.NewFakeRepository()
+	c, _ := r.CreateCustomer(domain.Customer{Name: "3skills", UserID: owner})
+	_, _ = r.CreateCustomer(domain.Customer{Name: "Acme", UserID: stranger})
+	_, _ = r.CreateInvoice(domain.Invoice{CustomerID: c.ID})
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"invoice of the customer", "/customers/1/invoices/1", http.StatusNoContent},
+		{"invoice of another customer", "/customers/2/invoices/1", http.StatusForbidden},
+		{"unknown customer", "/customers/3/invoices/1", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := rest.NewAdapter()
+			h := roles.DefaultPolicy().Require(noContent, roles.InvoiceWrite, roles.InvoiceOwner(r))
+			a.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", withClaims(h, *claims(owner, rest.RoleUser)))
+
+			res := httptest.NewRecorder()
+			a.R.ServeHTTP(res, httptest.NewRequest("PUT", tt.target, nil))
+			assert.Equal(t, tt.want, res.Result().StatusCode)
+		})
+	}
+}
+
+func TestPolicyRequireIf(t *testing.T) {
+	creates := func(r *http.Request) bool { return r.URL.Query().Get("create") == "true" }
+	tests := []struct {
+		name   string
+		target string
+		claims *rest.Claims
+		want   int
+	}{
+		{"user without condition", "/import", claims(owner, rest.RoleUser), http.StatusNoContent},
+		{"user with condition", "/import?create=true", claims(owner, rest.RoleUser), http.StatusForbidden},
+		{"admin with condition", "/import?create=true", claims(owner, rest.RoleAdmin), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := rest.NewAdapter()
+			h := roles.DefaultPolicy().RequireIf(noContent, creates, roles.ProjectWrite, roles.Self)
+			a.Handle("/import", withClaims(h, *tt.claims))
+
+			res := httptest.NewRecorder()
+			a.R.ServeHTTP(res, httptest.NewRequest("POST", tt.target, nil))
+			assert.Equal(t, tt.want, res.Result().StatusCode)
+		})
+	}
+}
+
+func TestPolicyTransitions(t *testing.T) {
+	p, err := roles.NewPolicy(roles.PolicyConfig{Roles: map[string][]string{
+		"CLERK":      {"invoice:write"},
+		"ACCOUNTANT": {"invoice:write", "invoice:payment"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		role string
+		body string
+		want int
+	}{
+		{"clerk updates", "CLERK", `{"status":"open","month":11}`, http.StatusNoContent},
+		{"clerk can not charge", "CLERK", `{"status":"ready for aggregation"}`, http.StatusForbidden},
+		{"clerk can not record payments", "CLERK", `{"status":"paid"}`, http.StatusForbidden},
+		{"accountant records payments", "ACCOUNTANT", `{"status":"paid"}`, http.StatusNoContent},
+		{"accountant can not charge", "ACCOUNTANT", `{"status":"payment expected"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+			h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+				w.WriteHeader(http.StatusNoContent)
+			}
+			h = p.RequireIf(h, roles.Charges, roles.InvoiceCharge, roles.Self)
+			h = p.RequireIf(h, roles.Pays, roles.InvoicePayment, roles.Self)
+			a := rest.NewAdapter()
+			a.Handle("/invoices/1", withClaims(h, *claims(owner, tt.role)))
+
+			res := httptest.NewRecorder()
+			a.R.ServeHTTP(res, httptest.NewRequest("PUT", "/invoices/1", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.want, res.Result().StatusCode)
+			if tt.want == http.StatusNoContent {
+				assert.Equal(t, tt.body, body, "body restored for the handler")
+			}
+		})
+	}
+}
+
+func claims(sub string, rs ...string) *rest.Claims {
+	var c rest.Claims
+	c.Subject = sub
+	c.Roles = rs
+	return &c
+}
+
+func withClaims(next rest.Handler, c rest.Claims) rest.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		next(context.WithValue(ctx, rest.Key, c), w, r)
+	}
+}
+
+func noContent(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,223 @@
+// Package timesheet reads the time entries of the detailed reports of time
+// tracking tools, exported as CSV: Toggl Track, Harvest and Clockify.
+//
+//	f, _ := timesheet.Lookup("toggl")
+//	es, err := f.Parse(file)
+package timesheet
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Entry is a time entry of an export, with the client, project and task
+// named as in the tool.
+type Entry struct {
+	Line        int // of the entry in the export, reported with its errors
+	Client      string
+	Project     string
+	Task        string
+	Date        time.Time
+	Hours       float32
+	Description string
+}
+
+// FieldError is an invalid field of an entry.
+type FieldError struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+// Errors lists the invalid fields of an export.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	return fmt.Sprintf("%d invalid fields", len(e))
+}
+
+// Format is the export format of a tool. The columns are matched ignoring
+// case.
+type Format struct {
+	Name string
+
+	client, project, task, description string // columns
+	date                               string // column
+	dateLayouts                        []string
+	hours                              string // column
+	parseHours                         func(s string) (float64, error)
+}
+
+// These are the supported formats.
+var (
+	// Toggl reads the detailed report of Toggl Track.
+	Toggl = &Format{
+		Name:        "toggl",
+		client:      "Client",
+		project:     "Project",
+		task:        "Task",
+		description: "Description",
+		date:        "Start date",
+		dateLayouts: []string{"2006-01-02"},
+		hours:       "Duration",
+		parseHours:  clock,
+	}
+	// Harvest reads the detailed time report of Harvest.
+	Harvest = &Format{
+		Name:        "harvest",
+		client:      "Client",
+		project:     "Project",
+		task:        "Task",
+		description: "Notes",
+		date:        "Date",
+		dateLayouts: []string{"2006-01-02", "01/02/2006"},
+		hours:       "Hours",
+		parseHours:  decimal,
+	}
+	// Clockify reads the detailed report of Clockify. Dates with slashes
+	// are read month first, as exported with the default settings.
+	Clockify = &Format{
+		Name:        "clockify",
+		client:      "Client",
+		project:     "Project",
+		task:        "Task",
+		description: "Description",
+		date:        "Start Date",
+		dateLayouts: []string{"01/02/2006", "2006-01-02", "02.01.2006"},
+		hours:       "Duration (decimal)",
+		parseHours:  decimal,
+	}
+)
+
+var formats = map[string]*Format{
+	Toggl.Name:    Toggl,
+	Harvest.Name:  Harvest,
+	Clockify.Name: Clockify,
+}
+
+// Lookup finds a format by name.
+func Lookup(name string) (*Format, bool) {
+	f, ok := formats[strings.ToLower(name)]
+	return f, ok
+}
+
+// Names returns the names of the supported formats.
+func Names() []string {
+	ns := make([]string, 0, len(formats))
+	for n := range formats {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+// Parse reads the entries of an export. A missing column or malformed CSV
+// is returned as is, invalid dates and hours are collected as Errors.
+func (f *Format) Parse(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		// Spreadsheet exports often start with a byte order mark.
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	index := make(map[string]int)
+	for _, col := range []string{f.client, f.project, f.task, f.description, f.date, f.hours} {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), col) {
+				index[col] = i
+				break
+			}
+		}
+		// Toggl exports lack the task column without the paid plans.
+		if _, ok := index[col]; !ok && col != f.task && col != f.description {
+			return nil, errors.Errorf("missing column %q of a %s export", col, f.Name)
+		}
+	}
+
+	var es []Entry
+	var errs Errors
+	for {
+		values, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		value := func(col string) string {
+			if i, ok := index[col]; ok && i < len(values) {
+				return strings.TrimSpace(values[i])
+			}
+			return ""
+		}
+		e := Entry{
+			Line:        line,
+			Client:      value(f.client),
+			Project:     value(f.project),
+			Task:        value(f.task),
+			Description: value(f.description),
+		}
+		if e.Date, err = f.parseDate(value(f.date)); err != nil {
+			errs = append(errs, FieldError{Line: line, Field: "date", Message: err.Error()})
+		}
+		h, err := f.parseHours(value(f.hours))
+		switch {
+		case err != nil:
+			errs = append(errs, FieldError{Line: line, Field: "hours", Message: fmt.Sprintf("invalid hours %q", value(f.hours))})
+		case h <= 0 || h > 24:
+			errs = append(errs, FieldError{Line: line, Field: "hours", Message: fmt.Sprintf("hours %s are not within a day", value(f.hours))})
+		}
+		e.Hours = float32(h)
+		es = append(es, e)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return es, nil
+}
+
+func (f *Format) parseDate(s string) (time.Time, error) {
+	for _, l := range f.dateLayouts {
+		if d, err := time.Parse(l, s); err == nil {
+			return d, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid date %q, expected layout %s", s, f.dateLayouts[0])
+}
+
+// decimal parses decimal hours, e.g. "1.5" or "1,5".
+func decimal(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+}
+
+// clock parses a duration of the form "1:30:00" or "1:30".
+func clock(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, errors.Errorf("invalid duration %q", s)
+	}
+	var h float64
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (i > 0 && (len(p) != 2 || n >= 60)) {
+			return 0, errors.Errorf("invalid duration %q", s)
+		}
+		h += float64(n) / [...]float64{1, 60, 3600}[i]
+	}
+	return h, nil
+}
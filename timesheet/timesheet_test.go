@@ -0,0 +1,74 @@
+package timesheet_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/timesheet"
+)
+
+func TestParse(t *testing.T) {
+	nov30 := time.Date(2020, 11, 30, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		format string
+		export string
+		want   timesheet.Entry
+	}{
+		{
+			"toggl",
+			"\ufeffUser,Email,Client,Project,Task,Description,Billable,Start date,Start time,End date,End time,Duration,Tags,Amount ()\n" +
+				"Go,go@example.com,3skills,Instanfoo.com,Programming,Front: bugfix #6789,Yes,2020-11-30,09:00:00,2020-11-30,10:30:00,01:30:00,,\n",
+			timesheet.Entry{Line: 2, Client: "3skills", Project: "Instanfoo.com", Task: "Programming", Date: nov30, Hours: 1.5, Description: "Front: bugfix #6789"},
+		},
+		{
+			"harvest",
+			"Date,Client,Project,Project Code,Task,Notes,Hours,Hours Rounded,Billable?,Invoiced?,First Name,Last Name\n" +
+				"2020-11-30,3skills,Instanfoo.com,,Programming,Front: bugfix #6789,1.5,1.5,Yes,No,Go,Pher\n",
+			timesheet.Entry{Line: 2, Client: "3skills", Project: "Instanfoo.com", Task: "Programming", Date: nov30, Hours: 1.5, Description: "Front: bugfix #6789"},
+		},
+		{
+			"Clockify",
+			"Project,Client,Description,Task,User,Email,Tags,Billable,Start Date,Start Time,End Date,End Time,Duration (h),Duration (decimal)\n" +
+				"Instanfoo.com,3skills,Front: bugfix #6789,Programming,Go,go@example.com,,Yes,11/30/2020,09:00 AM,11/30/2020,10:30 AM,01:30:00,1.50\n",
+			timesheet.Entry{Line: 2, Client: "3skills", Project: "Instanfoo.com", Task: "Programming", Date: nov30, Hours: 1.5, Description: "Front: bugfix #6789"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			f, ok := timesheet.Lookup(tt.format)
+			require.True(t, ok)
+			es, err := f.Parse(strings.NewReader(tt.export))
+			require.NoError(t, err)
+			assert.Equal(t, []timesheet.Entry{tt.want}, es)
+		})
+	}
+	_, ok := timesheet.Lookup("excel")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"clockify", "harvest", "toggl"}, timesheet.Names())
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := timesheet.Harvest.Parse(strings.NewReader("Date,Client,Project,Task\n"))
+	assert.EqualError(t, err, `missing column "Hours" of a harvest export`)
+
+	export := "Client,Project,Description,Start date,Duration\n" +
+		"3skills,Instanfoo.com,,2020-11-30,0:45:00\n" +
+		"3skills,Instanfoo.com,,30.11.2020,1:60:00\n" +
+		"3skills,Instanfoo.com,,2020-11-30,25:00:00\n"
+	_, err = timesheet.Toggl.Parse(strings.NewReader(export))
+	assert.Equal(t, timesheet.Errors{
+		{Line: 3, Field: "date", Message: `invalid date "30.11.2020", expected layout 2006-01-02`},
+		{Line: 3, Field: "hours", Message: `invalid hours "1:60:00"`},
+		{Line: 4, Field: "hours", Message: "hours 25:00:00 are not within a day"},
+	}, err)
+
+	es, err := timesheet.Toggl.Parse(strings.NewReader(export[:strings.Index(export, "3skills,Instanfoo.com,,30")]))
+	require.NoError(t, err)
+	if assert.Len(t, es, 1) {
+		assert.Equal(t, float32(0.75), es[0].Hours)
+		assert.Empty(t, es[0].Task, "toggl exports without tasks")
+	}
+}
@@ -0,0 +1,38 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+// AuthenticateAPIKeyPort is a small and use case specific interface.
+type AuthenticateAPIKeyPort interface {
+	// Finds a key by the digest of its secret.
+	APIKeyByHash(hash string) (domain.APIKey, bool)
+	// Records the time the key was last used.
+	TouchAPIKey(id int, t time.Time)
+}
+
+// AuthenticateAPIKey implements the business logic.
+type AuthenticateAPIKey struct {
+	port AuthenticateAPIKeyPort
+}
+
+// NewAuthenticateAPIKey instatiates the use case <Authenticate API Key>'.
+func NewAuthenticateAPIKey(p AuthenticateAPIKeyPort) AuthenticateAPIKey {
+	return AuthenticateAPIKey{port: p}
+}
+
+// Run implements the use case <Authenticate API Key>'. Revoked and expired
+// keys are rejected.
+func (u AuthenticateAPIKey) Run(secret string) (domain.APIKey, bool) {
+	k, ok := u.port.APIKeyByHash(domain.HashAPIKey(secret))
+	now := time.Now().UTC()
+	if !ok || !k.Active(now) {
+		return domain.APIKey{}, false
+	}
+	u.port.TouchAPIKey(k.ID, now)
+	k.LastUsed = now
+	return k, true
+}
@@ -0,0 +1,45 @@
+// This use case charges an open invoice: its bookings are aggregated into
+// positions and the payment is expected from then on.
+
+package usecase
+
+import (
+	"errors"
+
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+// ErrOperationNotAllowed is returned for operations the current state of
+// the invoice does not offer, e.g. charging a paid invoice.
+var ErrOperationNotAllowed = errors.New("operation not allowed in the state of the invoice")
+
+// ChargeInvoicePort is a small and use case specific interface.
+type ChargeInvoicePort interface {
+	UpdateInvoicePort
+	// Gets the invoice.
+	GetInvoice(id int, join ...string) domain.Invoice
+}
+
+// ChargeInvoice implements the business logic.
+type ChargeInvoice struct {
+	port   ChargeInvoicePort
+	update UpdateInvoice
+}
+
+// NewChargeInvoice instatiates the use case <Charge Invoice>'.
+func NewChargeInvoice(p ChargeInvoicePort) ChargeInvoice {
+	return ChargeInvoice{port: p, update: NewUpdateInvoice(p)}
+}
+
+// Run implements the use case <Charge Invoice>'.
+func (u ChargeInvoice) Run(uid string, id int) (domain.Invoice, error) {
+	i := u.port.GetInvoice(id)
+	if !i.Allows("charge") {
+		return i, ErrOperationNotAllowed
+	}
+	i.Status = "ready for aggregation"
+	if err := u.update.Run(uid, i); err != nil {
+		return i, err
+	}
+	return u.port.GetInvoice(id), nil
+}
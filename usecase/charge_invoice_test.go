@@ -0,0 +1,34 @@
+package usecase_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+func TestChargeAndPayInvoice(t *testing.T) {
+	r := database.NewFakeRepository()
+	setupBaseData(r)
+	r.CreateBooking(booking(inv1, pro1, act1, 8, "Feature 4321 development"))
+	i, _ := r.CreateInvoice(domain.Invoice{ID: inv1, CustomerID: customer})
+
+	_, err := usecase.NewPayInvoice(r).Run(i.ID)
+	assert.Equal(t, usecase.ErrOperationNotAllowed, err, "open invoices are not paid")
+
+	charged, err := usecase.NewChargeInvoice(r).Run(user, i.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "payment expected", charged.Status)
+	assert.Equal(t, domain.Position{Hours: 8, Price: 480}, charged.Positions[pro1]["Programming"])
+	assert.False(t, charged.Charged.IsZero())
+
+	_, err = usecase.NewChargeInvoice(r).Run(user, i.ID)
+	assert.Equal(t, usecase.ErrOperationNotAllowed, err, "charged twice")
+
+	paid, err := usecase.NewPayInvoice(r).Run(i.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "paid", paid.Status)
+	assert.Equal(t, charged.Positions, paid.Positions)
+}
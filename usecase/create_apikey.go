@@ -0,0 +1,41 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+// CreateAPIKeyPort is a small and use case specific interface.
+type CreateAPIKeyPort interface {
+	CreateAPIKey(k domain.APIKey) (domain.APIKey, error)
+}
+
+// CreateAPIKey implements the business logic.
+type CreateAPIKey struct {
+	port CreateAPIKeyPort
+}
+
+// NewCreateAPIKey instatiates the use case <Create API Key>'.
+func NewCreateAPIKey(p CreateAPIKeyPort) CreateAPIKey {
+	return CreateAPIKey{port: p}
+}
+
+// Run implements the use case <Create API Key>'. It returns the stored key
+// and its secret, which is not retrievable later on.
+func (u CreateAPIKey) Run(k domain.APIKey) (domain.APIKey, string, error) {
+	if err := k.Validate(); err != nil {
+		return k, "", err
+	}
+	secret, err := domain.NewAPIKeySecret()
+	if err != nil {
+		return k, "", err
+	}
+	k.Hash = domain.HashAPIKey(secret)
+	k.Prefix = secret[:len(domain.APIKeyPrefix)+6]
+	k.Created = time.Now().UTC()
+	k.LastUsed = time.Time{}
+	k.Revoked = false
+	created, err := u.port.CreateAPIKey(k)
+	return created, secret, err
+}
@@ -0,0 +1,130 @@
+package usecase_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/rest"
+	"github.com/tullo/invoice-mvp/roles"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+func TestHttpAPIKeys(t *testing.T) {
+	//=========================================================================
+	// Setup
+	r := database.NewFakeRepository()
+	auth := config.Default().Auth
+	auth.Schemes = []string{"basic", "apikey"}
+	auth.User = config.User{Username: "go", Password: "time", ID: "f8c39a31-9ced-4761-8a33-b9c628a67510"}
+	// A static key of the same user without scopes.
+	sum := sha256.Sum256([]byte("s3cr3t-api-key"))
+	var static rest.Claims
+	static.Subject = auth.User.ID
+	static.Roles = []string{rest.RoleUser}
+	keys := rest.APIKeyStores{
+		rest.NewPersonalAPIKeys(usecase.NewAuthenticateAPIKey(r), rest.SingleUser(auth.User)),
+		rest.APIKeys{hex.EncodeToString(sum[:]): static},
+	}
+	chain, err := rest.NewAuthChain(auth, nil, nil, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := roles.DefaultPolicy()
+
+	a := rest.NewAdapter()
+	ck := a.CreateAPIKeyHandler(usecase.NewCreateAPIKey(r))
+	a.Handle("/apikeys", chain.Authenticate(policy.Require(ck, roles.APIKeyWrite))).Methods("POST")
+	gk := a.APIKeysHandler(usecase.NewAPIKeys(r))
+	a.Handle("/apikeys", chain.Authenticate(policy.Require(gk, roles.APIKeyRead))).Methods("GET")
+	rk := a.RevokeAPIKeyHandler(usecase.NewRevokeAPIKey(r))
+	a.Handle("/apikeys/{keyId:[0-9]+}", chain.Authenticate(policy.Require(rk, roles.APIKeyWrite))).Methods("DELETE")
+	ca := a.CreateActivityHandler(usecase.NewCreateActivity(r))
+	a.Handle("/activities", chain.Authenticate(policy.Require(ca, roles.ActivityWrite))).Methods("POST")
+
+	do := func(method, path, body string, auth func(*http.Request)) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		auth(req)
+		a.R.ServeHTTP(res, req)
+		return res
+	}
+	basic := func(req *http.Request) { req.SetBasicAuth("go", "time") }
+	create := func(k domain.APIKey) (domain.APIKey, string) {
+		bs, _ := json.Marshal(k)
+		res := do("POST", "/apikeys", string(bs), basic)
+		if res.Code != http.StatusCreated {
+			t.Fatal("Unexpected response status", res.Code)
+		}
+		var created struct {
+			domain.APIKey
+			Key string `json:"key"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+		return created.APIKey, created.Key
+	}
+
+	//=========================================================================
+	// Create keys
+	k, secret := create(domain.APIKey{Name: "reporting", Scopes: []string{"apikey:read", "activity:read"}})
+	assert.True(t, strings.HasPrefix(secret, domain.APIKeyPrefix))
+	assert.True(t, strings.HasPrefix(secret, k.Prefix))
+	assert.Equal(t, []string{rest.RoleUser}, k.Roles)
+	stored := r.APIKeys("f8c39a31-9ced-4761-8a33-b9c628a67510")[0]
+	assert.Equal(t, domain.HashAPIKey(secret), stored.Hash)
+	assert.NotContains(t, stored.Hash, secret)
+
+	res := do("POST", "/apikeys", `{"name":"no scopes"}`, basic)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	withKey := func(s string) func(*http.Request) {
+		return func(req *http.Request) { req.Header.Set(rest.APIKeyHeader, s) }
+	}
+
+	//=========================================================================
+	// Use the key
+	res = do("GET", "/apikeys", "", withKey(secret))
+	assert.Equal(t, http.StatusOK, res.Code)
+	var ks []domain.APIKey
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&ks))
+	assert.Len(t, ks, 1)
+	assert.False(t, ks[0].LastUsed.IsZero())
+	assert.NotContains(t, res.Body.String(), secret)
+
+	// Scopes limit the permissions of the roles.
+	res = do("POST", "/activities", `{"name":"Programming"}`, withKey(secret))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	// API keys can not create API keys.
+	_, all := create(domain.APIKey{Name: "all", Scopes: []string{"*"}})
+	res = do("POST", "/apikeys", `{"name":"child","scopes":["*"]}`, withKey(all))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	res = do("POST", "/apikeys", `{"name":"child","scopes":["*"]}`, withKey("s3cr3t-api-key"))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	res = do("POST", "/activities", `{"name":"Programming"}`, withKey(all))
+	assert.Equal(t, http.StatusCreated, res.Code)
+
+	// Expired keys are rejected.
+	_, expired := create(domain.APIKey{Name: "expired", Scopes: []string{"*"}, Expires: time.Now().Add(-time.Minute)})
+	res = do("GET", "/apikeys", "", withKey(expired))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	//=========================================================================
+	// Revoke the key
+	res = do("DELETE", "/apikeys/99", "", basic)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	res = do("DELETE", "/apikeys/1", "", basic)
+	assert.Equal(t, http.StatusNoContent, res.Code)
+	res = do("GET", "/apikeys", "", withKey(secret))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
@@ -6,15 +6,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
-	"os"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tullo/invoice-mvp/database"
 	"github.com/tullo/invoice-mvp/domain"
-	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
 	"github.com/tullo/invoice-mvp/rest"
 	"github.com/tullo/invoice-mvp/roles"
 	"github.com/tullo/invoice-mvp/usecase"
@@ -34,13 +31,7 @@ func TestHttpAddBooking(t *testing.T) {
 	}
 
 	// Login to IDM
-	data := make(url.Values)
-	data.Set("loginId", os.Getenv("MVP_USERNAME"))
-	data.Set("password", os.Getenv("MVP_PASSWORD"))
-	auth, err := fusionauth.Login(data)
-	if err != nil {
-		t.Error(err)
-	}
+	auth := login(t)
 
 	// Prepare HTTP-Request
 	b := domain.Booking{
@@ -60,7 +51,7 @@ func TestHttpAddBooking(t *testing.T) {
 	res := httptest.NewRecorder()
 	a := rest.NewAdapter()
 	cb := a.CreateBookingHandler(createBooking)
-	cb = rest.JWTAuth(roles.AssertOwnsInvoice(cb, r))
+	cb = rest.JWTAuth(cfg.Auth, keyFunc, roles.AssertOwnsInvoice(cb, r))
 	a.Handle("/book/{invoiceId:[0-9]+}", cb).Methods("POST")
 	a.R.ServeHTTP(res, req)
 
@@ -6,14 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
-	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tullo/invoice-mvp/database"
 	"github.com/tullo/invoice-mvp/domain"
-	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
 	"github.com/tullo/invoice-mvp/rest"
 	"github.com/tullo/invoice-mvp/roles"
 	"github.com/tullo/invoice-mvp/usecase"
@@ -46,7 +43,7 @@ func TestHttpCreateProjectUnauthorized(t *testing.T) {
 	res := httptest.NewRecorder()
 	a := rest.NewAdapter()
 	cp := a.CreateProjectHandler(createProject)
-	cp = rest.JWTAuth(roles.AssertAdmin(cp))
+	cp = rest.JWTAuth(cfg.Auth, keyFunc, roles.AssertAdmin(cp, cfg.Auth.Realm))
 	a.Handle("/customers/{customerId:[0-9]+}/projects", cp).Methods("POST")
 	a.R.ServeHTTP(res, req)
 
@@ -63,13 +60,7 @@ func TestHttpCreateProjectAuthorized(t *testing.T) {
 	createProject := usecase.NewCreateProject(r)
 
 	// Login to IDM
-	data := make(url.Values)
-	data.Set("loginId", os.Getenv("MVP_USERNAME"))
-	data.Set("password", os.Getenv("MVP_PASSWORD"))
-	auth, err := fusionauth.Login(data)
-	if err != nil {
-		t.Error(err)
-	}
+	auth := login(t)
 
 	// Prepare HTTP-Request
 	p := domain.Project{CustomerID: customer, Name: "Testing"}
@@ -83,7 +74,7 @@ func TestHttpCreateProjectAuthorized(t *testing.T) {
 	res := httptest.NewRecorder()
 	a := rest.NewAdapter()
 	cp := a.CreateProjectHandler(createProject)
-	cp = rest.JWTAuth(roles.AssertAdmin(cp))
+	cp = rest.JWTAuth(cfg.Auth, keyFunc, roles.AssertAdmin(cp, cfg.Auth.Realm))
 	a.Handle("/customers/{customerId:[0-9]+}/projects", cp).Methods("POST")
 	a.R.ServeHTTP(res, req)
 
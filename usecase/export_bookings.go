@@ -0,0 +1,151 @@
+// This use case exports the bookings and positions of the invoices of a
+// period, with the names of the customers, projects and activities, for
+// spreadsheets.
+
+package usecase
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+// ExportBookingsPort is a small and use case specific interface.
+type ExportBookingsPort interface {
+	Invoices(userID string) []domain.Invoice
+	GetInvoice(id int, join ...string) domain.Invoice
+	CustomerByID(id int) domain.Customer
+	ProjectByID(id int) domain.Project
+	ActivityByID(uid string, aid int) domain.Activity
+}
+
+// ExportedBooking is a booking along with the records it belongs to.
+type ExportedBooking struct {
+	Date        time.Time `json:"date"`
+	CustomerID  int       `json:"customerId"`
+	Customer    string    `json:"customer"`
+	InvoiceID   int       `json:"invoiceId"`
+	ProjectID   int       `json:"projectId"`
+	Project     string    `json:"project"`
+	ActivityID  int       `json:"activityId"`
+	Activity    string    `json:"activity"`
+	Hours       float32   `json:"hours"`
+	Description string    `json:"description"`
+}
+
+// ExportedPosition is a position of an invoice, the hours and price summed
+// up per project and activity when the invoice was charged.
+type ExportedPosition struct {
+	Period     time.Time `json:"period"` // first day of the month
+	CustomerID int       `json:"customerId"`
+	Customer   string    `json:"customer"`
+	InvoiceID  int       `json:"invoiceId"`
+	ProjectID  int       `json:"projectId"`
+	Project    string    `json:"project"`
+	Activity   string    `json:"activity"`
+	Hours      float32   `json:"hours"`
+	Price      float32   `json:"price"`
+}
+
+// Export lists the bookings and positions of invoices, ordered by period
+// and invoice.
+type Export struct {
+	Bookings  []ExportedBooking  `json:"bookings"`
+	Positions []ExportedPosition `json:"positions"`
+}
+
+// ExportBookings implements the business logic.
+type ExportBookings struct {
+	port ExportBookingsPort
+}
+
+// NewExportBookings instatiates the use case <Export Bookings>.
+func NewExportBookings(p ExportBookingsPort) ExportBookings {
+	return ExportBookings{port: p}
+}
+
+// Run implements the use case <Export Bookings> for the invoices of the
+// user of the months from through to.
+func (u ExportBookings) Run(userID string, from, to time.Time) Export {
+	first := from.Year()*12 + int(from.Month())
+	last := to.Year()*12 + int(to.Month())
+	var is []domain.Invoice
+	for _, i := range u.port.Invoices(userID) {
+		if m := i.Year*12 + i.Month; m >= first && m <= last {
+			is = append(is, i)
+		}
+	}
+	return export(u.port, is)
+}
+
+// exportPort resolves the records bookings and positions belong to.
+type exportPort interface {
+	GetInvoice(id int, join ...string) domain.Invoice
+	CustomerByID(id int) domain.Customer
+	ProjectByID(id int) domain.Project
+	ActivityByID(uid string, aid int) domain.Activity
+}
+
+// export returns the bookings and positions of the invoices.
+func export(p exportPort, is []domain.Invoice) Export {
+	sort.Slice(is, func(a, b int) bool {
+		if is[a].Year != is[b].Year {
+			return is[a].Year < is[b].Year
+		}
+		if is[a].Month != is[b].Month {
+			return is[a].Month < is[b].Month
+		}
+		return is[a].ID < is[b].ID
+	})
+	e := Export{Bookings: make([]ExportedBooking, 0), Positions: make([]ExportedPosition, 0)}
+	for _, i := range is {
+		c := p.CustomerByID(i.CustomerID)
+		period := time.Date(i.Year, time.Month(i.Month), 1, 0, 0, 0, 0, time.UTC)
+		bs := p.GetInvoice(i.ID, "bookings").Bookings
+		sort.Slice(bs, func(a, b int) bool {
+			if bs[a].Day != bs[b].Day {
+				return bs[a].Day < bs[b].Day
+			}
+			return bs[a].ID < bs[b].ID
+		})
+		for _, b := range bs {
+			e.Bookings = append(e.Bookings, ExportedBooking{
+				Date:        period.AddDate(0, 0, b.Day-1),
+				CustomerID:  c.ID,
+				Customer:    c.Name,
+				InvoiceID:   i.ID,
+				ProjectID:   b.ProjectID,
+				Project:     p.ProjectByID(b.ProjectID).Name,
+				ActivityID:  b.ActivityID,
+				Activity:    p.ActivityByID(c.UserID, b.ActivityID).Name,
+				Hours:       b.Hours,
+				Description: b.Description,
+			})
+		}
+		var ps []ExportedPosition
+		for pid, as := range i.Positions {
+			for a, pos := range as {
+				ps = append(ps, ExportedPosition{
+					Period:     period,
+					CustomerID: c.ID,
+					Customer:   c.Name,
+					InvoiceID:  i.ID,
+					ProjectID:  pid,
+					Project:    p.ProjectByID(pid).Name,
+					Activity:   a,
+					Hours:      pos.Hours,
+					Price:      pos.Price,
+				})
+			}
+		}
+		sort.Slice(ps, func(a, b int) bool {
+			if ps[a].ProjectID != ps[b].ProjectID {
+				return ps[a].ProjectID < ps[b].ProjectID
+			}
+			return ps[a].Activity < ps[b].Activity
+		})
+		e.Positions = append(e.Positions, ps...)
+	}
+	return e
+}
@@ -0,0 +1,66 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+func TestExportBookings(t *testing.T) {
+	r := database.NewFakeRepository()
+	setupBaseData(r)
+	nov, _ := r.CreateInvoice(domain.Invoice{CustomerID: customer, Month: 11, Year: 2020})
+	oct, _ := r.CreateInvoice(domain.Invoice{CustomerID: customer, Month: 10, Year: 2020})
+	_, _ = r.CreateInvoice(domain.Invoice{CustomerID: customer, Month: 12, Year: 2020})
+	b1 := booking(nov.ID, pro1, act1, 3.5, "Front: bugfix #6789")
+	b1.Day = 30
+	b2 := booking(oct.ID, pro2, act3, 2, "Planning")
+	b2.Day = 1
+	_, err := r.CreateBookings([]domain.Booking{b1, b2})
+	require.NoError(t, err)
+	oct.AddPosition(pro2, "Project management", 2, 50)
+	require.NoError(t, r.UpdateInvoice(oct))
+
+	uc := usecase.NewExportBookings(r)
+	e := uc.Run(user, time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 11, 30, 0, 0, 0, 0, time.UTC))
+	require.Len(t, e.Bookings, 2)
+	assert.Equal(t, usecase.ExportedBooking{
+		Date:        time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC),
+		CustomerID:  customer,
+		Customer:    "3skills",
+		InvoiceID:   oct.ID,
+		ProjectID:   pro2,
+		Project:     "Covid19tracker.biz",
+		ActivityID:  act3,
+		Activity:    "Project management",
+		Hours:       2,
+		Description: "Planning",
+	}, e.Bookings[0], "ordered by period")
+	assert.Equal(t, nov.ID, e.Bookings[1].InvoiceID)
+	assert.Equal(t, []usecase.ExportedPosition{{
+		Period:     time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC),
+		CustomerID: customer,
+		Customer:   "3skills",
+		InvoiceID:  oct.ID,
+		ProjectID:  pro2,
+		Project:    "Covid19tracker.biz",
+		Activity:   "Project management",
+		Hours:      2,
+		Price:      100,
+	}}, e.Positions)
+
+	e = uc.Run("nobody", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC))
+	assert.Empty(t, e.Bookings)
+	assert.NotNil(t, e.Positions, "encoded as empty list")
+
+	e = usecase.NewGetInvoice(r).Export(nov.ID)
+	if assert.Len(t, e.Bookings, 1) {
+		assert.Equal(t, "Programming", e.Bookings[0].Activity)
+	}
+	assert.Empty(t, usecase.NewGetInvoice(r).Export(42).Bookings)
+}
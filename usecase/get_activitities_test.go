@@ -6,12 +6,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
-	"os"
 	"testing"
 
 	"github.com/tullo/invoice-mvp/domain"
-	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tullo/invoice-mvp/database"
@@ -28,13 +25,7 @@ func TestHttpGetActivities(t *testing.T) {
 	userID := r.CustomerByID(customer).UserID
 
 	// Login to IDM
-	data := make(url.Values)
-	data.Set("loginId", os.Getenv("MVP_USERNAME"))
-	data.Set("password", os.Getenv("MVP_PASSWORD"))
-	auth, err := fusionauth.Login(data)
-	if err != nil {
-		t.Error(err)
-	}
+	auth := login(t)
 
 	// Prepare HTTP-Request
 	req, _ := http.NewRequest("GET", "/activities", nil)
@@ -45,7 +36,7 @@ func TestHttpGetActivities(t *testing.T) {
 	// Get request
 	a := rest.NewAdapter()
 	ga := a.ActivitiesHandler(activities)
-	ga = rest.JWTAuth(ga)
+	ga = rest.JWTAuth(cfg.Auth, keyFunc, ga)
 	a.Handle("/activities", ga).Methods("GET")
 
 	res := httptest.NewRecorder()
@@ -0,0 +1,23 @@
+package usecase
+
+import "github.com/tullo/invoice-mvp/domain"
+
+// APIKeysPort is a small and use case specific interface.
+type APIKeysPort interface {
+	APIKeys(userID string) []domain.APIKey
+}
+
+// APIKeys implements the business logic.
+type APIKeys struct {
+	port APIKeysPort
+}
+
+// NewAPIKeys instatiates the use case <Get API Keys>'.
+func NewAPIKeys(p APIKeysPort) APIKeys {
+	return APIKeys{port: p}
+}
+
+// Run implements the use case <Get API Keys>'.
+func (u APIKeys) Run(userID string) []domain.APIKey {
+	return u.port.APIKeys(userID)
+}
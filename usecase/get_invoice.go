@@ -1,10 +1,27 @@
 package usecase
 
-import "github.com/tullo/invoice-mvp/domain"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/einvoice"
+)
+
+// ErrInvoiceNotCharged is returned for electronic invoices of invoices that
+// have not been charged yet.
+var ErrInvoiceNotCharged = errors.New("invoice is not charged")
 
 // GetInvoicePort is a small and use case specific interface.
 type GetInvoicePort interface {
 	GetInvoice(id int, join ...string) domain.Invoice
+	CustomerByID(id int) domain.Customer
+	ProjectByID(id int) domain.Project
+	ActivityByID(uid string, aid int) domain.Activity
+	Profile(userID string) domain.Profile
 }
 
 // GetInvoice implements the business logic.
@@ -21,3 +38,83 @@ func NewGetInvoice(p GetInvoicePort) GetInvoice {
 func (u GetInvoice) Run(id int, join string) domain.Invoice {
 	return u.port.GetInvoice(id, join)
 }
+
+// Export returns the bookings and positions of the invoice for
+// spreadsheets.
+func (u GetInvoice) Export(id int) Export {
+	i := u.port.GetInvoice(id)
+	if i.ID != id {
+		return export(u.port, nil)
+	}
+	return export(u.port, []domain.Invoice{i})
+}
+
+// EInvoice returns the electronic invoice of a charged invoice: the profile
+// of the user is the seller, the customer the buyer and the positions are
+// the lines. It is issued the day the invoice was charged.
+func (u GetInvoice) EInvoice(id int) (einvoice.Invoice, error) {
+	i := u.port.GetInvoice(id)
+	switch i.Status {
+	case "payment expected", "paid", "archived":
+	default:
+		return einvoice.Invoice{}, ErrInvoiceNotCharged
+	}
+	c := u.port.CustomerByID(i.CustomerID)
+	s := u.port.Profile(c.UserID)
+	charged := i.Charged
+	if charged.IsZero() {
+		charged = i.Updated
+	}
+	y, m, d := charged.Date()
+	e := einvoice.Invoice{
+		Number:         strconv.Itoa(i.ID),
+		IssueDate:      time.Date(y, m, d, 0, 0, 0, 0, time.UTC),
+		Currency:       s.Currency,
+		BuyerReference: c.BuyerReference,
+		Seller: einvoice.Party{
+			Name:      s.Name,
+			Address:   einvoice.Address(s.Address),
+			VATID:     s.VATID,
+			TaxNumber: s.TaxNumber,
+			Email:     s.Email,
+			Contact:   einvoice.Contact{Name: s.ContactName, Phone: s.Phone, Email: s.Email},
+		},
+		Buyer: einvoice.Party{
+			Name:  c.Name,
+			VATID: c.VATID,
+			Email: c.Email,
+		},
+		Payment: einvoice.Payment{IBAN: s.IBAN, BIC: s.BIC},
+		VAT:     einvoice.VAT{Category: einvoice.StandardRate, Rate: float64(s.VATRate)},
+	}
+	if len(e.Currency) < 1 {
+		e.Currency = "EUR"
+	}
+	if c.Address != nil {
+		e.Buyer.Address = einvoice.Address(*c.Address)
+	}
+	if len(s.VATExemption) > 0 {
+		e.VAT = einvoice.VAT{Category: einvoice.Exempt, ExemptionReason: s.VATExemption}
+	}
+	if s.PaymentTermDays > 0 {
+		e.DueDate = e.IssueDate.AddDate(0, 0, s.PaymentTermDays)
+		e.PaymentTerms = fmt.Sprintf("Payable within %d days", s.PaymentTermDays)
+	}
+	if i.Month > 0 {
+		e.PeriodStart = time.Date(i.Year, time.Month(i.Month), 1, 0, 0, 0, 0, time.UTC)
+		e.PeriodEnd = e.PeriodStart.AddDate(0, 1, -1)
+	}
+	for n, pos := range export(u.port, []domain.Invoice{i}).Positions {
+		l := einvoice.Line{
+			ID:        strconv.Itoa(n + 1),
+			Name:      pos.Project + ": " + pos.Activity,
+			Hours:     math.Round(float64(pos.Hours)*10000) / 10000,
+			NetAmount: einvoice.NewAmount(float64(pos.Price)),
+		}
+		if pos.Hours != 0 {
+			l.Price = einvoice.NewAmount(float64(pos.Price / pos.Hours))
+		}
+		e.Lines = append(e.Lines, l)
+	}
+	return e, nil
+}
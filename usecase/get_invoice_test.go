@@ -0,0 +1,68 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/einvoice"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+func TestEInvoice(t *testing.T) {
+	r := database.NewFakeRepository()
+	setupBaseData(r)
+	require.NoError(t, r.SaveProfile(domain.Profile{
+		UserID:          user,
+		Name:            "Tullo & Partner",
+		Address:         domain.Address{City: "Hamburg", PostCode: "20095", CountryCode: "DE"},
+		VATID:           "DE123456789",
+		ContactName:     "Andreas Tullo",
+		Email:           "at@tullo.example",
+		IBAN:            "DE02120300000000202051",
+		VATRate:         19,
+		PaymentTermDays: 14,
+	}))
+	c := r.CustomerByID(customer)
+	c.Address = &domain.Address{City: "Kiel", CountryCode: "DE"}
+	c.BuyerReference = "04011000-12345-34"
+	require.NoError(t, r.UpdateCustomer(c))
+
+	i, _ := r.CreateInvoice(domain.Invoice{CustomerID: customer, Month: 10, Year: 2020})
+	uc := usecase.NewGetInvoice(r)
+	_, err := uc.EInvoice(i.ID)
+	assert.ErrorIs(t, err, usecase.ErrInvoiceNotCharged)
+
+	i.AddPosition(pro2, "Project management", 2.1, 50)
+	i.AddPosition(pro1, "Programming", 8, 60)
+	i.Status = "payment expected"
+	i.Charged = time.Date(2020, 11, 2, 17, 30, 0, 0, time.UTC)
+	require.NoError(t, r.UpdateInvoice(i))
+
+	e, err := uc.EInvoice(i.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "1", e.Number)
+	assert.Equal(t, time.Date(2020, 11, 2, 0, 0, 0, 0, time.UTC), e.IssueDate)
+	assert.Equal(t, time.Date(2020, 11, 16, 0, 0, 0, 0, time.UTC), e.DueDate)
+	assert.Equal(t, "Payable within 14 days", e.PaymentTerms)
+	assert.Equal(t, time.Date(2020, 10, 31, 0, 0, 0, 0, time.UTC), e.PeriodEnd)
+	assert.Equal(t, "EUR", e.Currency)
+	assert.Equal(t, "04011000-12345-34", e.BuyerReference)
+	assert.Equal(t, einvoice.Address{City: "Kiel", CountryCode: "DE"}, e.Buyer.Address)
+	assert.Equal(t, einvoice.Contact{Name: "Andreas Tullo", Email: "at@tullo.example"}, e.Seller.Contact)
+	assert.Equal(t, einvoice.VAT{Category: einvoice.StandardRate, Rate: 19}, e.VAT)
+	assert.Equal(t, []einvoice.Line{
+		{ID: "1", Name: "Instanfoo.com: Programming", Hours: 8, Price: 6000, NetAmount: 48000},
+		{ID: "2", Name: "Covid19tracker.biz: Project management", Hours: 2.1, Price: 5000, NetAmount: 10500},
+	}, e.Lines, "ordered by project")
+	assert.NoError(t, e.Validate(einvoice.EN16931))
+
+	require.NoError(t, r.SaveProfile(domain.Profile{UserID: user, Name: "Tullo", TaxNumber: "22/815/08154", VATExemption: "Kleinunternehmer gemäß § 19 UStG"}))
+	e, err = uc.EInvoice(i.ID)
+	require.NoError(t, err)
+	assert.Equal(t, einvoice.VAT{Category: einvoice.Exempt, ExemptionReason: "Kleinunternehmer gemäß § 19 UStG"}, e.VAT)
+	assert.True(t, e.DueDate.IsZero())
+}
@@ -0,0 +1,30 @@
+package usecase
+
+import "github.com/tullo/invoice-mvp/domain"
+
+// InvoicesPort is a small and use case specific interface.
+type InvoicesPort interface {
+	Invoices(userID string) []domain.Invoice
+}
+
+// Invoices implements the business logic.
+type Invoices struct {
+	port InvoicesPort
+}
+
+// NewInvoices instatiates the use case <Get Invoices>'.
+func NewInvoices(p InvoicesPort) Invoices {
+	return Invoices{port: p}
+}
+
+// Run implements the use case <Get Invoices>'. Invoices are filtered by
+// status unless it is empty.
+func (u Invoices) Run(userID, status string) []domain.Invoice {
+	var is []domain.Invoice
+	for _, i := range u.port.Invoices(userID) {
+		if len(status) < 1 || i.Status == status {
+			is = append(is, i)
+		}
+	}
+	return is
+}
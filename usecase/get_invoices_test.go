@@ -0,0 +1,33 @@
+package usecase_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+func TestGetInvoices(t *testing.T) {
+	r := database.NewFakeRepository()
+	setupBaseData(r)
+	r.CreateCustomer(domain.Customer{ID: 2, Name: "other", UserID: "other"})
+	open, _ := r.CreateInvoice(domain.Invoice{CustomerID: customer, Month: 11, Year: 2020})
+	paid, _ := r.CreateInvoice(domain.Invoice{CustomerID: customer, Month: 10, Year: 2020})
+	paid.Status = "payment expected"
+	r.UpdateInvoice(paid)
+	_, _ = r.CreateInvoice(domain.Invoice{CustomerID: 2, Month: 10, Year: 2020})
+
+	uc := usecase.NewInvoices(r)
+	is := uc.Run(user, "")
+	if assert.Len(t, is, 2, "invoices of the user's customers") {
+		assert.Equal(t, open.ID, is[0].ID)
+		assert.Equal(t, paid.ID, is[1].ID)
+	}
+	is = uc.Run(user, "payment expected")
+	if assert.Len(t, is, 1) {
+		assert.Equal(t, paid.ID, is[0].ID)
+	}
+	assert.Empty(t, uc.Run("nobody", ""))
+}
@@ -0,0 +1,23 @@
+package usecase
+
+import "github.com/tullo/invoice-mvp/domain"
+
+// GetProfilePort is a small and use case specific interface.
+type GetProfilePort interface {
+	Profile(userID string) domain.Profile
+}
+
+// GetProfile implements the business logic.
+type GetProfile struct {
+	port GetProfilePort
+}
+
+// NewGetProfile instatiates the use case <Get Profile>'.
+func NewGetProfile(p GetProfilePort) GetProfile {
+	return GetProfile{port: p}
+}
+
+// Run implements the use case <Get Profile>'.
+func (u GetProfile) Run(userID string) domain.Profile {
+	return u.port.Profile(userID)
+}
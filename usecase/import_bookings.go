@@ -0,0 +1,151 @@
+// This use case books the records of a timesheet exported by another tool.
+// All records are validated before the first one is booked, so an import
+// either books all of them or none.
+
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+// DefaultDateLayout is the layout of imported dates unless configured
+// otherwise.
+const DefaultDateLayout = "2006-01-02"
+
+// ErrInvoiceNotOpen is returned for imports into invoices which do not
+// accept bookings any more.
+var ErrInvoiceNotOpen = errors.New("invoice is not open for bookings")
+
+// ImportBookingsPort is a small and use case specific interface.
+type ImportBookingsPort interface {
+	GetInvoice(id int, join ...string) domain.Invoice
+	Activities(userID string) []domain.Activity
+	Projects(customerID int) []domain.Project
+	CreateBookings(bs []domain.Booking) ([]domain.Booking, error)
+}
+
+// BookingRecord is a record of a timesheet with the values as imported.
+type BookingRecord struct {
+	Line        int // of the record in the import, reported with its errors
+	Date        string
+	Project     string // name or ID of a project of the invoice's customer
+	Activity    string // name or ID of an activity of the user
+	Hours       string // e.g. "3.5", "3,5" or "3:30"
+	Description string
+}
+
+// RecordError is an invalid field of a record.
+type RecordError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportError lists the invalid fields of a rejected import.
+type ImportError []RecordError
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("%d invalid fields, no booking was imported", len(e))
+}
+
+// ImportBookings implements the business logic.
+type ImportBookings struct {
+	port ImportBookingsPort
+}
+
+// NewImportBookings instatiates the use case <Import Bookings>.
+func NewImportBookings(p ImportBookingsPort) ImportBookings {
+	return ImportBookings{port: p}
+}
+
+// Run implements the use case <Import Bookings>. Dates are parsed with the
+// layout, DefaultDateLayout when empty, and must be in the month of the
+// invoice. An ImportError is returned when a record is invalid.
+func (u ImportBookings) Run(userID string, invoiceID int, layout string, rs []BookingRecord) ([]domain.Booking, error) {
+	if len(layout) < 1 {
+		layout = DefaultDateLayout
+	}
+	i := u.port.GetInvoice(invoiceID)
+	if i.Status != "open" {
+		return nil, ErrInvoiceNotOpen
+	}
+	projects := make(map[string]int)
+	for _, p := range u.port.Projects(i.CustomerID) {
+		projects[strings.ToLower(p.Name)] = p.ID
+		projects[strconv.Itoa(p.ID)] = p.ID
+	}
+	activities := make(map[string]int)
+	for _, a := range u.port.Activities(userID) {
+		activities[strings.ToLower(a.Name)] = a.ID
+		activities[strconv.Itoa(a.ID)] = a.ID
+	}
+
+	var errs ImportError
+	invalid := func(r BookingRecord, field, format string, args ...interface{}) {
+		errs = append(errs, RecordError{Line: r.Line, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	bs := make([]domain.Booking, 0, len(rs))
+	for _, r := range rs {
+		b := domain.Booking{InvoiceID: invoiceID, Description: strings.TrimSpace(r.Description)}
+		if d, err := time.Parse(layout, strings.TrimSpace(r.Date)); err != nil {
+			invalid(r, "date", "invalid date %q, expected layout %s", r.Date, layout)
+		} else if d.Year() != i.Year || int(d.Month()) != i.Month {
+			invalid(r, "date", "date %s is not in the invoice period %d-%02d", d.Format(DefaultDateLayout), i.Year, i.Month)
+		} else {
+			b.Day = d.Day()
+		}
+		var ok bool
+		if b.ProjectID, ok = projects[strings.ToLower(strings.TrimSpace(r.Project))]; !ok {
+			invalid(r, "project", "unknown project %q", r.Project)
+		}
+		if b.ActivityID, ok = activities[strings.ToLower(strings.TrimSpace(r.Activity))]; !ok {
+			invalid(r, "activity", "unknown activity %q", r.Activity)
+		}
+		h, err := parseHours(r.Hours)
+		if err != nil {
+			invalid(r, "hours", "%v", err)
+		}
+		b.Hours = h
+		bs = append(bs, b)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(bs) < 1 {
+		return nil, ImportError{{Message: "no records"}}
+	}
+	return u.port.CreateBookings(bs)
+}
+
+// parseHours parses decimal hours, e.g. "3.5" or "3,5", or hours and
+// minutes, e.g. "3:30".
+func parseHours(s string) (float32, error) {
+	s = strings.TrimSpace(s)
+	var h float64
+	var err error
+	if hh, mm, ok := strings.Cut(s, ":"); ok {
+		var hi, mi int
+		if hi, err = strconv.Atoi(hh); err == nil {
+			mi, err = strconv.Atoi(mm)
+		}
+		if err == nil && (len(mm) != 2 || mi >= 60) {
+			err = errors.New("minutes out of range")
+		}
+		h = float64(hi) + float64(mi)/60
+	} else {
+		h, err = strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 32)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid hours %q", s)
+	}
+	if h <= 0 || h > 24 {
+		return 0, fmt.Errorf("hours %s are not within a day", s)
+	}
+	return float32(h), nil
+}
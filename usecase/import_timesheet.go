@@ -0,0 +1,302 @@
+// This use case imports the entries of a timesheet exported by a time
+// tracking tool. Clients, projects and tasks of the tool are matched by name
+// with the customers, projects and activities of the user, the entries are
+// booked onto the open invoice of the customer for their month. Missing
+// records are created on request. Like the import of bookings, it either
+// books all entries or none.
+
+package usecase
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/timesheet"
+)
+
+// ImportTimesheetPort is a small and use case specific interface.
+type ImportTimesheetPort interface {
+	Customers() []domain.Customer
+	Projects(customerID int) []domain.Project
+	Activities(userID string) []domain.Activity
+	Invoices(userID string) []domain.Invoice
+	CreateCustomer(c domain.Customer) (domain.Customer, error)
+	CreateProject(p domain.Project) (domain.Project, error)
+	CreateActivity(a domain.Activity) (domain.Activity, error)
+	CreateInvoice(i domain.Invoice) (domain.Invoice, error)
+	CreateBookings(bs []domain.Booking) ([]domain.Booking, error)
+}
+
+// TimesheetOptions control the import of a timesheet.
+type TimesheetOptions struct {
+	// Create creates missing customers, projects, activities and invoices
+	// instead of rejecting their entries.
+	Create bool
+	// DryRun plans the import without changing anything.
+	DryRun bool
+	// Activity is booked for entries without task.
+	Activity string
+}
+
+// TimesheetPlan lists the records an import creates. The IDs of records
+// which are only planned, in a dry run, are 0.
+type TimesheetPlan struct {
+	DryRun     bool              `json:"dryRun"`
+	Customers  []domain.Customer `json:"customers"`
+	Projects   []domain.Project  `json:"projects"`
+	Activities []domain.Activity `json:"activities"`
+	Invoices   []domain.Invoice  `json:"invoices"`
+	Bookings   []PlannedBooking  `json:"bookings"`
+}
+
+// PlannedBooking is a booking of an entry along with the names of the
+// records it belongs to.
+type PlannedBooking struct {
+	domain.Booking
+	Line     int    `json:"line"` // of the entry
+	Customer string `json:"customer"`
+	Project  string `json:"project"`
+	Activity string `json:"activity"`
+	Period   string `json:"period"` // of the invoice, e.g. "2020-11"
+}
+
+// ImportTimesheet implements the business logic.
+type ImportTimesheet struct {
+	port ImportTimesheetPort
+}
+
+// NewImportTimesheet instatiates the use case <Import Timesheet>.
+func NewImportTimesheet(p ImportTimesheetPort) ImportTimesheet {
+	return ImportTimesheet{port: p}
+}
+
+// entryRecords links an entry to the records it is booked on.
+type entryRecords struct {
+	entry    timesheet.Entry
+	customer *domain.Customer
+	project  *domain.Project
+	activity *domain.Activity
+	invoice  *domain.Invoice
+}
+
+// Run implements the use case <Import Timesheet>. An ImportError is returned
+// when an entry can not be booked.
+func (u ImportTimesheet) Run(userID string, es []timesheet.Entry, o TimesheetOptions) (TimesheetPlan, error) {
+	plan := TimesheetPlan{DryRun: o.DryRun}
+	customers := make(map[string]*domain.Customer)
+	cs := u.port.Customers()
+	sort.Slice(cs, func(a, b int) bool { return cs[a].ID < cs[b].ID })
+	for _, c := range cs {
+		if k := key(c.Name); c.UserID == userID && customers[k] == nil {
+			c := c
+			customers[k] = &c
+		}
+	}
+	activities := make(map[string]*domain.Activity)
+	for _, a := range u.port.Activities(userID) {
+		if k := key(a.Name); activities[k] == nil {
+			a := a
+			activities[k] = &a
+		}
+	}
+	invoices := u.port.Invoices(userID)
+	projects := make(map[*domain.Customer]map[string]*domain.Project)
+	periods := make(map[*domain.Customer]map[string]*domain.Invoice)
+
+	var errs ImportError
+	invalid := func(e timesheet.Entry, field, format string, args ...interface{}) {
+		errs = append(errs, RecordError{Line: e.Line, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	var newCustomers []*domain.Customer
+	var newProjects []*domain.Project
+	var newActivities []*domain.Activity
+	var newInvoices []*domain.Invoice
+	rs := make([]entryRecords, 0, len(es))
+	for _, e := range es {
+		r := entryRecords{entry: e}
+		switch c, ok := customers[key(e.Client)]; {
+		case len(e.Client) < 1:
+			invalid(e, "client", "missing client")
+		case ok:
+			r.customer = c
+		case o.Create:
+			r.customer = &domain.Customer{Name: e.Client, UserID: userID}
+			customers[key(e.Client)] = r.customer
+			newCustomers = append(newCustomers, r.customer)
+		default:
+			invalid(e, "client", "unknown client %q", e.Client)
+		}
+
+		if r.customer != nil {
+			ps, ok := projects[r.customer]
+			if !ok {
+				ps = make(map[string]*domain.Project)
+				for _, p := range u.port.Projects(r.customer.ID) {
+					if k := key(p.Name); ps[k] == nil {
+						p := p
+						ps[k] = &p
+					}
+				}
+				projects[r.customer] = ps
+			}
+			switch p, ok := ps[key(e.Project)]; {
+			case len(e.Project) < 1:
+				invalid(e, "project", "missing project")
+			case ok:
+				r.project = p
+			case o.Create:
+				r.project = &domain.Project{Name: e.Project}
+				ps[key(e.Project)] = r.project
+				newProjects = append(newProjects, r.project)
+			default:
+				invalid(e, "project", "unknown project %q of client %q", e.Project, e.Client)
+			}
+
+			period := e.Date.Format("2006-01")
+			is, ok := periods[r.customer]
+			if !ok {
+				is = make(map[string]*domain.Invoice)
+				periods[r.customer] = is
+			}
+			if is[period] == nil {
+				is[period] = invoiceOf(invoices, r.customer.ID, e.Date.Year(), int(e.Date.Month()))
+			}
+			switch i := is[period]; {
+			case i != nil && i.Status != "open":
+				invalid(e, "date", "invoice %d of client %q for %s is %s", i.ID, e.Client, period, i.Status)
+			case i != nil:
+				r.invoice = i
+			case o.Create:
+				r.invoice = &domain.Invoice{Month: int(e.Date.Month()), Year: e.Date.Year(), Status: "open"}
+				is[period] = r.invoice
+				newInvoices = append(newInvoices, r.invoice)
+			default:
+				invalid(e, "date", "no invoice of client %q for %s", e.Client, period)
+			}
+		}
+
+		task := e.Task
+		if len(task) < 1 {
+			task = o.Activity
+		}
+		switch a, ok := activities[key(task)]; {
+		case len(task) < 1:
+			invalid(e, "task", "missing task")
+		case ok:
+			r.activity = a
+		case o.Create:
+			r.activity = &domain.Activity{Name: task, UserID: userID}
+			activities[key(task)] = r.activity
+			newActivities = append(newActivities, r.activity)
+		default:
+			invalid(e, "task", "unknown task %q", task)
+		}
+		rs = append(rs, r)
+	}
+	if len(errs) > 0 {
+		return plan, errs
+	}
+	if len(rs) < 1 {
+		return plan, ImportError{{Message: "no entries"}}
+	}
+
+	// Creates the missing records, customers first as the projects and
+	// invoices refer to them.
+	var err error
+	if !o.DryRun {
+		for _, c := range newCustomers {
+			if *c, err = u.port.CreateCustomer(*c); err != nil {
+				return plan, err
+			}
+		}
+	}
+	for _, r := range rs {
+		r.project.CustomerID = r.customer.ID
+		r.invoice.CustomerID = r.customer.ID
+	}
+	if !o.DryRun {
+		for _, p := range newProjects {
+			if *p, err = u.port.CreateProject(*p); err != nil {
+				return plan, err
+			}
+		}
+		for _, a := range newActivities {
+			if *a, err = u.port.CreateActivity(*a); err != nil {
+				return plan, err
+			}
+		}
+		for _, i := range newInvoices {
+			if *i, err = u.port.CreateInvoice(*i); err != nil {
+				return plan, err
+			}
+		}
+	}
+
+	bs := make([]domain.Booking, 0, len(rs))
+	for _, r := range rs {
+		bs = append(bs, domain.Booking{
+			Day:         r.entry.Date.Day(),
+			Hours:       r.entry.Hours,
+			Description: r.entry.Description,
+			InvoiceID:   r.invoice.ID,
+			ProjectID:   r.project.ID,
+			ActivityID:  r.activity.ID,
+		})
+	}
+	if !o.DryRun {
+		if bs, err = u.port.CreateBookings(bs); err != nil {
+			return plan, err
+		}
+	}
+
+	plan.Customers = make([]domain.Customer, 0, len(newCustomers))
+	for _, c := range newCustomers {
+		plan.Customers = append(plan.Customers, *c)
+	}
+	plan.Projects = make([]domain.Project, 0, len(newProjects))
+	for _, p := range newProjects {
+		plan.Projects = append(plan.Projects, *p)
+	}
+	plan.Activities = make([]domain.Activity, 0, len(newActivities))
+	for _, a := range newActivities {
+		plan.Activities = append(plan.Activities, *a)
+	}
+	plan.Invoices = make([]domain.Invoice, 0, len(newInvoices))
+	for _, i := range newInvoices {
+		plan.Invoices = append(plan.Invoices, *i)
+	}
+	plan.Bookings = make([]PlannedBooking, 0, len(rs))
+	for n, r := range rs {
+		plan.Bookings = append(plan.Bookings, PlannedBooking{
+			Booking:  bs[n],
+			Line:     r.entry.Line,
+			Customer: r.customer.Name,
+			Project:  r.project.Name,
+			Activity: r.activity.Name,
+			Period:   r.entry.Date.Format("2006-01"),
+		})
+	}
+	return plan, nil
+}
+
+// invoiceOf returns the invoice of the customer for the month, preferring
+// an open one, or nil.
+func invoiceOf(is []domain.Invoice, customerID, year, month int) *domain.Invoice {
+	var found *domain.Invoice
+	for n, i := range is {
+		if i.CustomerID != customerID || i.Year != year || i.Month != month {
+			continue
+		}
+		if found == nil || (found.Status != "open" && i.Status == "open") {
+			found = &is[n]
+		}
+	}
+	return found
+}
+
+// key matches names ignoring case and surrounding space.
+func key(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -0,0 +1,88 @@
+package usecase_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/timesheet"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+func entry(line int, client, project, task string, day int, hours float32) timesheet.Entry {
+	return timesheet.Entry{
+		Line:    line,
+		Client:  client,
+		Project: project,
+		Task:    task,
+		Date:    time.Date(2020, 11, day, 0, 0, 0, 0, time.UTC),
+		Hours:   hours,
+	}
+}
+
+func TestImportTimesheet(t *testing.T) {
+	r := database.NewFakeRepository()
+	setupBaseData(r)
+	nov, _ := r.CreateInvoice(domain.Invoice{CustomerID: customer, Month: 11, Year: 2020})
+	es := []timesheet.Entry{
+		entry(2, "3skills", "instanfoo.com", "Programming", 2, 8),
+		entry(3, "3SKILLS", "Instanfoo.com", "", 3, 1.5),
+		entry(4, "Acme", "Roadrunner", "Design", 4, 2),
+	}
+	uc := usecase.NewImportTimesheet(r)
+
+	_, err := uc.Run(user, es, usecase.TimesheetOptions{})
+	assert.Equal(t, usecase.ImportError{
+		{Line: 3, Field: "task", Message: "missing task"},
+		{Line: 4, Field: "client", Message: `unknown client "Acme"`},
+		{Line: 4, Field: "task", Message: `unknown task "Design"`},
+	}, err)
+
+	o := usecase.TimesheetOptions{Create: true, DryRun: true, Activity: "Quality control"}
+	plan, err := uc.Run(user, es, o)
+	require.NoError(t, err)
+	assert.True(t, plan.DryRun)
+	assert.Equal(t, []domain.Customer{{Name: "Acme", UserID: user}}, plan.Customers)
+	assert.Equal(t, []domain.Project{{Name: "Roadrunner"}}, plan.Projects)
+	assert.Equal(t, []domain.Activity{{Name: "Design", UserID: user}}, plan.Activities)
+	assert.Equal(t, []domain.Invoice{{Month: 11, Year: 2020, Status: "open"}}, plan.Invoices)
+	if assert.Len(t, plan.Bookings, 3) {
+		assert.Equal(t, usecase.PlannedBooking{
+			Booking:  domain.Booking{Day: 3, Hours: 1.5, InvoiceID: nov.ID, ProjectID: pro1, ActivityID: act2},
+			Line:     3,
+			Customer: "3skills",
+			Project:  "Instanfoo.com",
+			Activity: "Quality control",
+			Period:   "2020-11",
+		}, plan.Bookings[1])
+		assert.Zero(t, plan.Bookings[2].InvoiceID, "planned invoice")
+	}
+	assert.Len(t, r.Customers(), 1, "dry run creates nothing")
+	assert.Empty(t, r.BookingsByInvoiceID(nov.ID))
+
+	o.DryRun = false
+	plan, err = uc.Run(user, es, o)
+	require.NoError(t, err)
+	require.Len(t, plan.Customers, 1)
+	acme := plan.Customers[0]
+	assert.NotZero(t, acme.ID)
+	require.Len(t, plan.Projects, 1)
+	assert.Equal(t, acme.ID, plan.Projects[0].CustomerID)
+	require.Len(t, plan.Invoices, 1)
+	assert.Equal(t, acme.ID, plan.Invoices[0].CustomerID)
+	assert.Len(t, r.BookingsByInvoiceID(nov.ID), 2)
+	if bs := r.BookingsByInvoiceID(plan.Invoices[0].ID); assert.Len(t, bs, 1) {
+		assert.Equal(t, plan.Projects[0].ID, bs[0].ProjectID)
+		assert.Equal(t, plan.Activities[0].ID, bs[0].ActivityID)
+	}
+
+	nov.Status = "payment expected"
+	require.NoError(t, r.UpdateInvoice(nov))
+	_, err = uc.Run(user, es[:1], o)
+	assert.Equal(t, usecase.ImportError{
+		{Line: 2, Field: "date", Message: `invoice 1 of client "3skills" for 2020-11 is payment expected`},
+	}, err)
+}
@@ -0,0 +1,33 @@
+package usecase
+
+import "github.com/tullo/invoice-mvp/domain"
+
+// PayInvoicePort is a small and use case specific interface.
+type PayInvoicePort interface {
+	GetInvoice(id int, join ...string) domain.Invoice
+	UpdateInvoice(i domain.Invoice) error
+}
+
+// PayInvoice implements the business logic.
+type PayInvoice struct {
+	port PayInvoicePort
+}
+
+// NewPayInvoice instatiates the use case <Pay Invoice>'.
+func NewPayInvoice(p PayInvoicePort) PayInvoice {
+	return PayInvoice{port: p}
+}
+
+// Run implements the use case <Pay Invoice>', recording the payment of a
+// charged invoice.
+func (u PayInvoice) Run(id int) (domain.Invoice, error) {
+	i := u.port.GetInvoice(id)
+	if !i.Allows("payment") {
+		return i, ErrOperationNotAllowed
+	}
+	i.Status = "paid"
+	if err := u.port.UpdateInvoice(i); err != nil {
+		return i, err
+	}
+	return u.port.GetInvoice(id), nil
+}
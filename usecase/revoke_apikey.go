@@ -0,0 +1,21 @@
+package usecase
+
+// RevokeAPIKeyPort is a small and use case specific interface.
+type RevokeAPIKeyPort interface {
+	RevokeAPIKey(userID string, id int) error
+}
+
+// RevokeAPIKey implements the business logic.
+type RevokeAPIKey struct {
+	port RevokeAPIKeyPort
+}
+
+// NewRevokeAPIKey instatiates the use case <Revoke API Key>'.
+func NewRevokeAPIKey(p RevokeAPIKeyPort) RevokeAPIKey {
+	return RevokeAPIKey{port: p}
+}
+
+// Run implements the use case <Revoke API Key>'.
+func (u RevokeAPIKey) Run(userID string, id int) error {
+	return u.port.RevokeAPIKey(userID, id)
+}
@@ -0,0 +1,23 @@
+package usecase
+
+import "github.com/tullo/invoice-mvp/domain"
+
+// SaveProfilePort is a small and use case specific interface.
+type SaveProfilePort interface {
+	SaveProfile(p domain.Profile) error
+}
+
+// SaveProfile implements the business logic.
+type SaveProfile struct {
+	port SaveProfilePort
+}
+
+// NewSaveProfile instatiates the use case <Save Profile>'.
+func NewSaveProfile(p SaveProfilePort) SaveProfile {
+	return SaveProfile{port: p}
+}
+
+// Run implements the use case <Save Profile>'.
+func (u SaveProfile) Run(p domain.Profile) error {
+	return u.port.SaveProfile(p)
+}
@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"errors"
+
+	"github.com/tullo/invoice-mvp/domain"
+)
+
+// ErrCustomerNotFound is returned for customers missing or owned by other
+// users.
+var ErrCustomerNotFound = errors.New("customer not found")
+
+// UpdateCustomerPort is a small and use case specific interface.
+type UpdateCustomerPort interface {
+	CustomerByID(id int) domain.Customer
+	UpdateCustomer(c domain.Customer) error
+}
+
+// UpdateCustomer implements the business logic.
+type UpdateCustomer struct {
+	port UpdateCustomerPort
+}
+
+// NewUpdateCustomer instatiates the use case <Update Customer>'.
+func NewUpdateCustomer(p UpdateCustomerPort) UpdateCustomer {
+	return UpdateCustomer{port: p}
+}
+
+// Run implements the use case <Update Customer>'. It replaces the name and
+// buyer master data of a customer of the user.
+func (u UpdateCustomer) Run(userID string, c domain.Customer) (domain.Customer, error) {
+	stored := u.port.CustomerByID(c.ID)
+	if stored.ID != c.ID || stored.UserID != userID {
+		return domain.Customer{}, ErrCustomerNotFound
+	}
+	stored.Name = c.Name
+	stored.Address = c.Address
+	stored.VATID = c.VATID
+	stored.Email = c.Email
+	stored.BuyerReference = c.BuyerReference
+	return stored, u.port.UpdateCustomer(stored)
+}
@@ -0,0 +1,28 @@
+package usecase_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/database"
+	"github.com/tullo/invoice-mvp/domain"
+	"github.com/tullo/invoice-mvp/usecase"
+)
+
+func TestUpdateCustomer(t *testing.T) {
+	r := database.NewFakeRepository()
+	setupBaseData(r)
+	uc := usecase.NewUpdateCustomer(r)
+
+	c, err := uc.Run(user, domain.Customer{ID: customer, Name: "3skills GmbH", UserID: "mallory", VATID: "DE987654321", BuyerReference: "991-01234-56"})
+	require.NoError(t, err)
+	assert.Equal(t, domain.Customer{ID: customer, Name: "3skills GmbH", UserID: user, VATID: "DE987654321", BuyerReference: "991-01234-56"}, c, "keeps the owner")
+	assert.Equal(t, c, r.CustomerByID(customer))
+
+	_, err = uc.Run("mallory", domain.Customer{ID: customer, Name: "mine"})
+	assert.ErrorIs(t, err, usecase.ErrCustomerNotFound)
+	_, err = uc.Run(user, domain.Customer{ID: 42})
+	assert.ErrorIs(t, err, usecase.ErrCustomerNotFound)
+	assert.Equal(t, "3skills GmbH", r.CustomerByID(customer).Name)
+}
@@ -4,7 +4,11 @@
 
 package usecase
 
-import "github.com/tullo/invoice-mvp/domain"
+import (
+	"time"
+
+	"github.com/tullo/invoice-mvp/domain"
+)
 
 // UpdateInvoicePort is a small and use case specific interface.
 type UpdateInvoicePort interface {
@@ -41,6 +45,7 @@ func (u UpdateInvoice) Run(uid string, i domain.Invoice) error {
 			i.AddPosition(b.ProjectID, a.Name, b.Hours, r.Price)
 		}
 		i.Status = "payment expected"
+		i.Charged = time.Now().UTC()
 	}
 
 	return u.port.UpdateInvoice(i)
@@ -7,16 +7,18 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"os"
 	"testing"
 	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/dgrijalva/jwt-go/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/tullo/invoice-mvp/config"
 	"github.com/tullo/invoice-mvp/database"
 	"github.com/tullo/invoice-mvp/domain"
 	"github.com/tullo/invoice-mvp/identityprovider/fusionauth"
+	"github.com/tullo/invoice-mvp/identityprovider/jwks"
+	"github.com/tullo/invoice-mvp/identityprovider/stub/stubtest"
 	"github.com/tullo/invoice-mvp/rest"
 	"github.com/tullo/invoice-mvp/usecase"
 )
@@ -36,25 +38,48 @@ const (
 	act3
 )
 
+// cfg is the configuration of the service, authenticating the tokens of idp.
+var cfg config.Config
+
+// idp is the stub identity provider of the tests, its user is an admin.
+var idp *stubtest.Server
+
+// keyFunc verifies the tokens of idp.
+var keyFunc jwt.Keyfunc
+
 func TestMain(m *testing.M) {
-	err := godotenv.Load("../.env")
+	var err error
+	idp, err = stubtest.NewServer()
 	if err != nil {
-		log.Println("Error loading .env file")
+		log.Println(err)
 		os.Exit(1)
 	}
+	idp.User.ID = user
+	idp.User.Roles = []string{rest.RoleAdmin, rest.RoleUser}
+	idp.AddUser(idp.User)
+
+	cfg = config.Default()
+	cfg.IDP = idp.Config()
+	cfg.Auth.Issuer = idp.Issuer()
+	cfg.Auth.Audience = idp.Client.ID
+	keyFunc = rest.KeyFunc(cfg.Auth.Algorithms, jwks.New(idp.KeySet, jwks.Options{}), nil)
 	code := m.Run()
+	idp.Close()
 	os.Exit(code)
 }
 
-func setupBaseData(r *database.FakeRepository) {
-	var uid string
-	if v, ok := os.LookupEnv("USER_ID"); ok {
-		uid = v
-	}
-	if len(uid) != len(user) {
-		// Fallback test user.
-		uid = user
+// login logs the admin user in with the stub identity provider.
+func login(t *testing.T) fusionauth.AuthInfo {
+	t.Helper()
+	auth, err := idp.Login()
+	if err != nil {
+		t.Fatal(err)
 	}
+	return auth
+}
+
+func setupBaseData(r *database.FakeRepository) {
+	uid := user
 
 	// Customers
 	r.CreateCustomer(domain.Customer{ID: customer, Name: "3skills", UserID: uid})
@@ -106,6 +131,7 @@ func TestShouldUpdateState(t *testing.T) {
 	// Assert
 	actual := r.GetInvoice(inv1)
 	assert.Equal(t, "payment expected", actual.Status)
+	assert.False(t, actual.Charged.IsZero())
 }
 
 func TestAggregateBookings(t *testing.T) {
@@ -153,9 +179,12 @@ func TestAggregateBookings(t *testing.T) {
 	expected.AddPosition(pro2, "Project management", 7, 50)
 	expected.AddPosition(pro2, "Quality control", 8, 55)
 	expected.Updated = mod
+	expected.Charged = mod
 
 	actual := r.GetInvoice(inv1)
+	assert.False(t, actual.Charged.IsZero())
 	actual.Updated = mod
+	actual.Charged = mod
 	assert.Equal(t, expected, actual)
 }
 
@@ -185,13 +214,7 @@ func TestHttpInvoiceAggregation(t *testing.T) {
 	i.Status = "ready for aggregation"
 
 	// Login to IDM
-	data := make(url.Values)
-	data.Set("loginId", os.Getenv("MVP_USERNAME"))
-	data.Set("password", os.Getenv("MVP_PASSWORD"))
-	auth, err := fusionauth.Login(data)
-	if err != nil {
-		t.Error(err)
-	}
+	auth := login(t)
 
 	// Prepare HTTP-Request
 	bs, _ := json.Marshal(&i)
@@ -204,7 +227,7 @@ func TestHttpInvoiceAggregation(t *testing.T) {
 	res := httptest.NewRecorder()
 	a := rest.NewAdapter()
 	ui := a.UpdateInvoiceHandler(updateInvoice)
-	ui = rest.JWTAuth(ui)
+	ui = rest.JWTAuth(cfg.Auth, keyFunc, ui)
 	a.Handle("/customers/{customerId:[0-9]+}/invoices/{invoiceId:[0-9]+}", ui).Methods("PUT")
 	a.R.ServeHTTP(res, req)
 
@@ -217,8 +240,11 @@ func TestHttpInvoiceAggregation(t *testing.T) {
 	expected.AddPosition(pro1, "Quality control", 3, 55)
 	expected.AddPosition(pro2, "Project management", 7, 50)
 	expected.AddPosition(pro2, "Quality control", 8, 55)
+	expected.Charged = mod
 
 	actual := r.GetInvoice(inv1)
+	assert.False(t, actual.Charged.IsZero())
 	actual.Updated = mod
+	actual.Charged = mod
 	assert.Equal(t, expected, actual)
 }
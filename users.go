@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/tullo/invoice-mvp/config"
+	"github.com/tullo/invoice-mvp/credentials"
+)
+
+const usersUsage = `usage: invoice-mvp users <command> [flags] [username]
+
+Manages the local credential store used by Basic and Digest authentication.
+Passwords are read from the first line of standard input.
+
+commands:
+  add      -subject <user id> [-roles USER,ADMIN] <username>
+  rotate   <username>
+  disable  <username>
+  enable   <username>
+  list
+`
+
+// runUsers implements the "users" subcommand.
+func runUsers(args []string) error {
+	if len(args) < 1 {
+		return errors.New(usersUsage)
+	}
+	cmd := args[0]
+	// Defaults of the service configuration, without its flags.
+	cfg, err := config.Parse(nil)
+	if err != nil {
+		return err
+	}
+	file := cfg.Auth.CredentialsFile
+	if len(file) < 1 {
+		file = "credentials.json"
+	}
+	fs := flag.NewFlagSet("users "+cmd, flag.ContinueOnError)
+	path := fs.String("file", file, "credential store file")
+	realm := fs.String("realm", cfg.Auth.Realm, "authentication realm")
+	subject := fs.String("subject", "", "user ID used as claims subject")
+	roles := fs.String("roles", "USER", "comma separated list of roles")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	s, err := credentials.Open(*path, *realm)
+	if err != nil {
+		return err
+	}
+
+	if cmd == "list" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tSUBJECT\tROLES\tDISABLED\tROTATED")
+		for _, u := range s.Users() {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", u.Username, u.Subject, strings.Join(u.Roles, ","), u.Disabled, u.Rotated.Format("2006-01-02"))
+		}
+		return w.Flush()
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New(usersUsage)
+	}
+	username := fs.Arg(0)
+	switch cmd {
+	case "add":
+		p, err := readPassword()
+		if err != nil {
+			return err
+		}
+		err = s.Add(username, *subject, p, strings.Split(*roles, ","))
+		if err != nil {
+			return err
+		}
+	case "rotate":
+		p, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if err := s.Rotate(username, p); err != nil {
+			return err
+		}
+	case "disable", "enable":
+		if err := s.SetDisabled(username, cmd == "disable"); err != nil {
+			return err
+		}
+	default:
+		return errors.New(usersUsage)
+	}
+	return s.Save()
+}
+
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) < 1 {
+		return "", errors.Wrap(err, "reading password")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,264 @@
+// Package xlsx writes Office Open XML spreadsheets (.xlsx) with typed cells:
+// numbers are stored as numbers and dates as date serials, so spreadsheet
+// applications sum and sort them without conversion.
+//
+//	wb := xlsx.New()
+//	s := wb.AddSheet("Bookings")
+//	s.AddHeader("Date", "Hours", "Description")
+//	s.AddRow(time.Date(2020, 11, 30, 0, 0, 0, 0, time.UTC), 3.5, "Front: bugfix #6789")
+//	err := wb.Write(w)
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ContentType is the media type of XLSX files.
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// Month is a cell value of a month, formatted like 2020-11.
+type Month time.Time
+
+// These are the indexes of the cell formats in styles.xml.
+const (
+	styleGeneral = iota
+	styleHeader
+	styleDate
+	styleMonth
+	styleDecimal
+)
+
+// Workbook is a spreadsheet of sheets.
+type Workbook struct {
+	sheets []*Sheet
+}
+
+// Sheet is a worksheet of rows.
+type Sheet struct {
+	name string
+	rows []row
+	err  error
+}
+
+type row struct {
+	header bool
+	values []interface{}
+}
+
+// New returns an empty workbook.
+func New() *Workbook {
+	return &Workbook{}
+}
+
+// AddSheet appends a sheet. Names are at most 31 characters and must not
+// contain any of []:*?/\.
+func (wb *Workbook) AddSheet(name string) *Sheet {
+	s := &Sheet{name: name}
+	if len(name) < 1 || len([]rune(name)) > 31 || strings.ContainsAny(name, `[]:*?/\`) {
+		s.err = errors.Errorf("invalid sheet name %q", name)
+	}
+	wb.sheets = append(wb.sheets, s)
+	return s
+}
+
+// AddHeader appends a row of bold column names.
+func (s *Sheet) AddHeader(names ...string) {
+	values := make([]interface{}, 0, len(names))
+	for _, n := range names {
+		values = append(values, n)
+	}
+	s.rows = append(s.rows, row{header: true, values: values})
+}
+
+// AddRow appends a row of cells. Values are strings, integers, floats,
+// time.Time for dates, Month or nil for empty cells.
+func (s *Sheet) AddRow(values ...interface{}) {
+	for _, v := range values {
+		switch v.(type) {
+		case nil, string, int, int64, float32, float64, time.Time, Month:
+		default:
+			if s.err == nil {
+				s.err = errors.Errorf("sheet %s: unsupported cell value of type %T", s.name, v)
+			}
+		}
+	}
+	s.rows = append(s.rows, row{values: values})
+}
+
+// Write writes the workbook as XLSX file.
+func (wb *Workbook) Write(w io.Writer) error {
+	if len(wb.sheets) < 1 {
+		return errors.New("workbook without sheets")
+	}
+	for _, s := range wb.sheets {
+		if s.err != nil {
+			return s.err
+		}
+	}
+	z := zip.NewWriter(w)
+	parts := []struct {
+		name    string
+		content []byte
+	}{
+		{"[Content_Types].xml", wb.contentTypes()},
+		{"_rels/.rels", []byte(xml.Header + rootRels)},
+		{"xl/workbook.xml", wb.workbook()},
+		{"xl/_rels/workbook.xml.rels", wb.workbookRels()},
+		{"xl/styles.xml", []byte(xml.Header + styles)},
+	}
+	for n, s := range wb.sheets {
+		parts = append(parts, struct {
+			name    string
+			content []byte
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", n+1), s.xml()})
+	}
+	for _, p := range parts {
+		f, err := z.Create(p.name)
+		if err != nil {
+			return errors.Wrapf(err, "creating %s", p.name)
+		}
+		if _, err := f.Write(p.content); err != nil {
+			return errors.Wrapf(err, "writing %s", p.name)
+		}
+	}
+	return z.Close()
+}
+
+const rootRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// styles defines the cell formats: general, bold header, ISO date, month
+// and two decimals.
+const styles = `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd"/><numFmt numFmtId="165" formatCode="yyyy-mm"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="5">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="2" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
+
+func (wb *Workbook) contentTypes() []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	b.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	b.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for n := range wb.sheets {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n+1)
+	}
+	b.WriteString(`</Types>`)
+	return b.Bytes()
+}
+
+func (wb *Workbook) workbook() []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for n, s := range wb.sheets {
+		fmt.Fprintf(&b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(s.name), n+1, n+1)
+	}
+	b.WriteString(`</sheets></workbook>`)
+	return b.Bytes()
+}
+
+func (wb *Workbook) workbookRels() []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for n := range wb.sheets {
+		fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n+1, n+1)
+	}
+	fmt.Fprintf(&b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(wb.sheets)+1)
+	b.WriteString(`</Relationships>`)
+	return b.Bytes()
+}
+
+func (s *Sheet) xml() []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if len(s.rows) > 0 && s.rows[0].header {
+		// Keeps the header row visible while scrolling.
+		b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	}
+	b.WriteString(`<sheetData>`)
+	for n, r := range s.rows {
+		fmt.Fprintf(&b, `<row r="%d">`, n+1)
+		for c, v := range r.values {
+			ref := Column(c) + strconv.Itoa(n+1)
+			style := styleGeneral
+			if r.header {
+				style = styleHeader
+			}
+			switch v := v.(type) {
+			case nil:
+			case string:
+				fmt.Fprintf(&b, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, escape(v))
+			case int:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%d</v></c>`, ref, style, v)
+			case int64:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%d</v></c>`, ref, style, v)
+			case float32:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleDecimal, strconv.FormatFloat(float64(v), 'f', -1, 32))
+			case float64:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleDecimal, strconv.FormatFloat(v, 'f', -1, 64))
+			case time.Time:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleDate, serial(v))
+			case Month:
+				fmt.Fprintf(&b, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleMonth, serial(time.Time(v)))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.Bytes()
+}
+
+// Column returns the name of the column with the zero based index, e.g. "A"
+// or "AB".
+func Column(i int) string {
+	var name []byte
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = append([]byte{byte('A' + (i-1)%26)}, name...)
+	}
+	return string(name)
+}
+
+// epoch is day 0 of the date serials of the 1900 date system, which counts
+// the nonexistent 1900-02-29.
+var epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// serial returns the date serial of the wall clock time of t.
+func serial(t time.Time) string {
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	days := day.Sub(epoch).Hours() / 24
+	clock := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location())).Hours() / 24
+	return strconv.FormatFloat(days+clock, 'f', -1, 64)
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package xlsx_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tullo/invoice-mvp/xlsx"
+)
+
+// cell is a cell of a worksheet as read back.
+type cell struct {
+	Ref    string `xml:"r,attr"`
+	Style  int    `xml:"s,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+type worksheet struct {
+	Rows []struct {
+		Cells []cell `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func TestWrite(t *testing.T) {
+	wb := xlsx.New()
+	s := wb.AddSheet("Bookings")
+	s.AddHeader("Date", "Invoice", "Hours", "Description")
+	s.AddRow(time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC), 1, float32(3.5), "Front: <bugfix> & #6789")
+	s.AddRow(xlsx.Month(time.Date(2020, 11, 1, 0, 0, 0, 0, time.UTC)), nil, 0.1)
+	wb.AddSheet("Positions")
+
+	var b bytes.Buffer
+	require.NoError(t, wb.Write(&b))
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	require.NoError(t, err)
+	parts := make(map[string][]byte)
+	for _, f := range z.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		parts[f.Name], err = io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet2.xml"} {
+		if assert.Contains(t, parts, name) {
+			assert.NoError(t, xml.Unmarshal(parts[name], new(interface{})), name)
+		}
+	}
+	assert.Contains(t, string(parts["xl/workbook.xml"]), `<sheet name="Positions" sheetId="2" r:id="rId2"/>`)
+
+	var ws worksheet
+	require.NoError(t, xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &ws))
+	require.Len(t, ws.Rows, 3)
+	assert.Equal(t, cell{Ref: "D1", Style: 1, Type: "inlineStr", Inline: "Description"}, ws.Rows[0].Cells[3])
+	assert.Equal(t, []cell{
+		{Ref: "A2", Style: 2, Value: "44165.5"},
+		{Ref: "B2", Value: "1"},
+		{Ref: "C2", Style: 4, Value: "3.5"},
+		{Ref: "D2", Type: "inlineStr", Inline: "Front: <bugfix> & #6789"},
+	}, ws.Rows[1].Cells)
+	assert.Equal(t, []cell{
+		{Ref: "A3", Style: 3, Value: "44136"},
+		{Ref: "C3", Style: 4, Value: "0.1"},
+	}, ws.Rows[2].Cells)
+}
+
+func TestWriteErrors(t *testing.T) {
+	assert.EqualError(t, xlsx.New().Write(io.Discard), "workbook without sheets")
+
+	wb := xlsx.New()
+	wb.AddSheet("2020/11")
+	assert.EqualError(t, wb.Write(io.Discard), `invalid sheet name "2020/11"`)
+
+	wb = xlsx.New()
+	wb.AddSheet("Bookings").AddRow(true)
+	assert.EqualError(t, wb.Write(io.Discard), "sheet Bookings: unsupported cell value of type bool")
+}
+
+func TestColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, want, xlsx.Column(i))
+	}
+}